@@ -12,12 +12,21 @@ type SentPacketHandler interface {
 	// SentPacket may modify the packet
 	SentPacket(packet *Packet) error
 	ReceivedAck(ackFrame *wire.AckFrame, withPacketNumber protocol.PacketNumber, recvTime time.Time) error
+	// ReceivedCrossPathAck handles an ACK received on another path, whose ordering is checked by the caller
+	ReceivedCrossPathAck(ackFrame *wire.AckFrame, recvTime time.Time) error
 
 	// Specific to multipath operation
 	ReceivedClosePath(f *wire.ClosePathFrame, withPacketNumber protocol.PacketNumber, recvTime time.Time) error
 	SetInflightAsLost()
+	RetransmitStreamData(streamID protocol.StreamID, offset protocol.ByteCount, length protocol.ByteCount) bool
+	OnConnectionMigration()
 
 	SendingAllowed() bool
+	GetCongestionWindow() protocol.ByteCount
+	SetCongestionWindow(window protocol.ByteCount)
+	GetBytesInFlight() protocol.ByteCount
+	// GetPacketsInFlight returns the number of retransmittable packets sent and neither acked nor declared lost
+	GetPacketsInFlight() int
 	GetStopWaitingFrame(force bool) *wire.StopWaitingFrame
 	ShouldSendRetransmittablePacket() bool
 	DequeuePacketForRetransmission() (packet *Packet)
@@ -25,16 +34,52 @@ type SentPacketHandler interface {
 
 	GetAlarmTimeout() time.Time
 	OnAlarm()
+	TimeUntilSend() time.Time
+
+	// SetPacketReorderingThreshold makes a packet also count as lost as soon as a packet sent threshold
+	// packet numbers after it is acknowledged, in addition to the time-based detection. 0 disables it.
+	SetPacketReorderingThreshold(threshold protocol.PacketNumber)
+	// SetPotentiallyFailedRTOs sets the number of consecutive RTOs after which the path can be marked potentially failed,
+	// until then an RTO only retransmits the oldest packets. 0 and 1 mark it on the first RTO.
+	SetPotentiallyFailedRTOs(n uint32)
+	// SetIgnoreClosePathLosses keeps the packets declared lost when the peer closes the path
+	// from being reported to the congestion controller. They are still retransmitted.
+	SetIgnoreClosePathLosses(ignore bool)
+	// SetPacingGain paces the packets at the rate of the congestion window per smoothed RTT.
+	// Once per pacing cycle, the rate is multiplied by the gain for one RTT and divided by it in the next RTT,
+	// such that the path is probed for more (gain > 1) or less (gain < 1) bandwidth. 0 disables pacing.
+	SetPacingGain(gain float64)
+	// SetLostPacketCallback sets a callback called with every packet declared lost,
+	// before it is queued for retransmission
+	SetLostPacketCallback(callback func(packet *Packet))
+	// SetAckedPacketCallback sets a callback called with every packet acked by the peer
+	SetAckedPacketCallback(callback func(packet *Packet))
+	// OnAppLimited tells that the application had no data to send although the congestion window allowed it.
+	// The packets sent from now on are app-limited until a packet sent after the ones in flight is acked.
+	OnAppLimited()
 
 	DuplicatePacket(packet *Packet)
 
+	// For debugging the detection of ACKs for skipped packets
+	GetSkippedPackets() []protocol.PacketNumber
+	SetMaxTrackedSkippedPackets(n int)
+
 	GetStatistics() (uint64, uint64, uint64)
+	GetLossRate() float64
+	// InRecovery returns true if the congestion controller reduced its window after a loss,
+	// and no packet sent since then was acked yet
+	InRecovery() bool
+	// PacketNumbersExhausted returns true once the packet numbers sent came close to the largest one that can be encoded.
+	// The path has to be closed before they run out.
+	PacketNumbersExhausted() bool
 }
 
 // ReceivedPacketHandler handles ACKs needed to send for incoming packets
 type ReceivedPacketHandler interface {
 	ReceivedPacket(packetNumber protocol.PacketNumber, shouldInstigateAck bool) error
 	SetLowerLimit(protocol.PacketNumber)
+	// SetAckFrequency sets the number of retransmittable packets an ACK is sent for, and the maximum delay of an ACK
+	SetAckFrequency(packetTolerance int, maxAckDelay time.Duration)
 
 	GetAlarmTimeout() time.Time
 	GetAckFrame() *wire.AckFrame
@@ -42,4 +87,11 @@ type ReceivedPacketHandler interface {
 	GetClosePathFrame() *wire.ClosePathFrame
 
 	GetStatistics() uint64
+	// GetLargestObserved returns the largest packet number received
+	GetLargestObserved() protocol.PacketNumber
+	// GetLargestInOrder returns the largest packet number up to which no packet is missing
+	GetLargestInOrder() protocol.PacketNumber
+	// GetAckRanges returns the ranges of received packets, from the highest to the lowest, as the next ACK would report them.
+	// Unlike GetAckFrame, it doesn't dequeue the ACK.
+	GetAckRanges() []wire.AckRange
 }
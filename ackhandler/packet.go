@@ -16,8 +16,22 @@ type Packet struct {
 	EncryptionLevel protocol.EncryptionLevel
 
 	SendTime time.Time
+	// sent during an app-limited period, the bandwidth sample of its ACK measures the application rather than the path
+	AppLimited bool
+
+	// why the packet was queued for retransmission
+	retransmissionCause retransmissionCause
 }
 
+// retransmissionCause is the reason a packet was queued for retransmission
+type retransmissionCause uint8
+
+const (
+	lossRetransmission retransmissionCause = iota
+	tlpRetransmission
+	rtoRetransmission
+)
+
 // GetFramesForRetransmission gets all the frames for retransmission
 func (p *Packet) GetFramesForRetransmission() []wire.Frame {
 	var fs []wire.Frame
@@ -27,6 +41,9 @@ func (p *Packet) GetFramesForRetransmission() []wire.Frame {
 			continue
 		case *wire.StopWaitingFrame:
 			continue
+		case *wire.DatagramFrame:
+			// datagrams are unreliable
+			continue
 		}
 		fs = append(fs, frame)
 	}
@@ -52,6 +69,10 @@ func (p *Packet) IsRetransmittable() bool {
 			return true
 		case *wire.PathsFrame:
 			return true
+		case *wire.SkipStreamDataFrame:
+			return true
+		case *wire.DatagramFrame:
+			return true
 		}
 	}
 	return false
@@ -47,5 +47,16 @@ var _ = Describe("Packet", func() {
 			Expect(fs).ToNot(ContainElement(ackFrame))
 		})
 
+		It("doesn't retransmit datagrams", func() {
+			datagramFrame := &wire.DatagramFrame{Data: []byte("foobar")}
+			packet := &Packet{
+				Frames: []wire.Frame{datagramFrame, streamFrame},
+			}
+			Expect(packet.IsRetransmittable()).To(BeTrue())
+			Expect(packet.GetFramesForRetransmission()).To(Equal([]wire.Frame{streamFrame}))
+			packet.Frames = []wire.Frame{datagramFrame}
+			Expect(packet.GetFramesForRetransmission()).To(BeEmpty())
+		})
+
 	})
 })
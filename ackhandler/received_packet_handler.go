@@ -18,6 +18,8 @@ type receivedPacketHandler struct {
 	packetHistory *receivedPacketHistory
 
 	ackSendDelay time.Duration
+	// the number of retransmittable packets an ACK is sent for, it can be changed by the peer
+	packetTolerance int
 
 	packetsReceivedSinceLastAck                int
 	retransmittablePacketsReceivedSinceLastAck int
@@ -33,9 +35,10 @@ type receivedPacketHandler struct {
 // NewReceivedPacketHandler creates a new receivedPacketHandler
 func NewReceivedPacketHandler(version protocol.VersionNumber) ReceivedPacketHandler {
 	return &receivedPacketHandler{
-		packetHistory: newReceivedPacketHistory(),
-		ackSendDelay:  protocol.AckSendDelay,
-		version:       version,
+		packetHistory:   newReceivedPacketHistory(),
+		ackSendDelay:    protocol.AckSendDelay,
+		packetTolerance: protocol.RetransmittablePacketsBeforeAck,
+		version:         version,
 	}
 }
 
@@ -43,6 +46,20 @@ func (h *receivedPacketHandler) GetStatistics() uint64 {
 	return h.packets
 }
 
+func (h *receivedPacketHandler) GetLargestObserved() protocol.PacketNumber {
+	return h.largestObserved
+}
+
+// GetLargestInOrder returns the largest packet number up to which all packets were received.
+// Packets below the lower limit are not waited for anymore, so they count as received.
+func (h *receivedPacketHandler) GetLargestInOrder() protocol.PacketNumber {
+	lowestRange := h.packetHistory.GetLowestAckRange()
+	if lowestRange.Last != 0 && lowestRange.First <= h.lowerLimit+1 {
+		return lowestRange.Last
+	}
+	return h.lowerLimit
+}
+
 func (h *receivedPacketHandler) ReceivedPacket(packetNumber protocol.PacketNumber, shouldInstigateAck bool) error {
 	if packetNumber == 0 {
 		return errInvalidPacketNumber
@@ -67,6 +84,18 @@ func (h *receivedPacketHandler) ReceivedPacket(packetNumber protocol.PacketNumbe
 	return nil
 }
 
+// SetAckFrequency sets the number of retransmittable packets an ACK is sent for, and the maximum delay of an ACK.
+// Out-of-order packets are still acked immediately.
+func (h *receivedPacketHandler) SetAckFrequency(packetTolerance int, maxAckDelay time.Duration) {
+	if packetTolerance < 1 {
+		packetTolerance = 1
+	}
+	h.packetTolerance = packetTolerance
+	if maxAckDelay > 0 {
+		h.ackSendDelay = maxAckDelay
+	}
+}
+
 // SetLowerLimit sets a lower limit for acking packets.
 // Packets with packet numbers smaller or equal than p will not be acked.
 func (h *receivedPacketHandler) SetLowerLimit(p protocol.PacketNumber) {
@@ -107,7 +136,7 @@ func (h *receivedPacketHandler) maybeQueueAck(packetNumber protocol.PacketNumber
 	}
 
 	if !h.ackQueued && shouldInstigateAck {
-		if h.retransmittablePacketsReceivedSinceLastAck >= protocol.RetransmittablePacketsBeforeAck {
+		if h.retransmittablePacketsReceivedSinceLastAck >= h.packetTolerance {
 			h.ackQueued = true
 		} else {
 			if h.ackAlarm.IsZero() {
@@ -147,6 +176,10 @@ func (h *receivedPacketHandler) GetAckFrame() *wire.AckFrame {
 	return ack
 }
 
+func (h *receivedPacketHandler) GetAckRanges() []wire.AckRange {
+	return h.packetHistory.GetAckRanges()
+}
+
 func (h *receivedPacketHandler) GetClosePathFrame() *wire.ClosePathFrame {
 	ackRanges := h.packetHistory.GetAckRanges()
 	frame := &wire.ClosePathFrame{
@@ -73,6 +73,54 @@ var _ = Describe("receivedPacketHandler", func() {
 		})
 	})
 
+	Context("largest received packet numbers", func() {
+		It("reports zero if no packet was received", func() {
+			Expect(handler.GetLargestObserved()).To(BeZero())
+			Expect(handler.GetLargestInOrder()).To(BeZero())
+		})
+
+		It("reports the same packet number if no packet is missing", func() {
+			for i := protocol.PacketNumber(1); i <= 4; i++ {
+				err := handler.ReceivedPacket(i, true)
+				Expect(err).ToNot(HaveOccurred())
+			}
+			Expect(handler.GetLargestObserved()).To(Equal(protocol.PacketNumber(4)))
+			Expect(handler.GetLargestInOrder()).To(Equal(protocol.PacketNumber(4)))
+		})
+
+		It("reports the packet number before a gap as the largest in-order", func() {
+			for _, p := range []protocol.PacketNumber{1, 2, 3, 6, 7} {
+				err := handler.ReceivedPacket(p, true)
+				Expect(err).ToNot(HaveOccurred())
+			}
+			Expect(handler.GetLargestObserved()).To(Equal(protocol.PacketNumber(7)))
+			Expect(handler.GetLargestInOrder()).To(Equal(protocol.PacketNumber(3)))
+			// filling the gap
+			Expect(handler.ReceivedPacket(4, true)).To(Succeed())
+			Expect(handler.ReceivedPacket(5, true)).To(Succeed())
+			Expect(handler.GetLargestInOrder()).To(Equal(protocol.PacketNumber(7)))
+		})
+
+		It("reports no in-order packet if the first one is missing", func() {
+			err := handler.ReceivedPacket(2, true)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(handler.GetLargestObserved()).To(Equal(protocol.PacketNumber(2)))
+			Expect(handler.GetLargestInOrder()).To(BeZero())
+		})
+
+		It("doesn't wait for packets below the lower limit", func() {
+			for _, p := range []protocol.PacketNumber{1, 4, 5, 7} {
+				err := handler.ReceivedPacket(p, true)
+				Expect(err).ToNot(HaveOccurred())
+			}
+			Expect(handler.GetLargestInOrder()).To(Equal(protocol.PacketNumber(1)))
+			handler.SetLowerLimit(3)
+			Expect(handler.GetLargestInOrder()).To(Equal(protocol.PacketNumber(5)))
+			handler.SetLowerLimit(6)
+			Expect(handler.GetLargestInOrder()).To(Equal(protocol.PacketNumber(7)))
+		})
+	})
+
 	Context("ACKs", func() {
 		Context("queueing ACKs", func() {
 			receiveAndAck10Packets := func() {
@@ -169,6 +217,47 @@ var _ = Describe("receivedPacketHandler", func() {
 			})
 		})
 
+		Context("ACK frequency", func() {
+			countAcks := func(from, to protocol.PacketNumber) int {
+				var acks int
+				for i := from; i <= to; i++ {
+					err := handler.ReceivedPacket(i, true)
+					Expect(err).ToNot(HaveOccurred())
+					if handler.GetAckFrame() != nil {
+						acks++
+					}
+				}
+				return acks
+			}
+
+			It("acks less often when asked for by the peer", func() {
+				// the first packet is always acked
+				Expect(countAcks(1, 1)).To(Equal(1))
+				Expect(countAcks(2, 21)).To(Equal(10))
+				handler.SetAckFrequency(10, 0)
+				Expect(countAcks(22, 121)).To(Equal(10))
+			})
+
+			It("still acks out-of-order packets immediately", func() {
+				handler.SetAckFrequency(10, 0)
+				Expect(countAcks(1, 1)).To(Equal(1))
+				err := handler.ReceivedPacket(3, true)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(handler.ackQueued).To(BeTrue())
+			})
+
+			It("uses the maximum ACK delay of the peer", func() {
+				handler.SetAckFrequency(10, 5*time.Millisecond)
+				Expect(countAcks(1, 2)).To(Equal(1))
+				Expect(handler.GetAlarmTimeout()).To(BeTemporally("~", time.Now().Add(5*time.Millisecond), time.Millisecond))
+			})
+
+			It("acks at least every packet", func() {
+				handler.SetAckFrequency(0, 0)
+				Expect(countAcks(1, 10)).To(Equal(10))
+			})
+		})
+
 		Context("ACK generation", func() {
 			BeforeEach(func() {
 				handler.ackQueued = true
@@ -214,6 +303,25 @@ var _ = Describe("receivedPacketHandler", func() {
 				Expect(ack.AckRanges[1]).To(Equal(wire.AckRange{First: 1, Last: 1}))
 			})
 
+			It("reports the ACK ranges without dequeueing the ACK", func() {
+				for _, pn := range []protocol.PacketNumber{1, 2, 4, 5, 7} {
+					err := handler.ReceivedPacket(pn, true)
+					Expect(err).ToNot(HaveOccurred())
+				}
+				Expect(handler.GetAckRanges()).To(Equal([]wire.AckRange{
+					{First: 7, Last: 7},
+					{First: 4, Last: 5},
+					{First: 1, Last: 2},
+				}))
+				ack := handler.GetAckFrame()
+				Expect(ack).ToNot(BeNil())
+				Expect(ack.AckRanges).To(HaveLen(3))
+			})
+
+			It("reports no ACK ranges if no packet was received", func() {
+				Expect(handler.GetAckRanges()).To(BeEmpty())
+			})
+
 			It("accepts packets below the lower limit", func() {
 				handler.SetLowerLimit(5)
 				err := handler.ReceivedPacket(2, true)
@@ -116,3 +116,13 @@ func (h *receivedPacketHistory) GetHighestAckRange() wire.AckRange {
 	}
 	return ackRange
 }
+
+func (h *receivedPacketHistory) GetLowestAckRange() wire.AckRange {
+	ackRange := wire.AckRange{}
+	if h.ranges.Len() > 0 {
+		r := h.ranges.Front().Value
+		ackRange.First = r.Start
+		ackRange.Last = r.End
+	}
+	return ackRange
+}
@@ -3,6 +3,7 @@ package ackhandler
 import (
 	"errors"
 	"fmt"
+	"math"
 	"time"
 
 	"github.com/lucas-clemente/pstream/congestion"
@@ -31,6 +32,14 @@ const (
 	minRetransmissionTime = 200 * time.Millisecond
 	// Minimum tail loss probe time in ms
 	minTailLossProbeTimeout = 10 * time.Millisecond
+	// Minimum delay of a bandwidth sample, to avoid dividing by (almost) zero
+	minBDWSampleDelay = time.Microsecond
+	// Duration of the windows used to compute the recent loss rate
+	lossRateWindow = time.Second
+	// Number of RTTs of a pacing cycle. The pacing gain is applied in the first RTT, and its inverse in the second one.
+	pacingCycleRTTs = 8
+	// Bytes a paced path may send ahead of its pacing rate, such that a few packets go out back-to-back
+	pacingBurstQuantum = 2 * protocol.DefaultTCPMSS
 )
 
 var (
@@ -41,6 +50,8 @@ var (
 	// ErrAckForSkippedPacket occurs when the client sent an ACK for a packet number that we intentionally skipped
 	ErrAckForSkippedPacket = qerr.Error(qerr.InvalidAckData, "Received an ACK for a skipped packet number")
 	errAckForUnsentPacket  = qerr.Error(qerr.InvalidAckData, "Received ACK for an unsent package")
+	// ErrPacketNumberSpaceExhausted occurs when a packet number can't be encoded anymore
+	ErrPacketNumberSpaceExhausted = errors.New("SentPacketHandler: packet number space exhausted")
 )
 
 var errPacketNumberNotIncreasing = errors.New("Already sent a packet with a higher packet number")
@@ -48,12 +59,20 @@ var errPacketNumberNotIncreasing = errors.New("Already sent a packet with a high
 type sentPacketHandler struct {
 	lastSentPacketNumber protocol.PacketNumber
 	skippedPackets       []protocol.PacketNumber
+	// If set, used instead of protocol.MaxTrackedSkippedPackets
+	maxTrackedSkippedPackets int
 
 	pathID protocol.PathID // record corresponding path ID
 
 	numNonRetransmittablePackets int // number of non-retransmittable packets since the last retransmittable packet
 
 	LargestAcked protocol.PacketNumber
+	// when the peer received the largest acked packet, zero until a bandwidth sample was taken
+	largestAckedDeliveryTime time.Time
+
+	// If set, the packets sent are app-limited until a packet larger than appLimitedUntil is acked
+	appLimited      bool
+	appLimitedUntil protocol.PacketNumber
 
 	largestReceivedPacketWithAck protocol.PacketNumber
 
@@ -61,6 +80,8 @@ type sentPacketHandler struct {
 	stopWaitingManager stopWaitingManager
 
 	retransmissionQueue []*Packet
+	// number of TLPs in the retransmissionQueue
+	queuedTLPs int
 
 	bytesInFlight protocol.ByteCount
 
@@ -69,15 +90,25 @@ type sentPacketHandler struct {
 	bdwStats   *congestion.BDWStats
 
 	onRTOCallback func(time.Time) bool
+	// If set, called for every packet declared lost
+	lostPacketCallback func(*Packet)
+	// If set, called for every packet acked by the peer
+	ackedPacketCallback func(*Packet)
 
 	// The number of times an RTO has been sent without receiving an ack.
 	rtoCount uint32
+	// the number of consecutive RTOs after which the onRTOCallback is asked whether the path potentially failed
+	potentiallyFailedRTOs uint32
 
 	// The number of times a TLP has been sent without receiving an ACK
 	tlpCount uint32
 
 	// The time at which the next packet will be considered lost based on early transmit or exceeding the reordering window in time.
 	lossTime time.Time
+	// If set, a packet is also lost once a packet with a number larger by this threshold is acknowledged
+	packetReorderingThreshold protocol.PacketNumber
+	// If set, the packets in flight when the peer closes the path are not reported to the congestion controller
+	ignoreClosePathLosses bool
 
 	// The time the last packet was sent, used to set the retransmission timeout
 	lastSentTime time.Time
@@ -88,10 +119,28 @@ type sentPacketHandler struct {
 	packets         uint64
 	retransmissions uint64
 	losses          uint64
+
+	// The loss rate is computed over the current and the previous loss rate window,
+	// using the statistics at the start of these windows
+	lossRateWindowStart      time.Time
+	packetsAtWindowStart     uint64
+	lossesAtWindowStart      uint64
+	packetsAtPrevWindowStart uint64
+	lossesAtPrevWindowStart  uint64
+
+	// If set, used instead of the congestion window of the congestion controller
+	congestionWindowOverride protocol.ByteCount
+
+	// If set, packets are paced at the rate of the congestion window per smoothed RTT, scaled by the gain in each pacing cycle
+	pacingGain       float64
+	pacingCycleStart time.Time
+	nextPacketTime   time.Time
+
+	logger utils.Logger
 }
 
-// NewSentPacketHandler creates a new sentPacketHandler
-func NewSentPacketHandler(pathID protocol.PathID, rttStats *congestion.RTTStats, bdwStats *congestion.BDWStats, cong congestion.SendAlgorithm, onRTOCallback func(time.Time) bool) SentPacketHandler {
+// NewSentPacketHandler creates a new sentPacketHandler, logging its traces with the logger of the session
+func NewSentPacketHandler(pathID protocol.PathID, rttStats *congestion.RTTStats, bdwStats *congestion.BDWStats, cong congestion.SendAlgorithm, onRTOCallback func(time.Time) bool, logger utils.Logger) SentPacketHandler {
 	var congestionControl congestion.SendAlgorithm
 
 	if cong != nil {
@@ -114,6 +163,7 @@ func NewSentPacketHandler(pathID protocol.PathID, rttStats *congestion.RTTStats,
 		bdwStats:           bdwStats,
 		congestion:         congestionControl,
 		onRTOCallback:      onRTOCallback,
+		logger:             logger,
 	}
 }
 
@@ -121,6 +171,32 @@ func (h *sentPacketHandler) GetStatistics() (uint64, uint64, uint64) {
 	return h.packets, h.retransmissions, h.losses
 }
 
+// GetLossRate returns the fraction of the packets sent during the last one to two loss rate windows that were lost
+func (h *sentPacketHandler) GetLossRate() float64 {
+	h.maybeStartLossRateWindow(time.Now())
+	packets := h.packets - h.packetsAtPrevWindowStart
+	if packets == 0 {
+		return 0
+	}
+	// losses of packets sent before the window are counted too
+	return math.Min(float64(h.losses-h.lossesAtPrevWindowStart)/float64(packets), 1)
+}
+
+func (h *sentPacketHandler) maybeStartLossRateWindow(now time.Time) {
+	elapsed := now.Sub(h.lossRateWindowStart)
+	if elapsed < lossRateWindow {
+		return
+	}
+	if elapsed < 2*lossRateWindow {
+		h.packetsAtPrevWindowStart, h.lossesAtPrevWindowStart = h.packetsAtWindowStart, h.lossesAtWindowStart
+	} else {
+		// nothing happened during the previous window
+		h.packetsAtPrevWindowStart, h.lossesAtPrevWindowStart = h.packets, h.losses
+	}
+	h.packetsAtWindowStart, h.lossesAtWindowStart = h.packets, h.losses
+	h.lossRateWindowStart = now
+}
+
 func (h *sentPacketHandler) largestInOrderAcked() protocol.PacketNumber {
 	if f := h.packetHistory.Front(); f != nil {
 		return f.Value.PacketNumber - 1
@@ -136,6 +212,10 @@ func (h *sentPacketHandler) SentPacket(packet *Packet) error {
 	if packet.PacketNumber <= h.lastSentPacketNumber {
 		return errPacketNumberNotIncreasing
 	}
+	// the peer would decode a wrapped packet number
+	if packet.PacketNumber > protocol.MaxPacketNumber {
+		return ErrPacketNumberSpaceExhausted
+	}
 
 	if protocol.PacketNumber(len(h.retransmissionQueue)+h.packetHistory.Len()+1) > protocol.MaxTrackedSentPackets {
 		return ErrTooManyTrackedSentPackets
@@ -144,7 +224,7 @@ func (h *sentPacketHandler) SentPacket(packet *Packet) error {
 	for p := h.lastSentPacketNumber + 1; p < packet.PacketNumber; p++ {
 		h.skippedPackets = append(h.skippedPackets, p)
 
-		if len(h.skippedPackets) > protocol.MaxTrackedSkippedPackets {
+		if len(h.skippedPackets) > h.getMaxTrackedSkippedPackets() {
 			h.skippedPackets = h.skippedPackets[1:]
 		}
 	}
@@ -153,6 +233,7 @@ func (h *sentPacketHandler) SentPacket(packet *Packet) error {
 	now := time.Now()
 
 	// Update some statistics
+	h.maybeStartLossRateWindow(now)
 	h.packets++
 
 	// XXX RTO and TLP are recomputed based on the possible last sent retransmission. Is it ok like this?
@@ -163,9 +244,13 @@ func (h *sentPacketHandler) SentPacket(packet *Packet) error {
 
 	if isRetransmittable {
 		packet.SendTime = now
+		packet.AppLimited = h.appLimited
 		h.bytesInFlight += packet.Length
 		h.packetHistory.PushBack(*packet)
 		h.numNonRetransmittablePackets = 0
+		if h.pacingGain > 0 {
+			h.onPacketPaced(now, packet.Length)
+		}
 	} else {
 		h.numNonRetransmittablePackets++
 	}
@@ -192,11 +277,24 @@ func (h *sentPacketHandler) ReceivedAck(ackFrame *wire.AckFrame, withPacketNumbe
 		return ErrDuplicateOrOutOfOrderAck
 	}
 	h.largestReceivedPacketWithAck = withPacketNumber
+	return h.handleAck(ackFrame, rcvTime)
+}
+
+// ReceivedCrossPathAck handles an ACK received on another path. The packet numbers of that path can't be compared
+// to the ones of the packets carrying ACKs on this path, so the caller checks the ordering of these ACKs.
+func (h *sentPacketHandler) ReceivedCrossPathAck(ackFrame *wire.AckFrame, rcvTime time.Time) error {
+	if ackFrame.LargestAcked > h.lastSentPacketNumber {
+		return errAckForUnsentPacket
+	}
+	return h.handleAck(ackFrame, rcvTime)
+}
 
+func (h *sentPacketHandler) handleAck(ackFrame *wire.AckFrame, rcvTime time.Time) error {
 	// ignore repeated ACK (ACKs that don't have a higher LargestAcked than the last ACK)
 	if ackFrame.LargestAcked <= h.largestInOrderAcked() {
 		return nil
 	}
+	previousLargestAcked := h.LargestAcked
 	h.LargestAcked = ackFrame.LargestAcked
 
 	if h.skippedPacketsAcked(ackFrame) {
@@ -215,22 +313,29 @@ func (h *sentPacketHandler) ReceivedAck(ackFrame *wire.AckFrame, withPacketNumbe
 	}
 
 	flag := 0
-	var sentDelay time.Duration
+	var sampleDelay time.Duration
+	var appLimited bool
 	if len(ackedPackets) > 0 {
 		preInflight := h.bytesInFlight
-		if utils.Debug() {
-			utils.Debugf("In test: now preInflight = %d bytes", preInflight)
+		if h.logger.Debug() {
+			h.logger.Debugf("In test: now preInflight = %d bytes", preInflight)
 		}
 		for _, p := range ackedPackets {
 			packet := p.Value
 			if packet.PacketNumber == ackFrame.LargestAcked {
-				flag = 1
-				sentDelay = rcvTime.Sub(packet.SendTime)
-				if sentDelay > ackFrame.DelayTime {
-					sentDelay -= ackFrame.DelayTime
-				}
-				if utils.Debug() {
-					utils.Debugf("In test: now sentDelay = %s ", sentDelay.String())
+				var ok bool
+				if sampleDelay, ok = bdwSampleDelay(packet.SendTime, rcvTime, ackFrame.DelayTime); ok {
+					deliveryTime := rcvTime.Add(-ackFrame.DelayTime)
+					if sampleDelay, ok = h.bdwSampleInterval(deliveryTime, sampleDelay); ok {
+						flag = 1
+						appLimited = packet.AppLimited
+						if h.logger.Debug() {
+							h.logger.Debugf("In test: now sampleDelay = %s ", sampleDelay.String())
+						}
+					}
+					h.largestAckedDeliveryTime = utils.MaxTime(h.largestAckedDeliveryTime, deliveryTime)
+				} else if h.logger.Debug() {
+					h.logger.Debugf("Path %x: ignoring bandwidth sample, ACK delay %s is larger than the %s since sending", h.pathID, ackFrame.DelayTime, rcvTime.Sub(packet.SendTime))
 				}
 			}
 
@@ -238,16 +343,21 @@ func (h *sentPacketHandler) ReceivedAck(ackFrame *wire.AckFrame, withPacketNumbe
 			h.congestion.OnPacketAcked(p.Value.PacketNumber, p.Value.Length, h.bytesInFlight)
 		}
 
-		changeInflight := preInflight - h.bytesInFlight
-		if utils.Debug() {
-			utils.Debugf("In test:  preInflight = %d, h.bytesInFlight = %d, changeInflight = %d", preInflight, h.bytesInFlight, changeInflight)
+		delivered := bdwSampleBytes(ackedPackets, previousLargestAcked)
+		if h.logger.Debug() {
+			h.logger.Debugf("In test:  preInflight = %d, h.bytesInFlight = %d, delivered = %d", preInflight, h.bytesInFlight, delivered)
 		}
-		if flag == 1 {
-			h.bdwStats.UpdateBDW(changeInflight, sentDelay)
+		if flag == 1 && delivered > 0 {
+			h.bdwStats.UpdateBDW(delivered, sampleDelay, appLimited)
 		}
 
 	}
 
+	// the app-limited period ends once the packets in flight when it started are delivered
+	if h.appLimited && ackFrame.LargestAcked > h.appLimitedUntil {
+		h.appLimited = false
+	}
+
 	h.detectLostPackets()
 	h.updateLossDetectionAlarm()
 
@@ -288,7 +398,8 @@ func (h *sentPacketHandler) ReceivedClosePath(f *wire.ClosePathFrame, withPacket
 		}
 	}
 
-	h.SetInflightAsLost()
+	// the path is gone, its packets in flight are no congestion signal
+	h.setInflightAsLost(!h.ignoreClosePathLosses)
 
 	h.garbageCollectSkippedPackets()
 	// We do not send any STOP WAITING Frames, so no need to update the manager
@@ -372,6 +483,43 @@ func (h *sentPacketHandler) determineNewlyAckedPacketsClosePath(f *wire.ClosePat
 	return ackedPackets, nil
 }
 
+// bdwSampleDelay returns the delay between sending a packet and receiving its ACK, without the ACK delay reported by the peer.
+// It returns false if the reported ACK delay is implausible, i.e. not shorter than the time elapsed since sending.
+func bdwSampleDelay(sendTime, rcvTime time.Time, ackDelay time.Duration) (time.Duration, bool) {
+	elapsed := rcvTime.Sub(sendTime)
+	if elapsed <= 0 || ackDelay < 0 || ackDelay >= elapsed {
+		return 0, false
+	}
+	return utils.MaxDuration(elapsed-ackDelay, minBDWSampleDelay), true
+}
+
+// bdwSampleInterval returns the time between the deliveries of the previous and the current largest acked packet,
+// over which the bytes of bdwSampleBytes were delivered. The first sample falls back to the delay since sending the packet.
+// An ACK delivered before the previous largest acked one, judging by its DelayTime, gives no sample.
+func (h *sentPacketHandler) bdwSampleInterval(deliveryTime time.Time, sentDelay time.Duration) (time.Duration, bool) {
+	if h.largestAckedDeliveryTime.IsZero() {
+		return sentDelay, true
+	}
+	interval := deliveryTime.Sub(h.largestAckedDeliveryTime)
+	if interval <= 0 {
+		return 0, false
+	}
+	return utils.MaxDuration(interval, minBDWSampleDelay), true
+}
+
+// bdwSampleBytes returns the bytes delivered between the previous and the current largest acked packet.
+// Newly acked packets below the previous largest acked were delivered in an earlier interval,
+// e.g. reordered packets or packets filling a gap of a previous ACK, and would inflate the sample.
+func bdwSampleBytes(ackedPackets []*PacketElement, previousLargestAcked protocol.PacketNumber) protocol.ByteCount {
+	var delivered protocol.ByteCount
+	for _, p := range ackedPackets {
+		if p.Value.PacketNumber > previousLargestAcked {
+			delivered += p.Value.Length
+		}
+	}
+	return delivered
+}
+
 func (h *sentPacketHandler) maybeUpdateRTT(largestAcked protocol.PacketNumber, ackDelay time.Duration, rcvTime time.Time) bool {
 	for el := h.packetHistory.Front(); el != nil; el = el.Next() {
 		packet := el.Value
@@ -432,7 +580,8 @@ func (h *sentPacketHandler) detectLostPackets() {
 		}
 
 		timeSinceSent := now.Sub(packet.SendTime)
-		if timeSinceSent > delayUntilLost {
+		reordered := h.packetReorderingThreshold > 0 && packet.PacketNumber+h.packetReorderingThreshold <= h.LargestAcked
+		if timeSinceSent > delayUntilLost || reordered {
 			// Update statistics
 			h.losses++
 			lostPackets = append(lostPackets, el)
@@ -444,13 +593,18 @@ func (h *sentPacketHandler) detectLostPackets() {
 
 	if len(lostPackets) > 0 {
 		for _, p := range lostPackets {
-			h.queuePacketForRetransmission(p)
+			h.reportLostPacket(p)
+			h.queuePacketForRetransmission(p, lossRetransmission)
 			h.congestion.OnPacketLost(p.Value.PacketNumber, p.Value.Length, h.bytesInFlight)
 		}
 	}
 }
 
 func (h *sentPacketHandler) SetInflightAsLost() {
+	h.setInflightAsLost(true)
+}
+
+func (h *sentPacketHandler) setInflightAsLost(notifyCongestion bool) {
 	var lostPackets []*PacketElement
 	for el := h.packetHistory.Front(); el != nil; el = el.Next() {
 		packet := el.Value
@@ -465,13 +619,62 @@ func (h *sentPacketHandler) SetInflightAsLost() {
 
 	if len(lostPackets) > 0 {
 		for _, p := range lostPackets {
-			h.queuePacketForRetransmission(p)
+			h.reportLostPacket(p)
+			h.queuePacketForRetransmission(p, lossRetransmission)
 			// XXX (QDC): should we?
-			h.congestion.OnPacketLost(p.Value.PacketNumber, p.Value.Length, h.bytesInFlight)
+			if notifyCongestion {
+				h.congestion.OnPacketLost(p.Value.PacketNumber, p.Value.Length, h.bytesInFlight)
+			}
 		}
 	}
 }
 
+func (h *sentPacketHandler) reportLostPacket(packetElement *PacketElement) {
+	if h.lostPacketCallback == nil {
+		return
+	}
+	packet := packetElement.Value
+	h.lostPacketCallback(&packet)
+}
+
+// RetransmitStreamData queues the outstanding packets carrying data of the given
+// stream range for retransmission, without waiting for the loss detection alarm.
+// Packets sent less than an RTT ago may only be reordered behind the packets of a faster path, so they stay in flight.
+// The peer only hints at the missing data, so the congestion controller and the loss counters are left alone.
+func (h *sentPacketHandler) RetransmitStreamData(streamID protocol.StreamID, offset protocol.ByteCount, length protocol.ByteCount) bool {
+	var missingPackets []*PacketElement
+	sentBefore := time.Now().Add(-h.rttStats.SmoothedRTT())
+	for el := h.packetHistory.Front(); el != nil; el = el.Next() {
+		if el.Value.SendTime.After(sentBefore) {
+			break
+		}
+		for _, f := range el.Value.Frames {
+			sf, ok := f.(*wire.StreamFrame)
+			if !ok || sf.StreamID != streamID {
+				continue
+			}
+			if sf.Offset < offset+length && sf.Offset+sf.DataLen() > offset {
+				missingPackets = append(missingPackets, el)
+				break
+			}
+		}
+	}
+
+	for _, p := range missingPackets {
+		h.queuePacketForRetransmission(p, lossRetransmission)
+	}
+	if len(missingPackets) > 0 {
+		h.updateLossDetectionAlarm()
+	}
+	return len(missingPackets) > 0
+}
+
+// OnConnectionMigration resets the congestion controller to slow start and clears the RTT statistics
+func (h *sentPacketHandler) OnConnectionMigration() {
+	h.congestion.OnConnectionMigration()
+	h.rttStats.OnConnectionMigration()
+}
+
 func (h *sentPacketHandler) OnAlarm() {
 	// Do we really have packet to retransmit?
 	if !h.hasOutstandingRetransmittablePacket() {
@@ -492,7 +695,7 @@ func (h *sentPacketHandler) OnAlarm() {
 	} else {
 		// RTO
 		potentiallyFailed := false
-		if h.onRTOCallback != nil {
+		if h.onRTOCallback != nil && h.rtoCount+1 >= h.potentiallyFailedRTOs {
 			potentiallyFailed = h.onRTOCallback(h.lastSentTime)
 		}
 		if potentiallyFailed {
@@ -510,11 +713,81 @@ func (h *sentPacketHandler) GetAlarmTimeout() time.Time {
 	return h.alarm
 }
 
+// TimeUntilSend returns the time at which the congestion controller paces the next packet.
+// It returns a zero time if sending is not delayed by pacing.
+func (h *sentPacketHandler) TimeUntilSend() time.Time {
+	now := time.Now()
+	delay := h.congestion.TimeUntilSend(now, h.bytesInFlight)
+	if delay == utils.InfDuration {
+		return time.Time{}
+	}
+	if h.paced(now) {
+		delay = utils.MaxDuration(delay, h.nextPacketTime.Sub(now)-h.pacingBurstDelay())
+	}
+	if delay <= 0 {
+		return time.Time{}
+	}
+	return now.Add(delay)
+}
+
+// onPacketPaced delays the next packet by the pacing time of a packet of the given length sent at now
+func (h *sentPacketHandler) onPacketPaced(now time.Time, length protocol.ByteCount) {
+	h.nextPacketTime = utils.MaxTime(h.nextPacketTime, now).Add(h.pacingDelay(now, length))
+}
+
+// paced returns true if pacing delays the next packet at the given time,
+// i.e. the path ran ahead of its pacing rate by more than pacingBurstQuantum
+func (h *sentPacketHandler) paced(now time.Time) bool {
+	return h.pacingGain > 0 && h.nextPacketTime.Sub(now) > h.pacingBurstDelay()
+}
+
+// pacingBurstDelay returns how far ahead of its pacing rate a path may run, at a gain of 1 in every phase of the cycle
+func (h *sentPacketHandler) pacingBurstDelay() time.Duration {
+	srtt := h.rttStats.SmoothedRTT()
+	congestionWindow := h.GetCongestionWindow()
+	if srtt == 0 || congestionWindow == 0 {
+		return 0
+	}
+	return time.Duration(float64(srtt) * float64(pacingBurstQuantum) / float64(congestionWindow))
+}
+
+// pacingDelay returns the time to wait after sending a packet of the given length.
+// No delay is applied before the first RTT sample.
+func (h *sentPacketHandler) pacingDelay(now time.Time, length protocol.ByteCount) time.Duration {
+	srtt := h.rttStats.SmoothedRTT()
+	congestionWindow := h.GetCongestionWindow()
+	if srtt == 0 || congestionWindow == 0 {
+		return 0
+	}
+	return time.Duration(float64(srtt) * float64(length) / float64(congestionWindow) / h.currentPacingGain(now, srtt))
+}
+
+// currentPacingGain returns the gain of the phase of the pacing cycle at the given time
+func (h *sentPacketHandler) currentPacingGain(now time.Time, srtt time.Duration) float64 {
+	if h.pacingCycleStart.IsZero() {
+		h.pacingCycleStart = now
+	}
+	switch (now.Sub(h.pacingCycleStart) / srtt) % pacingCycleRTTs {
+	case 0:
+		// probe for more bandwidth
+		return h.pacingGain
+	case 1:
+		// drain the queue built up while probing
+		return 1 / h.pacingGain
+	default:
+		return 1
+	}
+}
+
 func (h *sentPacketHandler) onPacketAcked(packetElement *PacketElement) {
 	h.bytesInFlight -= packetElement.Value.Length
 	h.rtoCount = 0
 	h.tlpCount = 0
 	h.packetHistory.Remove(packetElement)
+	if h.ackedPacketCallback != nil {
+		packet := packetElement.Value
+		h.ackedPacketCallback(&packet)
+	}
 }
 
 func (h *sentPacketHandler) DequeuePacketForRetransmission() *Packet {
@@ -526,6 +799,9 @@ func (h *sentPacketHandler) DequeuePacketForRetransmission() *Packet {
 	copy(h.retransmissionQueue, h.retransmissionQueue[1:])
 	h.retransmissionQueue[len(h.retransmissionQueue)-1] = nil
 	h.retransmissionQueue = h.retransmissionQueue[:len(h.retransmissionQueue)-1]
+	if packet.retransmissionCause == tlpRetransmission {
+		h.queuedTLPs--
+	}
 	// Update statistics
 	h.retransmissions++
 	return packet
@@ -540,24 +816,124 @@ func (h *sentPacketHandler) GetStopWaitingFrame(force bool) *wire.StopWaitingFra
 }
 
 func (h *sentPacketHandler) SendingAllowed() bool {
-	congestionLimited := h.bytesInFlight > h.congestion.GetCongestionWindow()
+	congestionLimited := h.bytesInFlight > h.GetCongestionWindow()
+	paced := h.paced(time.Now())
 	maxTrackedLimited := protocol.PacketNumber(len(h.retransmissionQueue)+h.packetHistory.Len()) >= protocol.MaxTrackedSentPackets
 	if congestionLimited {
-		utils.Debugf("Congestion limited: Path %x, bytes in flight %d, window %d",
+		h.logger.Debugf("Congestion limited: Path %x, bytes in flight %d, window %d",
 			h.pathID,
 			h.bytesInFlight,
-			h.congestion.GetCongestionWindow())
+			h.GetCongestionWindow())
 	}
 	// Workaround for #555:
-	// Always allow sending of retransmissions. This should probably be limited
-	// to RTOs, but we currently don't have a nice way of distinguishing them.
-	haveRetransmissions := len(h.retransmissionQueue) > 0
-	return !maxTrackedLimited && (!congestionLimited || haveRetransmissions)
+	// Always allow sending of retransmissions, except for TLPs. A TLP is only a probe,
+	// so it has to respect the congestion window like new data.
+	haveRetransmissions := len(h.retransmissionQueue) > h.queuedTLPs
+	return !maxTrackedLimited && ((!congestionLimited && !paced) || haveRetransmissions)
+}
+
+// InRecovery returns true if the congestion controller reduced its window after a loss,
+// and no packet sent since then was acked yet
+func (h *sentPacketHandler) InRecovery() bool {
+	return h.congestion.InRecovery()
+}
+
+// PacketNumbersExhausted returns true once the packet numbers sent came within protocol.PacketNumberExhaustionMargin of protocol.MaxPacketNumber
+func (h *sentPacketHandler) PacketNumbersExhausted() bool {
+	return h.lastSentPacketNumber >= protocol.MaxPacketNumber-protocol.PacketNumberExhaustionMargin
+}
+
+// GetCongestionWindow returns the congestion window used to limit sending, in bytes
+func (h *sentPacketHandler) GetCongestionWindow() protocol.ByteCount {
+	if h.congestionWindowOverride != 0 {
+		return h.congestionWindowOverride
+	}
+	return h.congestion.GetCongestionWindow()
+}
+
+// GetBytesInFlight returns the number of bytes sent and neither acked nor declared lost
+func (h *sentPacketHandler) GetBytesInFlight() protocol.ByteCount {
+	return h.bytesInFlight
+}
+
+// GetPacketsInFlight returns the number of retransmittable packets sent and neither acked nor declared lost
+func (h *sentPacketHandler) GetPacketsInFlight() int {
+	return h.packetHistory.Len()
+}
+
+// SetCongestionWindow overrides the congestion window of the congestion controller.
+// A value of 0 removes the override.
+func (h *sentPacketHandler) SetCongestionWindow(window protocol.ByteCount) {
+	h.congestionWindowOverride = window
+}
+
+// GetSkippedPackets returns the skipped packet numbers that are currently tracked
+func (h *sentPacketHandler) GetSkippedPackets() []protocol.PacketNumber {
+	skipped := make([]protocol.PacketNumber, len(h.skippedPackets))
+	copy(skipped, h.skippedPackets)
+	return skipped
+}
+
+// SetPacketReorderingThreshold enables the packet-threshold loss detection, 0 disables it
+func (h *sentPacketHandler) SetPacketReorderingThreshold(threshold protocol.PacketNumber) {
+	h.packetReorderingThreshold = threshold
+}
+
+// SetPotentiallyFailedRTOs sets the number of consecutive RTOs before the path can be marked potentially failed.
+// 0 and 1 mark it on the first RTO.
+func (h *sentPacketHandler) SetPotentiallyFailedRTOs(n uint32) {
+	h.potentiallyFailedRTOs = n
+}
+
+// SetIgnoreClosePathLosses keeps the packets in flight when the peer closes the path from being reported to the congestion controller
+func (h *sentPacketHandler) SetIgnoreClosePathLosses(ignore bool) {
+	h.ignoreClosePathLosses = ignore
+}
+
+// SetLostPacketCallback sets a callback called for every packet declared lost, nil removes it
+func (h *sentPacketHandler) SetLostPacketCallback(callback func(packet *Packet)) {
+	h.lostPacketCallback = callback
+}
+
+// SetAckedPacketCallback sets a callback called for every packet acked by the peer, nil removes it
+func (h *sentPacketHandler) SetAckedPacketCallback(callback func(packet *Packet)) {
+	h.ackedPacketCallback = callback
+}
+
+// OnAppLimited marks the packets sent from now on as app-limited, until a packet sent after the last one sent so far is acked,
+// and tells the congestion controller
+func (h *sentPacketHandler) OnAppLimited() {
+	h.congestion.OnAppLimited()
+	h.appLimited = true
+	h.appLimitedUntil = h.lastSentPacketNumber
+}
+
+// SetPacingGain enables pacing with the given gain, 0 disables it
+func (h *sentPacketHandler) SetPacingGain(gain float64) {
+	h.pacingGain = gain
+	h.pacingCycleStart = time.Time{}
+	h.nextPacketTime = time.Time{}
+}
+
+// SetMaxTrackedSkippedPackets sets the maximum number of skipped packet numbers to keep track of.
+// A value of 0 restores the default.
+func (h *sentPacketHandler) SetMaxTrackedSkippedPackets(n int) {
+	h.maxTrackedSkippedPackets = n
+	if max := h.getMaxTrackedSkippedPackets(); len(h.skippedPackets) > max {
+		h.skippedPackets = h.skippedPackets[len(h.skippedPackets)-max:]
+	}
+}
+
+func (h *sentPacketHandler) getMaxTrackedSkippedPackets() int {
+	if h.maxTrackedSkippedPackets > 0 {
+		return h.maxTrackedSkippedPackets
+	}
+	return protocol.MaxTrackedSkippedPackets
 }
 
 func (h *sentPacketHandler) retransmitTLP() {
 	if p := h.packetHistory.Back(); p != nil {
-		h.queuePacketForRetransmission(p)
+		h.queuePacketForRetransmission(p, tlpRetransmission)
 	}
 }
 
@@ -582,18 +958,22 @@ func (h *sentPacketHandler) retransmitOldestTwoPackets() {
 
 func (h *sentPacketHandler) queueRTO(el *PacketElement) {
 	packet := &el.Value
-	utils.Debugf(
+	h.logger.Debugf(
 		"\tQueueing packet 0x%x for retransmission (RTO), %d outstanding",
 		packet.PacketNumber,
 		h.packetHistory.Len(),
 	)
-	h.queuePacketForRetransmission(el)
+	h.queuePacketForRetransmission(el, rtoRetransmission)
 	h.losses++
 	h.congestion.OnPacketLost(packet.PacketNumber, packet.Length, h.bytesInFlight)
 }
 
-func (h *sentPacketHandler) queuePacketForRetransmission(packetElement *PacketElement) {
+func (h *sentPacketHandler) queuePacketForRetransmission(packetElement *PacketElement, cause retransmissionCause) {
 	packet := &packetElement.Value
+	packet.retransmissionCause = cause
+	if cause == tlpRetransmission {
+		h.queuedTLPs++
+	}
 	h.bytesInFlight -= packet.Length
 	h.retransmissionQueue = append(h.retransmissionQueue, packet)
 	h.packetHistory.Remove(packetElement)
@@ -601,6 +981,7 @@ func (h *sentPacketHandler) queuePacketForRetransmission(packetElement *PacketEl
 }
 
 func (h *sentPacketHandler) DuplicatePacket(packet *Packet) {
+	packet.retransmissionCause = lossRetransmission
 	h.retransmissionQueue = append(h.retransmissionQueue, packet)
 }
 
@@ -5,22 +5,26 @@ import (
 
 	"github.com/lucas-clemente/pstream/congestion"
 	"github.com/lucas-clemente/pstream/internal/protocol"
+	"github.com/lucas-clemente/pstream/internal/utils"
 	"github.com/lucas-clemente/pstream/internal/wire"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
 
 type mockCongestion struct {
+	timeUntilSend           time.Duration
 	argsOnPacketSent        []interface{}
 	maybeExitSlowStart      bool
 	onRetransmissionTimeout bool
 	getCongestionWindow     bool
+	onAppLimited            bool
+	inRecovery              bool
 	packetsAcked            [][]interface{}
 	packetsLost             [][]interface{}
 }
 
 func (m *mockCongestion) TimeUntilSend(now time.Time, bytesInFlight protocol.ByteCount) time.Duration {
-	panic("not implemented")
+	return m.timeUntilSend
 }
 
 func (m *mockCongestion) OnPacketSent(sentTime time.Time, bytesInFlight protocol.ByteCount, packetNumber protocol.PacketNumber, bytes protocol.ByteCount, isRetransmittable bool) bool {
@@ -41,6 +45,14 @@ func (m *mockCongestion) OnRetransmissionTimeout(packetsRetransmitted bool) {
 	m.onRetransmissionTimeout = true
 }
 
+func (m *mockCongestion) OnAppLimited() {
+	m.onAppLimited = true
+}
+
+func (m *mockCongestion) InRecovery() bool {
+	return m.inRecovery
+}
+
 func (m *mockCongestion) RetransmissionDelay() time.Duration {
 	return defaultRTOTimeout
 }
@@ -75,7 +87,7 @@ var _ = Describe("SentPacketHandler", func() {
 	BeforeEach(func() {
 		rttStats := &congestion.RTTStats{}
 		bdwStats := &congestion.BDWStats{}
-		handler = NewSentPacketHandler(0, rttStats, bdwStats, nil, nil).(*sentPacketHandler)
+		handler = NewSentPacketHandler(0, rttStats, bdwStats, nil, nil, utils.Logger{}).(*sentPacketHandler)
 		streamFrame = wire.StreamFrame{
 			StreamID: 5,
 			Data:     []byte{0x13, 0x37},
@@ -136,6 +148,23 @@ var _ = Describe("SentPacketHandler", func() {
 			Expect(handler.bytesInFlight).To(Equal(protocol.ByteCount(1)))
 		})
 
+		It("detects when the packet numbers come close to the largest one", func() {
+			handler.lastSentPacketNumber = protocol.MaxPacketNumber - protocol.PacketNumberExhaustionMargin - 1
+			Expect(handler.PacketNumbersExhausted()).To(BeFalse())
+			err := handler.SentPacket(&Packet{PacketNumber: handler.lastSentPacketNumber + 1, Frames: []wire.Frame{&streamFrame}, Length: 1})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(handler.PacketNumbersExhausted()).To(BeTrue())
+		})
+
+		It("rejects packet numbers that can't be encoded", func() {
+			handler.lastSentPacketNumber = protocol.MaxPacketNumber - 1
+			err := handler.SentPacket(&Packet{PacketNumber: protocol.MaxPacketNumber, Frames: []wire.Frame{&streamFrame}, Length: 1})
+			Expect(err).ToNot(HaveOccurred())
+			err = handler.SentPacket(&Packet{PacketNumber: protocol.MaxPacketNumber + 1, Frames: []wire.Frame{&streamFrame}, Length: 1})
+			Expect(err).To(MatchError(ErrPacketNumberSpaceExhausted))
+			Expect(handler.lastSentPacketNumber).To(Equal(protocol.MaxPacketNumber))
+		})
+
 		It("stores the sent time", func() {
 			packet := Packet{PacketNumber: 1, Frames: []wire.Frame{&streamFrame}, Length: 1}
 			err := handler.SentPacket(&packet)
@@ -203,6 +232,30 @@ var _ = Describe("SentPacketHandler", func() {
 				Expect(handler.skippedPackets[protocol.MaxTrackedSkippedPackets-1]).To(Equal(protocol.PacketNumber(10 + 2*(protocol.MaxTrackedSkippedPackets-1))))
 			})
 
+			It("limits the lengths of the skipped packet slice to the configured maximum", func() {
+				handler.SetMaxTrackedSkippedPackets(3)
+				for i := 0; i < 6; i++ {
+					packet := Packet{PacketNumber: protocol.PacketNumber(2*i + 1), Frames: []wire.Frame{&streamFrame}, Length: 1}
+					err := handler.SentPacket(&packet)
+					Expect(err).ToNot(HaveOccurred())
+				}
+				Expect(handler.GetSkippedPackets()).To(Equal([]protocol.PacketNumber{6, 8, 10}))
+			})
+
+			It("trims the skipped packet slice when the maximum is lowered", func() {
+				handler.skippedPackets = []protocol.PacketNumber{2, 4, 6, 8}
+				handler.SetMaxTrackedSkippedPackets(2)
+				Expect(handler.GetSkippedPackets()).To(Equal([]protocol.PacketNumber{6, 8}))
+				handler.SetMaxTrackedSkippedPackets(0)
+				Expect(handler.getMaxTrackedSkippedPackets()).To(Equal(protocol.MaxTrackedSkippedPackets))
+			})
+
+			It("returns a copy of the skipped packets", func() {
+				handler.skippedPackets = []protocol.PacketNumber{2, 4}
+				handler.GetSkippedPackets()[0] = 3
+				Expect(handler.skippedPackets).To(Equal([]protocol.PacketNumber{2, 4}))
+			})
+
 			Context("garbage collection", func() {
 				It("keeps all packet numbers above the LargestAcked", func() {
 					handler.skippedPackets = []protocol.PacketNumber{2, 5, 8, 10}
@@ -320,6 +373,21 @@ var _ = Describe("SentPacketHandler", func() {
 				Expect(handler.bytesInFlight).To(Equal(protocol.ByteCount(len(packets) - 3)))
 			})
 
+			It("doesn't order ACKs received on another path with the ones of this path", func() {
+				err := handler.ReceivedCrossPathAck(&wire.AckFrame{LargestAcked: 3, LowestAcked: 1}, time.Now())
+				Expect(err).ToNot(HaveOccurred())
+				Expect(handler.bytesInFlight).To(Equal(protocol.ByteCount(len(packets) - 3)))
+				Expect(handler.largestReceivedPacketWithAck).To(BeZero())
+				err = handler.ReceivedAck(&wire.AckFrame{LargestAcked: 4, LowestAcked: 1}, 1, time.Now())
+				Expect(err).ToNot(HaveOccurred())
+				Expect(handler.LargestAcked).To(Equal(protocol.PacketNumber(4)))
+			})
+
+			It("rejects ACKs received on another path for unsent packets", func() {
+				err := handler.ReceivedCrossPathAck(&wire.AckFrame{LargestAcked: handler.lastSentPacketNumber + 1, LowestAcked: 1}, time.Now())
+				Expect(err).To(MatchError(errAckForUnsentPacket))
+			})
+
 			It("rejects ACKs with a too high LargestAcked packet number", func() {
 				ack := wire.AckFrame{
 					LargestAcked: packets[len(packets)-1].PacketNumber + 1337,
@@ -563,6 +631,163 @@ var _ = Describe("SentPacketHandler", func() {
 				Expect(handler.rttStats.LatestRTT()).To(BeNumerically("~", 5*time.Minute, 1*time.Second))
 			})
 		})
+
+		Context("bandwidth samples", func() {
+			It("subtracts the DelayTime in the ack frame", func() {
+				now := time.Now()
+				delay, ok := bdwSampleDelay(now.Add(-10*time.Millisecond), now, 4*time.Millisecond)
+				Expect(ok).To(BeTrue())
+				Expect(delay).To(Equal(6 * time.Millisecond))
+			})
+
+			It("clamps the delay to a minimum positive value", func() {
+				now := time.Now()
+				delay, ok := bdwSampleDelay(now.Add(-10*time.Millisecond), now, 10*time.Millisecond-time.Nanosecond)
+				Expect(ok).To(BeTrue())
+				Expect(delay).To(Equal(minBDWSampleDelay))
+			})
+
+			It("ignores samples with an implausible DelayTime", func() {
+				now := time.Now()
+				_, ok := bdwSampleDelay(now.Add(-10*time.Millisecond), now, 20*time.Millisecond)
+				Expect(ok).To(BeFalse())
+				_, ok = bdwSampleDelay(now.Add(-10*time.Millisecond), now, 10*time.Millisecond)
+				Expect(ok).To(BeFalse())
+				_, ok = bdwSampleDelay(now.Add(time.Millisecond), now, 0)
+				Expect(ok).To(BeFalse())
+			})
+
+			It("only counts the bytes delivered since the previous largest acked", func() {
+				acked := []*PacketElement{
+					{Value: Packet{PacketNumber: 2, Length: 1000}},
+					{Value: Packet{PacketNumber: 4, Length: 500}},
+					{Value: Packet{PacketNumber: 5, Length: 300}},
+				}
+				Expect(bdwSampleBytes(acked, 3)).To(Equal(protocol.ByteCount(800)))
+				Expect(bdwSampleBytes(acked, 0)).To(Equal(protocol.ByteCount(1800)))
+				Expect(bdwSampleBytes(acked, 5)).To(BeZero())
+			})
+
+			It("doesn't count a reordered packet acked late in the sample", func() {
+				// packet 2 is missing from the first ACK
+				ack := &wire.AckFrame{
+					LargestAcked: 3,
+					LowestAcked:  1,
+					AckRanges:    []wire.AckRange{{First: 3, Last: 3}, {First: 1, Last: 1}},
+				}
+				Expect(handler.ReceivedAck(ack, 1, time.Now())).To(Succeed())
+				Expect(handler.LargestAcked).To(Equal(protocol.PacketNumber(3)))
+				// the second ACK acks packets 2 and 4, but only packet 4 was delivered in the new interval
+				acked, err := handler.determineNewlyAckedPackets(&wire.AckFrame{LargestAcked: 4, LowestAcked: 1})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(acked).To(HaveLen(2))
+				Expect(bdwSampleBytes(acked, handler.LargestAcked)).To(Equal(protocol.ByteCount(1)))
+				Expect(handler.ReceivedAck(&wire.AckFrame{LargestAcked: 4, LowestAcked: 1}, 2, time.Now())).To(Succeed())
+				Expect(handler.bytesInFlight).To(Equal(protocol.ByteCount(len(packets) - 4)))
+			})
+
+			It("divides the bytes delivered by the time between the deliveries of the largest acked packets", func() {
+				now := time.Now()
+				for i := 1; i <= 5; i++ {
+					getPacketElement(protocol.PacketNumber(i)).Value.SendTime = now.Add(-50 * time.Millisecond)
+				}
+				Expect(handler.ReceivedAck(&wire.AckFrame{LargestAcked: 1, LowestAcked: 1}, 1, now)).To(Succeed())
+				// the first sample falls back to the delay since sending
+				Expect(handler.bdwStats.GetBandwidthBps()).To(Equal(20 * congestion.BytesPerSecond))
+				Expect(handler.largestAckedDeliveryTime).To(Equal(now))
+				// packets 2 to 5 were delivered within 10ms after packet 1, not over the 60ms since they were sent
+				ack := &wire.AckFrame{LargestAcked: 5, LowestAcked: 1, DelayTime: 5 * time.Millisecond}
+				Expect(handler.ReceivedAck(ack, 2, now.Add(15*time.Millisecond))).To(Succeed())
+				Expect(handler.bdwStats.GetBandwidthBps()).To(Equal(400 * congestion.BytesPerSecond))
+				Expect(handler.largestAckedDeliveryTime).To(Equal(now.Add(10 * time.Millisecond)))
+			})
+
+			It("takes no sample from an ACK delivered before the previous largest acked packet", func() {
+				now := time.Now()
+				handler.largestAckedDeliveryTime = now
+				getPacketElement(2).Value.SendTime = now.Add(-50 * time.Millisecond)
+				ack := &wire.AckFrame{LargestAcked: 2, LowestAcked: 1, DelayTime: 10 * time.Millisecond}
+				Expect(handler.ReceivedAck(ack, 1, now.Add(5*time.Millisecond))).To(Succeed())
+				Expect(handler.bdwStats.GetBandwidth()).To(BeZero())
+				Expect(handler.largestAckedDeliveryTime).To(Equal(now))
+			})
+
+			It("marks the packets sent during an app-limited period", func() {
+				handler.OnAppLimited()
+				Expect(handler.SentPacket(&Packet{PacketNumber: 13, Frames: []wire.Frame{&streamFrame}, Length: 1})).To(Succeed())
+				Expect(getPacketElement(12).Value.AppLimited).To(BeFalse())
+				Expect(getPacketElement(13).Value.AppLimited).To(BeTrue())
+				// the period lasts until a packet sent after it started is acked
+				Expect(handler.ReceivedAck(&wire.AckFrame{LargestAcked: 10, LowestAcked: 1}, 1, time.Now())).To(Succeed())
+				Expect(handler.appLimited).To(BeTrue())
+				Expect(handler.ReceivedAck(&wire.AckFrame{LargestAcked: 13, LowestAcked: 12}, 2, time.Now())).To(Succeed())
+				Expect(handler.appLimited).To(BeFalse())
+				Expect(handler.SentPacket(&Packet{PacketNumber: 14, Frames: []wire.Frame{&streamFrame}, Length: 1})).To(Succeed())
+				Expect(getPacketElement(14).Value.AppLimited).To(BeFalse())
+			})
+
+			It("doesn't lower the bandwidth with the ACKs of app-limited packets", func() {
+				handler = NewSentPacketHandler(0, &congestion.RTTStats{}, congestion.NewBDWStats(0), nil, nil, utils.Logger{}).(*sentPacketHandler)
+				start := time.Now()
+				// sends a packet every 10ms, the peer receives it 10ms later and acks it right away
+				deliver := func(pn protocol.PacketNumber, length protocol.ByteCount) {
+					Expect(handler.SentPacket(&Packet{PacketNumber: pn, Frames: []wire.Frame{&streamFrame}, Length: length})).To(Succeed())
+					sendTime := start.Add(time.Duration(pn) * 10 * time.Millisecond)
+					getPacketElement(pn).Value.SendTime = sendTime
+					Expect(handler.ReceivedAck(&wire.AckFrame{LargestAcked: pn, LowestAcked: pn}, pn, sendTime.Add(10*time.Millisecond))).To(Succeed())
+				}
+				deliver(1, 2000)
+				Expect(handler.bdwStats.GetBandwidthBps()).To(Equal(200000 * congestion.BytesPerSecond))
+				// the application only writes 100 bytes every 10ms
+				pn := protocol.PacketNumber(2)
+				for ; pn < 22; pn++ {
+					handler.OnAppLimited()
+					deliver(pn, 100)
+				}
+				Expect(handler.bdwStats.GetBandwidthBps()).To(Equal(200000 * congestion.BytesPerSecond))
+				// the samples taken while the congestion window limits the path replace the estimate
+				for i := 0; i < 10; i++ {
+					deliver(pn, 100)
+					pn++
+				}
+				Expect(handler.bdwStats.GetBandwidthBps()).To(Equal(10000 * congestion.BytesPerSecond))
+			})
+
+			It("follows the delivery rate of the ACKs", func() {
+				handler = NewSentPacketHandler(0, &congestion.RTTStats{}, congestion.NewBDWStats(0), nil, nil, utils.Logger{}).(*sentPacketHandler)
+				ackTime := time.Now()
+				pn := protocol.PacketNumber(1)
+				// the peer acks a packet of 1000 bytes every interval
+				deliver := func(interval time.Duration, count int) {
+					for i := 0; i < count; i++ {
+						Expect(handler.SentPacket(&Packet{PacketNumber: pn, Frames: []wire.Frame{&streamFrame}, Length: 1000})).To(Succeed())
+						getPacketElement(pn).Value.SendTime = ackTime
+						ackTime = ackTime.Add(interval)
+						Expect(handler.ReceivedAck(&wire.AckFrame{LargestAcked: pn, LowestAcked: pn}, pn, ackTime)).To(Succeed())
+						pn++
+					}
+				}
+				deliver(10*time.Millisecond, 5)
+				Expect(handler.bdwStats.GetBandwidthBps()).To(Equal(100000 * congestion.BytesPerSecond))
+				// a higher delivery rate raises the estimate right away
+				deliver(5*time.Millisecond, 5)
+				Expect(handler.bdwStats.GetBandwidthBps()).To(Equal(200000 * congestion.BytesPerSecond))
+				// a lower one once the higher samples left the window
+				deliver(10*time.Millisecond, 9)
+				Expect(handler.bdwStats.GetBandwidthBps()).To(Equal(200000 * congestion.BytesPerSecond))
+				deliver(10*time.Millisecond, 1)
+				Expect(handler.bdwStats.GetBandwidthBps()).To(Equal(100000 * congestion.BytesPerSecond))
+			})
+
+			It("safely processes an ACK with a DelayTime larger than the time since sending", func() {
+				getPacketElement(1).Value.SendTime = time.Now().Add(-10 * time.Millisecond)
+				err := handler.ReceivedAck(&wire.AckFrame{LargestAcked: 1, DelayTime: time.Hour}, 1, time.Now())
+				Expect(err).NotTo(HaveOccurred())
+				Expect(handler.bdwStats.GetBandwidth()).To(BeZero())
+				Expect(handler.rttStats.LatestRTT()).To(BeNumerically("~", 10*time.Millisecond, 5*time.Millisecond))
+				Expect(handler.packetHistory.Front().Value.PacketNumber).To(Equal(protocol.PacketNumber(2)))
+			})
+		})
 	})
 
 	Context("Retransmission handling", func() {
@@ -613,7 +838,7 @@ var _ = Describe("SentPacketHandler", func() {
 			})
 
 			It("gets a StopWaitingFrame after queueing a retransmission", func() {
-				handler.queuePacketForRetransmission(getPacketElement(5))
+				handler.queuePacketForRetransmission(getPacketElement(5), lossRetransmission)
 				Expect(handler.GetStopWaitingFrame(false)).To(Equal(&wire.StopWaitingFrame{LeastUnacked: 6}))
 			})
 		})
@@ -679,6 +904,54 @@ var _ = Describe("SentPacketHandler", func() {
 			Expect(cong.argsOnPacketSent[4]).To(BeTrue())
 		})
 
+		It("tells the congestion controller about app-limited periods", func() {
+			handler.OnAppLimited()
+			Expect(cong.onAppLimited).To(BeTrue())
+		})
+
+		It("tells if the congestion controller is in recovery", func() {
+			Expect(handler.InRecovery()).To(BeFalse())
+			cong.inRecovery = true
+			Expect(handler.InRecovery()).To(BeTrue())
+			cong.inRecovery = false
+			Expect(handler.InRecovery()).To(BeFalse())
+		})
+
+		Context("closing the path", func() {
+			BeforeEach(func() {
+				for i := protocol.PacketNumber(1); i <= 3; i++ {
+					err := handler.SentPacket(retransmittablePacket(i))
+					Expect(err).NotTo(HaveOccurred())
+				}
+				handler.LargestAcked = 3
+			})
+
+			It("reports the packets in flight as lost", func() {
+				err := handler.ReceivedClosePath(&wire.ClosePathFrame{LargestAcked: 1, LowestAcked: 1}, 1, time.Now())
+				Expect(err).NotTo(HaveOccurred())
+				Expect(cong.packetsAcked).To(HaveLen(1))
+				Expect(cong.packetsLost).To(HaveLen(2))
+			})
+
+			It("doesn't report the packets in flight as lost, if disabled", func() {
+				handler.SetIgnoreClosePathLosses(true)
+				err := handler.ReceivedClosePath(&wire.ClosePathFrame{LargestAcked: 1, LowestAcked: 1}, 1, time.Now())
+				Expect(err).NotTo(HaveOccurred())
+				Expect(cong.packetsAcked).To(HaveLen(1))
+				Expect(cong.packetsLost).To(BeEmpty())
+				// the packets are retransmitted anyway
+				Expect(handler.DequeuePacketForRetransmission().PacketNumber).To(Equal(protocol.PacketNumber(2)))
+				Expect(handler.DequeuePacketForRetransmission().PacketNumber).To(Equal(protocol.PacketNumber(3)))
+				Expect(handler.bytesInFlight).To(BeZero())
+			})
+
+			It("still reports the packets as lost when the path fails", func() {
+				handler.SetIgnoreClosePathLosses(true)
+				handler.SetInflightAsLost()
+				Expect(cong.packetsLost).To(HaveLen(3))
+			})
+		})
+
 		It("should call MaybeExitSlowStart and OnPacketAcked", func() {
 			handler.SentPacket(retransmittablePacket(1))
 			handler.SentPacket(retransmittablePacket(2))
@@ -717,6 +990,38 @@ var _ = Describe("SentPacketHandler", func() {
 			Expect(handler.SendingAllowed()).To(BeFalse())
 		})
 
+		It("returns the pacing time of the congestion controller", func() {
+			cong.timeUntilSend = 10 * time.Millisecond
+			Expect(handler.TimeUntilSend()).To(BeTemporally("~", time.Now().Add(10*time.Millisecond), time.Millisecond))
+		})
+
+		It("returns a zero pacing time if sending is not delayed by pacing", func() {
+			Expect(handler.TimeUntilSend().IsZero()).To(BeTrue())
+			cong.timeUntilSend = utils.InfDuration
+			Expect(handler.TimeUntilSend().IsZero()).To(BeTrue())
+		})
+
+		It("reads the congestion window of the congestion controller", func() {
+			Expect(handler.GetCongestionWindow()).To(Equal(protocol.DefaultTCPMSS))
+			Expect(cong.getCongestionWindow).To(BeTrue())
+		})
+
+		It("allows or denies sending based on the overridden congestion window", func() {
+			err := handler.SentPacket(&Packet{
+				PacketNumber: 1,
+				Frames:       []wire.Frame{&wire.PingFrame{}},
+				Length:       protocol.DefaultTCPMSS + 1,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(handler.SendingAllowed()).To(BeFalse())
+			handler.SetCongestionWindow(2 * protocol.DefaultTCPMSS)
+			Expect(handler.GetCongestionWindow()).To(Equal(2 * protocol.DefaultTCPMSS))
+			Expect(handler.SendingAllowed()).To(BeTrue())
+			handler.SetCongestionWindow(0)
+			Expect(handler.GetCongestionWindow()).To(Equal(protocol.DefaultTCPMSS))
+			Expect(handler.SendingAllowed()).To(BeFalse())
+		})
+
 		It("allows or denies sending based on the number of tracked packets", func() {
 			Expect(handler.SendingAllowed()).To(BeTrue())
 			handler.retransmissionQueue = make([]*Packet, protocol.MaxTrackedSentPackets)
@@ -734,6 +1039,100 @@ var _ = Describe("SentPacketHandler", func() {
 			handler.retransmissionQueue = []*Packet{nil}
 			Expect(handler.SendingAllowed()).To(BeTrue())
 		})
+
+		Context("retransmissions of TLPs and RTOs", func() {
+			BeforeEach(func() {
+				handler.rttStats.UpdateRTT(time.Second, 0, time.Now())
+				for i := protocol.PacketNumber(1); i <= 3; i++ {
+					err := handler.SentPacket(&Packet{
+						PacketNumber: i,
+						Frames:       []wire.Frame{&streamFrame},
+						Length:       protocol.DefaultTCPMSS,
+					})
+					Expect(err).NotTo(HaveOccurred())
+				}
+				handler.SetCongestionWindow(protocol.DefaultTCPMSS / 2)
+				Expect(handler.SendingAllowed()).To(BeFalse())
+			})
+
+			It("doesn't allow sending a TLP if congestion limited", func() {
+				handler.OnAlarm() // TLP
+				Expect(handler.retransmissionQueue).To(HaveLen(1))
+				Expect(handler.retransmissionQueue[0].retransmissionCause).To(Equal(tlpRetransmission))
+				Expect(handler.SendingAllowed()).To(BeFalse())
+				Expect(handler.DequeuePacketForRetransmission()).ToNot(BeNil())
+				Expect(handler.queuedTLPs).To(BeZero())
+			})
+
+			It("allows sending an RTO if congestion limited", func() {
+				handler.tlpCount = maxTailLossProbes
+				handler.OnAlarm() // RTO
+				Expect(handler.retransmissionQueue).To(HaveLen(2))
+				Expect(handler.retransmissionQueue[0].retransmissionCause).To(Equal(rtoRetransmission))
+				Expect(handler.SendingAllowed()).To(BeTrue())
+			})
+		})
+	})
+
+	Context("loss rate", func() {
+		It("is zero if no packets were sent", func() {
+			Expect(handler.GetLossRate()).To(BeZero())
+		})
+
+		It("computes the fraction of lost packets", func() {
+			for i := protocol.PacketNumber(1); i <= 4; i++ {
+				err := handler.SentPacket(retransmittablePacket(i))
+				Expect(err).NotTo(HaveOccurred())
+			}
+			handler.tlpCount = maxTailLossProbes
+			handler.OnAlarm() // RTO, meaning 2 lost packets
+			Expect(handler.GetLossRate()).To(Equal(0.5))
+		})
+
+		It("forgets the losses of old windows", func() {
+			for i := protocol.PacketNumber(1); i <= 4; i++ {
+				err := handler.SentPacket(retransmittablePacket(i))
+				Expect(err).NotTo(HaveOccurred())
+			}
+			handler.tlpCount = maxTailLossProbes
+			handler.OnAlarm()
+			handler.lossRateWindowStart = handler.lossRateWindowStart.Add(-2 * lossRateWindow)
+			Expect(handler.GetLossRate()).To(BeZero())
+			err := handler.SentPacket(retransmittablePacket(5))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(handler.GetLossRate()).To(BeZero())
+		})
+
+		It("keeps the losses of the previous window", func() {
+			for i := protocol.PacketNumber(1); i <= 4; i++ {
+				err := handler.SentPacket(retransmittablePacket(i))
+				Expect(err).NotTo(HaveOccurred())
+			}
+			handler.tlpCount = maxTailLossProbes
+			handler.OnAlarm()
+			handler.lossRateWindowStart = handler.lossRateWindowStart.Add(-lossRateWindow)
+			Expect(handler.GetLossRate()).To(Equal(0.5))
+		})
+	})
+
+	Context("connection migration", func() {
+		It("goes back to slow start and clears the RTT statistics", func() {
+			initialWindow := handler.congestion.GetCongestionWindow()
+			Expect(initialWindow).To(Equal(protocol.InitialCongestionWindow * protocol.DefaultTCPMSS))
+			handler.rttStats.UpdateRTT(100*time.Millisecond, 0, time.Now())
+			for i := protocol.PacketNumber(1); i <= 3; i++ {
+				err := handler.SentPacket(retransmittablePacket(i))
+				Expect(err).NotTo(HaveOccurred())
+			}
+			handler.tlpCount = maxTailLossProbes
+			handler.OnAlarm() // RTO, shrinking the congestion window
+			Expect(handler.congestion.GetCongestionWindow()).To(BeNumerically("<", initialWindow))
+
+			handler.OnConnectionMigration()
+			Expect(handler.congestion.GetCongestionWindow()).To(Equal(initialWindow))
+			Expect(handler.rttStats.SmoothedRTT()).To(BeZero())
+			Expect(handler.rttStats.MinRTT()).To(BeZero())
+		})
 	})
 
 	Context("calculating RTO", func() {
@@ -814,6 +1213,259 @@ var _ = Describe("SentPacketHandler", func() {
 		})
 	})
 
+	Context("Packet-threshold loss detection", func() {
+		BeforeEach(func() {
+			for i := protocol.PacketNumber(1); i <= 5; i++ {
+				err := handler.SentPacket(retransmittablePacket(i))
+				Expect(err).NotTo(HaveOccurred())
+			}
+		})
+
+		It("detects a packet as lost before the time threshold", func() {
+			handler.SetPacketReorderingThreshold(3)
+			err := handler.ReceivedAck(&wire.AckFrame{LargestAcked: 5, LowestAcked: 5}, 1, time.Now().Add(time.Hour))
+			Expect(err).NotTo(HaveOccurred())
+			// packets 1 and 2 are at least 3 packet numbers behind, packets 3 and 4 wait for the time threshold
+			packet := handler.DequeuePacketForRetransmission()
+			Expect(packet).ToNot(BeNil())
+			Expect(packet.PacketNumber).To(Equal(protocol.PacketNumber(1)))
+			packet = handler.DequeuePacketForRetransmission()
+			Expect(packet).ToNot(BeNil())
+			Expect(packet.PacketNumber).To(Equal(protocol.PacketNumber(2)))
+			Expect(handler.DequeuePacketForRetransmission()).To(BeNil())
+			Expect(handler.lossTime.Sub(time.Now())).To(BeNumerically("~", time.Hour*9/8, time.Minute))
+		})
+
+		It("only uses the time threshold if disabled", func() {
+			err := handler.ReceivedAck(&wire.AckFrame{LargestAcked: 5, LowestAcked: 5}, 1, time.Now().Add(time.Hour))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(handler.DequeuePacketForRetransmission()).To(BeNil())
+			Expect(handler.lossTime.IsZero()).To(BeFalse())
+		})
+	})
+
+	Context("reporting lost packets", func() {
+		var lost []*Packet
+
+		BeforeEach(func() {
+			lost = nil
+			handler.SetLostPacketCallback(func(p *Packet) { lost = append(lost, p) })
+			for i := protocol.PacketNumber(1); i <= 3; i++ {
+				err := handler.SentPacket(&Packet{
+					PacketNumber: i,
+					Length:       100,
+					Frames:       []wire.Frame{&wire.StreamFrame{StreamID: 5, Offset: protocol.ByteCount(i) * 10, Data: []byte("foobar")}},
+				})
+				Expect(err).NotTo(HaveOccurred())
+			}
+		})
+
+		It("reports the packets detected as lost", func() {
+			handler.SetPacketReorderingThreshold(2)
+			err := handler.ReceivedAck(&wire.AckFrame{LargestAcked: 3, LowestAcked: 3}, 1, time.Now().Add(time.Hour))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(lost).To(HaveLen(1))
+			Expect(lost[0].PacketNumber).To(Equal(protocol.PacketNumber(1)))
+			Expect(lost[0].Frames).To(Equal([]wire.Frame{&wire.StreamFrame{StreamID: 5, Offset: 10, Data: []byte("foobar")}}))
+		})
+
+		It("reports the packets in flight declared as lost", func() {
+			handler.LargestAcked = 3
+			handler.SetInflightAsLost()
+			Expect(lost).To(HaveLen(3))
+			Expect(lost[2].PacketNumber).To(Equal(protocol.PacketNumber(3)))
+		})
+
+		It("doesn't report acknowledged packets", func() {
+			err := handler.ReceivedAck(&wire.AckFrame{LargestAcked: 3, LowestAcked: 1}, 1, time.Now())
+			Expect(err).NotTo(HaveOccurred())
+			handler.SetInflightAsLost()
+			Expect(lost).To(BeEmpty())
+		})
+	})
+
+	Context("reporting acked packets", func() {
+		It("reports the packets acked by the peer", func() {
+			var acked []*Packet
+			handler.SetAckedPacketCallback(func(p *Packet) { acked = append(acked, p) })
+			for i := protocol.PacketNumber(1); i <= 3; i++ {
+				err := handler.SentPacket(&Packet{
+					PacketNumber: i,
+					Length:       100,
+					Frames:       []wire.Frame{&wire.StreamFrame{StreamID: 5, Offset: protocol.ByteCount(i) * 10, Data: []byte("foobar")}},
+				})
+				Expect(err).NotTo(HaveOccurred())
+			}
+			err := handler.ReceivedAck(&wire.AckFrame{LargestAcked: 3, LowestAcked: 2}, 1, time.Now())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(acked).To(HaveLen(2))
+			Expect(acked[0].PacketNumber).To(Equal(protocol.PacketNumber(2)))
+			Expect(acked[1].Frames).To(Equal([]wire.Frame{&wire.StreamFrame{StreamID: 5, Offset: 30, Data: []byte("foobar")}}))
+		})
+	})
+
+	Context("pacing", func() {
+		BeforeEach(func() {
+			handler.rttStats.UpdateRTT(100*time.Millisecond, 0, time.Now())
+			handler.SetCongestionWindow(10000)
+		})
+
+		sendPacket := func(pn protocol.PacketNumber) {
+			err := handler.SentPacket(&Packet{PacketNumber: pn, Length: 1000, Frames: []wire.Frame{&wire.PingFrame{}}})
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		It("doesn't pace if not enabled", func() {
+			sendPacket(1)
+			Expect(handler.SendingAllowed()).To(BeTrue())
+			Expect(handler.TimeUntilSend().IsZero()).To(BeTrue())
+		})
+
+		It("paces the packets at the rate of the congestion window per RTT", func() {
+			handler.SetPacingGain(1)
+			// the burst quantum of 2920 bytes lets 3 packets go out back-to-back
+			for pn := protocol.PacketNumber(1); pn <= 3; pn++ {
+				Expect(handler.SendingAllowed()).To(BeTrue())
+				sendPacket(pn)
+			}
+			Expect(handler.SendingAllowed()).To(BeFalse())
+			Expect(handler.pacingBurstDelay()).To(Equal(29200 * time.Microsecond))
+			Expect(handler.TimeUntilSend()).To(BeTemporally("~", time.Now().Add(30*time.Millisecond-handler.pacingBurstDelay()), time.Millisecond))
+			sendPacket(4)
+			Expect(handler.TimeUntilSend()).To(BeTemporally("~", time.Now().Add(40*time.Millisecond-handler.pacingBurstDelay()), time.Millisecond))
+		})
+
+		It("sends faster in the first RTT of a cycle and slower in the second one", func() {
+			handler.SetPacingGain(1.25)
+			for pn := protocol.PacketNumber(1); pn <= 4; pn++ {
+				sendPacket(pn)
+			}
+			Expect(handler.TimeUntilSend()).To(BeTemporally("~", time.Now().Add(32*time.Millisecond-handler.pacingBurstDelay()), time.Millisecond))
+			start := handler.pacingCycleStart
+			Expect(handler.pacingDelay(start.Add(150*time.Millisecond), 1000)).To(Equal(12500 * time.Microsecond))
+			Expect(handler.pacingDelay(start.Add(250*time.Millisecond), 1000)).To(Equal(10 * time.Millisecond))
+			Expect(handler.pacingDelay(start.Add(850*time.Millisecond), 1000)).To(Equal(8 * time.Millisecond))
+		})
+
+		It("increases the send rate transiently with a gain larger than 1", func() {
+			handler.SetPacingGain(1.25)
+			start := time.Now()
+			var sent [3]int
+			for t := start; t.Before(start.Add(300 * time.Millisecond)); t = t.Add(handler.pacingDelay(t, 1000)) {
+				sent[t.Sub(start)/(100*time.Millisecond)]++
+			}
+			Expect(sent[0]).To(Equal(13))
+			Expect(sent[1]).To(Equal(8))
+			Expect(sent[2]).To(Equal(10))
+		})
+
+		It("achieves the pacing rate at the gain once the burst quantum is used", func() {
+			handler.SetPacingGain(1.25)
+			start := time.Now()
+			var burst protocol.ByteCount
+			var sent [3]protocol.ByteCount
+			// a sender checking every 100µs if pacing lets the next packet go
+			for t := start; t.Before(start.Add(300 * time.Millisecond)); t = t.Add(100 * time.Microsecond) {
+				if handler.paced(t) {
+					continue
+				}
+				handler.onPacketPaced(t, 1000)
+				if t.Sub(start) < time.Millisecond {
+					burst += 1000
+				}
+				// the rate in each RTT, after the transition from the previous one
+				if t.Sub(start)%(100*time.Millisecond) >= 20*time.Millisecond {
+					sent[t.Sub(start)/(100*time.Millisecond)] += 1000
+				}
+			}
+			// the quantum is measured at the unscaled rate, it lets more bytes through at a gain larger than 1
+			Expect(burst).To(BeNumerically(">=", pacingBurstQuantum))
+			Expect(burst).To(BeNumerically("<", 1.25*float64(pacingBurstQuantum)+1000))
+			// 1.25 times, 1/1.25 times and once the congestion window of 10000 bytes per RTT, over 80ms
+			Expect(sent[0]).To(BeNumerically("~", 10000, 1000))
+			Expect(sent[1]).To(BeNumerically("~", 6400, 1000))
+			Expect(sent[2]).To(BeNumerically("~", 8000, 1000))
+		})
+
+		It("doesn't pace before the first RTT sample", func() {
+			handler.rttStats = &congestion.RTTStats{}
+			handler.SetPacingGain(1.25)
+			sendPacket(1)
+			Expect(handler.SendingAllowed()).To(BeTrue())
+		})
+
+		It("doesn't pace retransmissions", func() {
+			handler.SetPacingGain(1)
+			for pn := protocol.PacketNumber(1); pn <= 3; pn++ {
+				sendPacket(pn)
+			}
+			Expect(handler.SendingAllowed()).To(BeFalse())
+			handler.retransmissionQueue = []*Packet{retransmittablePacket(1)}
+			Expect(handler.SendingAllowed()).To(BeTrue())
+		})
+	})
+
+	Context("retransmitting missing stream data", func() {
+		streamPacket := func(num protocol.PacketNumber, streamID protocol.StreamID, offset protocol.ByteCount) *Packet {
+			return &Packet{PacketNumber: num, Length: 100, Frames: []wire.Frame{
+				&wire.StreamFrame{StreamID: streamID, Offset: offset, Data: make([]byte, 100)},
+			}}
+		}
+
+		BeforeEach(func() {
+			for i := protocol.PacketNumber(1); i <= 3; i++ {
+				err := handler.SentPacket(streamPacket(i, 5, protocol.ByteCount(i-1)*100))
+				Expect(err).NotTo(HaveOccurred())
+			}
+			err := handler.SentPacket(streamPacket(4, 7, 100))
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("queues a missing middle chunk before the RTO expires", func() {
+			Expect(handler.GetAlarmTimeout().Sub(time.Now())).To(BeNumerically(">", minRTOTimeout/2))
+			Expect(handler.RetransmitStreamData(5, 100, 100)).To(BeTrue())
+			p := handler.DequeuePacketForRetransmission()
+			Expect(p).ToNot(BeNil())
+			Expect(p.PacketNumber).To(Equal(protocol.PacketNumber(2)))
+			Expect(handler.DequeuePacketForRetransmission()).To(BeNil())
+			Expect(handler.bytesInFlight).To(Equal(protocol.ByteCount(300)))
+			Expect(getPacketElement(2)).To(BeNil())
+		})
+
+		It("doesn't report the queued packets as lost", func() {
+			cong := &mockCongestion{}
+			handler.congestion = cong
+			Expect(handler.RetransmitStreamData(5, 100, 100)).To(BeTrue())
+			Expect(cong.packetsLost).To(BeEmpty())
+			Expect(handler.losses).To(BeZero())
+		})
+
+		It("leaves the packets sent less than an RTT ago in flight", func() {
+			handler.rttStats.UpdateRTT(time.Second, 0, time.Now())
+			Expect(handler.RetransmitStreamData(5, 100, 100)).To(BeFalse())
+			Expect(getPacketElement(2)).ToNot(BeNil())
+			for pn := protocol.PacketNumber(1); pn <= 2; pn++ {
+				getPacketElement(pn).Value.SendTime = time.Now().Add(-2 * time.Second)
+			}
+			Expect(handler.RetransmitStreamData(5, 100, 100)).To(BeTrue())
+			Expect(handler.DequeuePacketForRetransmission().PacketNumber).To(Equal(protocol.PacketNumber(2)))
+		})
+
+		It("queues all packets overlapping the range", func() {
+			Expect(handler.RetransmitStreamData(5, 150, 100)).To(BeTrue())
+			Expect(handler.DequeuePacketForRetransmission().PacketNumber).To(Equal(protocol.PacketNumber(2)))
+			Expect(handler.DequeuePacketForRetransmission().PacketNumber).To(Equal(protocol.PacketNumber(3)))
+			Expect(handler.DequeuePacketForRetransmission()).To(BeNil())
+		})
+
+		It("doesn't queue anything if no outstanding packet carries the range", func() {
+			Expect(handler.RetransmitStreamData(5, 300, 100)).To(BeFalse())
+			Expect(handler.RetransmitStreamData(9, 100, 100)).To(BeFalse())
+			Expect(handler.DequeuePacketForRetransmission()).To(BeNil())
+			Expect(handler.bytesInFlight).To(Equal(protocol.ByteCount(400)))
+		})
+	})
+
 	Context("RTO retransmission", func() {
 		It("queues two packets if RTO expires", func() {
 			err := handler.SentPacket(retransmittablePacket(1))
@@ -837,5 +1489,52 @@ var _ = Describe("SentPacketHandler", func() {
 
 			Expect(handler.rtoCount).To(BeEquivalentTo(1))
 		})
+
+		Context("marking the path as potentially failed", func() {
+			var rtoCallbacks int
+
+			BeforeEach(func() {
+				rtoCallbacks = 0
+				handler.onRTOCallback = func(time.Time) bool {
+					rtoCallbacks++
+					return true
+				}
+				for i := protocol.PacketNumber(1); i <= 6; i++ {
+					err := handler.SentPacket(retransmittablePacket(i))
+					Expect(err).NotTo(HaveOccurred())
+				}
+				// Disable TLP
+				handler.tlpCount = maxTailLossProbes
+			})
+
+			It("marks the path on the first RTO by default", func() {
+				handler.OnAlarm()
+				Expect(rtoCallbacks).To(Equal(1))
+				// all packets are retransmitted on a potentially failed path
+				Expect(handler.packetHistory.Len()).To(BeZero())
+			})
+
+			It("waits for the configured number of consecutive RTOs", func() {
+				handler.SetPotentiallyFailedRTOs(2)
+				handler.OnAlarm()
+				Expect(rtoCallbacks).To(BeZero())
+				// only the oldest two packets are retransmitted
+				Expect(handler.packetHistory.Len()).To(Equal(4))
+				handler.OnAlarm()
+				Expect(rtoCallbacks).To(Equal(1))
+				Expect(handler.packetHistory.Len()).To(BeZero())
+			})
+
+			It("starts counting again after an ACK", func() {
+				handler.SetPotentiallyFailedRTOs(2)
+				handler.OnAlarm()
+				err := handler.ReceivedAck(&wire.AckFrame{LargestAcked: 3, LowestAcked: 3}, 1, time.Now())
+				Expect(err).NotTo(HaveOccurred())
+				Expect(handler.rtoCount).To(BeZero())
+				handler.tlpCount = maxTailLossProbes
+				handler.OnAlarm()
+				Expect(rtoCallbacks).To(BeZero())
+			})
+		})
 	})
 })
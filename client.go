@@ -42,6 +42,7 @@ var (
 	// make it possible to mock connection ID generation in the tests
 	generateConnectionID         = utils.GenerateConnectionID
 	errCloseSessionForNewVersion = errors.New("closing session in order to recreate it with a new version")
+	errNoVersions                = errors.New("no QUIC version left to offer")
 )
 
 // DialAddr establishes a new QUIC connection to a server.
@@ -52,7 +53,7 @@ func DialAddr(addr string, tlsConf *tls.Config, config *Config) (Session, error)
 		return nil, err
 	}
 	// Create the pconnManager here. It will be used to manage UDP connections
-	pconnMgr := &pconnManager{perspective: protocol.PerspectiveClient}
+	pconnMgr := &pconnManager{perspective: protocol.PerspectiveClient, config: config}
 	err = pconnMgr.setup(nil, nil)
 	if err != nil {
 		return nil, err
@@ -72,7 +73,7 @@ func DialAddrNonFWSecure(
 		return nil, err
 	}
 	// Create the pconnManager here. It will be used to manage UDP connections
-	pconnMgr := &pconnManager{perspective: protocol.PerspectiveClient}
+	pconnMgr := &pconnManager{perspective: protocol.PerspectiveClient, config: config}
 	err = pconnMgr.setup(nil, nil)
 	if err != nil {
 		return nil, err
@@ -110,7 +111,7 @@ func DialNonFWSecure(
 	var pconnMgr *pconnManager
 
 	if pconnMgrArg == nil {
-		pconnMgr = &pconnManager{perspective: protocol.PerspectiveClient}
+		pconnMgr = &pconnManager{perspective: protocol.PerspectiveClient, config: config}
 		err := pconnMgr.setup(pconn, nil)
 		if err != nil {
 			return nil, err
@@ -120,6 +121,9 @@ func DialNonFWSecure(
 	}
 
 	clientConfig := populateClientConfig(config)
+	if len(clientConfig.Versions) == 0 {
+		return nil, errNoVersions
+	}
 	c := &client{
 		pconnMgr:               pconnMgr,
 		connectionID:           connID,
@@ -170,6 +174,7 @@ func populateClientConfig(config *Config) *Config {
 	if len(versions) == 0 {
 		versions = protocol.SupportedVersions
 	}
+	versions = protocol.PreferMultipath(versions, !config.DisableMultipath)
 
 	handshakeTimeout := protocol.DefaultHandshakeTimeout
 	if config.HandshakeTimeout != 0 {
@@ -193,17 +198,82 @@ func populateClientConfig(config *Config) *Config {
 	if pathScheduler == "" {
 		pathScheduler = protocol.DefaultPathScheduler
 	}
+	pathSwitchMargin := config.PathSwitchMargin
+	if pathSwitchMargin == 0 {
+		pathSwitchMargin = protocol.DefaultPathSwitchMargin
+	}
+	highPriorityWeight := config.HighPriorityWeight
+	if highPriorityWeight == 0 {
+		highPriorityWeight = protocol.DefaultHighPriorityWeight
+	}
+	minPathsForSplit := config.MinPathsForSplit
+	if minPathsForSplit == 0 {
+		minPathsForSplit = protocol.DefaultMinPathsForSplit
+	}
+	pathSendBatchSize := config.PathSendBatchSize
+	if pathSendBatchSize == 0 {
+		pathSendBatchSize = protocol.DefaultPathSendBatchSize
+	}
+	maxRemotePaths := config.MaxRemotePaths
+	if maxRemotePaths == 0 {
+		maxRemotePaths = protocol.DefaultMaxRemotePaths
+	}
 	return &Config{
 		Versions:                              versions,
+		DisableMultipath:                      config.DisableMultipath,
 		HandshakeTimeout:                      handshakeTimeout,
 		IdleTimeout:                           idleTimeout,
 		RequestConnectionIDTruncation:         config.RequestConnectionIDTruncation,
 		MaxReceiveStreamFlowControlWindow:     maxReceiveStreamFlowControlWindow,
 		MaxReceiveConnectionFlowControlWindow: maxReceiveConnectionFlowControlWindow,
-		KeepAlive:      config.KeepAlive,
-		CacheHandshake: config.CacheHandshake,
-		CreatePaths:    config.CreatePaths,
-		PathScheduler:  pathScheduler,
+		KeepAlive:                             config.KeepAlive,
+		CacheHandshake:                        config.CacheHandshake,
+		CreatePaths:                           config.CreatePaths,
+		PathScheduler:                         pathScheduler,
+		PathCost:                              config.PathCost,
+		PathGroup:                             config.PathGroup,
+		PathGroupBandwidth:                    config.PathGroupBandwidth,
+		PathMTU:                               config.PathMTU,
+		RTTEstimator:                          config.RTTEstimator,
+		Metrics:                               config.Metrics,
+		InitialPathPolicy:                     config.InitialPathPolicy,
+		AckPathPolicy:                         config.AckPathPolicy,
+		PathsFrameOrder:                       config.PathsFrameOrder,
+		PathSwitchMargin:                      pathSwitchMargin,
+		ReservedBandwidth:                     config.ReservedBandwidth,
+		HighPriorityWeight:                    highPriorityWeight,
+		DumpSchedulingInputs:                  config.DumpSchedulingInputs,
+		ControlStreams:                        config.ControlStreams,
+		SocketReceiveBufferSize:               config.SocketReceiveBufferSize,
+		SocketSendBufferSize:                  config.SocketSendBufferSize,
+		MaxCoalescedPackets:                   config.MaxCoalescedPackets,
+		ResetCongestionOnMigration:            config.ResetCongestionOnMigration,
+		PacketReorderingThreshold:             config.PacketReorderingThreshold,
+		IgnoreClosePathLosses:                 config.IgnoreClosePathLosses,
+		StreamFrameChecksums:                  config.StreamFrameChecksums,
+		PotentiallyFailedRTOs:                 config.PotentiallyFailedRTOs,
+		MaxTrackedSkippedPackets:              config.MaxTrackedSkippedPackets,
+		MaxReassemblyBuffer:                   config.MaxReassemblyBuffer,
+		PacingGain:                            config.PacingGain,
+		LostPacketHandler:                     config.LostPacketHandler,
+		MaxSendRate:                           config.MaxSendRate,
+		SendWindowUpdatesOnce:                 config.SendWindowUpdatesOnce,
+		SendTimestamps:                        config.SendTimestamps,
+		MaxAckPacketTolerance:                 config.MaxAckPacketTolerance,
+		MinPathsForSplit:                      minPathsForSplit,
+		MinDetectedStreamSize:                 config.MinDetectedStreamSize,
+		MaxPathsPerStream:                     config.MaxPathsPerStream,
+		MaxStreamsPerPath:                     config.MaxStreamsPerPath,
+		PathKeepAlivePeriod:                   config.PathKeepAlivePeriod,
+		PathSendBatchSize:                     pathSendBatchSize,
+		MaxPathRetransmissionRate:             config.MaxPathRetransmissionRate,
+		MaxRemotePaths:                        maxRemotePaths,
+		RequireAdvertisedRemotePaths:          config.RequireAdvertisedRemotePaths,
+		DatagramHandler:                       config.DatagramHandler,
+		StreamResetHandler:                    config.StreamResetHandler,
+		PathStatsRecorder:                     config.PathStatsRecorder,
+		PathStatsReplay:                       config.PathStatsReplay,
+		TraceWriter:                           config.TraceWriter,
 	}
 }
 
@@ -282,6 +282,25 @@ var _ = Describe("Client", func() {
 			Expect(c.RequestConnectionIDTruncation).To(BeFalse())
 		})
 
+		It("prefers the multipath version by default", func() {
+			c := populateClientConfig(&Config{Versions: []protocol.VersionNumber{protocol.Version39, protocol.VersionMP}})
+			Expect(c.Versions).To(Equal([]protocol.VersionNumber{protocol.VersionMP, protocol.Version39}))
+		})
+
+		It("doesn't offer the multipath version if multipath is disabled", func() {
+			c := populateClientConfig(&Config{DisableMultipath: true})
+			Expect(c.DisableMultipath).To(BeTrue())
+			Expect(c.Versions).ToNot(ContainElement(protocol.VersionMP))
+			Expect(c.Versions[0]).To(Equal(protocol.Version39))
+		})
+
+		It("errors if no version is left to offer", func() {
+			config.Versions = []protocol.VersionNumber{protocol.VersionMP}
+			config.DisableMultipath = true
+			_, err := Dial(packetConn, addr, "quic.clemente.io:1337", nil, config, pconnMgr)
+			Expect(err).To(MatchError(errNoVersions))
+		})
+
 		It("errors when receiving an error from the connection", func(done Done) {
 			testErr := errors.New("connection error")
 			packetConn.readErr = testErr
@@ -11,9 +11,10 @@ type BDWStats struct {
 	bandwidth       Bandwidth //  bit per second
 	compareWindow   [10]Bandwidth
 	roundRobinIndex uint8 //  resume where ended
+	numSamples      uint32
 }
 
-// NewBDWStats makes a properly initialized BDWStats object
+// NewBDWStats makes a properly initialized BDWStats object, the bandwidth is an initial estimate replaced by the first sample
 func NewBDWStats(bandwidth Bandwidth) *BDWStats {
 	return &BDWStats{
 		bandwidth: bandwidth,
@@ -23,24 +24,39 @@ func NewBDWStats(bandwidth Bandwidth) *BDWStats {
 //GetBandwidth returns estimated bandwidth in Mbps
 func (b *BDWStats) GetBandwidth() Bandwidth { return b.bandwidth / Bandwidth(1048576) }
 
-// UpdateBDW updates the bandwidth based on a new sample.
-func (b *BDWStats) UpdateBDW(sentDelta protocol.ByteCount, sentDelay time.Duration) {
-	disable := true
-	if !disable {
+// GetBandwidthBps returns estimated bandwidth in bit per second
+func (b *BDWStats) GetBandwidthBps() Bandwidth { return b.bandwidth }
 
-		bdw := Bandwidth(sentDelta) * Bandwidth(time.Second) / Bandwidth(sentDelay) * BytesPerSecond
-		size := uint8(len(b.compareWindow))
-		startIndex := b.roundRobinIndex
-		b.compareWindow[(startIndex)%size] = bdw
+// SetBandwidth overrides the estimated bandwidth, in bit per second
+func (b *BDWStats) SetBandwidth(bandwidth Bandwidth) { b.bandwidth = bandwidth }
 
-		b.roundRobinIndex = (b.roundRobinIndex + 1) % size
+// NumSamples returns the number of samples taken into the estimate, the initial bandwidth isn't one.
+func (b *BDWStats) NumSamples() uint32 { return b.numSamples }
 
-		for i := uint8(0); i < size; i++ {
+// BDP returns the bandwidth-delay product for the given RTT, in bytes
+func (b *BDWStats) BDP(rtt time.Duration) protocol.ByteCount {
+	return protocol.ByteCount(float64(b.bandwidth/BytesPerSecond) * rtt.Seconds())
+}
 
-			if b.bandwidth < b.compareWindow[i] {
-				b.bandwidth = b.compareWindow[i]
-			}
-		}
+// UpdateBDW updates the bandwidth based on a new sample, the estimate is the highest of the last samples.
+// An app-limited sample, taken while the application didn't use the whole congestion window, is only used if it raises the estimate.
+func (b *BDWStats) UpdateBDW(sentDelta protocol.ByteCount, sentDelay time.Duration, appLimited bool) {
+	bdw := Bandwidth(sentDelta) * Bandwidth(time.Second) / Bandwidth(sentDelay) * BytesPerSecond
+	// the sample measures how fast the application wrote, not the bandwidth of the path
+	if appLimited && bdw <= b.bandwidth {
+		return
+	}
+	b.numSamples++
+	size := uint8(len(b.compareWindow))
+	startIndex := b.roundRobinIndex
+	b.compareWindow[(startIndex)%size] = bdw
 
+	b.roundRobinIndex = (b.roundRobinIndex + 1) % size
+
+	b.bandwidth = 0
+	for i := uint8(0); i < size; i++ {
+		if b.bandwidth < b.compareWindow[i] {
+			b.bandwidth = b.compareWindow[i]
+		}
 	}
 }
@@ -0,0 +1,59 @@
+package congestion
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("BDW stats", func() {
+	var bdwStats *BDWStats
+
+	BeforeEach(func() {
+		bdwStats = NewBDWStats(0)
+	})
+
+	It("uses the highest sample as the bandwidth", func() {
+		bdwStats.UpdateBDW(1000, 10*time.Millisecond, false)
+		Expect(bdwStats.GetBandwidthBps()).To(Equal(100000 * BytesPerSecond))
+		bdwStats.UpdateBDW(2000, 10*time.Millisecond, false)
+		Expect(bdwStats.GetBandwidthBps()).To(Equal(200000 * BytesPerSecond))
+	})
+
+	It("forgets a sample once the window moved past it", func() {
+		bdwStats.UpdateBDW(2000, 10*time.Millisecond, false)
+		for i := 0; i < len(bdwStats.compareWindow)-1; i++ {
+			bdwStats.UpdateBDW(100, 10*time.Millisecond, false)
+		}
+		Expect(bdwStats.GetBandwidthBps()).To(Equal(200000 * BytesPerSecond))
+		bdwStats.UpdateBDW(100, 10*time.Millisecond, false)
+		Expect(bdwStats.GetBandwidthBps()).To(Equal(10000 * BytesPerSecond))
+	})
+
+	It("replaces the initial bandwidth with the first sample", func() {
+		bdwStats = NewBDWStats(500000 * BytesPerSecond)
+		Expect(bdwStats.NumSamples()).To(BeZero())
+		bdwStats.UpdateBDW(1000, 10*time.Millisecond, false)
+		Expect(bdwStats.GetBandwidthBps()).To(Equal(100000 * BytesPerSecond))
+		Expect(bdwStats.NumSamples()).To(Equal(uint32(1)))
+	})
+
+	It("doesn't underestimate the bandwidth with app-limited samples", func() {
+		bdwStats.UpdateBDW(2000, 10*time.Millisecond, false)
+		for i := 0; i < 2*len(bdwStats.compareWindow); i++ {
+			bdwStats.UpdateBDW(100, 10*time.Millisecond, true)
+		}
+		Expect(bdwStats.GetBandwidthBps()).To(Equal(200000 * BytesPerSecond))
+		Expect(bdwStats.NumSamples()).To(Equal(uint32(1)))
+		// the app-limited samples didn't push the sample out of the window
+		bdwStats.UpdateBDW(100, 10*time.Millisecond, false)
+		Expect(bdwStats.GetBandwidthBps()).To(Equal(200000 * BytesPerSecond))
+	})
+
+	It("uses an app-limited sample that raises the bandwidth", func() {
+		bdwStats.UpdateBDW(1000, 10*time.Millisecond, false)
+		bdwStats.UpdateBDW(3000, 10*time.Millisecond, true)
+		Expect(bdwStats.GetBandwidthBps()).To(Equal(300000 * BytesPerSecond))
+	})
+})
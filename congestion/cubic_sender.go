@@ -101,6 +101,7 @@ func (c *cubicSender) OnPacketSent(sentTime time.Time, bytesInFlight protocol.By
 	return true
 }
 
+// InRecovery returns true if the window was reduced after a loss and no packet sent since then was acked yet
 func (c *cubicSender) InRecovery() bool {
 	return c.largestAckedPacketNumber <= c.largestSentAtLastCutback && c.largestAckedPacketNumber != 0
 }
@@ -236,6 +237,12 @@ func (c *cubicSender) isCwndLimited(bytesInFlight protocol.ByteCount) bool {
 	return slowStartLimited || availableBytes <= maxBurstBytes
 }
 
+// OnAppLimited is called when the application had no data to send although the congestion window allowed it,
+// Cubic doesn't grow the window during such a period
+func (c *cubicSender) OnAppLimited() {
+	c.cubic.OnApplicationLimited()
+}
+
 // BandwidthEstimate returns the current bandwidth estimate
 func (c *cubicSender) BandwidthEstimate() Bandwidth {
 	srtt := c.rttStats.SmoothedRTT()
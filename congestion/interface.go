@@ -14,6 +14,8 @@ type SendAlgorithm interface {
 	MaybeExitSlowStart()
 	OnPacketAcked(number protocol.PacketNumber, ackedBytes protocol.ByteCount, bytesInFlight protocol.ByteCount)
 	OnPacketLost(number protocol.PacketNumber, lostBytes protocol.ByteCount, bytesInFlight protocol.ByteCount)
+	OnAppLimited()
+	InRecovery() bool
 	SetNumEmulatedConnections(n int)
 	OnRetransmissionTimeout(packetsRetransmitted bool)
 	OnConnectionMigration()
@@ -24,6 +26,16 @@ type SendAlgorithm interface {
 	SetSlowStartLargeReduction(enabled bool)
 }
 
+// An RTTEstimator smoothes the RTT samples of a path. RTTStats implements it with the usual EWMA.
+type RTTEstimator interface {
+	// UpdateRTT is called with every RTT sample, the ackDelay reported by the peer is not subtracted yet
+	UpdateRTT(sendDelta, ackDelay time.Duration, now time.Time)
+	// SmoothedRTT returns the RTT estimate, 0 as long as there is none
+	SmoothedRTT() time.Duration
+	// OnConnectionMigration resets the estimate
+	OnConnectionMigration()
+}
+
 // SendAlgorithmWithDebugInfo adds some debug functions to SendAlgorithm
 type SendAlgorithmWithDebugInfo interface {
 	SendAlgorithm
@@ -34,5 +46,4 @@ type SendAlgorithmWithDebugInfo interface {
 	HybridSlowStart() *HybridSlowStart
 	SlowstartThreshold() protocol.PacketNumber
 	RenoBeta() float32
-	InRecovery() bool
 }
@@ -330,6 +330,10 @@ func (o *OliaSender) SmoothedRTT() time.Duration {
 	return o.rttStats.SmoothedRTT()
 }
 
+// OnAppLimited is called when the application had no data to send although the congestion window allowed it,
+// OLIA only grows the window while it is used anyway
+func (o *OliaSender) OnAppLimited() {}
+
 func (o *OliaSender) SetSlowStartLargeReduction(enabled bool) {
 	o.slowStartLargeReduction = enabled
 }
@@ -360,6 +364,7 @@ func (o *OliaSender) RenoBeta() float32 {
 	return (float32(o.numConnections) - 1. + renoBeta) / float32(o.numConnections)
 }
 
+// InRecovery returns true if the window was reduced after a loss and no packet sent since then was acked yet
 func (o *OliaSender) InRecovery() bool {
 	return o.largestAckedPacketNumber <= o.largestSentAtLastCutback && o.largestAckedPacketNumber != 0
 }
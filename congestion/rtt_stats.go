@@ -30,6 +30,7 @@ type RTTStats struct {
 	latestRTT          time.Duration
 	smoothedRTT        time.Duration
 	meanDeviation      time.Duration
+	numSamples         uint32
 
 	numMinRTTsamplesRemaining uint32
 
@@ -37,8 +38,13 @@ type RTTStats struct {
 	recentMinRTT     rttSample
 	halfWindowRTT    rttSample
 	quarterWindowRTT rttSample
+
+	// if set, it provides the smoothed RTT instead of the EWMA
+	estimator RTTEstimator
 }
 
+var _ RTTEstimator = &RTTStats{}
+
 // NewRTTStatsWithSmoothedRTT makes a properly initialized Smoothed RTTStats object
 func NewRTTStatsWithSmoothedRTT(smoothedRTT time.Duration) *RTTStats {
 	return &RTTStats{
@@ -69,10 +75,19 @@ func (r *RTTStats) LatestRTT() time.Duration { return r.latestRTT }
 // minRTT for the entire connection if SampleNewMinRtt was never called.
 func (r *RTTStats) RecentMinRTT() time.Duration { return r.recentMinRTT.rtt }
 
-// SmoothedRTT returns the EWMA smoothed RTT for the connection.
+// SmoothedRTT returns the EWMA smoothed RTT for the connection, or the estimate of the estimator if one is set.
 // May return Zero if no valid updates have occurred.
 func (r *RTTStats) SmoothedRTT() time.Duration { return r.smoothedRTT }
 
+// SetEstimator makes the estimator smooth the RTT samples. The other statistics are still computed.
+// A seeded smoothed RTT is kept until the estimator has an estimate.
+func (r *RTTStats) SetEstimator(estimator RTTEstimator) {
+	r.estimator = estimator
+	if smoothedRTT := estimator.SmoothedRTT(); smoothedRTT != 0 {
+		r.smoothedRTT = smoothedRTT
+	}
+}
+
 // GetQuarterWindowRTT gets the quarter window RTT
 func (r *RTTStats) GetQuarterWindowRTT() time.Duration { return r.quarterWindowRTT.rtt }
 
@@ -82,6 +97,9 @@ func (r *RTTStats) GetHalfWindowRTT() time.Duration { return r.halfWindowRTT.rtt
 // MeanDeviation gets the mean deviation
 func (r *RTTStats) MeanDeviation() time.Duration { return r.meanDeviation }
 
+// NumSamples returns the number of RTT samples taken since the last reset.
+func (r *RTTStats) NumSamples() uint32 { return r.numSamples }
+
 // SetRecentMinRTTwindow sets how old a recent min rtt sample can be.
 func (r *RTTStats) SetRecentMinRTTwindow(recentMinRTTwindow time.Duration) {
 	r.recentMinRTTwindow = recentMinRTTwindow
@@ -111,14 +129,23 @@ func (r *RTTStats) UpdateRTT(sendDelta, ackDelay time.Duration, now time.Time) {
 		sample -= ackDelay
 	}
 	r.latestRTT = sample
-	// First time call.
-	if r.smoothedRTT == 0 {
+	// First time call, it replaces a smoothed RTT seeded with NewRTTStatsWithSmoothedRTT.
+	if r.numSamples == 0 {
 		r.smoothedRTT = sample
 		r.meanDeviation = sample / 2
 	} else {
 		r.meanDeviation = time.Duration(oneMinusBeta*float32(r.meanDeviation/time.Microsecond)+rttBeta*float32(utils.AbsDuration(r.smoothedRTT-sample)/time.Microsecond)) * time.Microsecond
 		r.smoothedRTT = time.Duration((float32(r.smoothedRTT/time.Microsecond)*oneMinusAlpha)+(float32(sample/time.Microsecond)*rttAlpha)) * time.Microsecond
 	}
+	r.numSamples++
+	// The estimate of the estimator replaces the EWMA. Like the EWMA, it is the base of the mean deviation of the next sample,
+	// and of ExpireSmoothedMetrics and UpdateSessionRTT until then.
+	if r.estimator != nil {
+		r.estimator.UpdateRTT(sendDelta, ackDelay, now)
+		if smoothedRTT := r.estimator.SmoothedRTT(); smoothedRTT != 0 {
+			r.smoothedRTT = smoothedRTT
+		}
+	}
 }
 
 func (r *RTTStats) updateRecentMinRTT(sample time.Duration, now time.Time) { // Recent minRTT update.
@@ -172,12 +199,16 @@ func (r *RTTStats) OnConnectionMigration() {
 	r.minRTT = 0
 	r.smoothedRTT = 0
 	r.meanDeviation = 0
+	r.numSamples = 0
 	r.initialRTTus = initialRTTus
 	r.numMinRTTsamplesRemaining = 0
 	r.recentMinRTTwindow = utils.InfDuration
 	r.recentMinRTT = rttSample{}
 	r.halfWindowRTT = rttSample{}
 	r.quarterWindowRTT = rttSample{}
+	if r.estimator != nil {
+		r.estimator.OnConnectionMigration()
+	}
 }
 
 // ExpireSmoothedMetrics causes the smoothed_rtt to be increased to the latest_rtt if the latest_rtt
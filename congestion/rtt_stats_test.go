@@ -8,6 +8,19 @@ import (
 	. "github.com/onsi/gomega"
 )
 
+// an estimator using the latest sample as the RTT
+type latestSampleEstimator struct {
+	rtt        time.Duration
+	numSamples int
+}
+
+func (e *latestSampleEstimator) UpdateRTT(sendDelta, ackDelay time.Duration, _ time.Time) {
+	e.rtt = sendDelta - ackDelay
+	e.numSamples++
+}
+func (e *latestSampleEstimator) SmoothedRTT() time.Duration { return e.rtt }
+func (e *latestSampleEstimator) OnConnectionMigration()     { *e = latestSampleEstimator{} }
+
 var _ = Describe("RTT stats", func() {
 	var (
 		rttStats *RTTStats
@@ -194,6 +207,24 @@ var _ = Describe("RTT stats", func() {
 			Expect(rttStats.RecentMinRTT()).To(Equal(initial_rtt))
 			Expect(rttStats.SmoothedRTT()).To(Equal(initial_rtt))
 		}
+		Expect(rttStats.NumSamples()).To(Equal(uint32(1)))
+	})
+
+	It("counts the samples", func() {
+		Expect(rttStats.NumSamples()).To(BeZero())
+		rttStats.UpdateRTT(10*time.Millisecond, 0, time.Time{})
+		rttStats.UpdateRTT(20*time.Millisecond, 0, time.Time{})
+		Expect(rttStats.NumSamples()).To(Equal(uint32(2)))
+	})
+
+	It("replaces a seeded smoothed RTT with the first sample", func() {
+		rttStats = NewRTTStatsWithSmoothedRTT(30 * time.Millisecond)
+		Expect(rttStats.NumSamples()).To(BeZero())
+		rttStats.UpdateRTT(200*time.Millisecond, 0, time.Time{})
+		Expect(rttStats.SmoothedRTT()).To(Equal(200 * time.Millisecond))
+		Expect(rttStats.MeanDeviation()).To(Equal(100 * time.Millisecond))
+		rttStats.UpdateRTT(100*time.Millisecond, 0, time.Time{})
+		Expect(rttStats.SmoothedRTT()).To(Equal(187500 * time.Microsecond))
 	})
 
 	It("ResetAfterConnectionMigrations", func() {
@@ -209,6 +240,69 @@ var _ = Describe("RTT stats", func() {
 		Expect(rttStats.SmoothedRTT()).To(Equal(time.Duration(0)))
 		Expect(rttStats.MinRTT()).To(Equal(time.Duration(0)))
 		Expect(rttStats.RecentMinRTT()).To(Equal(time.Duration(0)))
+		Expect(rttStats.NumSamples()).To(BeZero())
 	})
 
+	Context("with an estimator", func() {
+		var estimator *latestSampleEstimator
+
+		BeforeEach(func() {
+			estimator = &latestSampleEstimator{}
+			rttStats.SetEstimator(estimator)
+		})
+
+		It("uses the smoothed RTT of the estimator", func() {
+			rttStats.UpdateRTT(100*time.Millisecond, 0, time.Time{})
+			rttStats.UpdateRTT(300*time.Millisecond, 100*time.Millisecond, time.Time{})
+			Expect(estimator.numSamples).To(Equal(2))
+			Expect(rttStats.SmoothedRTT()).To(Equal(200 * time.Millisecond))
+			// the other statistics are still computed
+			Expect(rttStats.MinRTT()).To(Equal(100 * time.Millisecond))
+			Expect(rttStats.LatestRTT()).To(Equal(200 * time.Millisecond))
+		})
+
+		It("doesn't pass invalid samples to the estimator", func() {
+			rttStats.UpdateRTT(utils.InfDuration, 0, time.Time{})
+			rttStats.UpdateRTT(0, 0, time.Time{})
+			Expect(estimator.numSamples).To(BeZero())
+		})
+
+		It("keeps a seeded smoothed RTT until the estimator has an estimate", func() {
+			rttStats = NewRTTStatsWithSmoothedRTT(30 * time.Millisecond)
+			rttStats.SetEstimator(estimator)
+			Expect(rttStats.SmoothedRTT()).To(Equal(30 * time.Millisecond))
+			rttStats.UpdateRTT(100*time.Millisecond, 0, time.Time{})
+			Expect(rttStats.SmoothedRTT()).To(Equal(100 * time.Millisecond))
+		})
+
+		It("computes the mean deviation from the estimate of the estimator", func() {
+			rttStats.UpdateRTT(100*time.Millisecond, 0, time.Time{})
+			Expect(rttStats.MeanDeviation()).To(Equal(50 * time.Millisecond))
+			// the EWMA would be at 112.5 ms, the estimator is at 200 ms
+			rttStats.UpdateRTT(200*time.Millisecond, 0, time.Time{})
+			rttStats.UpdateRTT(200*time.Millisecond, 0, time.Time{})
+			Expect(rttStats.MeanDeviation()).To(Equal(46875 * time.Microsecond))
+		})
+
+		It("expires the estimate of the estimator", func() {
+			rttStats.UpdateRTT(300*time.Millisecond, 0, time.Time{})
+			rttStats.latestRTT = 400 * time.Millisecond
+			rttStats.ExpireSmoothedMetrics()
+			Expect(rttStats.SmoothedRTT()).To(Equal(400 * time.Millisecond))
+			Expect(rttStats.MeanDeviation()).To(Equal(150 * time.Millisecond))
+		})
+
+		It("lets the session RTT override the estimate", func() {
+			rttStats.UpdateRTT(100*time.Millisecond, 0, time.Time{})
+			rttStats.UpdateSessionRTT(150 * time.Millisecond)
+			Expect(rttStats.SmoothedRTT()).To(Equal(150 * time.Millisecond))
+		})
+
+		It("resets the estimator on a connection migration", func() {
+			rttStats.UpdateRTT(100*time.Millisecond, 0, time.Time{})
+			rttStats.OnConnectionMigration()
+			Expect(rttStats.SmoothedRTT()).To(BeZero())
+			Expect(estimator.numSamples).To(BeZero())
+		})
+	})
 })
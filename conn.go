@@ -7,6 +7,7 @@ import (
 
 type connection interface {
 	Write([]byte) error
+	WriteBatch([][]byte) error
 	Read([]byte) (int, net.Addr, error)
 	Close() error
 	LocalAddr() net.Addr
@@ -19,6 +20,9 @@ type conn struct {
 
 	pconn       net.PacketConn
 	currentAddr net.Addr
+
+	batchWriterOnce sync.Once
+	batchWriter     batchWriter
 }
 
 var _ connection = &conn{}
@@ -28,6 +32,27 @@ func (c *conn) Write(p []byte) error {
 	return err
 }
 
+// WriteBatch writes the packets to the current remote address.
+// If the pconn supports it, they are written with a single call, otherwise one packet at a time.
+func (c *conn) WriteBatch(packets [][]byte) error {
+	c.batchWriterOnce.Do(func() {
+		c.batchWriter = newBatchWriter(c.pconn)
+	})
+	addr := c.RemoteAddr()
+	if c.batchWriter != nil {
+		err := c.batchWriter.WriteBatch(packets, addr)
+		if err != errBatchNotSupported {
+			return err
+		}
+	}
+	for _, p := range packets {
+		if _, err := c.pconn.WriteTo(p, addr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (c *conn) Read(p []byte) (int, net.Addr, error) {
 	return c.pconn.ReadFrom(p)
 }
@@ -2,6 +2,7 @@ package quic
 
 import (
 	"bytes"
+	"errors"
 	"io"
 	"net"
 	"time"
@@ -17,6 +18,8 @@ type mockPacketConn struct {
 	readErr       error
 	dataWritten   bytes.Buffer
 	dataWrittenTo net.Addr
+	writes        int
+	writeErr      error
 	closed        bool
 }
 
@@ -33,7 +36,11 @@ func (c *mockPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
 	return n, c.dataReadFrom, nil
 }
 func (c *mockPacketConn) WriteTo(b []byte, addr net.Addr) (n int, err error) {
+	if c.writeErr != nil {
+		return 0, c.writeErr
+	}
 	c.dataWrittenTo = addr
+	c.writes++
 	return c.dataWritten.Write(b)
 }
 func (c *mockPacketConn) Close() error                       { c.closed = true; return nil }
@@ -44,6 +51,27 @@ func (c *mockPacketConn) SetWriteDeadline(t time.Time) error { panic("not implem
 
 var _ net.PacketConn = &mockPacketConn{}
 
+// mockBatchPacketConn is a mockPacketConn supporting batched writes
+type mockBatchPacketConn struct {
+	*mockPacketConn
+	batches  int
+	batchErr error
+}
+
+func (c *mockBatchPacketConn) WriteBatch(packets [][]byte, addr net.Addr) error {
+	if c.batchErr != nil {
+		return c.batchErr
+	}
+	c.batches++
+	c.dataWrittenTo = addr
+	for _, p := range packets {
+		c.dataWritten.Write(p)
+	}
+	return nil
+}
+
+var _ batchWriter = &mockBatchPacketConn{}
+
 var _ = Describe("Connection", func() {
 	var c *conn
 	var packetConn *mockPacketConn
@@ -67,6 +95,64 @@ var _ = Describe("Connection", func() {
 		Expect(packetConn.dataWrittenTo.String()).To(Equal("192.168.100.200:1337"))
 	})
 
+	Context("batched writes", func() {
+		packets := [][]byte{[]byte("foo"), []byte("bar"), []byte("baz")}
+
+		It("writes the packets one by one if the pconn doesn't support batches", func() {
+			Expect(c.WriteBatch(packets)).To(Succeed())
+			Expect(packetConn.writes).To(Equal(3))
+			Expect(packetConn.dataWritten.Bytes()).To(Equal([]byte("foobarbaz")))
+			Expect(packetConn.dataWrittenTo.String()).To(Equal("192.168.100.200:1337"))
+		})
+
+		It("writes all packets with a single call if the pconn supports batches", func() {
+			batchConn := &mockBatchPacketConn{mockPacketConn: packetConn}
+			c.pconn = batchConn
+			Expect(c.WriteBatch(packets)).To(Succeed())
+			Expect(c.WriteBatch(packets)).To(Succeed())
+			Expect(batchConn.batches).To(Equal(2))
+			Expect(packetConn.writes).To(BeZero())
+			Expect(packetConn.dataWritten.Bytes()).To(Equal([]byte("foobarbazfoobarbaz")))
+			Expect(packetConn.dataWrittenTo.String()).To(Equal("192.168.100.200:1337"))
+		})
+
+		It("falls back to one write per packet if the batch can't be written to the address", func() {
+			c.pconn = &mockBatchPacketConn{mockPacketConn: packetConn, batchErr: errBatchNotSupported}
+			Expect(c.WriteBatch(packets)).To(Succeed())
+			Expect(packetConn.writes).To(Equal(3))
+			Expect(packetConn.dataWritten.Bytes()).To(Equal([]byte("foobarbaz")))
+		})
+
+		It("returns errors of batched writes", func() {
+			testErr := errors.New("write failed")
+			c.pconn = &mockBatchPacketConn{mockPacketConn: packetConn, batchErr: testErr}
+			Expect(c.WriteBatch(packets)).To(MatchError(testErr))
+			Expect(packetConn.writes).To(BeZero())
+		})
+
+		for _, network := range []string{"udp4", "udp"} {
+			network := network
+
+			It("sends the packets over a "+network+" socket", func() {
+				receiver, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+				Expect(err).ToNot(HaveOccurred())
+				defer receiver.Close()
+				sender, err := net.ListenUDP(network, nil)
+				Expect(err).ToNot(HaveOccurred())
+				defer sender.Close()
+				c = &conn{pconn: sender, currentAddr: receiver.LocalAddr()}
+				Expect(c.WriteBatch(packets)).To(Succeed())
+				receiver.SetReadDeadline(time.Now().Add(time.Second))
+				for _, p := range packets {
+					b := make([]byte, 10)
+					n, _, err := receiver.ReadFrom(b)
+					Expect(err).ToNot(HaveOccurred())
+					Expect(b[:n]).To(Equal(p))
+				}
+			})
+		}
+	})
+
 	It("reads", func() {
 		packetConn.dataToRead = []byte("foo")
 		packetConn.dataReadFrom = &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1336}
@@ -22,6 +22,7 @@ import (
 
 type roundTripperOpts struct {
 	DisableCompression bool
+	WeightMapping      WeightMapping
 }
 
 var dialAddr = quic.DialAddr
@@ -43,7 +44,7 @@ type client struct {
 
 	session       quic.Session
 	headerStream  quic.Stream
-	headerErr     *qerr.QuicError
+	headerErr     error
 	headerErrored chan struct{} // this channel is closed if an error occurs on the header stream
 	requestWriter *requestWriter
 
@@ -161,7 +162,12 @@ func (c *client) handleHeaderStream() {
 	for {
 		frame, err := h2framer.ReadFrame()
 		if err != nil {
-			c.headerErr = qerr.Error(qerr.HeadersStreamDataDecompressFailure, "cannot read frame")
+			// if the peer closed the connection, return its error code and reason to the requests
+			if _, ok := err.(*qerr.PeerClosedError); ok {
+				c.headerErr = err
+			} else {
+				c.headerErr = qerr.Error(qerr.HeadersStreamDataDecompressFailure, "cannot read frame")
+			}
 			break
 		}
 		lastStream = protocol.StreamID(frame.Header().StreamID)
@@ -239,11 +245,7 @@ func (c *client) RoundTrip(req *http.Request) (*http.Response, error) {
 		utils.Debugf("url: %s, weight: %d, dependency %d \n", req.URL.String(), priority.Weight, priority.StreamDep)
 	}
 
-	priorityTran := new(protocol.Priority)
-
-	priorityTran.Weight = priority.Weight
-	priorityTran.Dependency = protocol.StreamID(priority.StreamDep)
-	priorityTran.Exclusive = priority.Exclusive
+	priorityTran := streamPriority(*priority, c.opts.WeightMapping)
 
 	dataStream, err := c.session.OpenStreamPrioritySizeSync(priorityTran)
 
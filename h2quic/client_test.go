@@ -248,6 +248,16 @@ var _ = Describe("Client", func() {
 			close(done)
 		}, 2)
 
+		It("returns the error of the peer closing the connection", func(done Done) {
+			closeErr := &qerr.PeerClosedError{ErrorCode: 0x1337, ReasonPhrase: "foobar"}
+			headerStream.readErr = closeErr
+			close(headerStream.unblockRead)
+			rsp, err := clientPriority.RoundTrip(request)
+			Expect(err).To(MatchError(closeErr))
+			Expect(rsp).To(BeNil())
+			close(done)
+		}, 2)
+
 		It("returns subsequent request if there was an error on the header stream before", func(done Done) {
 			expectedErr := qerr.Error(qerr.HeadersStreamDataDecompressFailure, "cannot read frame")
 			session.streamsToOpen = []quic.Stream{headerStream, dataStream, newMockStream(7)}
@@ -0,0 +1,31 @@
+package h2quic
+
+import (
+	"golang.org/x/net/http2"
+
+	"github.com/lucas-clemente/pstream/internal/protocol"
+)
+
+// A WeightMapping maps the weight of an HTTP/2 priority to the weight of the QUIC stream,
+// which the scheduler uses to share the bandwidth of the paths between streams.
+// HTTP/2 weights range from 1 to 256 and are carried as weight-1, so the mapping receives
+// the value of http2.PriorityParam.Weight, i.e. 0 to 255.
+type WeightMapping func(http2Weight uint8) uint8
+
+// DefaultWeightMapping keeps the value carried in HTTP/2, i.e. the HTTP/2 weight w becomes the stream weight w-1.
+// All 256 HTTP/2 weights stay distinct and keep their order, 256 becomes 255 and 255 becomes 254.
+func DefaultWeightMapping(http2Weight uint8) uint8 {
+	return http2Weight
+}
+
+// streamPriority converts an HTTP/2 priority to the priority of the QUIC stream
+func streamPriority(p http2.PriorityParam, mapping WeightMapping) *protocol.Priority {
+	if mapping == nil {
+		mapping = DefaultWeightMapping
+	}
+	return &protocol.Priority{
+		Dependency: protocol.StreamID(p.StreamDep),
+		Weight:     mapping(p.Weight),
+		Exclusive:  p.Exclusive,
+	}
+}
@@ -0,0 +1,34 @@
+package h2quic
+
+import (
+	"golang.org/x/net/http2"
+
+	"github.com/lucas-clemente/pstream/internal/protocol"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Stream priorities", func() {
+	It("converts an HTTP/2 priority", func() {
+		p := streamPriority(http2.PriorityParam{StreamDep: 5, Exclusive: true, Weight: 15}, nil)
+		Expect(p).To(Equal(&protocol.Priority{Dependency: 5, Exclusive: true, Weight: 15}))
+	})
+
+	It("keeps all HTTP/2 weights distinct and ordered", func() {
+		// the HTTP/2 weight w is carried as w-1
+		for w := 1; w < 256; w++ {
+			lower := streamPriority(http2.PriorityParam{Weight: uint8(w - 1)}, nil)
+			higher := streamPriority(http2.PriorityParam{Weight: uint8(w)}, nil)
+			Expect(higher.Weight).To(BeNumerically(">", lower.Weight))
+		}
+		// weights 255 and 256
+		Expect(streamPriority(http2.PriorityParam{Weight: 254}, nil).Weight).To(BeEquivalentTo(254))
+		Expect(streamPriority(http2.PriorityParam{Weight: 255}, nil).Weight).To(BeEquivalentTo(255))
+	})
+
+	It("uses a custom mapping", func() {
+		mapping := func(w uint8) uint8 { return w / 16 }
+		Expect(streamPriority(http2.PriorityParam{Weight: 255}, mapping).Weight).To(BeEquivalentTo(15))
+	})
+})
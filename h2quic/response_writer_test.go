@@ -27,6 +27,7 @@ type mockStream struct {
 	remoteClosed bool
 
 	unblockRead chan struct{}
+	readErr     error // returned instead of io.EOF once the read is unblocked
 	ctx         context.Context
 	ctxCancel   context.CancelFunc
 }
@@ -59,6 +60,7 @@ func newMockStreamPrioritySize(id protocol.StreamID, priority *protocol.Priority
 	return s
 }
 func (s *mockStream) Close() error                          { s.closed = true; s.ctxCancel(); return nil }
+func (s *mockStream) CloseWrite() error                     { return s.Close() }
 func (s *mockStream) Reset(error)                           { s.reset = true }
 func (s *mockStream) CloseRemote(offset protocol.ByteCount) { s.remoteClosed = true; s.ctxCancel() }
 func (s *mockStream) StreamID() protocol.StreamID           { return s.id }
@@ -73,11 +75,17 @@ func (s *mockStream) SetReadDeadline(time.Time) error              { panic("not
 func (s *mockStream) SetWriteDeadline(time.Time) error             { panic("not implemented") }
 func (s *mockStream) GetBytesSent() (protocol.ByteCount, error)    { panic("not implemented") }
 func (s *mockStream) GetBytesRetrans() (protocol.ByteCount, error) { panic("not implemented") }
+func (s *mockStream) SetExpiry(time.Duration)                      { panic("not implemented") }
+func (s *mockStream) SetMaxSendRate(uint64)                        { panic("not implemented") }
+func (s *mockStream) Flush()                                       { panic("not implemented") }
 
 func (s *mockStream) Read(p []byte) (int, error) {
 	n, _ := s.dataToRead.Read(p)
 	if n == 0 { // block if there's no data
 		<-s.unblockRead
+		if s.readErr != nil {
+			return 0, s.readErr
+		}
 		return 0, io.EOF
 	}
 	return n, nil // never return an EOF
@@ -44,6 +44,10 @@ type RoundTripper struct {
 
 	PriorityURL map[string]*http2.PriorityParam
 
+	// WeightMapping maps the weights of the priorities in PriorityURL to stream weights.
+	// If nil, DefaultWeightMapping is used.
+	WeightMapping WeightMapping
+
 	clients map[string]roundTripCloser
 }
 
@@ -123,7 +127,7 @@ func (r *RoundTripper) getClient(hostname string, onlyCached bool) (http.RoundTr
 		if onlyCached {
 			return nil, ErrNoCachedConn
 		}
-		client = newClientPrioritySize(hostname, r.TLSClientConfig, &roundTripperOpts{DisableCompression: r.DisableCompression}, r.QuicConfig, r.PriorityURL)
+		client = newClientPrioritySize(hostname, r.TLSClientConfig, &roundTripperOpts{DisableCompression: r.DisableCompression, WeightMapping: r.WeightMapping}, r.QuicConfig, r.PriorityURL)
 
 		r.clients[hostname] = client
 	}
@@ -46,6 +46,10 @@ type Server struct {
 	// If nil, it uses reasonable default values.
 	QuicConfig *quic.Config
 
+	// WeightMapping maps the weights of the HTTP/2 priorities sent by the client to stream weights.
+	// If nil, DefaultWeightMapping is used.
+	WeightMapping WeightMapping
+
 	// Private flag for demo, do not use
 	CloseAfterFirstRequest bool
 
@@ -130,7 +134,7 @@ func (s *Server) handlePriorityFrame(session streamCreator, f *http2.PriorityFra
 		return nil
 	}
 
-	session.SetStreamPriority(dataStream.StreamID(), &protocol.Priority{Dependency: protocol.StreamID(f.StreamDep), Weight: f.Weight})
+	session.SetStreamPriority(dataStream.StreamID(), streamPriority(f.PriorityParam, s.WeightMapping))
 
 	return nil
 }
@@ -154,10 +158,12 @@ func (s *Server) handleHeaderStream(session streamCreator) {
 		var headerStreamMutex sync.Mutex // Protects concurrent calls to Write()
 		for {
 			if err := s.handleRequest(session, stream, &headerStreamMutex, hpackDecoder, h2framer); err != nil {
-				// QuicErrors must originate from stream.Read() returning an error.
+				// QuicErrors and PeerClosedErrors must originate from stream.Read() returning an error.
 				// In this case, the session has already logged the error, so we don't
 				// need to log it again.
-				if _, ok := err.(*qerr.QuicError); !ok {
+				switch err.(type) {
+				case *qerr.QuicError, *qerr.PeerClosedError:
+				default:
 					utils.Errorf("error handling h2 request: %s", err.Error())
 				}
 				session.Close(err)
@@ -207,10 +213,7 @@ func (s *Server) handleRequest(session streamCreator, headerStream quic.Stream,
 
 	//dataStream, err := session.GetOrOpenStream(protocol.StreamID(h2headersFrame.StreamID))
 
-	priorityTran := new(protocol.Priority)
-	priorityTran.Weight = h2headersFrame.Priority.Weight
-	priorityTran.Dependency = protocol.StreamID(h2headersFrame.Priority.StreamDep)
-	priorityTran.Exclusive = h2headersFrame.Priority.Exclusive
+	priorityTran := streamPriority(h2headersFrame.Priority, s.WeightMapping)
 
 	dataStream, err := session.GetOrOpenStreamPrioritySize(protocol.StreamID(h2headersFrame.StreamID), priorityTran)
 
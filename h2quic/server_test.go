@@ -34,6 +34,7 @@ type mockSession struct {
 	streamsToOpen       []quic.Stream
 	blockOpenStreamSync bool
 	streamOpenErr       error
+	priority            *protocol.Priority
 	ctx                 context.Context
 	ctxCancel           context.CancelFunc
 }
@@ -45,9 +46,13 @@ func (s *mockSession) GetOrOpenStreamPriority(id protocol.StreamID, priority *pr
 	return s.dataStream, nil
 }
 func (s *mockSession) GetOrOpenStreamPrioritySize(id protocol.StreamID, priority *protocol.Priority) (quic.Stream, error) {
+	s.priority = priority
 	return s.dataStream, nil
 }
 func (s *mockSession) AcceptStream() (quic.Stream, error) { return s.streamToAccept, nil }
+func (s *mockSession) AcceptStreamContext(context.Context) (quic.Stream, error) {
+	return s.streamToAccept, nil
+}
 func (s *mockSession) OpenStream() (quic.Stream, error) {
 	if s.streamOpenErr != nil {
 		return nil, s.streamOpenErr
@@ -74,12 +79,60 @@ func (s *mockSession) OpenStreamPrioritySizeSync(priority *protocol.Priority) (q
 	}
 	return s.OpenStream()
 }
+func (s *mockSession) OpenStreamStriped() (quic.Stream, error) {
+	return s.OpenStream()
+}
 func (s *mockSession) Close(e error) error {
 	s.closed = true
 	s.closedWithError = e
 	s.ctxCancel()
 	return nil
 }
+func (s *mockSession) CloseGracefully(time.Duration) error {
+	return s.Close(nil)
+}
+func (s *mockSession) PathCongestionWindow(protocol.PathID) (protocol.ByteCount, error) {
+	panic("not implemented")
+}
+func (s *mockSession) PathAckRanges(protocol.PathID) ([]quic.AckRange, error) {
+	panic("not implemented")
+}
+func (s *mockSession) Paths() []quic.PathInfo {
+	panic("not implemented")
+}
+func (s *mockSession) PathStats() []quic.PathStats {
+	panic("not implemented")
+}
+func (s *mockSession) StreamPending(protocol.StreamID) protocol.ByteCount {
+	panic("not implemented")
+}
+func (s *mockSession) BytesInFlight() protocol.ByteCount {
+	panic("not implemented")
+}
+func (s *mockSession) SendDatagram([]byte) error {
+	panic("not implemented")
+}
+func (s *mockSession) SmoothedRTT() time.Duration {
+	panic("not implemented")
+}
+func (s *mockSession) SchedulerState() quic.SchedulerState {
+	panic("not implemented")
+}
+func (s *mockSession) SetCongestionWindow(protocol.PathID, protocol.ByteCount) error {
+	panic("not implemented")
+}
+func (s *mockSession) SetScheduler(string) error {
+	panic("not implemented")
+}
+func (s *mockSession) Version() protocol.VersionNumber {
+	return protocol.VersionWhatever
+}
+func (s *mockSession) HandshakeDuration() time.Duration {
+	panic("not implemented")
+}
+func (s *mockSession) MultipathEnabled() bool {
+	return false
+}
 func (s *mockSession) LocalAddr() net.Addr {
 	panic("not implemented")
 }
@@ -151,6 +204,22 @@ var _ = Describe("H2 server", func() {
 			Expect(dataStream.reset).To(BeFalse())
 		})
 
+		It("maps the weight of the request to the stream weight", func() {
+			s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+			s.WeightMapping = func(w uint8) uint8 { return 255 - w }
+			err := http2.NewFramer(&headerStream.dataToRead, nil).WriteHeaders(http2.HeadersFrameParam{
+				StreamID:      5,
+				EndHeaders:    true,
+				EndStream:     true,
+				BlockFragment: []byte{0x82, 0x86, 0x84, 0x41, 0x8c, 0xf1, 0xe3, 0xc2, 0xe5, 0xf2, 0x3a, 0x6b, 0xa0, 0xab, 0x90, 0xf4, 0xff},
+				Priority:      http2.PriorityParam{StreamDep: 3, Weight: 200},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			err = s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, h2framer)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(session.priority).To(Equal(&protocol.Priority{Dependency: 3, Weight: 55}))
+		})
+
 		It("returns 200 with an empty handler", func() {
 			s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
 			headerStream.dataToRead.Write([]byte{
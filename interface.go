@@ -6,8 +6,10 @@ import (
 	"net"
 	"time"
 
+	"github.com/lucas-clemente/pstream/congestion"
 	"github.com/lucas-clemente/pstream/internal/handshake"
 	"github.com/lucas-clemente/pstream/internal/protocol"
+	"github.com/lucas-clemente/pstream/internal/wire"
 )
 
 // The StreamID is the ID of a QUIC stream.
@@ -19,6 +21,12 @@ type VersionNumber = protocol.VersionNumber
 // A Cookie can be used to verify the ownership of the client address.
 type Cookie = handshake.Cookie
 
+// An RTTEstimator smoothes the RTT samples of a path, see Config.RTTEstimator.
+type RTTEstimator = congestion.RTTEstimator
+
+// An AckRange is a range of packet numbers received without a gap, see Session.PathAckRanges.
+type AckRange = wire.AckRange
+
 // Stream is the interface implemented by QUIC streams
 type Stream interface {
 	// Read reads data from the stream.
@@ -29,7 +37,12 @@ type Stream interface {
 	// Write can be made to time out and return a net.Error with Timeout() == true
 	// after a fixed time limit; see SetDeadline and SetWriteDeadline.
 	io.Writer
+	// Close closes the write side of the stream, it is equivalent to CloseWrite.
 	io.Closer
+	// CloseWrite closes the write side of the stream.
+	// A FIN is sent once all data written before was sent, on one of the paths of the stream.
+	// The read side stays open: Read keeps returning the data of the peer until it closes the stream as well.
+	CloseWrite() error
 	StreamID() StreamID
 	Priority() *protocol.Priority
 	Size() protocol.ByteCount
@@ -58,6 +71,17 @@ type Stream interface {
 	GetBytesSent() (protocol.ByteCount, error)
 	// GetBytesRetrans returns the number of bytes of the stream that were retransmitted to the peer
 	GetBytesRetrans() (protocol.ByteCount, error)
+	// SetExpiry makes the stream partially reliable: lost data that was sent longer than d ago
+	// is not retransmitted anymore, and the peer skips over it.
+	// A zero value for d means data never expires.
+	SetExpiry(d time.Duration)
+	// SetMaxSendRate limits the rate of the data of the stream on all its paths together, in bytes per second,
+	// e.g. for a bulk stream, such that it leaves room in the congestion windows of its paths for interactive streams.
+	// Data above the rate waits, while the other streams of the paths are sent. A rate of 0 removes the limit.
+	SetMaxSendRate(rate uint64)
+	// Flush makes the paths of the stream send the data written so far right away.
+	// The paths a stream is split on usually only send the volume assigned to them, and wait for the next scheduling pass for the rest.
+	Flush()
 }
 
 // A Session is a QUIC connection between two peers.
@@ -65,6 +89,9 @@ type Session interface {
 	// AcceptStream returns the next stream opened by the peer, blocking until one is available.
 	// Since stream 1 is reserved for the crypto stream, the first stream is either 2 (for a client) or 3 (for a server).
 	AcceptStream() (Stream, error)
+	// AcceptStreamContext is like AcceptStream, but it returns the error of ctx
+	// if it is cancelled or its deadline passes before the peer opens a stream.
+	AcceptStreamContext(ctx context.Context) (Stream, error)
 	// OpenStream opens a new QUIC stream, returning a special error when the peer's concurrent stream limit is reached.
 	// New streams always have the smallest possible stream ID.
 	// TODO: Enable testing for the special error
@@ -76,12 +103,60 @@ type Session interface {
 	OpenStreamPrioritySync(*protocol.Priority) (Stream, error)
 	//OpenStreamPrioritySizeSync opens a new QUIC stream with priority and size
 	OpenStreamPrioritySizeSync(*protocol.Priority) (Stream, error)
+	// OpenStreamStriped opens a new QUIC stream whose data is split across all usable paths from the start,
+	// according to their bandwidth, without waiting for the size of the stream to be detected.
+	// The data written later is split on the same paths.
+	OpenStreamStriped() (Stream, error)
+	// SendDatagram sends the data in a DATAGRAM frame on the lowest-RTT path, once the handshake is complete.
+	// Datagrams are neither ordered nor retransmitted, the peer receives them with its Config.DatagramHandler.
+	// The data must not be larger than 1200 bytes.
+	SendDatagram([]byte) error
 	// LocalAddr returns the local address.
 	LocalAddr() net.Addr
 	// RemoteAddr returns the address of the peer.
 	RemoteAddr() net.Addr
 	// Close closes the connection. The error will be sent to the remote peer in a CONNECTION_CLOSE frame. An error value of nil is allowed and will cause a normal PeerGoingAway to be sent.
 	Close(error) error
+	// CloseGracefully sends the data buffered in the streams, waiting at most for the timeout, and closes the connection afterwards.
+	// Like Close(nil), it sends a CONNECTION_CLOSE with PeerGoingAway.
+	CloseGracefully(timeout time.Duration) error
+	// PathCongestionWindow returns the congestion window of a path, in bytes. Like PathStats, it is saved by the session and may lag behind slightly.
+	PathCongestionWindow(pathID protocol.PathID) (protocol.ByteCount, error)
+	// PathAckRanges returns the ranges of packets received on a path, from the highest to the lowest, as the next ACK of the path reports them.
+	// The gaps between them are the packets the host misses, lost or reordered. It is meant for debugging.
+	// Like PathStats, they are saved by the session and may lag behind slightly.
+	PathAckRanges(pathID protocol.PathID) ([]AckRange, error)
+	// Paths returns the local and remote address of each path, sorted by path ID.
+	Paths() []PathInfo
+	// PathStats returns statistics about the packets sent on each path, sorted by path ID.
+	// They are saved by the session each time it handled a packet or a timer, and may lag behind slightly.
+	PathStats() []PathStats
+	// StreamPending returns the number of bytes written to a stream that haven't been packed into a STREAM frame yet.
+	// Together with PathStats, it lets applications pace their writes. It returns 0 for an unknown or closed stream.
+	StreamPending(streamID protocol.StreamID) protocol.ByteCount
+	// BytesInFlight returns the number of bytes sent but not yet acked or declared lost, summed over all paths.
+	// Like PathStats, it is saved by the session and may lag behind slightly.
+	BytesInFlight() protocol.ByteCount
+	// SmoothedRTT returns the lowest smoothed RTT of the paths used for sending, i.e. the best latency the connection achieves.
+	// Potentially failed paths, and the initial path if the InitialPathPolicy avoids it, are not considered.
+	// It returns 0 if no such path has an RTT estimate yet. Like PathStats, it is saved by the session and may lag behind slightly.
+	SmoothedRTT() time.Duration
+	// SchedulerState returns the number of packets sent and of streams assigned on each path, as counted by the path scheduler
+	// at the end of its last pass, and why paths were not selected. It is meant for debugging, e.g. to check that the scheduler balances the paths.
+	SchedulerState() SchedulerState
+	// SetCongestionWindow overrides the congestion window of a path, in bytes. A window of 0 removes the override.
+	// It is meant for experiments, and only allowed if debug logging is enabled. The window is applied before the session sends the next packet.
+	SetCongestionWindow(pathID protocol.PathID, window protocol.ByteCount) error
+	// SetScheduler switches to another path scheduler, with the same names as Config.PathScheduler.
+	// A scheduling pass in progress completes with the previous one, the new one is used from the next pass on.
+	SetScheduler(name string) error
+	// Version returns the QUIC version negotiated for this session.
+	Version() protocol.VersionNumber
+	// HandshakeDuration returns the time from the creation of the session until the handshake completed.
+	// The secondary paths are only created afterwards. It returns 0 as long as the handshake is not complete.
+	HandshakeDuration() time.Duration
+	// MultipathEnabled returns true if the negotiated version supports multipath.
+	MultipathEnabled() bool
 	// The context is cancelled when the session is closed.
 	// Warning: This API should not be considered stable and might change soon.
 	Context() context.Context
@@ -100,6 +175,10 @@ type Config struct {
 	// If not set, it uses all versions available.
 	// Warning: This API should not be considered stable and will change soon.
 	Versions []VersionNumber
+	// DisableMultipath prevents the multipath version from being negotiated.
+	// By default, the multipath version is preferred whenever the peer supports it, and a single-path version is used otherwise.
+	// Session.MultipathEnabled tells which one was negotiated.
+	DisableMultipath bool
 	// Ask the server to truncate the connection ID sent in the Public Header.
 	// This saves 8 bytes in the Public Header in every packet. However, if the IP address of the server changes, the connection cannot be migrated.
 	// Currently only valid for the client.
@@ -131,7 +210,178 @@ type Config struct {
 	// Should the host try to create new paths, if possible?
 	CreatePaths bool
 	// Path scheduler, default multipath
+	// With "CostAware", paths with a high cost are only used when the paths with a low cost are congestion limited.
 	PathScheduler string
+	// InitialPathPolicy defines how the initial path is used once other paths exist.
+	// If not set, no streams are assigned to it once another path got an RTT sample, so it mostly carries ACKs.
+	InitialPathPolicy InitialPathPolicy
+	// AckPathPolicy defines on which paths the ACKs are sent when there is no data to send.
+	// If not set, the ACK of each path is sent on that path, together with the window updates on every path.
+	AckPathPolicy AckPathPolicy
+	// PathsFrameOrder defines in which order the paths are advertised in the PATHS frames, the peer creating its paths in this order.
+	// Failed paths are always listed last. If not set, the paths are listed by increasing path ID.
+	PathsFrameOrder PathsFrameOrder
+	// PathCost is called when a path is created to get the cost of sending data on it.
+	// If not set, all paths have a low cost.
+	PathCost func(localAddr, remoteAddr net.Addr) PathCost
+	// PathGroup is called when a path is created to get the group of the path, e.g. the name of its network interface
+	// for paths bonded on the same interface, or "wifi" for the paths of two Wi-Fi interfaces on the same access point.
+	// Paths of a group likely fail together: when the preferred path fails, the scheduler fails over to a path of another group if there is one.
+	// If not set, or for an empty group, paths are independent.
+	PathGroup func(localAddr, remoteAddr net.Addr) string
+	// PathGroupBandwidth is called with a group of paths, see PathGroup, to get the capacity of the bottleneck its paths share, in bytes per second.
+	// The scheduler scales the bandwidth estimates of the paths of the group down to it when splitting a stream,
+	// as the sum of the estimates of paths competing for the same bottleneck overestimates its capacity.
+	// If not set, or if it returns 0 for a group, the bandwidths of its paths are not capped.
+	PathGroupBandwidth func(group string) uint64
+	// PathMTU is called when a path is created to get the largest packet sent on it, including the public header,
+	// e.g. for a path through a tunnel. It is capped at protocol.MaxPacketSize, and raised to protocol.MinPathMTU.
+	// If not set, or if it returns 0, packets up to protocol.MaxPacketSize are sent.
+	PathMTU func(localAddr, remoteAddr net.Addr) protocol.ByteCount
+	// RTTEstimator is called when a path is created to get the estimator smoothing its RTT samples,
+	// e.g. with other gains than the usual EWMA. Its SmoothedRTT is used by the path schedulers and the loss detection.
+	// If not set, the RTT is smoothed with gains of 1/8 and 1/4.
+	RTTEstimator func() RTTEstimator
+	// Metrics receives counters about the path scheduler and the paths of the session, e.g. an *expvar.Map to export them.
+	// The counters of all sessions sharing it add up. If not set, no counters are kept.
+	Metrics MetricsRegistry
+	// PathSwitchMargin is the relative RTT improvement a path needs over the currently preferred path
+	// before the low-latency selection switches to it, e.g. 0.1 for 10%.
+	// Likewise, a rescheduled stream only moves off its previous paths if the one-way delay of another path is lower by this margin.
+	// If this value is zero, it defaults to 10%. A negative value disables the hysteresis.
+	PathSwitchMargin float64
+	// ReservedBandwidth is the fraction of the bandwidth of each path reserved for high priority streams, e.g. 0.3 for 30%.
+	// The low priority streams of a path together don't get more than the rest of its bandwidth.
+	// If not set, no bandwidth is reserved.
+	ReservedBandwidth float64
+	// HighPriorityWeight is the weight from which a stream is high priority for ReservedBandwidth.
+	// If this value is zero, it defaults to 128.
+	HighPriorityWeight uint8
+	// DumpSchedulingInputs is called at the beginning of every scheduling pass with the inputs of the path scheduler.
+	// It is meant for debugging: the records allow replaying assignment decisions offline.
+	// If not set, no record is built.
+	DumpSchedulingInputs func(*SchedulingInputs)
+	// ControlStreams are streams that, like the crypto and header streams, are always scheduled on the lowest-RTT path,
+	// are sent before all other streams and don't count for the priority based path assignment.
+	ControlStreams []StreamID
+	// SocketReceiveBufferSize is the requested receive buffer size of the UDP sockets, in bytes.
+	// If this value is zero, the OS default is used.
+	SocketReceiveBufferSize int
+	// SocketSendBufferSize is the requested send buffer size of the UDP sockets, in bytes.
+	// If this value is zero, the OS default is used.
+	SocketSendBufferSize int
+	// MaxCoalescedPackets is the maximum number of packets of a path that are written to its socket in a single batch.
+	// Waiting packets are written at the latest at the end of every sending pass.
+	// On platforms without batched writes (only Linux supports them), the packets are still written one by one.
+	// If not set or set to 1, every packet is written as soon as it is packed.
+	MaxCoalescedPackets int
+	// ResetCongestionOnMigration defines whether a path goes back to slow start and forgets its RTT statistics
+	// when the remote address of the path changes, since the new network may have very different characteristics.
+	ResetCongestionOnMigration bool
+	// PacketReorderingThreshold enables the packet-threshold loss detection next to the time-based one:
+	// a packet is also declared lost once a packet sent this many packet numbers later is acknowledged, e.g. 3.
+	// If not set, packets are only declared lost after a delay based on the RTT.
+	PacketReorderingThreshold int
+	// IgnoreClosePathLosses keeps the packets in flight on a path closed by the peer from counting as a congestion event.
+	// They are still retransmitted on the remaining paths. If not set, the congestion controller of the path treats them as lost.
+	IgnoreClosePathLosses bool
+	// StreamFrameChecksums adds a CRC32 of the data to every STREAM frame, to detect corruption in debugging experiments.
+	// A packet with a corrupted STREAM frame is dropped without being acked, such that it is retransmitted.
+	// It changes the wire format, and has to be set on both endpoints.
+	StreamFrameChecksums bool
+	// PotentiallyFailedRTOs is the number of consecutive retransmission timeouts without any packet received on a path
+	// before the path is considered potentially failed and no longer used, e.g. 3 to keep using flaky paths.
+	// If not set, a path is considered potentially failed on its first retransmission timeout.
+	PotentiallyFailedRTOs int
+	// MaxTrackedSkippedPackets is the number of packet numbers skipped on a path that are remembered,
+	// such that an ACK for one of them reveals a peer acking packets it didn't receive.
+	// If not set, the last 10 skipped packet numbers of each path are remembered.
+	MaxTrackedSkippedPackets int
+	// MaxReassemblyBuffer bounds the data of a stream that is buffered beyond missing data, e.g. data received on a fast path
+	// while the data before it is still in flight on a slow path. The receive window of the stream is not moved any further,
+	// such that the peer has to wait for the missing data to arrive. This also bounds the data in flight of a stream.
+	// If not set, only the flow control windows bound the buffered data.
+	MaxReassemblyBuffer uint64
+	// PacingGain paces the packets of each path at the rate of its congestion window per smoothed RTT.
+	// Every 8 RTTs, a path sends at this gain times the rate during one RTT and at the inverse of it in the next one,
+	// e.g. 1.25 to probe for more bandwidth. A path may run ahead of its rate by 2 full-sized packets, sending them back-to-back.
+	// If not set, packets are not paced.
+	PacingGain float64
+	// LostPacketHandler is called with every packet declared lost, before its frames are retransmitted.
+	// It is called from the goroutine of the session and must not block.
+	LostPacketHandler func(*LostPacket)
+	// MaxSendRate limits the rate of the packets sent on all paths together, in bytes per second,
+	// independently of the congestion windows of the paths. If not set, the send rate is not limited.
+	MaxSendRate uint64
+	// SendWindowUpdatesOnce disables sending every WINDOW_UPDATE frame a second time in the next packet.
+	// Lost WINDOW_UPDATE frames are still retransmitted.
+	SendWindowUpdatesOnce bool
+	// SendTimestamps makes the host send a TIMESTAMP frame on every path about once per RTT.
+	// It allows the peer to estimate the one-way delays of asymmetric paths instead of using half of the RTT.
+	SendTimestamps bool
+	// MaxAckPacketTolerance makes the host send ACK_FREQUENCY frames on every path, asking the peer to ack
+	// about 4 times per RTT, depending on the congestion window of the path, instead of every second packet.
+	// The peer is never asked to wait for more than MaxAckPacketTolerance packets. If not set, the peer acks every second packet.
+	MaxAckPacketTolerance uint16
+	// MinPathsForSplit is the number of usable paths needed before the server splits a stream across paths.
+	// With fewer paths, each stream is sent on the path with the lowest one-way delay. Striped streams are not affected.
+	// If this value is zero, it defaults to 2.
+	MinPathsForSplit int
+	// MinDetectedStreamSize is the data a stream needs to buffer before the server schedules it by its size,
+	// such that a stream written in small chunks is split on the paths by more than the size of its first chunk.
+	// Until then, Write returns as soon as the data is buffered. Closing or flushing the stream, or 100 ms passing since its first Write,
+	// schedules it with the data buffered so far.
+	// If not set, a stream is scheduled by the size of its first Write.
+	MinDetectedStreamSize uint64
+	// MaxPathsPerStream limits the paths the server splits a stream on to the ones with the lowest one-way delays,
+	// as spreading a small stream on many paths mostly reorders its data. Striped streams are not affected.
+	// If not set, a stream can be split on all usable paths.
+	MaxPathsPerStream int
+	// MaxStreamsPerPath limits the streams the scheduler assigns to a path, not counting the crypto and header streams.
+	// A path that reached it is skipped for new streams, even if it has the lowest RTT, until one of its streams finishes.
+	// If not set, the number of streams of a path is only balanced with the other paths.
+	MaxStreamsPerPath int
+	// PathKeepAlivePeriod makes the host send a PING on a path without streams that didn't send any packet for this period,
+	// e.g. a secondary path only carrying ACKs, such that its NAT binding doesn't expire while the other paths are busy.
+	// Paths carrying streams are not pinged. If not set, only KeepAlive sends PINGs.
+	PathKeepAlivePeriod time.Duration
+	// PathSendBatchSize is the number of packets the server sends per stream of a path in a round of the send loop,
+	// before moving on to the next path. Larger batches interleave the paths less, which reduces the overhead of bulk transfers.
+	// If not set, a path sends one packet per stream and round.
+	PathSendBatchSize int
+	// MaxPathRetransmissionRate limits the packets retransmitted on each path, in packets per second.
+	// Retransmissions beyond it are delayed, while new data is still sent. Handshake packets are not limited.
+	// If not set, retransmissions are only limited by the congestion controller.
+	MaxPathRetransmissionRate float64
+	// MaxRemotePaths limits the paths the peer opens by sending packets on a new path ID.
+	// Path IDs are sent unencrypted, so every such packet would otherwise create a path before it is even decrypted.
+	// Packets on further new path IDs are dropped. If this value is zero, it defaults to 8.
+	MaxRemotePaths int
+	// RequireAdvertisedRemotePaths only opens paths from remote addresses whose IP the peer advertised in an ADD_ADDRESS frame
+	// or used for the initial path. Packets on new path IDs from other addresses are dropped,
+	// which also drops the paths of a peer behind a NAT that rewrites its additional addresses.
+	RequireAdvertisedRemotePaths bool
+	// DatagramHandler is called with the data of every DATAGRAM frame received, see Session.SendDatagram.
+	// It is called from the run loop of the session and must not block.
+	// If not set, received datagrams are dropped.
+	DatagramHandler func(data []byte)
+	// StreamResetHandler is called with the ID of a stream and the error code when the peer resets the stream with a RST_STREAM frame,
+	// e.g. to release the resources of a request right away instead of on the next Read or Write.
+	// It is called from the run loop of the session and must not block.
+	StreamResetHandler func(id StreamID, errorCode uint32)
+	// PathStatsRecorder receives the smoothed RTT and the estimated bandwidth of a path every time an ACK updates them,
+	// as lines of the offset since the creation of the session in nanoseconds, the path ID, the smoothed RTT in nanoseconds and the bandwidth in bit per second.
+	// The series can be fed back to another session with PathStatsReplay, to evaluate schedulers under reproducible conditions.
+	PathStatsRecorder io.Writer
+	// PathStatsReplay is a series written to a PathStatsRecorder. Its samples are applied to the paths as the session reaches their offsets,
+	// replacing the live RTT and bandwidth estimates the schedulers see. The samples of paths not opened yet are skipped.
+	// It is read completely when the session is created.
+	PathStatsReplay io.Reader
+	// TraceWriter enables the per-packet and per-scheduling traces of this session, which are written to it.
+	// Unlike the global QUIC_GO_LOG_LEVEL, it doesn't make the other sessions log.
+	// The errors of the session are still logged with the global log level as well.
+	// If not set, the session logs with the global log level.
+	TraceWriter io.Writer
 }
 
 // A Listener for incoming QUIC connections
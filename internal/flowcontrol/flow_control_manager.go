@@ -147,6 +147,20 @@ func (f *flowControlManager) AddBytesRead(streamID protocol.StreamID, n protocol
 	return nil
 }
 
+// SetReceiveWindowLimit keeps the receive window of a stream from moving beyond an offset, 0 removes the limit
+// streamID must not be 0 here
+func (f *flowControlManager) SetReceiveWindowLimit(streamID protocol.StreamID, limit protocol.ByteCount) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	fc, err := f.getFlowController(streamID)
+	if err != nil {
+		return err
+	}
+	fc.SetReceiveWindowLimit(limit)
+	return nil
+}
+
 func (f *flowControlManager) GetWindowUpdates(force bool) (res []WindowUpdate) {
 	f.mutex.Lock()
 	defer f.mutex.Unlock()
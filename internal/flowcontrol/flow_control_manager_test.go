@@ -77,6 +77,15 @@ var _ = Describe("Flow Control Manager", func() {
 			Expect(fcm.connFlowController.highestReceived).To(Equal(protocol.ByteCount(100 + 50)))
 		})
 
+		It("doesn't count data received twice, e.g. on two paths, for the connection flow control window", func() {
+			Expect(fcm.UpdateHighestReceived(4, 100)).To(Succeed())
+			Expect(fcm.UpdateHighestReceived(4, 100)).To(Succeed())
+			Expect(fcm.UpdateHighestReceived(4, 60)).To(Succeed())
+			Expect(fcm.connFlowController.highestReceived).To(Equal(protocol.ByteCount(100)))
+			Expect(fcm.UpdateHighestReceived(6, 100)).To(Succeed())
+			Expect(fcm.connFlowController.highestReceived).To(Equal(protocol.ByteCount(200)))
+		})
+
 		It("does not update the connection level flow controller if the stream does not contribute", func() {
 			err := fcm.UpdateHighestReceived(1, 100)
 			// fcm.streamFlowController[4].receiveWindow = 0x1000
@@ -156,6 +165,22 @@ var _ = Describe("Flow Control Manager", func() {
 				Expect(updates).ToNot(ContainElement(WindowUpdate{StreamID: 0, Offset: 200}))
 			})
 
+			It("limits the window of a stream", func() {
+				err := fcm.UpdateHighestReceived(4, 100)
+				Expect(err).ToNot(HaveOccurred())
+				err = fcm.AddBytesRead(4, 90)
+				Expect(err).ToNot(HaveOccurred())
+				err = fcm.SetReceiveWindowLimit(4, 150)
+				Expect(err).ToNot(HaveOccurred())
+				updates := fcm.GetWindowUpdates(false)
+				Expect(updates).To(ContainElement(WindowUpdate{StreamID: 4, Offset: 150}))
+			})
+
+			It("errors when limiting the window of a stream that doesn't exist", func() {
+				err := fcm.SetReceiveWindowLimit(17, 1000)
+				Expect(err).To(MatchError(errMapAccess))
+			})
+
 			It("errors when AddBytesRead is called for a stream doesn't exist", func() {
 				err := fcm.AddBytesRead(17, 1000)
 				Expect(err).To(MatchError(errMapAccess))
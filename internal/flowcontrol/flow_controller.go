@@ -31,6 +31,8 @@ type flowController struct {
 	receiveWindow             protocol.ByteCount
 	receiveWindowIncrement    protocol.ByteCount
 	maxReceiveWindowIncrement protocol.ByteCount
+	// if set, the receive window is not moved beyond this offset
+	receiveWindowLimit protocol.ByteCount
 }
 
 // ErrReceivedSmallerByteOffset occurs if the ByteOffset received is smaller than a ByteOffset that was set previously
@@ -152,6 +154,10 @@ func (c *flowController) MaybeUpdateWindow() (bool, protocol.ByteCount /* new in
 
 	// Chromium implements the same threshold
 	if diff < (c.receiveWindowIncrement / 2) {
+		if c.receiveWindowLimit != 0 && c.receiveWindowLimit <= c.receiveWindow {
+			return false, c.receiveWindowIncrement, c.receiveWindow
+		}
+
 		var newWindowIncrement protocol.ByteCount
 		oldWindowIncrement := c.receiveWindowIncrement
 
@@ -162,6 +168,9 @@ func (c *flowController) MaybeUpdateWindow() (bool, protocol.ByteCount /* new in
 
 		c.lastWindowUpdateTime = time.Now()
 		c.receiveWindow = c.bytesRead + c.receiveWindowIncrement
+		if c.receiveWindowLimit != 0 {
+			c.receiveWindow = utils.MinByteCount(c.receiveWindow, c.receiveWindowLimit)
+		}
 		return true, newWindowIncrement, c.receiveWindow
 	}
 
@@ -215,6 +224,12 @@ func (c *flowController) EnsureMinimumWindowIncrement(inc protocol.ByteCount) {
 	}
 }
 
+// SetReceiveWindowLimit keeps the receive window from moving beyond an offset, 0 removes the limit.
+// A window that was already advertised is never reduced.
+func (c *flowController) SetReceiveWindowLimit(limit protocol.ByteCount) {
+	c.receiveWindowLimit = limit
+}
+
 func (c *flowController) CheckFlowControlViolation() bool {
 	return c.highestReceived > c.receiveWindow
 }
@@ -191,6 +191,39 @@ var _ = Describe("Flow controller", func() {
 			Expect(controller.lastWindowUpdateTime).To(Equal(lastWindowUpdateTime))
 		})
 
+		Context("limiting the receive window", func() {
+			var readPosition protocol.ByteCount
+
+			BeforeEach(func() {
+				controller.lastWindowUpdateTime = time.Now().Add(-time.Hour)
+				readPosition = receiveWindow - receiveWindowIncrement/2 + 1
+				controller.bytesRead = readPosition
+			})
+
+			It("doesn't move the window beyond the limit", func() {
+				controller.SetReceiveWindowLimit(receiveWindow + 100)
+				updateNecessary, _, offset := controller.MaybeUpdateWindow()
+				Expect(updateNecessary).To(BeTrue())
+				Expect(offset).To(Equal(receiveWindow + 100))
+				Expect(controller.receiveWindow).To(Equal(receiveWindow + 100))
+			})
+
+			It("doesn't trigger a window update once the limit is reached", func() {
+				controller.SetReceiveWindowLimit(receiveWindow)
+				updateNecessary, _, offset := controller.MaybeUpdateWindow()
+				Expect(updateNecessary).To(BeFalse())
+				Expect(offset).To(Equal(receiveWindow))
+			})
+
+			It("moves the window again once the limit is removed", func() {
+				controller.SetReceiveWindowLimit(receiveWindow)
+				controller.SetReceiveWindowLimit(0)
+				updateNecessary, _, offset := controller.MaybeUpdateWindow()
+				Expect(updateNecessary).To(BeTrue())
+				Expect(offset).To(Equal(readPosition + receiveWindowIncrement))
+			})
+		})
+
 		It("updates the highestReceived", func() {
 			controller.highestReceived = 1337
 			increment, err := controller.UpdateHighestReceived(1338)
@@ -16,6 +16,8 @@ type FlowControlManager interface {
 	ResetStream(streamID protocol.StreamID, byteOffset protocol.ByteCount) error
 	UpdateHighestReceived(streamID protocol.StreamID, byteOffset protocol.ByteCount) error
 	AddBytesRead(streamID protocol.StreamID, n protocol.ByteCount) error
+	// SetReceiveWindowLimit keeps the receive window of a stream from moving beyond an offset, 0 removes the limit
+	SetReceiveWindowLimit(streamID protocol.StreamID, limit protocol.ByteCount) error
 	GetWindowUpdates(force bool) []WindowUpdate
 	GetReceiveWindow(streamID protocol.StreamID) (protocol.ByteCount, error)
 	// methods needed for sending data
@@ -88,6 +88,18 @@ func (_mr *MockFlowControlManagerMockRecorder) AddBytesRead(arg0, arg1 interface
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "AddBytesRead", arg0, arg1)
 }
 
+// SetReceiveWindowLimit mocks base method
+func (_m *MockFlowControlManager) SetReceiveWindowLimit(streamID protocol.StreamID, limit protocol.ByteCount) error {
+	ret := _m.ctrl.Call(_m, "SetReceiveWindowLimit", streamID, limit)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetReceiveWindowLimit indicates an expected call of SetReceiveWindowLimit
+func (_mr *MockFlowControlManagerMockRecorder) SetReceiveWindowLimit(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "SetReceiveWindowLimit", arg0, arg1)
+}
+
 // GetWindowUpdates mocks base method
 func (_m *MockFlowControlManager) GetWindowUpdates(force bool) []flowcontrol.WindowUpdate {
 	ret := _m.ctrl.Call(_m, "GetWindowUpdates", force)
@@ -76,5 +76,30 @@ const MaxClientHellos = 3
 // DefaultStreamWeight is the default stream weight
 const DefaultStreamWeight uint8 = 15
 
+// DefaultHighPriorityWeight is the default weight from which a stream can use the bandwidth reserved for high priority streams
+const DefaultHighPriorityWeight uint8 = 128
+
 // DefaultPathScheduler is the default path scheduler
 const DefaultPathScheduler = "MultiPath"
+
+// CostAwarePathScheduler is the path scheduler using high cost paths only for the data beyond the windows of the low cost paths
+const CostAwarePathScheduler = "CostAware"
+
+// DefaultPathSwitchMargin is the default relative RTT improvement needed to switch the preferred path
+const DefaultPathSwitchMargin = 0.1
+
+// DefaultMinPathsForSplit is the default number of usable paths needed before a stream is split across paths
+const DefaultMinPathsForSplit = 2
+
+// DefaultPathSendBatchSize is the default number of packets sent per stream of a path in a round of the send loop
+const DefaultPathSendBatchSize = 1
+
+// DefaultMaxRemotePaths is the default number of paths the peer may open by sending packets on a new path ID
+const DefaultMaxRemotePaths = 8
+
+// RecoveryBandwidthFactor scales the bandwidth of a path in loss recovery when assigning the volume of a stream,
+// as its congestion window was just reduced
+const RecoveryBandwidthFactor = 0.5
+
+// PathWarmUpRTTSamples is the number of RTT samples after which the volume assigned to a path isn't reduced anymore
+const PathWarmUpRTTSamples = 4
@@ -6,6 +6,17 @@ import "time"
 // This is the value used by Chromium for a QUIC packet sent using IPv6 (for IPv4 it would be 1370)
 const MaxPacketSize ByteCount = 1350
 
+// MinPathMTU is the smallest packet size, including the public header, a path is limited to.
+// It leaves room for a padded CHLO, and for the headers and overhead of any packet.
+const MinPathMTU ByteCount = 1200
+
+// MaxPacketNumber is the largest packet number that can be sent, it is encoded on at most 6 bytes
+const MaxPacketNumber PacketNumber = 1<<48 - 1
+
+// PacketNumberExhaustionMargin is how close to MaxPacketNumber the packet numbers of a path may get before the path is closed.
+// It leaves room for the packets sent while closing the path.
+const PacketNumberExhaustionMargin PacketNumber = 1 << 16
+
 // NonForwardSecurePacketSizeReduction is the number of bytes a non forward-secure packet has to be smaller than a forward-secure packet
 // This makes sure that those packets can always be retransmitted without splitting the contained StreamFrames
 const NonForwardSecurePacketSizeReduction = 50
@@ -26,6 +37,19 @@ const MaxUndecryptablePackets = 10
 // This timeout allows the Go scheduler to switch to the Go rountine that reads the crypto stream and to escalate the crypto
 const PublicResetTimeout = 500 * time.Millisecond
 
+// TransientWriteErrorBackoff is the time a path is not used after a write on its conn failed temporarily, e.g. with ENOBUFS
+const TransientWriteErrorBackoff = 5 * time.Millisecond
+
+// MaxStreamSizeDetectionDelay is the time after its first Write after which a stream waiting for Config.MinDetectedStreamSize
+// is scheduled with the data it buffered so far
+const MaxStreamSizeDetectionDelay = 100 * time.Millisecond
+
+// FailedPathRetryPeriod is the time after which a path whose conn write failed is probed again
+const FailedPathRetryPeriod = 1 * time.Second
+
+// RetransmissionRateWindow is the period the retransmission rate of a path is measured and limited over
+const RetransmissionRateWindow = time.Second
+
 // AckSendDelay is the maximum delay that can be applied to an ACK for a retransmittable packet
 // This is the value Chromium is using
 const AckSendDelay = 25 * time.Millisecond
@@ -101,6 +125,13 @@ const MaxNonRetransmittablePackets = 19
 // RetransmittablePacketsBeforeAck is the number of retransmittable that an ACK is sent for
 const RetransmittablePacketsBeforeAck = 2
 
+// UnderUtilizationRTTs is the number of smoothed RTTs during which fewer bytes than the BDP have to be in flight
+// for a path to be considered under-utilized
+const UnderUtilizationRTTs = 4
+
+// AckFrequencyAcksPerRTT is the number of ACKs per RTT the peer is asked for with ACK_FREQUENCY frames
+const AckFrequencyAcksPerRTT = 4
+
 // MaxStreamFrameSorterGaps is the maximum number of gaps between received StreamFrames
 // prevents DoS attacks against the streamFrameSorter
 // XXX (QDC): needs to be compliant with the maximal congestion window
@@ -128,3 +159,17 @@ const ClosedSessionDeleteTimeout = time.Minute
 
 // NumCachedCertificates is the number of cached compressed certificate chains, each taking ~1K space
 const NumCachedCertificates = 128
+
+// DefaultStreamGapTimeout is the time a gap in the received stream data may persist before the peer is asked to
+// retransmit the missing range, used as long as no RTT is known
+const DefaultStreamGapTimeout = 100 * time.Millisecond
+
+// MaxDatagramSize is the maximum size of the data sent in a DATAGRAM frame, such that it fits in a single packet
+const MaxDatagramSize ByteCount = 1200
+
+// MaxQueuedDatagrams is the maximum number of datagrams waiting to be sent
+const MaxQueuedDatagrams = 32
+
+// SendRateBurstDuration is the duration of sending at Config.MaxSendRate that can be sent in a burst,
+// after no packets were sent for a while
+const SendRateBurstDuration = 10 * time.Millisecond
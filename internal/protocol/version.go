@@ -67,6 +67,22 @@ func IsSupportedVersion(supported []VersionNumber, v VersionNumber) bool {
 	return false
 }
 
+// PreferMultipath orders versions for the multipath preference, keeping the order of the single-path versions
+// if multipath is true, VersionMP is moved to the front, so that it is chosen whenever the peer supports it
+// if multipath is false, VersionMP is removed
+func PreferMultipath(versions []VersionNumber, multipath bool) []VersionNumber {
+	preferred := make([]VersionNumber, 0, len(versions))
+	if multipath && IsSupportedVersion(versions, VersionMP) {
+		preferred = append(preferred, VersionMP)
+	}
+	for _, v := range versions {
+		if v != VersionMP {
+			preferred = append(preferred, v)
+		}
+	}
+	return preferred
+}
+
 // ChooseSupportedVersion finds the best version in the overlap of ours and theirs
 // ours is a slice of versions that we support, sorted by our preference (descending)
 // theirs is a slice of versions offered by the peer. The order does not matter
@@ -65,4 +65,29 @@ var _ = Describe("Version", func() {
 			Expect(ChooseSupportedVersion(supportedVersions, []VersionNumber{})).To(Equal(VersionUnsupported))
 		})
 	})
+
+	Context("multipath preference", func() {
+		It("prefers multipath if both sides support it", func() {
+			ours := PreferMultipath([]VersionNumber{Version39, VersionMP, Version37}, true)
+			Expect(ours).To(Equal([]VersionNumber{VersionMP, Version39, Version37}))
+			Expect(ChooseSupportedVersion(ours, SupportedVersions)).To(Equal(VersionMP))
+		})
+
+		It("falls back to a single-path version if the peer doesn't support multipath", func() {
+			ours := PreferMultipath(SupportedVersions, true)
+			Expect(ChooseSupportedVersion(ours, []VersionNumber{Version38, Version39})).To(Equal(Version39))
+		})
+
+		It("uses a single-path version if multipath is disabled", func() {
+			ours := PreferMultipath(SupportedVersions, false)
+			Expect(ours).ToNot(ContainElement(VersionMP))
+			Expect(ChooseSupportedVersion(ours, SupportedVersions)).To(Equal(Version39))
+		})
+
+		It("doesn't modify the versions passed in", func() {
+			versions := []VersionNumber{Version39, VersionMP}
+			PreferMultipath(versions, true)
+			Expect(versions).To(Equal([]VersionNumber{Version39, VersionMP}))
+		})
+	})
 })
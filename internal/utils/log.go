@@ -2,6 +2,7 @@ package utils
 
 import (
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"strings"
@@ -75,6 +76,60 @@ func Debug() bool {
 	return logLevel == LogLevelDebug
 }
 
+// A Logger logs the messages of a single session.
+// The zero value logs like Debugf, Infof and Errorf, with the global log level.
+type Logger struct {
+	out *log.Logger
+}
+
+// NewWriterLogger returns a Logger that writes all messages, including the debug messages, to w.
+// It doesn't depend on the global log level, but the errors are also logged like Errorf, so that they aren't missed.
+func NewWriterLogger(w io.Writer) Logger {
+	return Logger{out: log.New(w, "", 0)}
+}
+
+// Debug returns true if the debug messages are logged
+func (l Logger) Debug() bool {
+	if l.out == nil {
+		return Debug()
+	}
+	return true
+}
+
+// Debugf logs something
+func (l Logger) Debugf(format string, args ...interface{}) {
+	if l.out == nil {
+		Debugf(format, args...)
+		return
+	}
+	l.logMessage(format, args...)
+}
+
+// Infof logs something
+func (l Logger) Infof(format string, args ...interface{}) {
+	if l.out == nil {
+		Infof(format, args...)
+		return
+	}
+	l.logMessage(format, args...)
+}
+
+// Errorf logs something
+func (l Logger) Errorf(format string, args ...interface{}) {
+	Errorf(format, args...)
+	if l.out != nil {
+		l.logMessage(format, args...)
+	}
+}
+
+func (l Logger) logMessage(format string, args ...interface{}) {
+	if len(timeFormat) > 0 {
+		l.out.Printf(time.Now().Format(timeFormat)+" "+format, args...)
+	} else {
+		l.out.Printf(format, args...)
+	}
+}
+
 func init() {
 	readLoggingEnv()
 }
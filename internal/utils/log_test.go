@@ -97,6 +97,44 @@ var _ = Describe("Log", func() {
 		Expect(Debug()).To(BeTrue())
 	})
 
+	Context("session loggers", func() {
+		It("logs with the global log level by default", func() {
+			var logger Logger
+			Expect(logger.Debug()).To(BeFalse())
+			logger.Debugf("debug")
+			logger.Errorf("err")
+			Expect(b.Bytes()).To(BeEmpty())
+			SetLogLevel(LogLevelError)
+			logger.Infof("info")
+			logger.Errorf("err")
+			Expect(b.String()).To(ContainSubstring("err\n"))
+			Expect(b.String()).ToNot(ContainSubstring("info"))
+		})
+
+		It("writes all messages to its writer, independently of the global log level", func() {
+			SetLogTimeFormat("")
+			out := &bytes.Buffer{}
+			logger := NewWriterLogger(out)
+			Expect(logger.Debug()).To(BeTrue())
+			logger.Debugf("debug %d", 1)
+			logger.Infof("info")
+			logger.Errorf("err")
+			Expect(out.String()).To(Equal("debug 1\ninfo\nerr\n"))
+			Expect(b.Bytes()).To(BeEmpty())
+		})
+
+		It("also logs the errors with the global log level", func() {
+			SetLogTimeFormat("")
+			SetLogLevel(LogLevelError)
+			out := &bytes.Buffer{}
+			logger := NewWriterLogger(out)
+			logger.Infof("info")
+			logger.Errorf("err")
+			Expect(out.String()).To(Equal("info\nerr\n"))
+			Expect(b.String()).To(Equal("err\n"))
+		})
+	})
+
 	Context("reading from env", func() {
 		BeforeEach(func() {
 			Expect(logLevel).To(Equal(LogLevelNothing))
@@ -0,0 +1,61 @@
+package wire
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/lucas-clemente/pstream/internal/protocol"
+	"github.com/lucas-clemente/pstream/internal/utils"
+)
+
+// An AckFrequencyFrame asks the peer to ack the packets received on the path the frame is sent on
+// only once PacketTolerance retransmittable packets were received, or after MaxAckDelay.
+// Frames with a SequenceNumber not larger than the one of the last frame received are ignored.
+type AckFrequencyFrame struct {
+	SequenceNumber  uint32
+	PacketTolerance uint16
+	MaxAckDelay     time.Duration
+}
+
+// Write writes an ACK_FREQUENCY frame
+func (f *AckFrequencyFrame) Write(b *bytes.Buffer, version protocol.VersionNumber) error {
+	b.WriteByte(0x17)
+	utils.GetByteOrder(version).WriteUint32(b, f.SequenceNumber)
+	utils.GetByteOrder(version).WriteUint16(b, f.PacketTolerance)
+	utils.GetByteOrder(version).WriteUint32(b, uint32(f.MaxAckDelay/time.Microsecond))
+	return nil
+}
+
+// MinLength of a written frame
+func (f *AckFrequencyFrame) MinLength(version protocol.VersionNumber) (protocol.ByteCount, error) {
+	return 1 + 4 + 2 + 4, nil
+}
+
+// ParseAckFrequencyFrame parses an ACK_FREQUENCY frame
+func ParseAckFrequencyFrame(r *bytes.Reader, version protocol.VersionNumber) (*AckFrequencyFrame, error) {
+	frame := &AckFrequencyFrame{}
+
+	// read the TypeByte
+	if _, err := r.ReadByte(); err != nil {
+		return nil, err
+	}
+
+	seq, err := utils.GetByteOrder(version).ReadUint32(r)
+	if err != nil {
+		return nil, err
+	}
+	frame.SequenceNumber = seq
+
+	tolerance, err := utils.GetByteOrder(version).ReadUint16(r)
+	if err != nil {
+		return nil, err
+	}
+	frame.PacketTolerance = tolerance
+
+	delay, err := utils.GetByteOrder(version).ReadUint32(r)
+	if err != nil {
+		return nil, err
+	}
+	frame.MaxAckDelay = time.Duration(delay) * time.Microsecond
+	return frame, nil
+}
@@ -0,0 +1,71 @@
+package wire
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/lucas-clemente/pstream/internal/protocol"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("AckFrequencyFrame", func() {
+	Context("when parsing", func() {
+		It("accepts sample frame", func() {
+			b := bytes.NewReader([]byte{0x17,
+				0xde, 0xad, 0xbe, 0xef, // sequence number
+				0x00, 0x0a, // packet tolerance
+				0x00, 0x00, 0x61, 0xa8, // max ack delay
+			})
+			frame, err := ParseAckFrequencyFrame(b, versionBigEndian)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(frame.SequenceNumber).To(Equal(uint32(0xdeadbeef)))
+			Expect(frame.PacketTolerance).To(Equal(uint16(10)))
+			Expect(frame.MaxAckDelay).To(Equal(25 * time.Millisecond))
+			Expect(b.Len()).To(BeZero())
+		})
+
+		It("errors on EOFs", func() {
+			data := []byte{0x17,
+				0xef, 0xbe, 0xad, 0xde, // sequence number
+				0x0a, 0x00, // packet tolerance
+				0xa8, 0x61, 0x00, 0x00, // max ack delay
+			}
+			_, err := ParseAckFrequencyFrame(bytes.NewReader(data), versionLittleEndian)
+			Expect(err).NotTo(HaveOccurred())
+			for i := range data {
+				_, err := ParseAckFrequencyFrame(bytes.NewReader(data[0:i]), versionLittleEndian)
+				Expect(err).To(HaveOccurred())
+			}
+		})
+	})
+
+	Context("when writing", func() {
+		It("has proper min length", func() {
+			f := &AckFrequencyFrame{SequenceNumber: 1, PacketTolerance: 10}
+			Expect(f.MinLength(0)).To(Equal(protocol.ByteCount(11)))
+		})
+
+		It("writes a sample frame", func() {
+			b := &bytes.Buffer{}
+			f := &AckFrequencyFrame{SequenceNumber: 0xdecafbad, PacketTolerance: 0x1337, MaxAckDelay: 25 * time.Millisecond}
+			err := f.Write(b, versionBigEndian)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(b.Bytes()).To(Equal([]byte{0x17,
+				0xde, 0xca, 0xfb, 0xad, // sequence number
+				0x13, 0x37, // packet tolerance
+				0x00, 0x00, 0x61, 0xa8, // max ack delay
+			}))
+		})
+
+		It("is parsed back", func() {
+			b := &bytes.Buffer{}
+			f := &AckFrequencyFrame{SequenceNumber: 3, PacketTolerance: 16, MaxAckDelay: 10 * time.Millisecond}
+			err := f.Write(b, versionLittleEndian)
+			Expect(err).ToNot(HaveOccurred())
+			frame, err := ParseAckFrequencyFrame(bytes.NewReader(b.Bytes()), versionLittleEndian)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(frame).To(Equal(f))
+		})
+	})
+})
@@ -0,0 +1,55 @@
+package wire
+
+import (
+	"bytes"
+	"errors"
+	"io"
+
+	"github.com/lucas-clemente/pstream/internal/protocol"
+	"github.com/lucas-clemente/pstream/internal/utils"
+)
+
+// A DatagramFrame carries application data outside of any stream.
+// It is neither ordered nor retransmitted when the packet carrying it is lost.
+type DatagramFrame struct {
+	Data []byte
+}
+
+// Write writes a DATAGRAM frame
+func (f *DatagramFrame) Write(b *bytes.Buffer, version protocol.VersionNumber) error {
+	if len(f.Data) > 0xffff {
+		return errors.New("DatagramFrame: data too large")
+	}
+	b.WriteByte(0x16)
+	utils.GetByteOrder(version).WriteUint16(b, uint16(len(f.Data)))
+	b.Write(f.Data)
+	return nil
+}
+
+// MinLength of a written frame
+func (f *DatagramFrame) MinLength(version protocol.VersionNumber) (protocol.ByteCount, error) {
+	return 1 + 2 + protocol.ByteCount(len(f.Data)), nil
+}
+
+// ParseDatagramFrame parses a DATAGRAM frame
+func ParseDatagramFrame(r *bytes.Reader, version protocol.VersionNumber) (*DatagramFrame, error) {
+	frame := &DatagramFrame{}
+
+	// read the TypeByte
+	if _, err := r.ReadByte(); err != nil {
+		return nil, err
+	}
+
+	length, err := utils.GetByteOrder(version).ReadUint16(r)
+	if err != nil {
+		return nil, err
+	}
+	if int(length) > r.Len() {
+		return nil, errors.New("DatagramFrame: data length larger than the packet")
+	}
+	frame.Data = make([]byte, length)
+	if _, err := io.ReadFull(r, frame.Data); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
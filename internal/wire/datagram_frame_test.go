@@ -0,0 +1,68 @@
+package wire
+
+import (
+	"bytes"
+
+	"github.com/lucas-clemente/pstream/internal/protocol"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("DatagramFrame", func() {
+	Context("when parsing", func() {
+		It("accepts sample frame", func() {
+			b := bytes.NewReader([]byte{0x16,
+				0x00, 0x03, // length
+				'f', 'o', 'o',
+			})
+			frame, err := ParseDatagramFrame(b, versionBigEndian)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(frame.Data).To(Equal([]byte("foo")))
+			Expect(b.Len()).To(BeZero())
+		})
+
+		It("accepts an empty frame", func() {
+			b := bytes.NewReader([]byte{0x16, 0x00, 0x00})
+			frame, err := ParseDatagramFrame(b, versionBigEndian)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(frame.Data).To(BeEmpty())
+			Expect(b.Len()).To(BeZero())
+		})
+
+		It("errors on EOFs", func() {
+			data := []byte{0x16,
+				0x03, 0x00, // length
+				'f', 'o', 'o',
+			}
+			_, err := ParseDatagramFrame(bytes.NewReader(data), versionLittleEndian)
+			Expect(err).NotTo(HaveOccurred())
+			for i := range data {
+				_, err := ParseDatagramFrame(bytes.NewReader(data[0:i]), versionLittleEndian)
+				Expect(err).To(HaveOccurred())
+			}
+		})
+	})
+
+	Context("when writing", func() {
+		It("has proper min length", func() {
+			f := &DatagramFrame{Data: []byte("foobar")}
+			Expect(f.MinLength(0)).To(Equal(protocol.ByteCount(1 + 2 + 6)))
+		})
+
+		It("writes a sample frame", func() {
+			b := &bytes.Buffer{}
+			f := &DatagramFrame{Data: []byte("foo")}
+			err := f.Write(b, versionBigEndian)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(b.Bytes()).To(Equal([]byte{0x16,
+				0x00, 0x03, // length
+				'f', 'o', 'o',
+			}))
+		})
+
+		It("refuses to write too much data", func() {
+			f := &DatagramFrame{Data: make([]byte, 0x10000)}
+			Expect(f.Write(&bytes.Buffer{}, versionBigEndian)).ToNot(Succeed())
+		})
+	})
+})
@@ -0,0 +1,59 @@
+package wire
+
+import (
+	"bytes"
+
+	"github.com/lucas-clemente/pstream/internal/protocol"
+	"github.com/lucas-clemente/pstream/internal/utils"
+)
+
+// A FastRetransmitFrame asks the peer to retransmit a range of stream data
+// that is missing at the receiver
+type FastRetransmitFrame struct {
+	StreamID protocol.StreamID
+	Offset   protocol.ByteCount
+	Length   protocol.ByteCount
+}
+
+// Write writes a FAST_RETRANSMIT frame
+func (f *FastRetransmitFrame) Write(b *bytes.Buffer, version protocol.VersionNumber) error {
+	b.WriteByte(0x13)
+	utils.GetByteOrder(version).WriteUint32(b, uint32(f.StreamID))
+	utils.GetByteOrder(version).WriteUint64(b, uint64(f.Offset))
+	utils.GetByteOrder(version).WriteUint64(b, uint64(f.Length))
+	return nil
+}
+
+// MinLength of a written frame
+func (f *FastRetransmitFrame) MinLength(version protocol.VersionNumber) (protocol.ByteCount, error) {
+	return 1 + 4 + 8 + 8, nil
+}
+
+// ParseFastRetransmitFrame parses a FAST_RETRANSMIT frame
+func ParseFastRetransmitFrame(r *bytes.Reader, version protocol.VersionNumber) (*FastRetransmitFrame, error) {
+	frame := &FastRetransmitFrame{}
+
+	// read the TypeByte
+	if _, err := r.ReadByte(); err != nil {
+		return nil, err
+	}
+
+	sid, err := utils.GetByteOrder(version).ReadUint32(r)
+	if err != nil {
+		return nil, err
+	}
+	frame.StreamID = protocol.StreamID(sid)
+
+	offset, err := utils.GetByteOrder(version).ReadUint64(r)
+	if err != nil {
+		return nil, err
+	}
+	frame.Offset = protocol.ByteCount(offset)
+
+	length, err := utils.GetByteOrder(version).ReadUint64(r)
+	if err != nil {
+		return nil, err
+	}
+	frame.Length = protocol.ByteCount(length)
+	return frame, nil
+}
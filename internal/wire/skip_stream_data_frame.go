@@ -0,0 +1,59 @@
+package wire
+
+import (
+	"bytes"
+
+	"github.com/lucas-clemente/pstream/internal/protocol"
+	"github.com/lucas-clemente/pstream/internal/utils"
+)
+
+// A SkipStreamDataFrame tells the peer that a range of stream data expired at the sender
+// and will never be retransmitted, such that the receiver skips over it
+type SkipStreamDataFrame struct {
+	StreamID protocol.StreamID
+	Offset   protocol.ByteCount
+	Length   protocol.ByteCount
+}
+
+// Write writes a SKIP_STREAM_DATA frame
+func (f *SkipStreamDataFrame) Write(b *bytes.Buffer, version protocol.VersionNumber) error {
+	b.WriteByte(0x14)
+	utils.GetByteOrder(version).WriteUint32(b, uint32(f.StreamID))
+	utils.GetByteOrder(version).WriteUint64(b, uint64(f.Offset))
+	utils.GetByteOrder(version).WriteUint64(b, uint64(f.Length))
+	return nil
+}
+
+// MinLength of a written frame
+func (f *SkipStreamDataFrame) MinLength(version protocol.VersionNumber) (protocol.ByteCount, error) {
+	return 1 + 4 + 8 + 8, nil
+}
+
+// ParseSkipStreamDataFrame parses a SKIP_STREAM_DATA frame
+func ParseSkipStreamDataFrame(r *bytes.Reader, version protocol.VersionNumber) (*SkipStreamDataFrame, error) {
+	frame := &SkipStreamDataFrame{}
+
+	// read the TypeByte
+	if _, err := r.ReadByte(); err != nil {
+		return nil, err
+	}
+
+	sid, err := utils.GetByteOrder(version).ReadUint32(r)
+	if err != nil {
+		return nil, err
+	}
+	frame.StreamID = protocol.StreamID(sid)
+
+	offset, err := utils.GetByteOrder(version).ReadUint64(r)
+	if err != nil {
+		return nil, err
+	}
+	frame.Offset = protocol.ByteCount(offset)
+
+	length, err := utils.GetByteOrder(version).ReadUint64(r)
+	if err != nil {
+		return nil, err
+	}
+	frame.Length = protocol.ByteCount(length)
+	return frame, nil
+}
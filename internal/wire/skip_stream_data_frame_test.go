@@ -0,0 +1,78 @@
+package wire
+
+import (
+	"bytes"
+
+	"github.com/lucas-clemente/pstream/internal/protocol"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SkipStreamDataFrame", func() {
+	Context("when parsing", func() {
+		It("accepts sample frame", func() {
+			b := bytes.NewReader([]byte{0x14,
+				0xde, 0xad, 0xbe, 0xef, // stream id
+				0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x13, 0x37, // offset
+				0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x04, 0x00, // length
+			})
+			frame, err := ParseSkipStreamDataFrame(b, versionBigEndian)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(frame.StreamID).To(Equal(protocol.StreamID(0xdeadbeef)))
+			Expect(frame.Offset).To(Equal(protocol.ByteCount(0x1337)))
+			Expect(frame.Length).To(Equal(protocol.ByteCount(0x400)))
+			Expect(b.Len()).To(BeZero())
+		})
+
+		It("errors on EOFs", func() {
+			data := []byte{0x14,
+				0xef, 0xbe, 0xad, 0xde, // stream id
+				0x37, 0x13, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // offset
+				0x00, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // length
+			}
+			_, err := ParseSkipStreamDataFrame(bytes.NewReader(data), versionLittleEndian)
+			Expect(err).NotTo(HaveOccurred())
+			for i := range data {
+				_, err := ParseSkipStreamDataFrame(bytes.NewReader(data[0:i]), versionLittleEndian)
+				Expect(err).To(HaveOccurred())
+			}
+		})
+	})
+
+	Context("when writing", func() {
+		It("has proper min length", func() {
+			f := &SkipStreamDataFrame{
+				StreamID: 0x1337,
+				Offset:   0xdeadbeef,
+				Length:   0x100,
+			}
+			Expect(f.MinLength(0)).To(Equal(protocol.ByteCount(21)))
+		})
+
+		It("writes a sample frame", func() {
+			b := &bytes.Buffer{}
+			f := &SkipStreamDataFrame{
+				StreamID: 0xdecafbad,
+				Offset:   0xdeadbeefcafe1337,
+				Length:   0x400,
+			}
+			err := f.Write(b, versionBigEndian)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(b.Bytes()).To(Equal([]byte{0x14,
+				0xde, 0xca, 0xfb, 0xad, // stream id
+				0xde, 0xad, 0xbe, 0xef, 0xca, 0xfe, 0x13, 0x37, // offset
+				0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x04, 0x00, // length
+			}))
+		})
+
+		It("is parsed back to the same frame", func() {
+			b := &bytes.Buffer{}
+			f := &SkipStreamDataFrame{StreamID: 5, Offset: 0x1000, Length: 0x500}
+			err := f.Write(b, versionLittleEndian)
+			Expect(err).ToNot(HaveOccurred())
+			frame, err := ParseSkipStreamDataFrame(bytes.NewReader(b.Bytes()), versionLittleEndian)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(frame).To(Equal(f))
+		})
+	})
+})
@@ -3,6 +3,7 @@ package wire
 import (
 	"bytes"
 	"errors"
+	"hash/crc32"
 	"io"
 
 	"github.com/lucas-clemente/pstream/internal/protocol"
@@ -17,6 +18,11 @@ type StreamFrame struct {
 	DataLenPresent bool
 	Offset         protocol.ByteCount
 	Data           []byte
+	// ChecksumPresent adds a CRC32 of the data after the data length, for debugging corruption.
+	// It is not signaled in the type byte, the peer has to expect it, see ParseStreamFrameWithChecksum.
+	ChecksumPresent bool
+	// Checksum is the checksum read from the wire, Write computes it from the data
+	Checksum uint32
 }
 
 var (
@@ -26,7 +32,17 @@ var (
 
 // ParseStreamFrame reads a stream frame. The type byte must not have been read yet.
 func ParseStreamFrame(r *bytes.Reader, version protocol.VersionNumber) (*StreamFrame, error) {
-	frame := &StreamFrame{}
+	return parseStreamFrame(r, version, false)
+}
+
+// ParseStreamFrameWithChecksum reads a stream frame that carries a checksum of its data.
+// The checksum is not verified, see ChecksumValid.
+func ParseStreamFrameWithChecksum(r *bytes.Reader, version protocol.VersionNumber) (*StreamFrame, error) {
+	return parseStreamFrame(r, version, true)
+}
+
+func parseStreamFrame(r *bytes.Reader, version protocol.VersionNumber, withChecksum bool) (*StreamFrame, error) {
+	frame := &StreamFrame{ChecksumPresent: withChecksum}
 
 	typeByte, err := r.ReadByte()
 	if err != nil {
@@ -65,6 +81,13 @@ func ParseStreamFrame(r *bytes.Reader, version protocol.VersionNumber) (*StreamF
 		return nil, qerr.Error(qerr.InvalidStreamData, "data len too large")
 	}
 
+	if frame.ChecksumPresent {
+		frame.Checksum, err = utils.GetByteOrder(version).ReadUint32(r)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	if !frame.DataLenPresent {
 		// The rest of the packet is data
 		dataLen = uint16(r.Len())
@@ -146,6 +169,10 @@ func (f *StreamFrame) Write(b *bytes.Buffer, version protocol.VersionNumber) err
 		utils.GetByteOrder(version).WriteUint16(b, uint16(len(f.Data)))
 	}
 
+	if f.ChecksumPresent {
+		utils.GetByteOrder(version).WriteUint32(b, crc32.ChecksumIEEE(f.Data))
+	}
+
 	b.Write(f.Data)
 	return nil
 }
@@ -193,9 +220,18 @@ func (f *StreamFrame) MinLength(protocol.VersionNumber) (protocol.ByteCount, err
 	if f.DataLenPresent {
 		length += 2
 	}
+	if f.ChecksumPresent {
+		length += 4
+	}
 	return length, nil
 }
 
+// ChecksumValid checks the checksum of a parsed frame against its data.
+// A frame without checksum is always valid.
+func (f *StreamFrame) ChecksumValid() bool {
+	return !f.ChecksumPresent || f.Checksum == crc32.ChecksumIEEE(f.Data)
+}
+
 // DataLen gives the length of data in bytes
 func (f *StreamFrame) DataLen() protocol.ByteCount {
 	return protocol.ByteCount(len(f.Data))
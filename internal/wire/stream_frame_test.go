@@ -729,4 +729,55 @@ var _ = Describe("StreamFrame", func() {
 			Expect(frame.DataLen()).To(Equal(protocol.ByteCount(6)))
 		})
 	})
+
+	Context("checksums", func() {
+		var frame *StreamFrame
+
+		BeforeEach(func() {
+			frame = &StreamFrame{
+				StreamID:        5,
+				Offset:          0x1337,
+				DataLenPresent:  true,
+				ChecksumPresent: true,
+				Data:            []byte("foobar"),
+			}
+		})
+
+		It("writes and parses the checksum", func() {
+			b := &bytes.Buffer{}
+			err := frame.Write(b, versionBigEndian)
+			Expect(err).ToNot(HaveOccurred())
+			minLength, _ := frame.MinLength(versionBigEndian)
+			Expect(b.Len()).To(BeEquivalentTo(minLength + frame.DataLen()))
+			parsed, err := ParseStreamFrameWithChecksum(bytes.NewReader(b.Bytes()), versionBigEndian)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(parsed.StreamID).To(Equal(frame.StreamID))
+			Expect(parsed.Offset).To(Equal(frame.Offset))
+			Expect(parsed.Data).To(Equal(frame.Data))
+			Expect(parsed.ChecksumValid()).To(BeTrue())
+		})
+
+		It("detects corrupted data", func() {
+			b := &bytes.Buffer{}
+			err := frame.Write(b, versionBigEndian)
+			Expect(err).ToNot(HaveOccurred())
+			data := b.Bytes()
+			data[len(data)-1] ^= 0xff
+			parsed, err := ParseStreamFrameWithChecksum(bytes.NewReader(data), versionBigEndian)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(parsed.ChecksumValid()).To(BeFalse())
+		})
+
+		It("adds the checksum to the min length", func() {
+			withChecksum, _ := frame.MinLength(versionBigEndian)
+			frame.ChecksumPresent = false
+			withoutChecksum, _ := frame.MinLength(versionBigEndian)
+			Expect(withChecksum).To(Equal(withoutChecksum + 4))
+		})
+
+		It("treats a frame without checksum as valid", func() {
+			frame.ChecksumPresent = false
+			Expect(frame.ChecksumValid()).To(BeTrue())
+		})
+	})
 })
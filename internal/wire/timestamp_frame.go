@@ -0,0 +1,44 @@
+package wire
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/lucas-clemente/pstream/internal/protocol"
+	"github.com/lucas-clemente/pstream/internal/utils"
+)
+
+// A TimestampFrame carries the time at which the packet was sent, according to the clock of the sender.
+// It allows the receiver to measure the one-way delay of the path, up to the offset between both clocks.
+type TimestampFrame struct {
+	Timestamp time.Time
+}
+
+// Write writes a TIMESTAMP frame
+func (f *TimestampFrame) Write(b *bytes.Buffer, version protocol.VersionNumber) error {
+	b.WriteByte(0x15)
+	utils.GetByteOrder(version).WriteUint64(b, uint64(f.Timestamp.UnixNano()/int64(time.Microsecond)))
+	return nil
+}
+
+// MinLength of a written frame
+func (f *TimestampFrame) MinLength(version protocol.VersionNumber) (protocol.ByteCount, error) {
+	return 1 + 8, nil
+}
+
+// ParseTimestampFrame parses a TIMESTAMP frame
+func ParseTimestampFrame(r *bytes.Reader, version protocol.VersionNumber) (*TimestampFrame, error) {
+	frame := &TimestampFrame{}
+
+	// read the TypeByte
+	if _, err := r.ReadByte(); err != nil {
+		return nil, err
+	}
+
+	us, err := utils.GetByteOrder(version).ReadUint64(r)
+	if err != nil {
+		return nil, err
+	}
+	frame.Timestamp = time.Unix(0, int64(us)*int64(time.Microsecond))
+	return frame, nil
+}
@@ -0,0 +1,64 @@
+package wire
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/lucas-clemente/pstream/internal/protocol"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("TimestampFrame", func() {
+	Context("when parsing", func() {
+		It("accepts sample frame", func() {
+			b := bytes.NewReader([]byte{0x15,
+				0x00, 0x00, 0x00, 0x00, 0xde, 0xad, 0xbe, 0xef, // timestamp
+			})
+			frame, err := ParseTimestampFrame(b, versionBigEndian)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(frame.Timestamp).To(Equal(time.Unix(0, 0xdeadbeef*int64(time.Microsecond))))
+			Expect(b.Len()).To(BeZero())
+		})
+
+		It("errors on EOFs", func() {
+			data := []byte{0x15,
+				0xef, 0xbe, 0xad, 0xde, 0x00, 0x00, 0x00, 0x00, // timestamp
+			}
+			_, err := ParseTimestampFrame(bytes.NewReader(data), versionLittleEndian)
+			Expect(err).NotTo(HaveOccurred())
+			for i := range data {
+				_, err := ParseTimestampFrame(bytes.NewReader(data[0:i]), versionLittleEndian)
+				Expect(err).To(HaveOccurred())
+			}
+		})
+	})
+
+	Context("when writing", func() {
+		It("has proper min length", func() {
+			f := &TimestampFrame{Timestamp: time.Now()}
+			Expect(f.MinLength(0)).To(Equal(protocol.ByteCount(9)))
+		})
+
+		It("writes a sample frame", func() {
+			b := &bytes.Buffer{}
+			f := &TimestampFrame{Timestamp: time.Unix(0, 0xdecafbad*int64(time.Microsecond))}
+			err := f.Write(b, versionBigEndian)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(b.Bytes()).To(Equal([]byte{0x15,
+				0x00, 0x00, 0x00, 0x00, 0xde, 0xca, 0xfb, 0xad, // timestamp
+			}))
+		})
+
+		It("is parsed back with microsecond precision", func() {
+			b := &bytes.Buffer{}
+			now := time.Now()
+			f := &TimestampFrame{Timestamp: now}
+			err := f.Write(b, versionLittleEndian)
+			Expect(err).ToNot(HaveOccurred())
+			frame, err := ParseTimestampFrame(bytes.NewReader(b.Bytes()), versionLittleEndian)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(frame.Timestamp).To(BeTemporally("~", now, time.Microsecond))
+		})
+	})
+})
@@ -0,0 +1,46 @@
+package quic
+
+import (
+	"fmt"
+
+	"github.com/lucas-clemente/pstream/internal/protocol"
+)
+
+// MetricsRegistry receives the counters of the sessions, see Config.Metrics.
+// An *expvar.Map implements it, such that the counters can be published with expvar.
+// It is shared by the sessions and has to be safe for concurrent use.
+type MetricsRegistry interface {
+	Add(key string, delta int64)
+}
+
+// Keys of the counters added to the MetricsRegistry.
+// The per-path counters are suffixed with the path ID, e.g. "bytes_sent.path1",
+// and the stream assignments with the name of the path scheduler, e.g. "streams_assigned.MultiPath".
+const (
+	MetricStreamsAssigned = "streams_assigned"
+	MetricBytesSent       = "bytes_sent"
+	MetricBytesAcked      = "bytes_acked"
+	MetricRetransmissions = "retransmissions"
+	MetricPathsOpened     = "paths_opened"
+	MetricPathsClosed     = "paths_closed"
+)
+
+// addMetric adds delta to a counter of Config.Metrics, if set
+func (s *session) addMetric(key string, delta int64) {
+	if s == nil || s.config == nil || s.config.Metrics == nil {
+		return
+	}
+	s.config.Metrics.Add(key, delta)
+}
+
+// addPathMetric adds delta to the counter of a path
+func (s *session) addPathMetric(key string, pathID protocol.PathID, delta int64) {
+	if s == nil || s.config == nil || s.config.Metrics == nil {
+		return
+	}
+	s.config.Metrics.Add(pathMetricKey(key, pathID), delta)
+}
+
+func pathMetricKey(key string, pathID protocol.PathID) string {
+	return fmt.Sprintf("%s.path%d", key, pathID)
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/lucas-clemente/pstream/ackhandler"
 	"github.com/lucas-clemente/pstream/internal/handshake"
@@ -31,6 +32,9 @@ type packetPacker struct {
 	controlFrames []wire.Frame
 	stopWaiting   map[protocol.PathID]*wire.StopWaitingFrame
 	ackFrame      map[protocol.PathID]*wire.AckFrame
+	timestamp     map[protocol.PathID]*wire.TimestampFrame
+
+	logger utils.Logger
 }
 
 func newPacketPacker(connectionID protocol.ConnectionID,
@@ -39,6 +43,7 @@ func newPacketPacker(connectionID protocol.ConnectionID,
 	streamFramer *streamFramer,
 	perspective protocol.Perspective,
 	version protocol.VersionNumber,
+	logger utils.Logger,
 ) *packetPacker {
 	return &packetPacker{
 		cryptoSetup:          cryptoSetup,
@@ -49,6 +54,8 @@ func newPacketPacker(connectionID protocol.ConnectionID,
 		streamFramer:         streamFramer,
 		stopWaiting:          make(map[protocol.PathID]*wire.StopWaitingFrame),
 		ackFrame:             make(map[protocol.PathID]*wire.AckFrame),
+		timestamp:            make(map[protocol.PathID]*wire.TimestampFrame),
+		logger:               logger,
 	}
 }
 
@@ -66,6 +73,23 @@ func (p *packetPacker) PackConnectionClose(ccf *wire.ConnectionCloseFrame, pth *
 	}, err
 }
 
+// PackDatagram packs a packet that ONLY contains a DatagramFrame
+func (p *packetPacker) PackDatagram(df *wire.DatagramFrame, pth *path) (*packedPacket, error) {
+	encLevel, sealer := p.cryptoSetup.GetSealer()
+	if encLevel != protocol.EncryptionForwardSecure {
+		return nil, errors.New("PacketPacker BUG: datagrams must be sent forward-secure")
+	}
+	frames := []wire.Frame{df}
+	ph := p.getPublicHeader(encLevel, pth)
+	raw, err := p.writeAndSealPacket(ph, frames, sealer, pth)
+	return &packedPacket{
+		number:          ph.PacketNumber,
+		raw:             raw,
+		frames:          frames,
+		encryptionLevel: encLevel,
+	}, err
+}
+
 // PackPing packs a packet that ONLY contains a PingFrame
 func (p *packetPacker) PackPing(pf *wire.PingFrame, pth *path) (*packedPacket, error) {
 	// Add the PingFrame in front of the controlFrames
@@ -74,9 +98,11 @@ func (p *packetPacker) PackPing(pf *wire.PingFrame, pth *path) (*packedPacket, e
 	return p.PackPacket(pth)
 }
 
+// PackAckPacket packs a packet containing only the ACK frame queued for a path, and its StopWaitingFrame
+// It returns nil if no ACK frame is queued, e.g. because it was already sent in another packet
 func (p *packetPacker) PackAckPacket(pth *path) (*packedPacket, error) {
 	if p.ackFrame[pth.pathID] == nil {
-		return nil, errors.New("packet packer BUG: no ack frame queued")
+		return nil, nil
 	}
 	encLevel, sealer := p.cryptoSetup.GetSealer()
 	ph := p.getPublicHeader(encLevel, pth)
@@ -124,7 +150,7 @@ func (p *packetPacker) PackHandshakeRetransmission(packet *ackhandler.Packet, pt
 }
 
 // PackPacket packs a new packet
-// the other controlFrames are sent in the next packet, but might be queued and sent in the next packet if the packet would overflow MaxPacketSize otherwise
+// the other controlFrames are sent in the next packet, but might be queued and sent in the next packet if the packet would overflow the packet size of the path otherwise
 func (p *packetPacker) PackPacket(pth *path) (*packedPacket, error) {
 	if p.streamFramer.HasCryptoStreamFrame() {
 		return p.packCryptoPacket(pth)
@@ -154,7 +180,7 @@ func (p *packetPacker) PackPacket(pth *path) (*packedPacket, error) {
 		// Remove the ping frame from the control frames
 		p.controlFrames = p.controlFrames[1:len(p.controlFrames)]
 	} else {
-		maxSize := protocol.MaxPacketSize - protocol.ByteCount(sealer.Overhead()) - publicHeaderLength
+		maxSize := pth.maxPacketSize() - protocol.ByteCount(sealer.Overhead()) - publicHeaderLength
 		payloadFrames, err = p.composeNextPacket(maxSize, p.canSendData(encLevel), pth)
 		if err != nil {
 			return nil, err
@@ -214,12 +240,13 @@ func (p *packetPacker) PackPacketOfPath(pth *path) (*packedPacket, error) {
 		// Remove the ping frame from the control frames
 		p.controlFrames = p.controlFrames[1:len(p.controlFrames)]
 	} else {
-		maxSize := protocol.MaxPacketSize - protocol.ByteCount(sealer.Overhead()) - publicHeaderLength
+		maxSize := pth.maxPacketSize() - protocol.ByteCount(sealer.Overhead()) - publicHeaderLength - p.timestampLength(pth)
 		payloadFrames, err = p.composeNextPacketOfPath(maxSize, p.canSendData(encLevel), pth)
 		if err != nil {
 			return nil, err
 		}
 	}
+	payloadFrames = p.maybeAddTimestampFrame(payloadFrames, pth)
 
 	// Check if we have enough frames to send
 	if len(payloadFrames) == 0 {
@@ -274,12 +301,13 @@ func (p *packetPacker) PackPacketOfStream(pth *path, streamID protocol.StreamID)
 		// Remove the ping frame from the control frames
 		p.controlFrames = p.controlFrames[1:len(p.controlFrames)]
 	} else {
-		maxSize := protocol.MaxPacketSize - protocol.ByteCount(sealer.Overhead()) - publicHeaderLength
+		maxSize := pth.maxPacketSize() - protocol.ByteCount(sealer.Overhead()) - publicHeaderLength - p.timestampLength(pth)
 		payloadFrames, err = p.composeNextPacketOfStream(maxSize, p.canSendData(encLevel), pth, streamID)
 		if err != nil {
 			return nil, err
 		}
 	}
+	payloadFrames = p.maybeAddTimestampFrame(payloadFrames, pth)
 
 	// Check if we have enough frames to send
 	if len(payloadFrames) == 0 {
@@ -311,14 +339,14 @@ func (p *packetPacker) packCryptoPacket(pth *path) (*packedPacket, error) {
 	if err != nil {
 		return nil, err
 	}
-	maxLen := protocol.MaxPacketSize - protocol.ByteCount(sealer.Overhead()) - protocol.NonForwardSecurePacketSizeReduction - publicHeaderLength
+	maxLen := pth.maxPacketSize() - protocol.ByteCount(sealer.Overhead()) - protocol.NonForwardSecurePacketSizeReduction - publicHeaderLength
 	frames := []wire.Frame{p.streamFramer.PopCryptoStreamFrame(maxLen)}
 	raw, err := p.writeAndSealPacket(publicHeader, frames, sealer, pth)
 	if err != nil {
 		return nil, err
 	}
-	if utils.Debug() {
-		utils.Debugf("packCryptoPacket: packet number %d\n", publicHeader.PacketNumber)
+	if p.logger.Debug() {
+		p.logger.Debugf("packCryptoPacket: packet number %d\n", publicHeader.PacketNumber)
 	}
 	return &packedPacket{
 		number:          publicHeader.PacketNumber,
@@ -407,6 +435,14 @@ func (p *packetPacker) composeNextPacketOfStream(
 	var payloadLength protocol.ByteCount
 	var payloadFrames []wire.Frame
 
+	// the stream might have been scheduled onto another path in the meantime
+	if !pth.hasStream(streamID) {
+		if p.logger.Debug() {
+			p.logger.Debugf("composeNextPacketOfStream: stream %d is not scheduled on path %x", streamID, pth.pathID)
+		}
+		return nil, nil
+	}
+
 	// STOP_WAITING and ACK will always fit
 	if p.stopWaiting[pth.pathID] != nil {
 		payloadFrames = append(payloadFrames, p.stopWaiting[pth.pathID])
@@ -545,11 +581,35 @@ func (p *packetPacker) QueueControlFrame(frame wire.Frame, pth *path) {
 		p.stopWaiting[pth.pathID] = f
 	case *wire.AckFrame:
 		p.ackFrame[pth.pathID] = f
+	case *wire.TimestampFrame:
+		p.timestamp[pth.pathID] = f
 	default:
 		p.controlFrames = append(p.controlFrames, f)
 	}
 }
 
+// timestampLength is the room kept for a queued TIMESTAMP frame of the path
+func (p *packetPacker) timestampLength(pth *path) protocol.ByteCount {
+	if p.timestamp[pth.pathID] == nil {
+		return 0
+	}
+	l, _ := p.timestamp[pth.pathID].MinLength(p.version)
+	return l
+}
+
+// maybeAddTimestampFrame adds the queued TIMESTAMP frame of the path to a packet carrying other retransmittable frames,
+// an idle path doesn't send packets only for the timestamp
+func (p *packetPacker) maybeAddTimestampFrame(payloadFrames []wire.Frame, pth *path) []wire.Frame {
+	f := p.timestamp[pth.pathID]
+	if f == nil || !ackhandler.HasRetransmittableFrames(payloadFrames) {
+		return payloadFrames
+	}
+	p.timestamp[pth.pathID] = nil
+	f.Timestamp = time.Now()
+	pth.lastTimestampSent = f.Timestamp
+	return append(payloadFrames, f)
+}
+
 func (p *packetPacker) getPublicHeader(encLevel protocol.EncryptionLevel, pth *path) *wire.PublicHeader {
 	pnum := pth.packetNumberGenerator.Peek()
 	packetNumberLen := protocol.GetPacketNumberLengthForPublicHeader(pnum, pth.leastUnacked)
@@ -598,7 +658,7 @@ func (p *packetPacker) writeAndSealPacket(
 			return nil, err
 		}
 	}
-	if protocol.ByteCount(buffer.Len()+sealer.Overhead()) > protocol.MaxPacketSize {
+	if protocol.ByteCount(buffer.Len()+sealer.Overhead()) > pth.maxPacketSize() {
 		return nil, errors.New("PacketPacker BUG: packet too large")
 	}
 
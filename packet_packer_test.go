@@ -10,6 +10,7 @@ import (
 	"github.com/lucas-clemente/pstream/internal/mocks"
 	"github.com/lucas-clemente/pstream/internal/mocks/mocks_fc"
 	"github.com/lucas-clemente/pstream/internal/protocol"
+	"github.com/lucas-clemente/pstream/internal/utils"
 	"github.com/lucas-clemente/pstream/internal/wire"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -74,8 +75,9 @@ var _ = Describe("Packet packer", func() {
 		streamFramer = newStreamFramer(streamsMap, nil)
 
 		pth = &path{
+			streamIDs:             []protocol.StreamID{1, 5},
 			streamQuota:           make(map[protocol.StreamID]uint8),
-			sentPacketHandler:     ackhandler.NewSentPacketHandler(0, &congestion.RTTStats{}, &congestion.BDWStats{}, nil, nil),
+			sentPacketHandler:     ackhandler.NewSentPacketHandler(0, &congestion.RTTStats{}, &congestion.BDWStats{}, nil, nil, utils.Logger{}),
 			packetNumberGenerator: newPacketNumberGenerator(protocol.SkipPacketAveragePeriodLength),
 		}
 
@@ -87,6 +89,7 @@ var _ = Describe("Packet packer", func() {
 			perspective:          protocol.PerspectiveServer,
 			stopWaiting:          make(map[protocol.PathID]*wire.StopWaitingFrame),
 			ackFrame:             make(map[protocol.PathID]*wire.AckFrame),
+			timestamp:            make(map[protocol.PathID]*wire.TimestampFrame),
 		}
 		publicHeaderLen = 1 + 8 + 2 // 1 flag byte, 8 connection ID, 2 packet number
 		maxFrameSize = protocol.MaxPacketSize - protocol.ByteCount((&mockSealer{}).Overhead()) - publicHeaderLen
@@ -159,6 +162,20 @@ var _ = Describe("Packet packer", func() {
 		Expect(p.encryptionLevel).To(Equal(protocol.EncryptionForwardSecure))
 	})
 
+	It("doesn't pack a packet for a stream not scheduled on the path", func() {
+		f := &wire.StreamFrame{
+			StreamID: 7,
+			Data:     []byte{0xDE, 0xCA, 0xFB, 0xAD},
+		}
+		streamFramer.AddFrameForRetransmission(f)
+		packer.QueueControlFrame(&wire.WindowUpdateFrame{StreamID: 7}, pth)
+		p, err := packer.PackPacketOfStream(pth, 7)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(p).To(BeNil())
+		Expect(packer.controlFrames).To(HaveLen(1))
+		Expect(streamFramer.retransmissionQueue).To(HaveLen(1))
+	})
+
 	Context("diversificaton nonces", func() {
 		var nonce []byte
 
@@ -724,6 +741,30 @@ var _ = Describe("Packet packer", func() {
 			Expect(p.raw).To(HaveLen(int(protocol.MaxPacketSize)))
 		})
 
+		It("packs packets no larger than the MTU of the path", func() {
+			pth.mtu = 1250
+			streamFramer.AddFrameForRetransmission(&wire.StreamFrame{
+				StreamID: 5,
+				Offset:   1,
+				Data:     bytes.Repeat([]byte{'f'}, 1300),
+			})
+			p, err := packer.PackPacket(pth)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(p.raw).To(HaveLen(1250))
+		})
+
+		It("doesn't limit the packets of a path below the minimum MTU", func() {
+			pth.mtu = 100
+			streamFramer.AddFrameForRetransmission(&wire.StreamFrame{
+				StreamID: 5,
+				Offset:   1,
+				Data:     bytes.Repeat([]byte{'f'}, 1300),
+			})
+			p, err := packer.PackPacket(pth)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(p.raw).To(HaveLen(int(protocol.MinPathMTU)))
+		})
+
 		It("splits a stream frame larger than the maximum size", func() {
 			f := &wire.StreamFrame{
 				StreamID: 5,
@@ -799,6 +840,27 @@ var _ = Describe("Packet packer", func() {
 			Expect(p.frames[0]).To(Equal(&wire.StreamFrame{StreamID: 1, Data: []byte("foobar")}))
 		})
 
+		It("packs crypto stream data before stream data on a path that doesn't carry the crypto stream", func() {
+			newPth := &path{
+				pathID:                3,
+				streamIDs:             []protocol.StreamID{5},
+				sentPacketHandler:     ackhandler.NewSentPacketHandler(3, &congestion.RTTStats{}, &congestion.BDWStats{}, nil, nil, utils.Logger{}),
+				packetNumberGenerator: newPacketNumberGenerator(protocol.SkipPacketAveragePeriodLength),
+			}
+			streamFramer.streamsMap.putStream(&stream{streamID: 5, priority: &protocol.Priority{Weight: 16}})
+			streamFramer.AddFrameForRetransmission(&wire.StreamFrame{StreamID: 5, Data: []byte("data")})
+			cryptoStream.dataForWriting = []byte("foobar")
+			p, err := packer.PackPacketOfPath(newPth)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(p.frames).To(Equal([]wire.Frame{&wire.StreamFrame{StreamID: 1, Data: []byte("foobar")}}))
+			streamFramer.AddFrameForRetransmission(&wire.StreamFrame{StreamID: 1, Data: []byte("foobar")})
+			p, err = packer.PackPacketOfPath(newPth)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(p.frames).To(HaveLen(2))
+			Expect(p.frames[0].(*wire.StreamFrame).StreamID).To(Equal(protocol.StreamID(1)))
+			Expect(p.frames[1].(*wire.StreamFrame).StreamID).To(Equal(protocol.StreamID(5)))
+		})
+
 		It("does not pack stream frames if not allowed", func() {
 			packer.cryptoSetup.(*mockCryptoSetup).encLevelSeal = protocol.EncryptionUnencrypted
 			packer.QueueControlFrame(&wire.AckFrame{}, pth)
@@ -950,6 +1012,26 @@ var _ = Describe("Packet packer", func() {
 			Expect(err).To(MatchError("PacketPacker BUG: packet too large"))
 		})
 
+		It("refuses to send a packet larger than the MTU of the path", func() {
+			pth.mtu = protocol.MinPathMTU
+			packet := &ackhandler.Packet{
+				EncryptionLevel: protocol.EncryptionSecure,
+				Frames: []wire.Frame{
+					&wire.StreamFrame{
+						StreamID: 1,
+						Data:     bytes.Repeat([]byte{'f'}, int(protocol.MinPathMTU)),
+					},
+				},
+			}
+			_, err := packer.PackHandshakeRetransmission(packet, pth)
+			Expect(err).To(MatchError("PacketPacker BUG: packet too large"))
+			// the StopWaitingFrame was used up by the first attempt
+			packer.QueueControlFrame(swf, pth)
+			pth.mtu = 0
+			_, err = packer.PackHandshakeRetransmission(packet, pth)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
 		It("refuses to retransmit packets that were sent with forward-secure encryption", func() {
 			p := &ackhandler.Packet{
 				EncryptionLevel: protocol.EncryptionForwardSecure,
@@ -968,6 +1050,22 @@ var _ = Describe("Packet packer", func() {
 	})
 
 	Context("packing ACK packets", func() {
+		It("doesn't pack an ACK packet if no ACK frame is queued", func() {
+			p, err := packer.PackAckPacket(pth)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(p).To(BeNil())
+		})
+
+		It("doesn't pack an ACK packet twice", func() {
+			packer.QueueControlFrame(&wire.AckFrame{}, pth)
+			p, err := packer.PackAckPacket(pth)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(p).ToNot(BeNil())
+			p, err = packer.PackAckPacket(pth)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(p).To(BeNil())
+		})
+
 		It("packs ACK packets", func() {
 			packer.QueueControlFrame(&wire.AckFrame{}, pth)
 			p, err := packer.PackAckPacket(pth)
@@ -19,9 +19,15 @@ type quicAEAD interface {
 	Open(dst, src []byte, packetNumber protocol.PacketNumber, associatedData []byte) ([]byte, protocol.EncryptionLevel, error)
 }
 
+// errStreamFrameChecksumMismatch is returned for a packet with a corrupted STREAM frame.
+// The packet must be dropped without being acked, such that the peer retransmits it.
+var errStreamFrameChecksumMismatch = errors.New("STREAM frame checksum mismatch")
+
 type packetUnpacker struct {
 	version protocol.VersionNumber
 	aead    quicAEAD
+	// if set, STREAM frames carry a checksum of their data, see Config.StreamFrameChecksums
+	streamFrameChecksums bool
 }
 
 func (u *packetUnpacker) Unpack(publicHeaderBinary []byte, hdr *wire.PublicHeader, data []byte) (*unpackedPacket, error) {
@@ -50,11 +56,19 @@ func (u *packetUnpacker) Unpack(publicHeaderBinary []byte, hdr *wire.PublicHeade
 
 		var frame wire.Frame
 		if typeByte&0x80 == 0x80 {
-			frame, err = wire.ParseStreamFrame(r, u.version)
+			var streamFrame *wire.StreamFrame
+			if u.streamFrameChecksums {
+				streamFrame, err = wire.ParseStreamFrameWithChecksum(r, u.version)
+			} else {
+				streamFrame, err = wire.ParseStreamFrame(r, u.version)
+			}
+			frame = streamFrame
 			if err != nil {
 				err = qerr.Error(qerr.InvalidStreamData, err.Error())
+			} else if !streamFrame.ChecksumValid() {
+				err = errStreamFrameChecksumMismatch
 			} else {
-				streamID := frame.(*wire.StreamFrame).StreamID
+				streamID := streamFrame.StreamID
 				if streamID != 1 && encryptionLevel <= protocol.EncryptionUnencrypted {
 					err = qerr.Error(qerr.UnencryptedStreamData, fmt.Sprintf("received unencrypted stream data on stream %d", streamID))
 				}
@@ -106,6 +120,19 @@ func (u *packetUnpacker) Unpack(publicHeaderBinary []byte, hdr *wire.PublicHeade
 				frame, err = wire.ParseClosePathFrame(r, u.version)
 			case 0x12:
 				frame, err = wire.ParsePathsFrame(r, u.version)
+			case 0x13:
+				frame, err = wire.ParseFastRetransmitFrame(r, u.version)
+			case 0x14:
+				frame, err = wire.ParseSkipStreamDataFrame(r, u.version)
+			case 0x15:
+				frame, err = wire.ParseTimestampFrame(r, u.version)
+			case 0x16:
+				frame, err = wire.ParseDatagramFrame(r, u.version)
+				if err == nil && encryptionLevel <= protocol.EncryptionUnencrypted {
+					err = qerr.Error(qerr.InvalidFrameData, "received unencrypted DATAGRAM frame")
+				}
+			case 0x17:
+				frame, err = wire.ParseAckFrequencyFrame(r, u.version)
 			default:
 				err = qerr.Error(qerr.InvalidFrameData, fmt.Sprintf("unknown type byte 0x%x", typeByte))
 			}
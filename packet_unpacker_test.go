@@ -2,6 +2,7 @@ package quic
 
 import (
 	"bytes"
+	"time"
 
 	"github.com/lucas-clemente/pstream/internal/crypto"
 	"github.com/lucas-clemente/pstream/internal/protocol"
@@ -198,6 +199,57 @@ var _ = Describe("Packet unpacker", func() {
 		}))
 	})
 
+	It("accepts FAST_RETRANSMIT frames", func() {
+		f := &wire.FastRetransmitFrame{StreamID: 5, Offset: 0x1000, Length: 0x500}
+		err := f.Write(buf, 0)
+		Expect(err).ToNot(HaveOccurred())
+		setData(buf.Bytes())
+		packet, err := unpacker.Unpack(hdrBin, hdr, data)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(packet.frames).To(Equal([]wire.Frame{f}))
+	})
+
+	It("accepts SKIP_STREAM_DATA frames", func() {
+		f := &wire.SkipStreamDataFrame{StreamID: 5, Offset: 0x1000, Length: 0x500}
+		err := f.Write(buf, 0)
+		Expect(err).ToNot(HaveOccurred())
+		setData(buf.Bytes())
+		packet, err := unpacker.Unpack(hdrBin, hdr, data)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(packet.frames).To(Equal([]wire.Frame{f}))
+	})
+
+	It("accepts TIMESTAMP frames", func() {
+		f := &wire.TimestampFrame{Timestamp: time.Unix(1500000000, 123456000)}
+		err := f.Write(buf, 0)
+		Expect(err).ToNot(HaveOccurred())
+		setData(buf.Bytes())
+		packet, err := unpacker.Unpack(hdrBin, hdr, data)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(packet.frames).To(Equal([]wire.Frame{f}))
+	})
+
+	It("accepts ACK_FREQUENCY frames", func() {
+		f := &wire.AckFrequencyFrame{SequenceNumber: 1, PacketTolerance: 10, MaxAckDelay: 25 * time.Millisecond}
+		err := f.Write(buf, 0)
+		Expect(err).ToNot(HaveOccurred())
+		setData(buf.Bytes())
+		packet, err := unpacker.Unpack(hdrBin, hdr, data)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(packet.frames).To(Equal([]wire.Frame{f}))
+	})
+
+	It("accepts DATAGRAM frames", func() {
+		unpacker.aead.(*mockAEAD).encLevelOpen = protocol.EncryptionForwardSecure
+		f := &wire.DatagramFrame{Data: []byte("foobar")}
+		err := f.Write(buf, 0)
+		Expect(err).ToNot(HaveOccurred())
+		setData(buf.Bytes())
+		packet, err := unpacker.Unpack(hdrBin, hdr, data)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(packet.frames).To(Equal([]wire.Frame{f}))
+	})
+
 	It("errors on invalid type", func() {
 		setData([]byte{0x08})
 		_, err := unpacker.Unpack(hdrBin, hdr, data)
@@ -262,5 +314,50 @@ var _ = Describe("Packet unpacker", func() {
 			_, err = unpacker.Unpack(hdrBin, hdr, data)
 			Expect(err).To(MatchError(qerr.Error(qerr.UnencryptedStreamData, "received unencrypted stream data on stream 3")))
 		})
+
+		Context("with checksums", func() {
+			var f *wire.StreamFrame
+
+			BeforeEach(func() {
+				unpacker.streamFrameChecksums = true
+				unpacker.aead.(*mockAEAD).encLevelOpen = protocol.EncryptionForwardSecure
+				f = &wire.StreamFrame{
+					StreamID:        5,
+					Data:            []byte("foobar"),
+					ChecksumPresent: true,
+				}
+				err := f.Write(buf, 0)
+				Expect(err).ToNot(HaveOccurred())
+			})
+
+			It("unpacks STREAM frames with a valid checksum", func() {
+				setData(buf.Bytes())
+				packet, err := unpacker.Unpack(hdrBin, hdr, data)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(packet.frames).To(HaveLen(1))
+				frame := packet.frames[0].(*wire.StreamFrame)
+				Expect(frame.StreamID).To(Equal(protocol.StreamID(5)))
+				Expect(frame.Data).To(Equal([]byte("foobar")))
+				Expect(frame.ChecksumValid()).To(BeTrue())
+			})
+
+			It("detects corrupted STREAM frames", func() {
+				corrupted := buf.Bytes()
+				corrupted[len(corrupted)-1] ^= 0x1
+				setData(corrupted)
+				_, err := unpacker.Unpack(hdrBin, hdr, data)
+				Expect(err).To(MatchError(errStreamFrameChecksumMismatch))
+			})
+		})
+
+		It("does not unpack unencrypted DATAGRAM frames", func() {
+			unpacker.aead.(*mockAEAD).encLevelOpen = protocol.EncryptionUnencrypted
+			f := &wire.DatagramFrame{Data: []byte("foobar")}
+			err := f.Write(buf, 0)
+			Expect(err).ToNot(HaveOccurred())
+			setData(buf.Bytes())
+			_, err = unpacker.Unpack(hdrBin, hdr, data)
+			Expect(err).To(MatchError(qerr.Error(qerr.InvalidFrameData, "received unencrypted DATAGRAM frame")))
+		})
 	})
 })
@@ -1,6 +1,8 @@
 package quic
 
 import (
+	"net"
+	"sync"
 	"time"
 
 	"github.com/lucas-clemente/pstream/ackhandler"
@@ -17,6 +19,82 @@ const (
 	maxPathTimer = 1 * time.Second
 )
 
+// PathCost is the cost of sending data on a path, e.g. whether the network is metered
+type PathCost uint8
+
+const (
+	// PathCostLow is the cost of unmetered paths, e.g. Wi-Fi. It is the default.
+	PathCostLow PathCost = iota
+	// PathCostHigh is the cost of metered paths, e.g. cellular.
+	// The cost aware path scheduler only uses them when all low cost paths are congestion limited.
+	PathCostHigh
+)
+
+// PathsFrameOrder defines in which order the paths are listed in the PATHS frames, i.e. which ones the peer probes first
+type PathsFrameOrder uint8
+
+const (
+	// PathsFrameByPathID lists the paths by increasing path ID. It is the default.
+	PathsFrameByPathID PathsFrameOrder = iota
+	// PathsFrameLowestRTTFirst lists the paths by increasing smoothed RTT, the paths without an RTT sample yet last
+	PathsFrameLowestRTTFirst
+	// PathsFrameHighestBandwidthFirst lists the paths by decreasing estimated bandwidth, the paths without a bandwidth sample yet last
+	PathsFrameHighestBandwidthFirst
+)
+
+// PathInfo describes the endpoints of a path
+type PathInfo struct {
+	PathID     protocol.PathID
+	LocalAddr  net.Addr
+	RemoteAddr net.Addr
+}
+
+// PathStats are statistics about the packets sent on a path
+type PathStats struct {
+	PathID           protocol.PathID
+	SmoothedRTT      time.Duration
+	CongestionWindow protocol.ByteCount
+	// Cumulative counts since the path was created
+	Packets         uint64
+	Retransmissions uint64
+	Losses          uint64
+	// LossRate is the fraction of the recently sent packets that were lost
+	LossRate float64
+	// LargestReceived is the largest packet number received on the path,
+	// LargestInOrderReceived the largest one up to which no packet is missing
+	LargestReceived        protocol.PacketNumber
+	LargestInOrderReceived protocol.PacketNumber
+	// BDP is the bandwidth-delay product of the path, from its estimated bandwidth and smoothed RTT
+	BDP           protocol.ByteCount
+	BytesInFlight protocol.ByteCount
+	// PacketsInFlight is the number of packets sent and neither acked nor declared lost,
+	// Streams the number of streams scheduled on the path. Together they indicate whether the path is backing up.
+	PacketsInFlight int
+	Streams         int
+	// UnderUtilized is set if fewer bytes than the BDP were in flight for several RTTs,
+	// e.g. because the application or the scheduler didn't provide enough data for the path
+	UnderUtilized bool
+	// RetransmissionRate is the number of packets retransmitted per second, over the last second
+	RetransmissionRate float64
+}
+
+// LostPacket describes a packet that was declared lost, it is passed to Config.LostPacketHandler
+type LostPacket struct {
+	PathID       protocol.PathID
+	PacketNumber protocol.PacketNumber
+	Length       protocol.ByteCount
+	// StreamFrames are the stream data carried by the packet, other frames are not reported
+	StreamFrames []LostStreamFrame
+}
+
+// LostStreamFrame is the stream data of a STREAM frame in a lost packet
+type LostStreamFrame struct {
+	StreamID protocol.StreamID
+	Offset   protocol.ByteCount
+	Length   protocol.ByteCount
+	FinBit   bool
+}
+
 type path struct {
 	pathID protocol.PathID
 	conn   connection
@@ -28,6 +106,17 @@ type path struct {
 
 	rttStats *congestion.RTTStats
 	bdwStats *congestion.BDWStats
+	// set once Config.PathStatsReplay applied a sample to the path, the schedulers then see replayedRTT instead of the measured RTT
+	replaying   bool
+	replayedRTT time.Duration
+
+	cost PathCost
+	// largest packet sent on the path, including the public header, see Config.PathMTU. If 0, protocol.MaxPacketSize is used
+	mtu protocol.ByteCount
+	// paths of the same non-empty group are not independent for failover, see Config.PathGroup
+	group string
+	// created for a packet received on a new path ID, the path counts against Config.MaxRemotePaths while open
+	remote bool
 
 	sentPacketHandler     ackhandler.SentPacketHandler
 	receivedPacketHandler ackhandler.ReceivedPacketHandler
@@ -37,9 +126,19 @@ type path struct {
 	runClosed chan struct{}
 
 	potentiallyFailed utils.AtomicBool
+	// set when a write on the conn failed, the path is no longer selected for sending
+	// until it is probed again protocol.FailedPathRetryPeriod after connFailedTime
+	connFailed     utils.AtomicBool
+	connFailedTime time.Time
+	// a write on the conn failed temporarily, the path is not used before this time
+	writeBlockedUntil time.Time
 
 	sentPacket chan struct{}
 
+	// packets waiting to be written to the conn in a single batch, see Config.MaxCoalescedPackets
+	pendingPacketsMutex sync.Mutex
+	pendingPackets      [][]byte
+
 	// It is now the responsibility of the path to keep its packet number
 	packetNumberGenerator *packetNumberGenerator
 
@@ -49,8 +148,35 @@ type path struct {
 	largestRcvdPacketNumber protocol.PacketNumber
 
 	leastUnacked protocol.PacketNumber
+	// for the ACKs of this path received on other paths, the largest packet number carrying one on each of these paths
+	largestCrossPathAcks map[protocol.PathID]protocol.PacketNumber
 
 	lastNetworkActivityTime time.Time
+	// time the last packet was sent on the path, used to keep idle paths alive
+	lastPacketSentTime time.Time
+
+	// smoothed one-way delay from the peer, measured with its TIMESTAMP frames.
+	// It includes the offset between the clocks of both peers.
+	reverseDelay      time.Duration
+	hasReverseDelay   bool
+	lastTimestampSent time.Time
+
+	// sequence numbers of the last ACK_FREQUENCY frames sent and received on the path
+	ackFrequencySent     uint32
+	ackFrequencyReceived uint32
+	lastAckFrequencySent time.Time
+	// packet tolerance the peer was asked for with the last ACK_FREQUENCY frame
+	ackPacketTolerance uint16
+
+	// since when fewer bytes than the BDP are in flight, zero if the path is used to its BDP
+	underUtilizedSince     time.Time
+	underUtilizationLogged bool
+
+	// times of the retransmissions of the last protocol.RetransmissionRateWindow
+	retransmissionsMutex sync.Mutex
+	retransmissionTimes  []time.Time
+	// a retransmission dequeued while the path was at Config.MaxPathRetransmissionRate, sent once allowed
+	heldRetransmission *ackhandler.Packet
 
 	timer *utils.Timer
 }
@@ -67,7 +193,26 @@ func (p *path) setup(oliaSenders map[protocol.PathID]*congestion.OliaSender) {
 		oliaSenders[p.pathID] = cong.(*congestion.OliaSender)
 	}
 
-	sentPacketHandler := ackhandler.NewSentPacketHandler(p.pathID, p.rttStats, p.bdwStats, cong, p.onRTO)
+	sentPacketHandler := ackhandler.NewSentPacketHandler(p.pathID, p.rttStats, p.bdwStats, cong, p.onRTO, p.sess.logger)
+	if p.sess.config.PacketReorderingThreshold > 0 {
+		sentPacketHandler.SetPacketReorderingThreshold(protocol.PacketNumber(p.sess.config.PacketReorderingThreshold))
+	}
+	if p.sess.config.IgnoreClosePathLosses {
+		sentPacketHandler.SetIgnoreClosePathLosses(true)
+	}
+	if p.sess.config.PotentiallyFailedRTOs > 0 {
+		sentPacketHandler.SetPotentiallyFailedRTOs(uint32(p.sess.config.PotentiallyFailedRTOs))
+	}
+	if p.sess.config.MaxTrackedSkippedPackets > 0 {
+		sentPacketHandler.SetMaxTrackedSkippedPackets(p.sess.config.MaxTrackedSkippedPackets)
+	}
+	if p.sess.config.PacingGain > 0 {
+		sentPacketHandler.SetPacingGain(p.sess.config.PacingGain)
+	}
+	if p.sess.config.LostPacketHandler != nil {
+		sentPacketHandler.SetLostPacketCallback(p.onLostPacket)
+	}
+	sentPacketHandler.SetAckedPacketCallback(p.onAckedPacket)
 
 	now := time.Now()
 
@@ -75,6 +220,10 @@ func (p *path) setup(oliaSenders map[protocol.PathID]*congestion.OliaSender) {
 	p.receivedPacketHandler = ackhandler.NewReceivedPacketHandler(p.sess.version)
 
 	p.packetNumberGenerator = newPacketNumberGenerator(protocol.SkipPacketAveragePeriodLength)
+	p.setupCost()
+	p.setupGroup()
+	p.setupMTU()
+	p.setupRTTEstimator()
 
 	p.closeChan = make(chan *qerr.QuicError, 1)
 	p.runClosed = make(chan struct{}, 1)
@@ -85,6 +234,7 @@ func (p *path) setup(oliaSenders map[protocol.PathID]*congestion.OliaSender) {
 	p.lastNetworkActivityTime = now
 
 	p.open.Set(true)
+	p.sess.addMetric(MetricPathsOpened, 1)
 	p.potentiallyFailed.Set(false)
 
 	// Once the path is setup, run it
@@ -101,7 +251,26 @@ func (p *path) setupWithStatistics(oliaSenders map[protocol.PathID]*congestion.O
 		oliaSenders[p.pathID] = cong.(*congestion.OliaSender)
 	}
 
-	sentPacketHandler := ackhandler.NewSentPacketHandler(p.pathID, p.rttStats, p.bdwStats, cong, p.onRTO)
+	sentPacketHandler := ackhandler.NewSentPacketHandler(p.pathID, p.rttStats, p.bdwStats, cong, p.onRTO, p.sess.logger)
+	if p.sess.config.PacketReorderingThreshold > 0 {
+		sentPacketHandler.SetPacketReorderingThreshold(protocol.PacketNumber(p.sess.config.PacketReorderingThreshold))
+	}
+	if p.sess.config.IgnoreClosePathLosses {
+		sentPacketHandler.SetIgnoreClosePathLosses(true)
+	}
+	if p.sess.config.PotentiallyFailedRTOs > 0 {
+		sentPacketHandler.SetPotentiallyFailedRTOs(uint32(p.sess.config.PotentiallyFailedRTOs))
+	}
+	if p.sess.config.MaxTrackedSkippedPackets > 0 {
+		sentPacketHandler.SetMaxTrackedSkippedPackets(p.sess.config.MaxTrackedSkippedPackets)
+	}
+	if p.sess.config.PacingGain > 0 {
+		sentPacketHandler.SetPacingGain(p.sess.config.PacingGain)
+	}
+	if p.sess.config.LostPacketHandler != nil {
+		sentPacketHandler.SetLostPacketCallback(p.onLostPacket)
+	}
+	sentPacketHandler.SetAckedPacketCallback(p.onAckedPacket)
 
 	now := time.Now()
 
@@ -109,6 +278,10 @@ func (p *path) setupWithStatistics(oliaSenders map[protocol.PathID]*congestion.O
 	p.receivedPacketHandler = ackhandler.NewReceivedPacketHandler(p.sess.version)
 
 	p.packetNumberGenerator = newPacketNumberGenerator(protocol.SkipPacketAveragePeriodLength)
+	p.setupCost()
+	p.setupGroup()
+	p.setupMTU()
+	p.setupRTTEstimator()
 
 	p.closeChan = make(chan *qerr.QuicError, 1)
 	p.runClosed = make(chan struct{}, 1)
@@ -119,12 +292,200 @@ func (p *path) setupWithStatistics(oliaSenders map[protocol.PathID]*congestion.O
 	p.lastNetworkActivityTime = now
 
 	p.open.Set(true)
+	p.sess.addMetric(MetricPathsOpened, 1)
 	p.potentiallyFailed.Set(false)
 
 	// Once the path is setup, run it
 	go p.run()
 }
+func (p *path) stats() PathStats {
+	packets, retransmissions, losses := p.sentPacketHandler.GetStatistics()
+	return PathStats{
+		PathID:           p.pathID,
+		SmoothedRTT:      p.rttStats.SmoothedRTT(),
+		CongestionWindow: p.sentPacketHandler.GetCongestionWindow(),
+		Packets:          packets,
+		Retransmissions:  retransmissions,
+		Losses:           losses,
+		LossRate:         p.sentPacketHandler.GetLossRate(),
+
+		LargestReceived:        p.receivedPacketHandler.GetLargestObserved(),
+		LargestInOrderReceived: p.receivedPacketHandler.GetLargestInOrder(),
+		BDP:                    p.bdp(),
+		BytesInFlight:          p.sentPacketHandler.GetBytesInFlight(),
+		PacketsInFlight:        p.sentPacketHandler.GetPacketsInFlight(),
+		Streams:                len(p.streamIDs),
+		UnderUtilized:          p.underUtilized(time.Now()),
+		RetransmissionRate:     p.retransmissionRate(time.Now()),
+	}
+}
+
+// onRetransmission records a packet retransmitted on the path
+func (p *path) onRetransmission(now time.Time) {
+	p.retransmissionsMutex.Lock()
+	defer p.retransmissionsMutex.Unlock()
+	p.dropOldRetransmissions(now)
+	p.retransmissionTimes = append(p.retransmissionTimes, now)
+	p.sess.addPathMetric(MetricRetransmissions, p.pathID, 1)
+}
+
+// the caller holds the mutex
+func (p *path) dropOldRetransmissions(now time.Time) {
+	i := 0
+	for i < len(p.retransmissionTimes) && now.Sub(p.retransmissionTimes[i]) >= protocol.RetransmissionRateWindow {
+		i++
+	}
+	p.retransmissionTimes = p.retransmissionTimes[i:]
+}
+
+// retransmissionRate is the number of packets retransmitted per second over the last protocol.RetransmissionRateWindow
+func (p *path) retransmissionRate(now time.Time) float64 {
+	p.retransmissionsMutex.Lock()
+	defer p.retransmissionsMutex.Unlock()
+	p.dropOldRetransmissions(now)
+	return float64(len(p.retransmissionTimes)) / protocol.RetransmissionRateWindow.Seconds()
+}
+
+// retransmissionAllowedAt is the time the path may retransmit a packet again with at most maxRate retransmissions per second,
+// zero if it may retransmit now
+func (p *path) retransmissionAllowedAt(now time.Time, maxRate float64) time.Time {
+	if maxRate <= 0 {
+		return time.Time{}
+	}
+	p.retransmissionsMutex.Lock()
+	defer p.retransmissionsMutex.Unlock()
+	p.dropOldRetransmissions(now)
+	maxRetransmissions := int(maxRate * protocol.RetransmissionRateWindow.Seconds())
+	if maxRetransmissions < 1 {
+		maxRetransmissions = 1
+	}
+	if len(p.retransmissionTimes) < maxRetransmissions {
+		return time.Time{}
+	}
+	return p.retransmissionTimes[len(p.retransmissionTimes)-maxRetransmissions].Add(protocol.RetransmissionRateWindow)
+}
+
+// bdp is the bandwidth-delay product of the path, 0 as long as its bandwidth or RTT is unknown
+func (p *path) bdp() protocol.ByteCount {
+	return p.bdwStats.BDP(p.rttStats.SmoothedRTT())
+}
+
+// maxPacketSize is the size limit of the packets sent on the path, it never exceeds protocol.MaxPacketSize
+func (p *path) maxPacketSize() protocol.ByteCount {
+	if p.mtu == 0 || p.mtu > protocol.MaxPacketSize {
+		return protocol.MaxPacketSize
+	}
+	// the packer subtracts the headers and the AEAD overhead from it
+	return utils.MaxByteCount(p.mtu, protocol.MinPathMTU)
+}
+
+// updateUtilization keeps track of since when fewer bytes than the BDP are in flight.
+// It is called whenever the bytes in flight change.
+func (p *path) updateUtilization(now time.Time) {
+	bdp := p.bdp()
+	if bdp == 0 || p.sentPacketHandler.GetBytesInFlight() >= bdp {
+		if p.underUtilizationLogged {
+			p.sess.logger.Infof("Path %x of %x: not under-utilized anymore", p.pathID, p.sess.connectionID)
+			p.underUtilizationLogged = false
+		}
+		p.underUtilizedSince = time.Time{}
+		return
+	}
+	if p.underUtilizedSince.IsZero() {
+		p.underUtilizedSince = now
+	}
+	if !p.underUtilizationLogged && p.underUtilized(now) {
+		p.sess.logger.Infof("Path %x of %x: under-utilized, %d bytes in flight for a BDP of %d bytes", p.pathID, p.sess.connectionID, p.sentPacketHandler.GetBytesInFlight(), bdp)
+		p.underUtilizationLogged = true
+	}
+}
+
+// underUtilized says if fewer bytes than the BDP were in flight for UnderUtilizationRTTs smoothed RTTs
+func (p *path) underUtilized(now time.Time) bool {
+	return !p.underUtilizedSince.IsZero() && now.Sub(p.underUtilizedSince) >= protocol.UnderUtilizationRTTs*p.rttStats.SmoothedRTT()
+}
+
+// onTimestampFrame updates the one-way delay from the peer
+func (p *path) onTimestampFrame(frame *wire.TimestampFrame, rcvTime time.Time) {
+	sample := rcvTime.Sub(frame.Timestamp)
+	if !p.hasReverseDelay {
+		p.reverseDelay = sample
+		p.hasReverseDelay = true
+		return
+	}
+	p.reverseDelay = (7*p.reverseDelay + sample) / 8
+}
+
+// onAckFrequencyFrame changes how often the packets received on the path are acked, unless a newer frame was already received
+func (p *path) onAckFrequencyFrame(frame *wire.AckFrequencyFrame) {
+	if frame.SequenceNumber <= p.ackFrequencyReceived {
+		return
+	}
+	p.ackFrequencyReceived = frame.SequenceNumber
+	p.receivedPacketHandler.SetAckFrequency(int(frame.PacketTolerance), frame.MaxAckDelay)
+}
+
+// desiredAckPacketTolerance is the number of packets the peer should ack at once,
+// such that it acks about AckFrequencyAcksPerRTT times per congestion window
+func (p *path) desiredAckPacketTolerance(maxTolerance uint16) uint16 {
+	tolerance := p.sentPacketHandler.GetCongestionWindow() / protocol.DefaultTCPMSS / protocol.AckFrequencyAcksPerRTT
+	if tolerance < protocol.RetransmittablePacketsBeforeAck {
+		return protocol.RetransmittablePacketsBeforeAck
+	}
+	if tolerance > protocol.ByteCount(maxTolerance) {
+		return maxTolerance
+	}
+	return uint16(tolerance)
+}
+
+// schedulingRTT is the smoothed RTT the schedulers base their decisions on.
+// With Config.PathStatsReplay, it is the replayed one, while loss detection and congestion control keep using the measured RTT.
+func (p *path) schedulingRTT() time.Duration {
+	if p.replaying {
+		return p.replayedRTT
+	}
+	return p.rttStats.SmoothedRTT()
+}
+
+// oneWayDelay estimates the delay to the peer.
+// If the delay from the peer was measured, it is the remainder of the RTT. This estimate is shifted by the clock offset,
+// which is the same for all paths, so it can only be compared to the estimates of other paths.
+// Otherwise the path is assumed to be symmetric. Like the other scheduling inputs, it follows a replayed RTT.
+func (p *path) oneWayDelay(useTimestamps bool) time.Duration {
+	if useTimestamps && p.hasReverseDelay {
+		return p.schedulingRTT() - p.reverseDelay
+	}
+	return p.schedulingRTT() / 2
+}
+
+func (p *path) setupCost() {
+	if p.sess.config.PathCost != nil {
+		p.cost = p.sess.config.PathCost(p.conn.LocalAddr(), p.conn.RemoteAddr())
+	}
+}
+
+func (p *path) setupGroup() {
+	if p.sess.config.PathGroup != nil {
+		p.group = p.sess.config.PathGroup(p.conn.LocalAddr(), p.conn.RemoteAddr())
+	}
+}
+
+func (p *path) setupMTU() {
+	if p.sess.config.PathMTU != nil {
+		p.mtu = p.sess.config.PathMTU(p.conn.LocalAddr(), p.conn.RemoteAddr())
+	}
+}
+
+func (p *path) setupRTTEstimator() {
+	if p.sess.config.RTTEstimator != nil {
+		p.rttStats.SetEstimator(p.sess.config.RTTEstimator())
+	}
+}
+
 func (p *path) close() error {
+	if p.open.Get() {
+		p.sess.addMetric(MetricPathsClosed, 1)
+	}
 	p.open.Set(false)
 	return nil
 }
@@ -164,7 +525,31 @@ runLoop:
 }
 
 func (p *path) SendingAllowed() bool {
-	return p.open.Get() && p.sentPacketHandler.SendingAllowed()
+	return p.open.Get() && !p.writeBlocked(time.Now()) && p.sentPacketHandler.SendingAllowed()
+}
+
+// writeBlocked says if the path is not used because of a transient write error
+func (p *path) writeBlocked(now time.Time) bool {
+	return now.Before(p.writeBlockedUntil)
+}
+
+// keepAliveDeadline returns when a PING has to be sent on the path to keep it alive, see Config.PathKeepAlivePeriod.
+// It returns a zero time if the path carries streams or didn't send any packet yet.
+func (p *path) keepAliveDeadline(period time.Duration) time.Time {
+	if period <= 0 || len(p.streamIDs) > 0 || p.lastPacketSentTime.IsZero() {
+		return time.Time{}
+	}
+	return p.lastPacketSentTime.Add(period)
+}
+
+// hasStream checks whether the stream is scheduled on this path
+func (p *path) hasStream(streamID protocol.StreamID) bool {
+	for _, sid := range p.streamIDs {
+		if sid == streamID {
+			return true
+		}
+	}
+	return false
 }
 
 func (p *path) GetStopWaitingFrame(force bool) *wire.StopWaitingFrame {
@@ -239,11 +624,11 @@ func (p *path) handlePacketImpl(pkt *receivedPacket) error {
 	)
 
 	packet, err := p.sess.unpacker.Unpack(hdr.Raw, hdr, data)
-	if utils.Debug() {
+	if p.sess.logger.Debug() {
 		if err != nil {
-			utils.Debugf("<- Reading packet 0x%x (%d bytes) for connection %x on path %x", hdr.PacketNumber, len(data)+len(hdr.Raw), hdr.ConnectionID, p.pathID)
+			p.sess.logger.Debugf("<- Reading packet 0x%x (%d bytes) for connection %x on path %x", hdr.PacketNumber, len(data)+len(hdr.Raw), hdr.ConnectionID, p.pathID)
 		} else {
-			utils.Debugf("<- Reading packet 0x%x (%d bytes) for connection %x on path %x, %s", hdr.PacketNumber, len(data)+len(hdr.Raw), hdr.ConnectionID, p.pathID, packet.encryptionLevel)
+			p.sess.logger.Debugf("<- Reading packet 0x%x (%d bytes) for connection %x on path %x, %s", hdr.PacketNumber, len(data)+len(hdr.Raw), hdr.ConnectionID, p.pathID, packet.encryptionLevel)
 		}
 	}
 
@@ -252,9 +637,21 @@ func (p *path) handlePacketImpl(pkt *receivedPacket) error {
 	if quicErr, ok := err.(*qerr.QuicError); ok && quicErr.ErrorCode == qerr.DecryptionFailure {
 		return err
 	}
+	// drop the packet without acking it, the peer retransmits it once it detects the loss
+	if err == errStreamFrameChecksumMismatch {
+		p.sess.logger.Errorf("Dropping packet 0x%x on path %x: %s", hdr.PacketNumber, p.pathID, err)
+		return nil
+	}
 	if p.sess.perspective == protocol.PerspectiveServer {
 		// update the remote address, even if unpacking failed for any other reason than a decryption error
-		p.conn.SetCurrentRemoteAddr(pkt.remoteAddr)
+		if remoteAddr := p.conn.RemoteAddr(); remoteAddr != nil && pkt.remoteAddr != nil && remoteAddr.String() != pkt.remoteAddr.String() {
+			// a packet reordered behind the packets from the new address must not move the path back
+			if hdr.PacketNumber > p.largestRcvdPacketNumber {
+				p.migrate(pkt.remoteAddr, p.sess.config.ResetCongestionOnMigration)
+			}
+		} else {
+			p.conn.SetCurrentRemoteAddr(pkt.remoteAddr)
+		}
 	}
 	if err != nil {
 		return err
@@ -276,6 +673,17 @@ func (p *path) handlePacketImpl(pkt *receivedPacket) error {
 	return p.sess.handleFramesNew(packet.frames, p, pkt.rcvPconn)
 }
 
+// migrate moves the path to a new remote address.
+// If resetCongestion is set, the path goes back to slow start and its RTT statistics are cleared.
+func (p *path) migrate(remoteAddr net.Addr, resetCongestion bool) {
+	p.sess.logger.Infof("Path %x of %x migrates from %s to %s", p.pathID, p.sess.connectionID, p.conn.RemoteAddr(), remoteAddr)
+	p.conn.SetCurrentRemoteAddr(remoteAddr)
+	p.connFailed.Set(false)
+	if resetCongestion {
+		p.sentPacketHandler.OnConnectionMigration()
+	}
+}
+
 func (p *path) onRTO(lastSentTime time.Time) bool {
 	// Was there any activity since last sent packet?
 	if p.lastNetworkActivityTime.Before(lastSentTime) {
@@ -286,6 +694,97 @@ func (p *path) onRTO(lastSentTime time.Time) bool {
 	return false
 }
 
+// onLostPacket passes the stream data of a lost packet to Config.LostPacketHandler
+func (p *path) onLostPacket(packet *ackhandler.Packet) {
+	lost := &LostPacket{
+		PathID:       p.pathID,
+		PacketNumber: packet.PacketNumber,
+		Length:       packet.Length,
+	}
+	for _, f := range packet.Frames {
+		if sf, ok := f.(*wire.StreamFrame); ok {
+			lost.StreamFrames = append(lost.StreamFrames, LostStreamFrame{
+				StreamID: sf.StreamID,
+				Offset:   sf.Offset,
+				Length:   sf.DataLen(),
+				FinBit:   sf.FinBit,
+			})
+		}
+	}
+	p.sess.config.LostPacketHandler(lost)
+}
+
+// onAckedPacket records the stream data of an acked packet, such that it is not reinjected when another packet carrying it is lost
+func (p *path) onAckedPacket(packet *ackhandler.Packet) {
+	p.sess.addPathMetric(MetricBytesAcked, p.pathID, int64(packet.Length))
+	for _, f := range packet.Frames {
+		sf, ok := f.(*wire.StreamFrame)
+		if !ok {
+			continue
+		}
+		str, err := p.sess.streamsMap.GetOrOpenStream(sf.StreamID)
+		if err == nil && str != nil {
+			str.onDataAcked(sf.Offset, sf.DataLen())
+		}
+	}
+}
+
+// onWriteError marks the conn of the path as failed, and lets the peer know about it.
+// It may be called while the paths lock is held, so the PATHS frame is only scheduled by the run loop.
+func (p *path) onWriteError(err error, now time.Time) {
+	p.sess.logger.Errorf("Path %x of %x: write failed, not using it for %s: %s", p.pathID, p.sess.connectionID, protocol.FailedPathRetryPeriod, err)
+	p.connFailedTime = now
+	if !p.connFailed.Get() {
+		p.connFailed.Set(true)
+		p.sess.pathsFrameNeeded.Set(true)
+	}
+}
+
+// retryDeadline returns the time the failed conn of the path is probed again, or a zero time if it didn't fail
+func (p *path) retryDeadline() time.Time {
+	if !p.connFailed.Get() {
+		return time.Time{}
+	}
+	return p.connFailedTime.Add(protocol.FailedPathRetryPeriod)
+}
+
+// onTransientWriteError stops using the path for a short time, the packets that couldn't be written wait until flushPackets writes them again
+func (p *path) onTransientWriteError(err error, now time.Time) {
+	p.sess.logger.Infof("Path %x of %x: write failed temporarily, not using it for %s: %s", p.pathID, p.sess.connectionID, protocol.TransientWriteErrorBackoff, err)
+	p.writeBlockedUntil = now.Add(protocol.TransientWriteErrorBackoff)
+}
+
+// queuePacket copies the packet to the ones waiting to be written on the conn.
+// It returns true once limit packets are waiting.
+func (p *path) queuePacket(raw []byte, limit int) bool {
+	b := getPacketBuffer()[:len(raw)]
+	copy(b, raw)
+	p.pendingPacketsMutex.Lock()
+	defer p.pendingPacketsMutex.Unlock()
+	p.pendingPackets = append(p.pendingPackets, b)
+	return len(p.pendingPackets) >= limit
+}
+
+// flushPackets writes the waiting packets on the conn in a single batch.
+// After a transient write error, they are kept and written again once the backoff ended.
+// Some of them may then be sent twice, which the peer handles like any duplicate packet.
+func (p *path) flushPackets() error {
+	p.pendingPacketsMutex.Lock()
+	defer p.pendingPacketsMutex.Unlock()
+	if len(p.pendingPackets) == 0 || p.writeBlocked(time.Now()) {
+		return nil
+	}
+	err := p.conn.WriteBatch(p.pendingPackets)
+	if isTransientWriteError(err) {
+		return err
+	}
+	for _, b := range p.pendingPackets {
+		putPacketBuffer(b)
+	}
+	p.pendingPackets = p.pendingPackets[:0]
+	return err
+}
+
 func (p *path) SetLeastUnacked(leastUnacked protocol.PacketNumber) {
 	p.leastUnacked = leastUnacked
 }
@@ -13,12 +13,19 @@ import (
 	"github.com/lucas-clemente/pstream/internal/wire"
 )
 
+var (
+	errPathExists        = errors.New("trying to create already existing path")
+	errRemotePathRefused = errors.New("refusing to create path initiated by the peer")
+)
+
 type pathManager struct {
 	pconnMgr  *pconnManager
 	sess      *session
 	nxtPathID protocol.PathID
 	// Number of paths, excluding the initial one
 	nbPaths uint8
+	// Number of open paths created for packets received on a new path ID
+	nbRemotePaths int
 
 	remoteAddrs4 []net.UDPAddr
 	remoteAddrs6 []net.UDPAddr
@@ -133,17 +140,14 @@ func (pm *pathManager) advertiseAddresses() {
 }
 
 func (pm *pathManager) createPath(locAddr net.UDPAddr, remAddr net.UDPAddr) error {
+	// If probing the path fails, the PATHS frame takes the paths lock, so it is scheduled once the lock is released
+	defer pm.sess.maybeSchedulePathsFrame()
 	// First check that the path does not exist yet
 	pm.sess.pathsLock.Lock()
 	defer pm.sess.pathsLock.Unlock()
-	paths := pm.sess.paths
-	for _, pth := range paths {
-		locAddrPath := pth.conn.LocalAddr().String()
-		remAddrPath := pth.conn.RemoteAddr().String()
-		if locAddr.String() == locAddrPath && remAddr.String() == remAddrPath {
-			// Path already exists, so don't create it again
-			return nil
-		}
+	if pm.checkNewPath(pm.nxtPathID, &locAddr, &remAddr) != nil {
+		// Path already exists, so don't create it again
+		return nil
 	}
 	// No matching path, so create it
 
@@ -167,7 +171,7 @@ func (pm *pathManager) createPath(locAddr net.UDPAddr, remAddr net.UDPAddr) erro
 		bandwidth = 20
 		bandwidth *= 1048576
 	} else {
-		rtt = 0
+		rtt = pm.initialRTTEstimate()
 		bandwidth = 0
 	}
 	pth.setupWithStatistics(pm.oliaSenders, rtt, bandwidth)
@@ -181,7 +185,80 @@ func (pm *pathManager) createPath(locAddr net.UDPAddr, remAddr net.UDPAddr) erro
 	// Send a PING frame to get latency info about the new path and informing the
 	// peer of its existence
 	// Because we hold pathsLock, it is safe to send packet now
-	return pm.sess.sendPing(pth)
+	err := pm.sess.sendPing(pth)
+	if err != nil || pth.connFailed.Get() {
+		// a path that can't be probed would never be used, don't keep it
+		utils.Infof("Path %x on %s to %s: probing failed, removing it", pth.pathID, locAddr.String(), remAddr.String())
+		pm.removePath(pth)
+	}
+	return err
+}
+
+// removePath undoes the creation of a path that was never used.
+// The caller holds the paths lock.
+func (pm *pathManager) removePath(pth *path) {
+	delete(pm.sess.paths, pth.pathID)
+	for i, pathID := range pm.sess.openPaths {
+		if pathID == pth.pathID {
+			pm.sess.openPaths = append(pm.sess.openPaths[:i], pm.sess.openPaths[i+1:]...)
+			break
+		}
+	}
+	delete(pm.oliaSenders, pth.pathID)
+	pm.releaseRemotePath(pth)
+	pth.close()
+	select {
+	case pth.closeChan <- nil:
+	default:
+	}
+}
+
+// dropRemotePath removes a path created by the peer whose first packet couldn't be decrypted,
+// such that packets with a spoofed path ID don't use up Config.MaxRemotePaths
+func (pm *pathManager) dropRemotePath(pth *path) {
+	pm.sess.pathsLock.Lock()
+	defer pm.sess.pathsLock.Unlock()
+	pm.removePath(pth)
+}
+
+// releaseRemotePath frees the slot of a path created by the peer, once it is removed or closed
+func (pm *pathManager) releaseRemotePath(pth *path) {
+	if pth.remote {
+		pth.remote = false
+		pm.nbRemotePaths--
+	}
+}
+
+// checkNewPath returns errPathExists if a path with the same PathID,
+// or between the same local and remote addresses, was already created, whatever triggered its creation.
+// The caller holds the paths lock.
+func (pm *pathManager) checkNewPath(pathID protocol.PathID, locAddr net.Addr, remAddr net.Addr) error {
+	for id, pth := range pm.sess.paths {
+		if id == pathID {
+			return errPathExists
+		}
+		if sameAddr(pth.conn.LocalAddr(), locAddr) && sameAddr(pth.conn.RemoteAddr(), remAddr) {
+			return errPathExists
+		}
+	}
+	return nil
+}
+
+func sameAddr(a, b net.Addr) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.String() == b.String()
+}
+
+//   the RTT measured on the initial path, at least during the handshake, is a first guess for the RTT of new paths
+//   the caller holds the paths lock
+func (pm *pathManager) initialRTTEstimate() time.Duration {
+	pth, ok := pm.sess.paths[protocol.InitialPathID]
+	if !ok {
+		return 0
+	}
+	return pth.rttStats.SmoothedRTT()
 }
 
 func (pm *pathManager) createPaths() error {
@@ -235,10 +312,9 @@ func (pm *pathManager) createPathFromRemote(p *receivedPacket) (*path, error) {
 	remoteAddr := p.remoteAddr
 	pathID := p.publicHeader.PathID
 
-	// Sanity check: pathID should not exist yet
-	_, ko := pm.sess.paths[pathID]
-	if ko {
-		return nil, errors.New("trying to create already existing path")
+	// Sanity check: the path should not exist yet
+	if err := pm.checkNewPath(pathID, localPconn.LocalAddr(), remoteAddr); err != nil {
+		return nil, err
 	}
 
 	// Sanity check: odd is client initiated, even for server initiated
@@ -249,6 +325,13 @@ func (pm *pathManager) createPathFromRemote(p *receivedPacket) (*path, error) {
 		return nil, errors.New("client tries to create even pathID")
 	}
 
+	if pm.nbRemotePaths >= pm.sess.config.MaxRemotePaths {
+		return nil, errRemotePathRefused
+	}
+	if pm.sess.config.RequireAdvertisedRemotePaths && !pm.isAdvertisedRemoteAddr(remoteAddr) {
+		return nil, errRemotePathRefused
+	}
+
 	remoteIP := parseIP(remoteAddr)
 
 	var rtt time.Duration
@@ -263,7 +346,7 @@ func (pm *pathManager) createPathFromRemote(p *receivedPacket) (*path, error) {
 		bandwidth = 20
 		bandwidth *= 1048576
 	} else {
-		rtt = 0
+		rtt = pm.initialRTTEstimate()
 		bandwidth = 0
 
 	}
@@ -277,6 +360,8 @@ func (pm *pathManager) createPathFromRemote(p *receivedPacket) (*path, error) {
 	//pth.setup(pm.oliaSenders)
 	pm.sess.paths[pathID] = pth
 	pm.sess.openPaths = append(pm.sess.openPaths, pathID)
+	pth.remote = true
+	pm.nbRemotePaths++
 
 	if utils.Debug() {
 		utils.Debugf("Created remote path %x on %s to %s, rtt initialized to %s", pathID, localPconn.LocalAddr().String(), remoteAddr.String(), pth.rttStats.SmoothedRTT())
@@ -285,7 +370,25 @@ func (pm *pathManager) createPathFromRemote(p *receivedPacket) (*path, error) {
 	return pth, nil
 }
 
+// isAdvertisedRemoteAddr says if the peer advertised the IP of remoteAddr, or used it for the initial path
+func (pm *pathManager) isAdvertisedRemoteAddr(remoteAddr net.Addr) bool {
+	addr, err := net.ResolveUDPAddr("udp", remoteAddr.String())
+	if err != nil {
+		return false
+	}
+	for _, addrs := range [][]net.UDPAddr{pm.remoteAddrs4, pm.remoteAddrs6} {
+		for _, advertised := range addrs {
+			if advertised.IP.Equal(addr.IP) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func (pm *pathManager) createPathsFromRemotePathsFrame(frame *wire.PathsFrame, localPconn net.PacketConn) error {
+	pm.sess.pathsLock.Lock()
+	defer pm.sess.pathsLock.Unlock()
 
 	for i := 0; i < len(frame.PathIDs); i++ {
 		pathID := frame.PathIDs[i]
@@ -302,9 +405,8 @@ func (pm *pathManager) createPathsFromRemotePathsFrame(frame *wire.PathsFrame, l
 			Port: port,
 		}
 
-		// Sanity check: pathID should not exist yet
-		_, ko := pm.sess.paths[pathID]
-		if ko {
+		// Sanity check: the path should not exist yet
+		if pm.checkNewPath(pathID, localPconn.LocalAddr(), remoteAddr) != nil {
 			//trying to create already existing path, continue to check next path
 			continue
 		}
@@ -329,7 +431,7 @@ func (pm *pathManager) createPathsFromRemotePathsFrame(frame *wire.PathsFrame, l
 			bandwidth = 20
 			bandwidth *= 1048576
 		} else {
-			rtt = 0
+			rtt = pm.initialRTTEstimate()
 			bandwidth = 0
 
 		}
@@ -379,6 +481,7 @@ func (pm *pathManager) closePath(pthID protocol.PathID) error {
 	}
 
 	if pth.open.Get() {
+		pm.releaseRemotePath(pth)
 		pth.closeChan <- nil
 	}
 
@@ -0,0 +1,117 @@
+package quic
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lucas-clemente/pstream/congestion"
+	"github.com/lucas-clemente/pstream/internal/protocol"
+)
+
+// A pathStatsSample is the state of a path as seen by the schedulers at some point of the session
+type pathStatsSample struct {
+	offset      time.Duration // since the creation of the session
+	pathID      protocol.PathID
+	smoothedRTT time.Duration
+	bandwidth   congestion.Bandwidth // bit per second
+}
+
+// A pathStatsRecorder writes the samples of the paths, one per line:
+// the offset since the creation of the session in nanoseconds, the path ID, the smoothed RTT in nanoseconds and the bandwidth in bit per second.
+// The lines are buffered, since a sample is taken on every ACK, until flush is called.
+type pathStatsRecorder struct {
+	mutex sync.Mutex
+
+	w     *bufio.Writer
+	start time.Time
+}
+
+func newPathStatsRecorder(w io.Writer, start time.Time) *pathStatsRecorder {
+	return &pathStatsRecorder{w: bufio.NewWriter(w), start: start}
+}
+
+func (r *pathStatsRecorder) record(now time.Time, pth *path) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	_, err := fmt.Fprintf(r.w, "%d %d %d %d\n", now.Sub(r.start).Nanoseconds(), pth.pathID, pth.rttStats.SmoothedRTT().Nanoseconds(), uint64(pth.bdwStats.GetBandwidthBps()))
+	return err
+}
+
+// flush writes the buffered samples to the writer
+func (r *pathStatsRecorder) flush() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.w.Flush()
+}
+
+func parsePathStatsSeries(r io.Reader) ([]pathStatsSample, error) {
+	var samples []pathStatsSample
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var offset, rtt int64
+		var pathID uint8
+		var bandwidth uint64
+		if _, err := fmt.Sscanf(scanner.Text(), "%d %d %d %d", &offset, &pathID, &rtt, &bandwidth); err != nil {
+			return nil, fmt.Errorf("invalid path statistics on line %d: %s", line, err)
+		}
+		samples = append(samples, pathStatsSample{
+			offset:      time.Duration(offset),
+			pathID:      protocol.PathID(pathID),
+			smoothedRTT: time.Duration(rtt),
+			bandwidth:   congestion.Bandwidth(bandwidth),
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(samples, func(i, j int) bool { return samples[i].offset < samples[j].offset })
+	return samples, nil
+}
+
+// A pathStatsReplayer feeds recorded samples back into the statistics of the paths,
+// so that the schedulers see the same conditions as when they were recorded
+type pathStatsReplayer struct {
+	mutex sync.Mutex
+
+	samples []pathStatsSample
+	next    int
+	start   time.Time
+}
+
+func newPathStatsReplayer(r io.Reader, start time.Time) (*pathStatsReplayer, error) {
+	samples, err := parsePathStatsSeries(r)
+	if err != nil {
+		return nil, err
+	}
+	return &pathStatsReplayer{samples: samples, start: start}, nil
+}
+
+// replay applies the samples recorded until now to the paths.
+// The samples of paths that don't exist (yet) are skipped.
+// Only the schedulers see the replayed RTT and bandwidth, the sent packet handler of the path keeps measuring them.
+func (r *pathStatsReplayer) replay(now time.Time, paths map[protocol.PathID]*path) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for ; r.next < len(r.samples) && r.samples[r.next].offset <= now.Sub(r.start); r.next++ {
+		sample := r.samples[r.next]
+		pth, ok := paths[sample.pathID]
+		if !ok {
+			continue
+		}
+		pth.replayedRTT = sample.smoothedRTT
+		if !pth.replaying {
+			// the sent packet handler keeps sampling the bandwidth into the BDWStats it was created with
+			pth.bdwStats = congestion.NewBDWStats(0)
+			pth.replaying = true
+		}
+		pth.bdwStats.SetBandwidth(sample.bandwidth)
+	}
+}
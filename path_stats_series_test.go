@@ -0,0 +1,77 @@
+package quic
+
+import (
+	"bytes"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/lucas-clemente/pstream/congestion"
+	"github.com/lucas-clemente/pstream/internal/protocol"
+)
+
+var _ = Describe("Path statistics series", func() {
+	var paths map[protocol.PathID]*path
+
+	BeforeEach(func() {
+		paths = map[protocol.PathID]*path{
+			1: {pathID: 1, rttStats: congestion.NewRTTStatsWithSmoothedRTT(40 * time.Millisecond), bdwStats: congestion.NewBDWStats(10 * 1048576)},
+		}
+	})
+
+	It("records a sample per line", func() {
+		buf := &bytes.Buffer{}
+		start := time.Now()
+		recorder := newPathStatsRecorder(buf, start)
+		Expect(recorder.record(start.Add(time.Millisecond), paths[1])).To(Succeed())
+		Expect(recorder.record(start.Add(2*time.Millisecond), paths[1])).To(Succeed())
+		// the samples are buffered
+		Expect(buf.Len()).To(BeZero())
+		Expect(recorder.flush()).To(Succeed())
+		Expect(buf.String()).To(Equal("1000000 1 40000000 10485760\n2000000 1 40000000 10485760\n"))
+	})
+
+	It("applies the samples once their offset is reached", func() {
+		start := time.Now()
+		replayer, err := newPathStatsReplayer(strings.NewReader("20000000 1 30000000 3145728\n\n10000000 1 20000000 2097152\n"), start)
+		Expect(err).ToNot(HaveOccurred())
+		replayer.replay(start, paths)
+		Expect(paths[1].schedulingRTT()).To(Equal(40 * time.Millisecond))
+		replayer.replay(start.Add(10*time.Millisecond), paths)
+		Expect(paths[1].schedulingRTT()).To(Equal(20 * time.Millisecond))
+		Expect(paths[1].bdwStats.GetBandwidth()).To(BeEquivalentTo(2))
+		replayer.replay(start.Add(time.Second), paths)
+		Expect(paths[1].schedulingRTT()).To(Equal(30 * time.Millisecond))
+		Expect(paths[1].bdwStats.GetBandwidth()).To(BeEquivalentTo(3))
+	})
+
+	It("only replaces the estimates the schedulers see", func() {
+		start := time.Now()
+		liveBDWStats := paths[1].bdwStats
+		replayer, err := newPathStatsReplayer(strings.NewReader("0 1 20000000 2097152\n"), start)
+		Expect(err).ToNot(HaveOccurred())
+		replayer.replay(start, paths)
+		paths[1].rttStats.UpdateRTT(100*time.Millisecond, 0, start)
+		liveBDWStats.UpdateBDW(10000, 10*time.Millisecond, false)
+		Expect(paths[1].schedulingRTT()).To(Equal(20 * time.Millisecond))
+		Expect(paths[1].bdwStats.GetBandwidth()).To(BeEquivalentTo(2))
+		// loss detection and congestion control keep using the measured RTT
+		Expect(paths[1].rttStats.SmoothedRTT()).To(Equal(100 * time.Millisecond))
+	})
+
+	It("skips the samples of unknown paths", func() {
+		start := time.Now()
+		replayer, err := newPathStatsReplayer(strings.NewReader("0 3 20000000 2097152\n"), start)
+		Expect(err).ToNot(HaveOccurred())
+		replayer.replay(start, paths)
+		Expect(paths[1].schedulingRTT()).To(Equal(40 * time.Millisecond))
+		Expect(paths[1].replaying).To(BeFalse())
+	})
+
+	It("rejects an invalid series", func() {
+		_, err := newPathStatsReplayer(strings.NewReader("0 1 20000000 2097152\nfoobar\n"), time.Now())
+		Expect(err).To(MatchError(ContainSubstring("invalid path statistics on line 2")))
+	})
+})
@@ -0,0 +1,408 @@
+package quic
+
+import (
+	"net"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/lucas-clemente/pstream/ackhandler"
+	"github.com/lucas-clemente/pstream/congestion"
+	"github.com/lucas-clemente/pstream/internal/protocol"
+	"github.com/lucas-clemente/pstream/internal/utils"
+	"github.com/lucas-clemente/pstream/internal/wire"
+)
+
+var _ = Describe("Path", func() {
+	Context("migration", func() {
+		var (
+			pth     *path
+			conn    *mockConnection
+			newAddr *net.UDPAddr
+		)
+
+		BeforeEach(func() {
+			conn = &mockConnection{remoteAddr: &net.UDPAddr{IP: net.IPv4(192, 168, 100, 200), Port: 1337}}
+			newAddr = &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 4242}
+			pth = &path{
+				pathID:   1,
+				conn:     conn,
+				sess:     &session{connectionID: 0x1337},
+				rttStats: &congestion.RTTStats{},
+				bdwStats: &congestion.BDWStats{},
+			}
+			pth.sentPacketHandler = ackhandler.NewSentPacketHandler(pth.pathID, pth.rttStats, pth.bdwStats, nil, nil, utils.Logger{})
+			pth.rttStats.UpdateRTT(100*time.Millisecond, 0, time.Now())
+		})
+
+		It("resets the RTT statistics and the congestion controller", func() {
+			pth.migrate(newAddr, true)
+			Expect(conn.RemoteAddr()).To(Equal(newAddr))
+			Expect(pth.rttStats.SmoothedRTT()).To(BeZero())
+
+			sph := &mockSentPacketHandler{}
+			pth.sentPacketHandler = sph
+			pth.migrate(conn.remoteAddr, true)
+			Expect(sph.migrated).To(BeTrue())
+		})
+
+		It("keeps the RTT statistics and the congestion controller if not requested", func() {
+			sph := &mockSentPacketHandler{}
+			pth.sentPacketHandler = sph
+			pth.migrate(newAddr, false)
+			Expect(conn.RemoteAddr()).To(Equal(newAddr))
+			Expect(pth.rttStats.SmoothedRTT()).To(Equal(100 * time.Millisecond))
+			Expect(sph.migrated).To(BeFalse())
+		})
+	})
+
+	Context("cost", func() {
+		It("gets the cost of the path from the config", func() {
+			localAddr := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1234}
+			var paramLocalAddr net.Addr
+			pth := &path{
+				conn: &mockConnection{localAddr: localAddr, remoteAddr: &net.UDPAddr{}},
+				sess: &session{config: &Config{
+					PathCost: func(local, _ net.Addr) PathCost {
+						paramLocalAddr = local
+						return PathCostHigh
+					},
+				}},
+			}
+			pth.setupCost()
+			Expect(paramLocalAddr).To(Equal(localAddr))
+			Expect(pth.cost).To(Equal(PathCostHigh))
+		})
+
+		It("has a low cost if not configured", func() {
+			pth := &path{sess: &session{config: &Config{}}}
+			pth.setupCost()
+			Expect(pth.cost).To(Equal(PathCostLow))
+		})
+	})
+
+	Context("group", func() {
+		It("gets the group of the path from the config", func() {
+			remoteAddr := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 4433}
+			var paramRemoteAddr net.Addr
+			pth := &path{
+				conn: &mockConnection{localAddr: &net.UDPAddr{}, remoteAddr: remoteAddr},
+				sess: &session{config: &Config{
+					PathGroup: func(_, remote net.Addr) string {
+						paramRemoteAddr = remote
+						return "wifi"
+					},
+				}},
+			}
+			pth.setupGroup()
+			Expect(paramRemoteAddr).To(Equal(remoteAddr))
+			Expect(pth.group).To(Equal("wifi"))
+		})
+
+		It("has no group if not configured", func() {
+			pth := &path{sess: &session{config: &Config{}}}
+			pth.setupGroup()
+			Expect(pth.group).To(BeEmpty())
+		})
+	})
+
+	Context("MTU", func() {
+		It("gets the MTU of the path from the config", func() {
+			pth := &path{
+				conn: &mockConnection{localAddr: &net.UDPAddr{}, remoteAddr: &net.UDPAddr{}},
+				sess: &session{config: &Config{
+					PathMTU: func(_, _ net.Addr) protocol.ByteCount { return 1280 },
+				}},
+			}
+			pth.setupMTU()
+			Expect(pth.maxPacketSize()).To(Equal(protocol.ByteCount(1280)))
+		})
+
+		It("bounds the MTU", func() {
+			pth := &path{mtu: 100}
+			Expect(pth.maxPacketSize()).To(Equal(protocol.MinPathMTU))
+			pth.mtu = 9000
+			Expect(pth.maxPacketSize()).To(Equal(protocol.MaxPacketSize))
+		})
+
+		It("uses the maximum packet size if not configured", func() {
+			pth := &path{sess: &session{config: &Config{}}}
+			pth.setupMTU()
+			Expect(pth.maxPacketSize()).To(Equal(protocol.MaxPacketSize))
+		})
+	})
+
+	Context("RTT estimator", func() {
+		It("gets the RTT estimator of the path from the config", func() {
+			estimator := &fixedRTTEstimator{rtt: 42 * time.Millisecond}
+			pth := &path{
+				rttStats: &congestion.RTTStats{},
+				sess: &session{config: &Config{
+					RTTEstimator: func() RTTEstimator { return estimator },
+				}},
+			}
+			pth.setupRTTEstimator()
+			Expect(pth.rttStats.SmoothedRTT()).To(Equal(42 * time.Millisecond))
+		})
+	})
+
+	Context("loss detection", func() {
+		It("uses the packet reordering threshold from the config", func() {
+			pth := &path{
+				pathID: 1,
+				conn:   &mockConnection{remoteAddr: &net.UDPAddr{}},
+				sess:   &session{config: &Config{PacketReorderingThreshold: 3}},
+			}
+			pth.setup(nil)
+			defer func() {
+				pth.closeChan <- nil
+				Eventually(pth.runClosed).Should(Receive())
+			}()
+			for pn := protocol.PacketNumber(1); pn <= 5; pn++ {
+				err := pth.sentPacketHandler.SentPacket(&ackhandler.Packet{PacketNumber: pn, Length: 1, Frames: []wire.Frame{&wire.PingFrame{}}})
+				Expect(err).ToNot(HaveOccurred())
+			}
+			// with an RTT of an hour, no packet is lost because of the time threshold
+			err := pth.sentPacketHandler.ReceivedAck(&wire.AckFrame{LargestAcked: 5, LowestAcked: 5}, 1, time.Now().Add(time.Hour))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pth.sentPacketHandler.DequeuePacketForRetransmission().PacketNumber).To(Equal(protocol.PacketNumber(1)))
+			Expect(pth.sentPacketHandler.DequeuePacketForRetransmission().PacketNumber).To(Equal(protocol.PacketNumber(2)))
+			Expect(pth.sentPacketHandler.DequeuePacketForRetransmission()).To(BeNil())
+		})
+	})
+
+	Context("skipped packets", func() {
+		It("uses the number of tracked skipped packets from the config", func() {
+			pth := &path{
+				pathID: 1,
+				conn:   &mockConnection{remoteAddr: &net.UDPAddr{}},
+				sess:   &session{config: &Config{MaxTrackedSkippedPackets: 1}},
+			}
+			pth.setup(nil)
+			defer func() {
+				pth.closeChan <- nil
+				Eventually(pth.runClosed).Should(Receive())
+			}()
+			// packet numbers 2 and 4 are skipped, only 4 is remembered
+			for _, pn := range []protocol.PacketNumber{1, 3, 5} {
+				err := pth.sentPacketHandler.SentPacket(&ackhandler.Packet{PacketNumber: pn, Length: 1, Frames: []wire.Frame{&wire.PingFrame{}}})
+				Expect(err).ToNot(HaveOccurred())
+			}
+			err := pth.sentPacketHandler.ReceivedAck(&wire.AckFrame{LargestAcked: 3, LowestAcked: 1}, 1, time.Now())
+			Expect(err).ToNot(HaveOccurred())
+			err = pth.sentPacketHandler.ReceivedAck(&wire.AckFrame{LargestAcked: 5, LowestAcked: 1}, 2, time.Now())
+			Expect(err).To(MatchError(ackhandler.ErrAckForSkippedPacket))
+		})
+	})
+
+	Context("lost packets", func() {
+		It("reports the stream data of a lost packet to the config", func() {
+			var lost []*LostPacket
+			pth := &path{
+				pathID: 1,
+				conn:   &mockConnection{remoteAddr: &net.UDPAddr{}},
+				sess: &session{config: &Config{
+					PacketReorderingThreshold: 1,
+					LostPacketHandler:         func(p *LostPacket) { lost = append(lost, p) },
+				}},
+			}
+			pth.setup(nil)
+			defer func() {
+				pth.closeChan <- nil
+				Eventually(pth.runClosed).Should(Receive())
+			}()
+			err := pth.sentPacketHandler.SentPacket(&ackhandler.Packet{
+				PacketNumber: 1,
+				Length:       100,
+				Frames: []wire.Frame{
+					&wire.StreamFrame{StreamID: 5, Offset: 0x1337, Data: []byte("foobar"), FinBit: true},
+					&wire.WindowUpdateFrame{StreamID: 7},
+				},
+			})
+			Expect(err).ToNot(HaveOccurred())
+			err = pth.sentPacketHandler.SentPacket(&ackhandler.Packet{PacketNumber: 2, Length: 1, Frames: []wire.Frame{&wire.PingFrame{}}})
+			Expect(err).ToNot(HaveOccurred())
+			err = pth.sentPacketHandler.ReceivedAck(&wire.AckFrame{LargestAcked: 2, LowestAcked: 2}, 1, time.Now().Add(time.Hour))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(lost).To(Equal([]*LostPacket{{
+				PathID:       1,
+				PacketNumber: 1,
+				Length:       100,
+				StreamFrames: []LostStreamFrame{{StreamID: 5, Offset: 0x1337, Length: 6, FinBit: true}},
+			}}))
+		})
+	})
+
+	Context("pacing", func() {
+		It("uses the pacing gain from the config", func() {
+			pth := &path{
+				pathID: 1,
+				conn:   &mockConnection{remoteAddr: &net.UDPAddr{}},
+				sess:   &session{config: &Config{PacingGain: 1.25}},
+			}
+			pth.setup(nil)
+			defer func() {
+				pth.closeChan <- nil
+				Eventually(pth.runClosed).Should(Receive())
+			}()
+			pth.rttStats.UpdateRTT(time.Second, 0, time.Now())
+			// the packets of the burst quantum go out back-to-back, the following ones are paced
+			var pn protocol.PacketNumber
+			for pn = 1; pth.sentPacketHandler.SendingAllowed() && pn < 100; pn++ {
+				err := pth.sentPacketHandler.SentPacket(&ackhandler.Packet{PacketNumber: pn, Length: 1000, Frames: []wire.Frame{&wire.PingFrame{}}})
+				Expect(err).ToNot(HaveOccurred())
+			}
+			Expect(pn).To(BeNumerically(">", 2))
+			Expect(pth.sentPacketHandler.SendingAllowed()).To(BeFalse())
+			Expect(pth.sentPacketHandler.TimeUntilSend()).ToNot(BeZero())
+		})
+	})
+
+	Context("one-way delay", func() {
+		var pth *path
+
+		BeforeEach(func() {
+			pth = &path{rttStats: congestion.NewRTTStatsWithSmoothedRTT(40 * time.Millisecond)}
+		})
+
+		It("assumes a symmetric path without timestamps", func() {
+			Expect(pth.oneWayDelay(true)).To(Equal(20 * time.Millisecond))
+		})
+
+		It("subtracts the delay from the peer from the RTT", func() {
+			now := time.Now()
+			pth.onTimestampFrame(&wire.TimestampFrame{Timestamp: now.Add(-30 * time.Millisecond)}, now)
+			Expect(pth.oneWayDelay(true)).To(Equal(10 * time.Millisecond))
+			Expect(pth.oneWayDelay(false)).To(Equal(20 * time.Millisecond))
+		})
+
+		It("uses the replayed RTT", func() {
+			pth.replaying = true
+			pth.replayedRTT = 100 * time.Millisecond
+			Expect(pth.oneWayDelay(false)).To(Equal(50 * time.Millisecond))
+			now := time.Now()
+			pth.onTimestampFrame(&wire.TimestampFrame{Timestamp: now.Add(-30 * time.Millisecond)}, now)
+			Expect(pth.oneWayDelay(true)).To(Equal(70 * time.Millisecond))
+		})
+
+		It("smoothes the delay from the peer", func() {
+			now := time.Now()
+			pth.onTimestampFrame(&wire.TimestampFrame{Timestamp: now.Add(-30 * time.Millisecond)}, now)
+			pth.onTimestampFrame(&wire.TimestampFrame{Timestamp: now.Add(-38 * time.Millisecond)}, now)
+			Expect(pth.reverseDelay).To(Equal(31 * time.Millisecond))
+		})
+	})
+
+	Context("utilization", func() {
+		var (
+			pth *path
+			sph *mockSentPacketHandler
+		)
+
+		BeforeEach(func() {
+			sph = &mockSentPacketHandler{}
+			// 8 Mbit/s and 100 ms, i.e. a BDP of 100 kB
+			pth = &path{
+				sess:              &session{},
+				rttStats:          congestion.NewRTTStatsWithSmoothedRTT(100 * time.Millisecond),
+				bdwStats:          congestion.NewBDWStats(8000000),
+				sentPacketHandler: sph,
+			}
+		})
+
+		It("computes the BDP", func() {
+			Expect(pth.bdp()).To(Equal(protocol.ByteCount(100000)))
+		})
+
+		It("reports an app-limited path as under-utilized", func() {
+			now := time.Now()
+			sph.bytesInFlight = 10000
+			pth.updateUtilization(now)
+			Expect(pth.underUtilized(now)).To(BeFalse())
+			Expect(pth.underUtilized(now.Add(399 * time.Millisecond))).To(BeFalse())
+			Expect(pth.underUtilized(now.Add(400 * time.Millisecond))).To(BeTrue())
+			// the bytes in flight change, but stay below the BDP
+			sph.bytesInFlight = 50000
+			pth.updateUtilization(now.Add(300 * time.Millisecond))
+			Expect(pth.underUtilized(now.Add(400 * time.Millisecond))).To(BeTrue())
+		})
+
+		It("doesn't report a saturated path as under-utilized", func() {
+			now := time.Now()
+			sph.bytesInFlight = 10000
+			pth.updateUtilization(now)
+			sph.bytesInFlight = 120000
+			pth.updateUtilization(now.Add(200 * time.Millisecond))
+			Expect(pth.underUtilized(now.Add(time.Second))).To(BeFalse())
+		})
+
+		It("doesn't report a path with an unknown bandwidth as under-utilized", func() {
+			now := time.Now()
+			pth.bdwStats = &congestion.BDWStats{}
+			pth.updateUtilization(now)
+			Expect(pth.underUtilized(now.Add(time.Second))).To(BeFalse())
+		})
+
+		It("reports the BDP estimated from the ACKs in the path stats", func() {
+			pth = &path{
+				pathID: 1,
+				conn:   &mockConnection{remoteAddr: &net.UDPAddr{}},
+				sess:   &session{config: &Config{}},
+			}
+			pth.setup(nil)
+			defer func() {
+				pth.closeChan <- nil
+				Eventually(pth.runClosed).Should(Receive())
+			}()
+			Expect(pth.bdp()).To(BeZero())
+			// 10 kB delivered in one RTT of 100 ms
+			err := pth.sentPacketHandler.SentPacket(&ackhandler.Packet{PacketNumber: 1, Length: 10000, Frames: []wire.Frame{&wire.PingFrame{}}})
+			Expect(err).ToNot(HaveOccurred())
+			err = pth.sentPacketHandler.ReceivedAck(&wire.AckFrame{LargestAcked: 1, LowestAcked: 1}, 1, time.Now().Add(100*time.Millisecond))
+			Expect(err).ToNot(HaveOccurred())
+			err = pth.sentPacketHandler.SentPacket(&ackhandler.Packet{PacketNumber: 2, Length: 5000, Frames: []wire.Frame{&wire.PingFrame{}}})
+			Expect(err).ToNot(HaveOccurred())
+			stats := pth.stats()
+			Expect(stats.BDP).To(BeNumerically("~", 10000, 10))
+			Expect(stats.BytesInFlight).To(Equal(protocol.ByteCount(5000)))
+			Expect(stats.UnderUtilized).To(BeFalse())
+		})
+	})
+
+	Context("retransmission rate", func() {
+		var pth *path
+
+		BeforeEach(func() {
+			pth = &path{}
+		})
+
+		It("measures the retransmissions of the last second", func() {
+			now := time.Now()
+			Expect(pth.retransmissionRate(now)).To(BeZero())
+			pth.onRetransmission(now)
+			pth.onRetransmission(now.Add(100 * time.Millisecond))
+			pth.onRetransmission(now.Add(200 * time.Millisecond))
+			Expect(pth.retransmissionRate(now.Add(500 * time.Millisecond))).To(Equal(float64(3)))
+			Expect(pth.retransmissionRate(now.Add(1100 * time.Millisecond))).To(Equal(float64(1)))
+			Expect(pth.retransmissionRate(now.Add(2 * time.Second))).To(BeZero())
+		})
+
+		It("doesn't limit the retransmissions without a maximum rate", func() {
+			now := time.Now()
+			for i := 0; i < 100; i++ {
+				pth.onRetransmission(now)
+			}
+			Expect(pth.retransmissionAllowedAt(now, 0)).To(BeZero())
+		})
+
+		It("limits the retransmissions to the maximum rate", func() {
+			now := time.Now()
+			pth.onRetransmission(now)
+			Expect(pth.retransmissionAllowedAt(now, 2)).To(BeZero())
+			pth.onRetransmission(now.Add(100 * time.Millisecond))
+			Expect(pth.retransmissionAllowedAt(now.Add(100*time.Millisecond), 2)).To(Equal(now.Add(time.Second)))
+			Expect(pth.retransmissionAllowedAt(now.Add(time.Second), 2)).To(BeZero())
+		})
+	})
+})
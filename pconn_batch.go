@@ -0,0 +1,23 @@
+package quic
+
+import (
+	"errors"
+	"net"
+)
+
+// A batchWriter writes several packets to the same address, using as few system calls as possible
+type batchWriter interface {
+	WriteBatch(packets [][]byte, addr net.Addr) error
+}
+
+// errBatchNotSupported is returned by a batchWriter that can't write to the given address.
+// The packets then have to be written one by one.
+var errBatchNotSupported = errors.New("batched writes not supported")
+
+// newBatchWriter returns the batchWriter used for the pconn, or nil if batched writes are not supported
+func newBatchWriter(c net.PacketConn) batchWriter {
+	if bw, ok := c.(batchWriter); ok {
+		return bw
+	}
+	return newSysBatchWriter(c)
+}
@@ -0,0 +1,74 @@
+//go:build linux
+// +build linux
+
+package quic
+
+import (
+	"net"
+	"syscall"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// sysBatchWriter writes packets with sendmmsg
+type sysBatchWriter struct {
+	family     int
+	writeBatch func([]ipv4.Message, int) (int, error)
+}
+
+var _ batchWriter = &sysBatchWriter{}
+
+func newSysBatchWriter(c net.PacketConn) batchWriter {
+	udpConn, ok := c.(*net.UDPConn)
+	if !ok {
+		return nil
+	}
+	rawConn, err := udpConn.SyscallConn()
+	if err != nil {
+		return nil
+	}
+	var family int
+	var sockErr error
+	err = rawConn.Control(func(fd uintptr) {
+		family, sockErr = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_DOMAIN)
+	})
+	if err != nil || sockErr != nil {
+		return nil
+	}
+	w := &sysBatchWriter{family: family}
+	switch family {
+	case syscall.AF_INET:
+		w.writeBatch = ipv4.NewPacketConn(udpConn).WriteBatch
+	case syscall.AF_INET6:
+		w.writeBatch = ipv6.NewPacketConn(udpConn).WriteBatch
+	default:
+		return nil
+	}
+	return w
+}
+
+func (w *sysBatchWriter) WriteBatch(packets [][]byte, addr net.Addr) error {
+	udpAddr, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return errBatchNotSupported
+	}
+	// the socket address is encoded according to the IP version of the address,
+	// an IPv6 socket can't be used with an IPv4 address here
+	if (udpAddr.IP.To4() != nil) != (w.family == syscall.AF_INET) {
+		return errBatchNotSupported
+	}
+	ms := make([]ipv4.Message, len(packets))
+	for i, p := range packets {
+		ms[i].Buffers = [][]byte{p}
+		ms[i].Addr = addr
+	}
+	for len(ms) > 0 {
+		n, err := w.writeBatch(ms, 0)
+		if err != nil {
+			return err
+		}
+		ms = ms[n:]
+	}
+	return nil
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package quic
+
+import "net"
+
+// batched writes are only supported on Linux, packets are written one by one
+func newSysBatchWriter(c net.PacketConn) batchWriter {
+	return nil
+}
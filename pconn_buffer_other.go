@@ -0,0 +1,14 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris && !windows
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris,!windows
+
+package quic
+
+import (
+	"errors"
+	"net"
+)
+
+// getSocketBufferSizes is not supported on this platform
+func getSocketBufferSizes(c *net.UDPConn) (rcv int, snd int, err error) {
+	return 0, 0, errors.New("reading socket buffer sizes is not supported on this platform")
+}
@@ -0,0 +1,34 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package quic
+
+import (
+	"net"
+	"runtime"
+	"syscall"
+)
+
+// getSocketBufferSizes returns the receive and send buffer sizes reported by the OS
+// Linux reports twice the size that was set, to account for bookkeeping overhead, so it is halved to compare to the requested size
+func getSocketBufferSizes(c *net.UDPConn) (rcv int, snd int, err error) {
+	rawConn, err := c.SyscallConn()
+	if err != nil {
+		return 0, 0, err
+	}
+	var sockErr error
+	err = rawConn.Control(func(fd uintptr) {
+		rcv, sockErr = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_RCVBUF)
+		if sockErr != nil {
+			return
+		}
+		snd, sockErr = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_SNDBUF)
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	if runtime.GOOS == "linux" {
+		rcv, snd = rcv/2, snd/2
+	}
+	return rcv, snd, sockErr
+}
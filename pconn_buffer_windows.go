@@ -0,0 +1,29 @@
+//go:build windows
+// +build windows
+
+package quic
+
+import (
+	"net"
+	"syscall"
+)
+
+// getSocketBufferSizes returns the receive and send buffer sizes reported by the OS
+func getSocketBufferSizes(c *net.UDPConn) (rcv int, snd int, err error) {
+	rawConn, err := c.SyscallConn()
+	if err != nil {
+		return 0, 0, err
+	}
+	var sockErr error
+	err = rawConn.Control(func(fd uintptr) {
+		rcv, sockErr = syscall.GetsockoptInt(syscall.Handle(fd), syscall.SOL_SOCKET, syscall.SO_RCVBUF)
+		if sockErr != nil {
+			return
+		}
+		snd, sockErr = syscall.GetsockoptInt(syscall.Handle(fd), syscall.SOL_SOCKET, syscall.SO_SNDBUF)
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return rcv, snd, sockErr
+}
@@ -28,6 +28,8 @@ type pconnManager struct {
 	localAddrs []net.UDPAddr
 
 	perspective protocol.Perspective
+	// may be nil
+	config *Config
 
 	rcvRawPackets chan *receivedRawPacket
 
@@ -70,6 +72,7 @@ func (pcm *pconnManager) setup(pconnArg net.PacketConn, listenAddr net.Addr) err
 		// FIXME Update localAddrs
 		pcm.pconnAny = pconnArg
 	}
+	pcm.setBufferSizes(pcm.pconnAny)
 
 	if utils.Debug() {
 		utils.Debugf("Created pconn_manager, any on %s", pcm.pconnAny.LocalAddr().String())
@@ -81,6 +84,44 @@ func (pcm *pconnManager) setup(pconnArg net.PacketConn, listenAddr net.Addr) err
 	return nil
 }
 
+// setBufferSizes applies the socket buffer sizes of the config, and warns if the OS clamps them
+func (pcm *pconnManager) setBufferSizes(pconn net.PacketConn) {
+	if pcm.config == nil || (pcm.config.SocketReceiveBufferSize <= 0 && pcm.config.SocketSendBufferSize <= 0) {
+		return
+	}
+	udpConn, ok := pconn.(*net.UDPConn)
+	if !ok {
+		return
+	}
+
+	rcvSize := pcm.config.SocketReceiveBufferSize
+	sndSize := pcm.config.SocketSendBufferSize
+	if rcvSize > 0 {
+		if err := udpConn.SetReadBuffer(rcvSize); err != nil {
+			utils.Errorf("pconn_manager: failed to set receive buffer size of %s: %v", udpConn.LocalAddr().String(), err)
+		}
+	}
+	if sndSize > 0 {
+		if err := udpConn.SetWriteBuffer(sndSize); err != nil {
+			utils.Errorf("pconn_manager: failed to set send buffer size of %s: %v", udpConn.LocalAddr().String(), err)
+		}
+	}
+
+	rcv, snd, err := getSocketBufferSizes(udpConn)
+	if err != nil {
+		if utils.Debug() {
+			utils.Debugf("pconn_manager: cannot read buffer sizes of %s: %v", udpConn.LocalAddr().String(), err)
+		}
+		return
+	}
+	if rcv < rcvSize {
+		utils.Errorf("pconn_manager: receive buffer size of %s clamped to %d bytes (requested %d bytes)", udpConn.LocalAddr().String(), rcv, rcvSize)
+	}
+	if snd < sndSize {
+		utils.Errorf("pconn_manager: send buffer size of %s clamped to %d bytes (requested %d bytes)", udpConn.LocalAddr().String(), snd, sndSize)
+	}
+}
+
 func (pcm *pconnManager) listen(pconn net.PacketConn) {
 	var err error
 
@@ -173,6 +214,7 @@ func (pcm *pconnManager) createPconn(ip net.IP) (*net.UDPAddr, error) {
 	if err != nil {
 		return nil, err
 	}
+	pcm.setBufferSizes(pconn)
 	locAddr, err := net.ResolveUDPAddr("udp", pconn.LocalAddr().String())
 	if err != nil {
 		return nil, err
@@ -0,0 +1,76 @@
+package quic
+
+import (
+	"net"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/lucas-clemente/pstream/internal/protocol"
+)
+
+var _ = Describe("pconn Manager", func() {
+	Context("socket buffer sizes", func() {
+		const bufferSize = 1 << 16
+
+		var pconn *net.UDPConn
+
+		BeforeEach(func() {
+			var err error
+			pconn, err = net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0})
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			pconn.Close()
+		})
+
+		It("applies the configured buffer sizes", func() {
+			pcm := &pconnManager{
+				perspective: protocol.PerspectiveServer,
+				config: &Config{
+					SocketReceiveBufferSize: bufferSize,
+					SocketSendBufferSize:    bufferSize,
+				},
+			}
+			pcm.setBufferSizes(pconn)
+			rcv, snd, err := getSocketBufferSizes(pconn)
+			if err != nil {
+				Skip("reading socket buffer sizes is not supported")
+			}
+			Expect(rcv).To(BeNumerically(">=", bufferSize))
+			Expect(snd).To(BeNumerically(">=", bufferSize))
+		})
+
+		It("applies the configured buffer sizes to the pconns it creates", func() {
+			pcm := &pconnManager{
+				perspective: protocol.PerspectiveServer,
+				config:      &Config{SocketReceiveBufferSize: bufferSize},
+			}
+			err := pcm.setup(nil, nil)
+			Expect(err).ToNot(HaveOccurred())
+			defer func() {
+				pcm.closeConns <- struct{}{}
+				Eventually(pcm.closed).Should(BeClosed())
+			}()
+			rcv, _, err := getSocketBufferSizes(pcm.pconnAny.(*net.UDPConn))
+			if err != nil {
+				Skip("reading socket buffer sizes is not supported")
+			}
+			Expect(rcv).To(BeNumerically(">=", bufferSize))
+		})
+
+		It("keeps the OS defaults without config", func() {
+			rcvBefore, sndBefore, err := getSocketBufferSizes(pconn)
+			if err != nil {
+				Skip("reading socket buffer sizes is not supported")
+			}
+			pcm := &pconnManager{perspective: protocol.PerspectiveServer}
+			pcm.setBufferSizes(pconn)
+			rcv, snd, err := getSocketBufferSizes(pconn)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(rcv).To(Equal(rcvBefore))
+			Expect(snd).To(Equal(sndBefore))
+		})
+	})
+})
@@ -41,6 +41,16 @@ func (e *QuicError) Timeout() bool {
 	return false
 }
 
+// A PeerClosedError is returned by the session and its streams after the peer closed the connection with a CONNECTION_CLOSE frame
+type PeerClosedError struct {
+	ErrorCode    ErrorCode
+	ReasonPhrase string
+}
+
+func (e *PeerClosedError) Error() string {
+	return fmt.Sprintf("peer closed the connection: %s: %s", e.ErrorCode.String(), e.ReasonPhrase)
+}
+
 // ToQuicError converts an arbitrary error to a QuicError. It leaves QuicErrors
 // unchanged, and properly handles `ErrorCode`s.
 func ToQuicError(err error) *QuicError {
@@ -49,6 +59,8 @@ func ToQuicError(err error) *QuicError {
 		return e
 	case ErrorCode:
 		return Error(e, "")
+	case *PeerClosedError:
+		return Error(e.ErrorCode, e.ReasonPhrase)
 	}
 	utils.Errorf("Internal error: %v", err)
 	return Error(InternalError, err.Error())
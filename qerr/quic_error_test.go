@@ -22,6 +22,13 @@ var _ = Describe("Quic error", func() {
 		})
 	})
 
+	Context("PeerClosedError", func() {
+		It("has a string representation", func() {
+			err := &PeerClosedError{ErrorCode: PeerGoingAway, ReasonPhrase: "foobar"}
+			Expect(err).To(MatchError("peer closed the connection: PeerGoingAway: foobar"))
+		})
+	})
+
 	Context("TimeoutError", func() {
 		It("works as timeout error", func() {
 			err := Error(HandshakeTimeout, "handshake timeout")
@@ -40,6 +47,11 @@ var _ = Describe("Quic error", func() {
 			Expect(ToQuicError(err)).To(Equal(Error(DecryptionFailure, "")))
 		})
 
+		It("converts a PeerClosedError", func() {
+			err := &PeerClosedError{ErrorCode: DecryptionFailure, ReasonPhrase: "foo"}
+			Expect(ToQuicError(err)).To(Equal(Error(DecryptionFailure, "foo")))
+		})
+
 		It("changes default errors to InternalError", func() {
 			Expect(ToQuicError(io.EOF)).To(Equal(Error(InternalError, "EOF")))
 		})
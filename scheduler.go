@@ -1,10 +1,14 @@
 package quic
 
 import (
+	"fmt"
+	"math"
 	"sort"
+	"sync"
 	"time"
 
 	"github.com/lucas-clemente/pstream/ackhandler"
+	"github.com/lucas-clemente/pstream/congestion"
 	"github.com/lucas-clemente/pstream/internal/protocol"
 	"github.com/lucas-clemente/pstream/internal/utils"
 	"github.com/lucas-clemente/pstream/internal/wire"
@@ -19,6 +23,121 @@ type scheduler struct {
 	numstreams map[protocol.PathID]uint
 	//   round robin index for path sending loop
 	roundRobinIndexPath uint32
+	//   path chosen by the last low latency selection, kept until another path is better by the switch margin
+	preferredPath *path
+	//   reason why each path was not selected in the last selection pass
+	notSelected map[protocol.PathID]PathNotSelectedReason
+	//   only use high cost paths when the low cost paths are congestion limited
+	costAware bool
+	//   name of the path scheduler in use, as in Config.PathScheduler
+	name string
+	//   copy of quotas and numstreams at the end of the last pass, quotas and numstreams are only accessed by the run loop
+	stateMutex sync.RWMutex
+	state      SchedulerState
+	//   path scheduler set by Session.SetScheduler, only swapped in by the run loop before a pass
+	nextPathSchedulerMutex sync.Mutex
+	nextPathScheduler      string
+}
+
+// PathNotSelectedReason is the reason why the path scheduler did not select a path in a selection pass
+type PathNotSelectedReason uint8
+
+const (
+	// PathCongestionLimited means that the path is closed or its congestion window is full
+	PathCongestionLimited PathNotSelectedReason = iota + 1
+	// PathPotentiallyFailed means that the path is considered as potentially failed
+	PathPotentiallyFailed
+	// PathInitialAvoided means that the initial path is avoided because other paths exist
+	PathInitialAvoided
+	// PathHigherRTT means that another path with a lower RTT was preferred
+	PathHigherRTT
+	// PathHighCost means that the path has a high cost and low cost paths could be used
+	PathHighCost
+	// PathConnFailed means that writing on the conn of the path failed
+	PathConnFailed
+	// PathStreamCapReached means that the path already has Config.MaxStreamsPerPath streams
+	PathStreamCapReached
+)
+
+func (r PathNotSelectedReason) String() string {
+	switch r {
+	case PathCongestionLimited:
+		return "congestion-limited"
+	case PathPotentiallyFailed:
+		return "potentially failed"
+	case PathInitialAvoided:
+		return "initial path avoided"
+	case PathHigherRTT:
+		return "higher RTT"
+	case PathHighCost:
+		return "high cost"
+	case PathConnFailed:
+		return "conn failed"
+	case PathStreamCapReached:
+		return "stream cap reached"
+	}
+	return fmt.Sprintf("unknown reason %d", r)
+}
+
+// InitialPathPolicy defines how the path scheduler uses the initial path once other paths exist
+type InitialPathPolicy uint8
+
+const (
+	// InitialPathAvoid doesn't assign streams to the initial path. It is the default.
+	// The client still assigns its streams to it until another path got an RTT sample, as the handshake validated its RTT.
+	InitialPathAvoid InitialPathPolicy = iota
+	// InitialPathNormal treats the initial path like any other path
+	InitialPathNormal
+	// InitialPathAckOnly only sends ACKs on the initial path,
+	// even for streams that were assigned to it before the other paths existed
+	InitialPathAckOnly
+)
+
+// AckPathPolicy defines on which paths the ACKs are sent when there is no data to send
+type AckPathPolicy uint8
+
+const (
+	// AckOnAllPaths sends the ACK of each path on that path, and the window updates on every path. It is the default.
+	AckOnAllPaths AckPathPolicy = iota
+	// AckOnSamePath only sends a packet on the paths having an ACK to send, the ACK of a path being sent on that path
+	AckOnSamePath
+	// AckOnLowestRTTPath sends the ACKs of all paths on the path with the lowest smoothed RTT
+	AckOnLowestRTTPath
+)
+
+// SchedulingInputs is a snapshot of the inputs seen by the path scheduler in one scheduling pass.
+// It is passed to Config.DumpSchedulingInputs, e.g. to replay assignment decisions offline.
+type SchedulingInputs struct {
+	Time    time.Time
+	Paths   []PathSchedulingInputs
+	Streams []StreamSchedulingInputs
+}
+
+// PathSchedulingInputs describes a path as seen by the path scheduler
+type PathSchedulingInputs struct {
+	PathID protocol.PathID
+	RTT    time.Duration
+	// Bandwidth in Mbps, as used by choosePaths
+	Bandwidth congestion.Bandwidth
+	StreamIDs []protocol.StreamID
+}
+
+// StreamSchedulingInputs describes a stream waiting for a path assignment
+type StreamSchedulingInputs struct {
+	StreamID  protocol.StreamID
+	Size      protocol.ByteCount
+	SizeKnown bool
+	Priority  protocol.Priority
+}
+
+// SchedulerState is a snapshot of the counters of the path scheduler, meant for debugging
+type SchedulerState struct {
+	// Quotas is the number of packets sent on each path
+	Quotas map[protocol.PathID]uint
+	// NumStreams is the number of streams assigned to each path, without the control streams
+	NumStreams map[protocol.PathID]uint
+	// NotSelected is the reason why each path was not selected in the last path selection of the pass
+	NotSelected map[protocol.PathID]PathNotSelectedReason
 }
 
 type pathOrder struct {
@@ -30,8 +149,41 @@ func (sch *scheduler) setup(pathScheduler string) {
 	sch.quotas = make(map[protocol.PathID]uint)
 	sch.numstreams = make(map[protocol.PathID]uint)
 
+	sch.usePathScheduler(pathScheduler)
+}
+
+func (sch *scheduler) usePathScheduler(pathScheduler string) {
 	sch.pathScheduler = sch.scheduleToMultiplePaths
+	sch.costAware = pathScheduler == protocol.CostAwarePathScheduler
+	sch.name = pathScheduler
+}
+
+//   count a stream newly assigned to a path
+func (sch *scheduler) onStreamAssigned(s *session, pth *path) {
+	sch.numstreams[pth.pathID]++ //update stream quota
+	s.addMetric(MetricStreamsAssigned+"."+sch.name, 1)
+}
 
+//   the path scheduler is swapped by the run loop before its next pass, so that a pass never mixes two schedulers
+func (sch *scheduler) setPathScheduler(pathScheduler string) error {
+	if pathScheduler != protocol.DefaultPathScheduler && pathScheduler != protocol.CostAwarePathScheduler {
+		return errUnknownPathScheduler
+	}
+	sch.nextPathSchedulerMutex.Lock()
+	sch.nextPathScheduler = pathScheduler
+	sch.nextPathSchedulerMutex.Unlock()
+	return nil
+}
+
+//   only called by the run loop
+func (sch *scheduler) maybeSwapPathScheduler() {
+	sch.nextPathSchedulerMutex.Lock()
+	pathScheduler := sch.nextPathScheduler
+	sch.nextPathScheduler = ""
+	sch.nextPathSchedulerMutex.Unlock()
+	if pathScheduler != "" {
+		sch.usePathScheduler(pathScheduler)
+	}
 }
 
 //   loop to check all retransmit packets for every path(if handshake packet need to be retransmit, return imediately),
@@ -44,7 +196,7 @@ func (sch *scheduler) getRetransmission(s *session) (hasRetransmission bool, ret
 		s.pathsLock.RLock()
 	retransmitLoop:
 		for _, pthTmp := range s.paths {
-			retransmitPacket = pthTmp.sentPacketHandler.DequeuePacketForRetransmission()
+			retransmitPacket = sch.dequeueRetransmission(s, pthTmp)
 			if retransmitPacket != nil {
 				pth = pthTmp
 				break retransmitLoop
@@ -61,29 +213,12 @@ func (sch *scheduler) getRetransmission(s *session) (hasRetransmission bool, ret
 				// Don't retransmit handshake packets when the handshake is complete
 				continue
 			}
-			utils.Debugf("\tDequeueing handshake retransmission for packet 0x%x", retransmitPacket.PacketNumber)
+			s.logger.Debugf("\tDequeueing handshake retransmission for packet 0x%x", retransmitPacket.PacketNumber)
 			return
 		}
-		utils.Debugf("\tDequeueing retransmission of packet 0x%x from path %d", retransmitPacket.PacketNumber, pth.pathID)
-		// resend the frames that were in the packet
-		for _, frame := range retransmitPacket.GetFramesForRetransmission() {
-			switch f := frame.(type) {
-			case *wire.StreamFrame:
-				s.streamFramer.AddFrameForRetransmission(f)
-			case *wire.WindowUpdateFrame:
-				// only retransmit WindowUpdates if the stream is not yet closed and the we haven't sent another WindowUpdate with a higher ByteOffset for the stream
-				// XXX Should it be adapted to multiple paths?
-				currentOffset, err := s.flowControlManager.GetReceiveWindow(f.StreamID)
-				if err == nil && f.ByteOffset >= currentOffset {
-					s.packer.QueueControlFrame(f, pth)
-				}
-			case *wire.PathsFrame:
-				// Schedule a new PATHS frame to send
-				s.schedulePathsFrame()
-			default:
-				s.packer.QueueControlFrame(frame, pth)
-			}
-		}
+		s.logger.Debugf("\tDequeueing retransmission of packet 0x%x from path %d", retransmitPacket.PacketNumber, pth.pathID)
+		pth.onRetransmission(time.Now())
+		sch.queueFramesForRetransmission(s, pth, retransmitPacket)
 	}
 	return
 }
@@ -96,7 +231,7 @@ func (sch *scheduler) getRetransmissionOfPath(s *session, path *path) (hasRetran
 		// TODO add ability to reinject on another path
 		// XXX We need to check on ALL paths if any packet should be first retransmitted
 		s.pathsLock.RLock()
-		retransmitPacket = path.sentPacketHandler.DequeuePacketForRetransmission()
+		retransmitPacket = sch.dequeueRetransmission(s, path)
 		s.pathsLock.RUnlock()
 
 		if retransmitPacket == nil {
@@ -109,56 +244,154 @@ func (sch *scheduler) getRetransmissionOfPath(s *session, path *path) (hasRetran
 				// Don't retransmit handshake packets when the handshake is complete
 				continue
 			}
-			utils.Debugf("\tDequeueing handshake retransmission for packet 0x%x", retransmitPacket.PacketNumber)
+			s.logger.Debugf("\tDequeueing handshake retransmission for packet 0x%x", retransmitPacket.PacketNumber)
 			return
 		}
-		utils.Debugf("\tDequeueing retransmission of packet 0x%x from path %d", retransmitPacket.PacketNumber, path.pathID)
-		// resend the frames that were in the packet, ignore AckFrame and StopWaitingFrame
-		for _, frame := range retransmitPacket.GetFramesForRetransmission() {
-			switch f := frame.(type) {
-			case *wire.StreamFrame:
-				s.streamFramer.AddFrameForRetransmission(f)
-			case *wire.WindowUpdateFrame:
-				// only retransmit WindowUpdates if the stream is not yet closed and the we haven't sent another WindowUpdate with a higher ByteOffset for the stream
-				// XXX Should it be adapted to multiple paths?
-				currentOffset, err := s.flowControlManager.GetReceiveWindow(f.StreamID)
-				if err == nil && f.ByteOffset >= currentOffset {
-					s.packer.QueueControlFrame(f, path)
-				}
-			case *wire.PathsFrame:
-				// Schedule a new PATHS frame to send
-				s.schedulePathsFrame()
-			default:
-				s.packer.QueueControlFrame(frame, path)
+		s.logger.Debugf("\tDequeueing retransmission of packet 0x%x from path %d", retransmitPacket.PacketNumber, path.pathID)
+		path.onRetransmission(time.Now())
+		sch.queueFramesForRetransmission(s, path, retransmitPacket)
+	}
+	return
+}
+
+//   dequeue the next packet to retransmit on the path, the one held back by the retransmission rate limit first.
+//       A packet beyond Config.MaxPathRetransmissionRate is held until the path may retransmit again, and nil is returned
+func (sch *scheduler) dequeueRetransmission(s *session, path *path) *ackhandler.Packet {
+	retransmitPacket := path.heldRetransmission
+	path.heldRetransmission = nil
+	if retransmitPacket == nil {
+		retransmitPacket = path.sentPacketHandler.DequeuePacketForRetransmission()
+	}
+	if retransmitPacket == nil || retransmitPacket.EncryptionLevel != protocol.EncryptionForwardSecure {
+		return retransmitPacket
+	}
+	if allowedAt := path.retransmissionAllowedAt(time.Now(), s.config.MaxPathRetransmissionRate); !allowedAt.IsZero() {
+		s.logger.Debugf("\tRetransmission of packet 0x%x on path %d delayed by the retransmission rate limit", retransmitPacket.PacketNumber, path.pathID)
+		path.heldRetransmission = retransmitPacket
+		return nil
+	}
+	return retransmitPacket
+}
+
+//   resend the frames that were in the packet, ignore AckFrame and StopWaitingFrame
+func (sch *scheduler) queueFramesForRetransmission(s *session, path *path, retransmitPacket *ackhandler.Packet) {
+	for _, frame := range retransmitPacket.GetFramesForRetransmission() {
+		switch f := frame.(type) {
+		case *wire.StreamFrame:
+			if !s.streamFramer.AddFrameForRetransmissionUnlessExpired(f, retransmitPacket.SendTime) {
+				s.logger.Debugf("\tStream %d: data at offset 0x%x expired, not retransmitting it", f.StreamID, f.Offset)
+				s.packer.QueueControlFrame(&wire.SkipStreamDataFrame{StreamID: f.StreamID, Offset: f.Offset, Length: f.DataLen()}, path)
 			}
+		case *wire.WindowUpdateFrame:
+			// only retransmit WindowUpdates if the stream is not yet closed and the we haven't sent another WindowUpdate with a higher ByteOffset for the stream
+			// XXX Should it be adapted to multiple paths?
+			currentOffset, err := s.flowControlManager.GetReceiveWindow(f.StreamID)
+			if err == nil && f.ByteOffset >= currentOffset {
+				s.packer.QueueControlFrame(f, path)
+			}
+		case *wire.PathsFrame:
+			// Schedule a new PATHS frame to send
+			s.schedulePathsFrame()
+		case *wire.TimestampFrame:
+			// a stale timestamp would distort the delay measured by the peer
+		default:
+			s.packer.QueueControlFrame(frame, path)
 		}
 	}
-	return
 }
-func printStreamInfo(stream *stream) {
-	utils.Infof("stream %d: size %d, priority %d\n", stream.streamID, stream.size, stream.priority)
+
+func printStreamInfo(s *session, stream *stream) {
+	s.logger.Infof("stream %d: size %d, priority %d\n", stream.streamID, stream.size, stream.priority)
 }
 func printAllPathsInfo(s *session) {
 	for pathID, pth := range s.paths {
-		utils.Infof("path %x: bandwidth %d Mbps, rtt %s\n", pathID, float64(pth.bdwStats.GetBandwidth()), pth.rttStats.SmoothedRTT())
+		s.logger.Infof("path %x: bandwidth %d Mbps, rtt %s\n", pathID, float64(pth.bdwStats.GetBandwidth()), pth.schedulingRTT())
 	}
 }
 
+//   snapshot of all paths and of the streams not yet assigned to a path, sorted by ID
+func (sch *scheduler) getSchedulingInputs(s *session) *SchedulingInputs {
+	inputs := &SchedulingInputs{Time: time.Now()}
+
+	for pathID, pth := range s.paths {
+		pathInputs := PathSchedulingInputs{
+			PathID:    pathID,
+			RTT:       pth.schedulingRTT(),
+			StreamIDs: append([]protocol.StreamID{}, pth.streamIDs...),
+		}
+		if pth.bdwStats != nil {
+			pathInputs.Bandwidth = pth.bdwStats.GetBandwidth()
+		}
+		inputs.Paths = append(inputs.Paths, pathInputs)
+	}
+	sort.Slice(inputs.Paths, func(i, j int) bool {
+		return inputs.Paths[i].PathID < inputs.Paths[j].PathID
+	})
+
+	s.streamsMap.mutex.RLock()
+	for _, streamID := range s.streamsMap.openStreams {
+		str, ok := s.streamsMap.streams[streamID]
+		if !ok || str == nil {
+			continue
+		}
+		if _, assigned := s.streamToPath[streamID]; assigned {
+			continue
+		}
+		inputs.Streams = append(inputs.Streams, StreamSchedulingInputs{
+			StreamID:  streamID,
+			Size:      str.size,
+			SizeKnown: str.checksize,
+			Priority:  *str.priority,
+		})
+	}
+	s.streamsMap.mutex.RUnlock()
+	sort.Slice(inputs.Streams, func(i, j int) bool {
+		return inputs.Streams[i].StreamID < inputs.Streams[j].StreamID
+	})
+
+	return inputs
+}
+
 //assign stream to path
 //TODO: if need change schedule results periodically, each time reset the map --stream.pathVolume
 func (sch *scheduler) scheduleToMultiplePaths(s *session) (bool, error) {
+	defer sch.saveState()
+	if s.config.DumpSchedulingInputs != nil {
+		s.config.DumpSchedulingInputs(sch.getSchedulingInputs(s))
+	}
+	// without multipath, the initial path stays the only one
+	if len(s.paths) == 1 && !s.MultipathEnabled() {
+		return sch.scheduleToSinglePath(s)
+	}
+	if s.config.InitialPathPolicy == InitialPathAckOnly && len(s.paths) > 1 {
+		sch.releaseInitialPathStreams(s)
+	}
+
 	assignPath := func(stream *stream) (bool, error) {
 
 		// only assign when the pathID of this stream is not assigned,
 		// we assume path won't fail after assignment of a stream
 		_, ok := s.streamToPath[stream.streamID]
+		if ok && stream.striped.Get() {
+			sch.restripe(s, stream)
+		}
 		if !ok {
+			//   no data can be sent until the connection window grows, don't assign new data streams meanwhile
+			if !s.streamsMap.isControlStream(stream.streamID) && s.flowControlManager.RemainingConnectionWindowSize() == 0 {
+				if s.logger.Debug() {
+					s.logger.Debugf("  connection-level blocked, stream %d not assigned", stream.streamID)
+				}
+				return true, nil
+			}
 			if s.perspective == protocol.PerspectiveClient {
-				//client side: assign all streams to lowest RTT path
-				pth := sch.findPathLowLatency(s)
+				//client side: assign all streams to lowest RTT path, or to the handshake path while the others are unprobed
+				pth := sch.findHandshakePathForEarlyData(s)
+				if pth == nil {
+					pth = sch.findPathLowLatency(s)
+				}
 				if pth == nil {
-					if utils.Debug() {
-						utils.Debugf("  fail to assign path to stream %d", stream.streamID)
+					if s.logger.Debug() {
+						s.logger.Debugf("  fail to assign path to stream %d", stream.streamID)
 					}
 					windowUpdateFrames := s.getWindowUpdateFrames(false)
 					return false, sch.ackRemainingPaths(s, windowUpdateFrames)
@@ -167,22 +400,22 @@ func (sch *scheduler) scheduleToMultiplePaths(s *session) (bool, error) {
 				s.streamToPath.Add(stream.streamID, pth.pathID)
 				stream.pathVolume[pth.pathID] = 0
 				pth.streamIDs = append(pth.streamIDs, stream.streamID)
-				if stream.streamID != 1 && stream.streamID != 3 {
-					sch.numstreams[pth.pathID]++ //update stream quota
+				if !s.streamsMap.isControlStream(stream.streamID) {
+					sch.onStreamAssigned(s, pth)
 				}
-				utils.Infof("ScheduleToMultiplePaths():\n")
-				printStreamInfo(stream)
+				s.logger.Infof("ScheduleToMultiplePaths():\n")
+				printStreamInfo(s, stream)
 				printAllPathsInfo(s)
-				utils.Infof("assigned to path %x\n", pth.pathID)
+				s.logger.Infof("assigned to path %x\n", pth.pathID)
 
 			} else if s.perspective == protocol.PerspectiveServer {
 				//server side
-				//1.assign crypto and header stream to lowest RTT path every time
-				if stream.streamID == 1 || stream.streamID == 3 {
+				//1.assign crypto, header and control streams to lowest RTT path every time
+				if s.streamsMap.isControlStream(stream.streamID) {
 					pth := sch.findPathLowLatency(s)
 					if pth == nil {
-						if utils.Debug() {
-							utils.Debugf("  fail to assign path to stream %d", stream.streamID)
+						if s.logger.Debug() {
+							s.logger.Debugf("  fail to assign path to stream %d", stream.streamID)
 						}
 						windowUpdateFrames := s.getWindowUpdateFrames(false)
 						return false, sch.ackRemainingPaths(s, windowUpdateFrames)
@@ -191,10 +424,10 @@ func (sch *scheduler) scheduleToMultiplePaths(s *session) (bool, error) {
 					stream.pathVolume[pth.pathID] = 0
 					pth.streamIDs = append(pth.streamIDs, stream.streamID)
 
-					utils.Infof("ScheduleToMultiplePaths():\n")
-					printStreamInfo(stream)
+					s.logger.Infof("ScheduleToMultiplePaths():\n")
+					printStreamInfo(s, stream)
 					printAllPathsInfo(s)
-					utils.Infof("assigned to path %x\n", pth.pathID)
+					s.logger.Infof("assigned to path %x\n", pth.pathID)
 
 				} else {
 					//2:  assign other streams according to their priority, path RTT and bandwidth
@@ -204,29 +437,35 @@ func (sch *scheduler) scheduleToMultiplePaths(s *session) (bool, error) {
 						return true, nil
 					}
 
-					selectedPths := sch.choosePaths(s, stream.streamID, stream.priority.Weight)
-					if len(selectedPths) == 0 {
-						if utils.Debug() {
-							utils.Debugf("  fail to assign path to stream %d", stream.streamID)
-						}
-						if stream.checksize == true {
-							// only assign path when the stream size is known
-							// return error under the condition that fail to assign with stream size detected
-							windowUpdateFrames := s.getWindowUpdateFrames(false)
-							return false, sch.ackRemainingPaths(s, windowUpdateFrames)
+					//   hold a dependent stream until its parent got a path
+					if sch.waitsForParent(s, stream) {
+						if s.logger.Debug() {
+							s.logger.Debugf("  stream %d waits for parent stream %d to be scheduled", stream.streamID, stream.priority.Dependency)
 						}
 						return true, nil
+					}
 
+					selectedPths, result := sch.choosePaths(s, stream.streamID, stream.priority.Weight)
+					switch result {
+					case pathsRetryLater:
+						// only assign path when the stream size is known
+						return true, nil
+					case pathsUnavailable:
+						if s.logger.Debug() {
+							s.logger.Debugf("  fail to assign path to stream %d", stream.streamID)
+						}
+						windowUpdateFrames := s.getWindowUpdateFrames(false)
+						return false, sch.ackRemainingPaths(s, windowUpdateFrames)
 					}
-					utils.Infof("ScheduleToMultiplePaths():\n")
-					printStreamInfo(stream)
+					s.logger.Infof("ScheduleToMultiplePaths():\n")
+					printStreamInfo(s, stream)
 					printAllPathsInfo(s)
 					for pth, vol := range selectedPths {
 						s.streamToPath.Add(stream.streamID, pth.pathID)
 						stream.pathVolume[pth.pathID] = vol
 						pth.streamIDs = append(pth.streamIDs, stream.streamID)
-						sch.numstreams[pth.pathID]++ //update stream quota
-						utils.Infof("assigned to path %x(%s RTT) with volume %f bytes\n", pth.pathID, pth.rttStats.SmoothedRTT(), vol)
+						sch.onStreamAssigned(s, pth)
+						s.logger.Infof("assigned to path %x(%s RTT) with volume %f bytes\n", pth.pathID, pth.schedulingRTT(), vol)
 
 					}
 
@@ -240,8 +479,8 @@ func (sch *scheduler) scheduleToMultiplePaths(s *session) (bool, error) {
 
 	ok := s.streamsMap.sortStreamPriorityOrder()
 	if !ok {
-		if utils.Debug() {
-			utils.Debugf("No new stream to be scheduled\n")
+		if s.logger.Debug() {
+			s.logger.Debugf("No new stream to be scheduled\n")
 		}
 		return true, nil
 	}
@@ -250,6 +489,88 @@ func (sch *scheduler) scheduleToMultiplePaths(s *session) (bool, error) {
 	return s.streamsMap.RoundRobinIterateSchedule(assignPath)
 }
 
+//   assign every new stream to the only path of the session with all its data,
+//   neither the stream size nor the path bandwidth matter for that
+func (sch *scheduler) scheduleToSinglePath(s *session) (bool, error) {
+	var pth *path
+	for _, p := range s.paths {
+		pth = p
+	}
+	err := s.streamsMap.Iterate(func(stream *stream) (bool, error) {
+		if _, ok := s.streamToPath[stream.streamID]; ok {
+			return true, nil
+		}
+		s.streamToPath.Add(stream.streamID, pth.pathID)
+		stream.pathVolume[pth.pathID] = math.Inf(1)
+		pth.streamIDs = append(pth.streamIDs, stream.streamID)
+		if !s.streamsMap.isControlStream(stream.streamID) {
+			sch.onStreamAssigned(s, pth)
+		}
+		return true, nil
+	})
+	return true, err
+}
+
+//   copy the counters and the reasons of the last selection for getState, only called by the run loop
+func (sch *scheduler) saveState() {
+	state := SchedulerState{
+		Quotas:      make(map[protocol.PathID]uint, len(sch.quotas)),
+		NumStreams:  make(map[protocol.PathID]uint, len(sch.numstreams)),
+		NotSelected: make(map[protocol.PathID]PathNotSelectedReason, len(sch.notSelected)),
+	}
+	for pathID, quota := range sch.quotas {
+		state.Quotas[pathID] = quota
+	}
+	for pathID, num := range sch.numstreams {
+		state.NumStreams[pathID] = num
+	}
+	for pathID, reason := range sch.getPathsNotSelected() {
+		state.NotSelected[pathID] = reason
+	}
+	sch.stateMutex.Lock()
+	sch.state = state
+	sch.stateMutex.Unlock()
+}
+
+//   the counters at the end of the last pass, safe to call from any goroutine
+func (sch *scheduler) getState() SchedulerState {
+	sch.stateMutex.RLock()
+	defer sch.stateMutex.RUnlock()
+	return sch.state
+}
+
+//   unassign the streams of the initial path, so that they are assigned to the other paths
+func (sch *scheduler) releaseInitialPathStreams(s *session) {
+	pth := s.paths[protocol.InitialPathID]
+	for _, streamID := range pth.streamIDs {
+		s.streamToPath.DeleteOne(streamID, protocol.InitialPathID)
+		if str, ok := s.streamsMap.streams[streamID]; ok {
+			delete(str.pathVolume, protocol.InitialPathID)
+		}
+		s.logger.Infof("Unassigned stream %d from the initial path", streamID)
+	}
+	pth.streamIDs = nil
+	delete(sch.numstreams, protocol.InitialPathID)
+}
+
+//   a stream depending on a stream that is still open and not assigned to any path must not be scheduled ahead of it
+//   the streams map is locked by the caller
+func (sch *scheduler) waitsForParent(s *session, stream *stream) bool {
+	if stream.priority == nil {
+		return false
+	}
+	parentID := stream.priority.Dependency
+	if parentID == 0 || parentID == stream.streamID || s.streamsMap.isControlStream(parentID) {
+		return false
+	}
+	parent, ok := s.streamsMap.streams[parentID]
+	if !ok || parent == nil || parent.finishedWriteAndSentFin() {
+		return false
+	}
+	_, scheduled := s.streamToPath[parentID]
+	return !scheduled
+}
+
 func (sch *scheduler) iteratePathRoundRobin(s *session) *path {
 	if sch.quotas == nil {
 		sch.quotas = make(map[protocol.PathID]uint)
@@ -296,6 +617,7 @@ func (sch *scheduler) iteratePathRoundRobin(s *session) *path {
 }
 
 func (sch *scheduler) selectPathRoundRobin(s *session, hasRetransmission bool, hasStreamRetransmission bool, fromPth *path) *path {
+	sch.resetNotSelected()
 	if sch.quotas == nil {
 		sch.quotas = make(map[protocol.PathID]uint)
 	}
@@ -319,17 +641,7 @@ func (sch *scheduler) selectPathRoundRobin(s *session, hasRetransmission bool, h
 pathLoop:
 	for pathID, pth := range s.paths {
 		// Don't block path usage if we retransmit, even on another path
-		if !hasRetransmission && !pth.SendingAllowed() {
-			continue pathLoop
-		}
-
-		// If this path is potentially failed, do no consider it for sending
-		if pth.potentiallyFailed.Get() {
-			continue pathLoop
-		}
-
-		// XXX Prevent using initial pathID if multiple paths
-		if pathID == protocol.InitialPathID {
+		if !sch.isPathAvailableFor(pathID, pth, hasRetransmission) {
 			continue pathLoop
 		}
 
@@ -350,6 +662,7 @@ pathLoop:
 }
 
 func (sch *scheduler) selectPathLowLatency(s *session, hasRetransmission bool, hasStreamRetransmission bool, fromPth *path) *path {
+	sch.resetNotSelected()
 	// XXX Avoid using PathID 0 if there is more than 1 path
 	if len(s.paths) <= 1 {
 		if !hasRetransmission && !s.paths[protocol.InitialPathID].SendingAllowed() {
@@ -359,11 +672,11 @@ func (sch *scheduler) selectPathLowLatency(s *session, hasRetransmission bool, h
 	}
 
 	// FIXME Only works at the beginning... Cope with new paths during the connection
-	if hasRetransmission && hasStreamRetransmission && fromPth.rttStats.SmoothedRTT() == 0 {
+	if hasRetransmission && hasStreamRetransmission && fromPth.schedulingRTT() == 0 {
 		// Is there any other path with a lower number of packet sent?
 		currentQuota := sch.quotas[fromPth.pathID]
 		for pathID, pth := range s.paths {
-			if pathID == protocol.InitialPathID || pathID == fromPth.pathID {
+			if sch.avoidsInitialPath(s, pathID) || pathID == fromPth.pathID {
 				continue
 			}
 			// The congestion window was checked when duplicating the packet
@@ -374,28 +687,26 @@ func (sch *scheduler) selectPathLowLatency(s *session, hasRetransmission bool, h
 	}
 
 	var selectedPath *path
+	var incumbentPath *path
 	var lowerRTT time.Duration
 	var currentRTT time.Duration
 	selectedPathID := protocol.PathID(255)
+	var candidatePaths []*path
 
 pathLoop:
 	for pathID, pth := range s.paths {
 		// Don't block path usage if we retransmit, even on another path
-		if !hasRetransmission && !pth.SendingAllowed() {
+		if !sch.isPathAvailableFor(pathID, pth, hasRetransmission) {
 			continue pathLoop
 		}
 
-		// If this path is potentially failed, do not consider it for sending
-		if pth.potentiallyFailed.Get() {
-			continue pathLoop
-		}
+		candidatePaths = append(candidatePaths, pth)
 
-		// XXX Prevent using initial pathID if multiple paths
-		if pathID == protocol.InitialPathID {
-			continue pathLoop
+		if pth == sch.preferredPath {
+			incumbentPath = pth
 		}
 
-		currentRTT = pth.rttStats.SmoothedRTT()
+		currentRTT = pth.schedulingRTT()
 
 		// Prefer staying single-path if not blocked by current path
 		// Don't consider this sample if the smoothed RTT is 0
@@ -426,42 +737,151 @@ pathLoop:
 		selectedPathID = pathID
 	}
 
+	selectedPath = sch.failoverPath(selectedPath, candidatePaths)
+	return sch.keepPreferredPath(s, selectedPath, incumbentPath)
+}
+
+//   when the preferred path failed, fail over to the lowest RTT path outside of its group if there is one,
+//       as the other paths of the group likely failed with it
+func (sch *scheduler) failoverPath(selectedPath *path, candidatePaths []*path) *path {
+	failed := sch.preferredPath
+	if selectedPath == nil || failed == nil || failed.group == "" || selectedPath.group != failed.group {
+		return selectedPath
+	}
+	if !failed.potentiallyFailed.Get() && !failed.connFailed.Get() {
+		return selectedPath
+	}
+	var otherPath *path
+	for _, pth := range candidatePaths {
+		if pth.group == failed.group {
+			continue
+		}
+		rtt := pth.schedulingRTT()
+		if otherPath == nil || (rtt != 0 && (otherPath.schedulingRTT() == 0 || rtt < otherPath.schedulingRTT())) {
+			otherPath = pth
+		}
+	}
+	if otherPath == nil {
+		return selectedPath
+	}
+	failed.sess.logger.Infof("Preferred path %x of group %s failed, failing over to path %x of group %s", failed.pathID, failed.group, otherPath.pathID, otherPath.group)
+	return otherPath
+}
+
+//   hysteresis on the low latency choice: stay on the previously preferred path unless the new candidate
+//       has a smoothed RTT lower by more than the configured switch margin
+func (sch *scheduler) keepPreferredPath(s *session, selectedPath *path, incumbentPath *path) *path {
+	if selectedPath == nil {
+		return nil
+	}
+
+	margin := s.config.PathSwitchMargin
+	if incumbentPath != nil && incumbentPath != selectedPath && margin > 0 {
+		incumbentRTT := incumbentPath.schedulingRTT()
+		selectedRTT := selectedPath.schedulingRTT()
+		if incumbentRTT != 0 && selectedRTT != 0 && float64(selectedRTT) > float64(incumbentRTT)*(1-margin) {
+			return incumbentPath
+		}
+	}
+
+	sch.preferredPath = selectedPath
 	return selectedPath
 }
 
+//   start a new selection pass, forgetting the reasons of the previous one
+func (sch *scheduler) resetNotSelected() {
+	sch.notSelected = make(map[protocol.PathID]PathNotSelectedReason)
+}
+
+func (sch *scheduler) setNotSelected(pathID protocol.PathID, reason PathNotSelectedReason) {
+	if sch.notSelected == nil {
+		sch.resetNotSelected()
+	}
+	sch.notSelected[pathID] = reason
+	if utils.Debug() {
+		utils.Debugf("  path %x not selected: %s", pathID, reason)
+	}
+}
+
+//   reasons why paths were not selected in the last pass of a path selection function
+func (sch *scheduler) getPathsNotSelected() map[protocol.PathID]PathNotSelectedReason {
+	return sch.notSelected
+}
+
+//   whether the selection functions skip the initial path, only called if multiple paths exist
+func (sch *scheduler) avoidsInitialPath(s *session, pathID protocol.PathID) bool {
+	return pathID == protocol.InitialPathID && s.config.InitialPathPolicy != InitialPathNormal
+}
+
+//   common filter of the selection functions, recording the reason if the path can't be used for sending
+func (sch *scheduler) isPathAvailable(pathID protocol.PathID, pth *path) bool {
+	return sch.isPathAvailableFor(pathID, pth, false)
+}
+
+//   same as isPathAvailable, but a congestion limited path stays available if there is a retransmission to send
+func (sch *scheduler) isPathAvailableFor(pathID protocol.PathID, pth *path, hasRetransmission bool) bool {
+	if !hasRetransmission && !pth.SendingAllowed() {
+		sch.setNotSelected(pathID, PathCongestionLimited)
+		return false
+	}
+
+	// If this path is potentially failed, do not consider it for sending
+	if pth.potentiallyFailed.Get() {
+		sch.setNotSelected(pathID, PathPotentiallyFailed)
+		return false
+	}
+
+	// Writing on this path failed, do not try it again
+	if pth.connFailed.Get() {
+		sch.setNotSelected(pathID, PathConnFailed)
+		return false
+	}
+
+	// XXX Prevent using initial pathID if multiple paths
+	if sch.avoidsInitialPath(pth.sess, pathID) {
+		sch.setNotSelected(pathID, PathInitialAvoided)
+		return false
+	}
+	return true
+}
+
+// reachedStreamCap says if the path can't take new streams because of Config.MaxStreamsPerPath
+func (sch *scheduler) reachedStreamCap(s *session, pathID protocol.PathID) bool {
+	return s.config.MaxStreamsPerPath > 0 && sch.numstreams[pathID] >= uint(s.config.MaxStreamsPerPath)
+}
+
 //   find the path with lowest latency ; if multiple path unprobed, find path with lowest quota
 func (sch *scheduler) findPathLowLatency(s *session) *path {
+	sch.resetNotSelected()
+
 	// XXX Avoid using PathID 0 if there is more than 1 path
 	if len(s.paths) <= 1 {
 		if !s.paths[protocol.InitialPathID].SendingAllowed() {
+			sch.setNotSelected(protocol.InitialPathID, PathCongestionLimited)
 			return nil
 		}
 		return s.paths[protocol.InitialPathID]
 	}
 
 	var selectedPath *path
+	var incumbentPath *path
 	var lowerRTT time.Duration
 	var currentRTT time.Duration
 	selectedPathID := protocol.PathID(255)
+	var candidatePaths []*path
 
 pathLoop:
 	for pathID, pth := range s.paths {
-
-		if !pth.SendingAllowed() {
+		if !sch.isPathAvailable(pathID, pth) {
 			continue pathLoop
 		}
+		candidatePaths = append(candidatePaths, pth)
 
-		// If this path is potentially failed, do not consider it for sending
-		if pth.potentiallyFailed.Get() {
-			continue pathLoop
+		if pth == sch.preferredPath {
+			incumbentPath = pth
 		}
 
-		// XXX Prevent using initial pathID if multiple paths
-		if pathID == protocol.InitialPathID {
-			continue pathLoop
-		}
-
-		currentRTT = pth.rttStats.SmoothedRTT()
+		currentRTT = pth.schedulingRTT()
 
 		// Prefer staying single-path if not blocked by current path
 		// Don't consider this sample if the smoothed RTT is 0
@@ -492,9 +912,38 @@ pathLoop:
 		selectedPathID = pathID
 	}
 
+	selectedPath = sch.failoverPath(selectedPath, candidatePaths)
+	selectedPath = sch.keepPreferredPath(s, selectedPath, incumbentPath)
+	for _, pth := range candidatePaths {
+		if pth != selectedPath {
+			sch.setNotSelected(pth.pathID, PathHigherRTT)
+		}
+	}
 	return selectedPath
 }
 
+//   until the other paths got an RTT sample, the handshake path is the only one with a validated RTT,
+//   prefer it to an unprobed path chosen by quota, unless it only carries ACKs
+func (sch *scheduler) findHandshakePathForEarlyData(s *session) *path {
+	if len(s.paths) <= 1 || s.config.InitialPathPolicy == InitialPathAckOnly {
+		return nil
+	}
+	pth, ok := s.paths[protocol.InitialPathID]
+	if !ok || pth.rttStats.NumSamples() == 0 {
+		return nil
+	}
+	for pathID, other := range s.paths {
+		if pathID != protocol.InitialPathID && other.rttStats.NumSamples() != 0 {
+			return nil
+		}
+	}
+	if !pth.SendingAllowed() || pth.potentiallyFailed.Get() || pth.connFailed.Get() {
+		return nil
+	}
+	s.logger.Infof("No other path probed yet, preferring the handshake path for early data")
+	return pth
+}
+
 //   return available path set
 func (sch *scheduler) checkPathQuota(s *session) map[protocol.PathID]*path {
 	if sch.numstreams == nil {
@@ -502,7 +951,6 @@ func (sch *scheduler) checkPathQuota(s *session) map[protocol.PathID]*path {
 	}
 
 	avalPath := make(map[protocol.PathID]*path)
-	var pathID protocol.PathID
 
 	// Max possible value for lowerQuota at the beginning
 	lowerQuota := ^uint(0)
@@ -515,31 +963,50 @@ func (sch *scheduler) checkPathQuota(s *session) map[protocol.PathID]*path {
 	}
 
 	for pthID, quota := range sch.numstreams {
-		if pthID == protocol.InitialPathID {
+		if sch.avoidsInitialPath(s, pthID) || sch.reachedStreamCap(s, pthID) {
 			continue
 		}
 		if quota < lowerQuota {
 			lowerQuota = quota
-			pathID = pthID
 		}
 	}
 
-	avalPath[pathID] = s.paths[pathID]
-
 	for pthID, quota := range sch.numstreams {
-		if pthID == protocol.InitialPathID {
+		if sch.avoidsInitialPath(s, pthID) || sch.reachedStreamCap(s, pthID) {
 			continue
 		}
 		if quota == lowerQuota {
 			avalPath[pthID] = s.paths[pthID]
 		}
 	}
-	avalPath[protocol.InitialPathID] = s.paths[protocol.InitialPathID]
+	// the selection records why the initial path is avoided, but if it is used like any other path, the cap applies to it too
+	if s.config.InitialPathPolicy != InitialPathNormal || !sch.reachedStreamCap(s, protocol.InitialPathID) {
+		avalPath[protocol.InitialPathID] = s.paths[protocol.InitialPathID]
+	}
 
 	return avalPath
 }
 
+//   a stream is scheduled by its size once it buffered Config.MinDetectedStreamSize, when no more data comes for now,
+//   or protocol.MaxStreamSizeDetectionDelay after its first Write
+func (sch *scheduler) sizeDetected(s *session, stream *stream) bool {
+	if stream.size >= protocol.ByteCount(s.config.MinDetectedStreamSize) || stream.finishedWriting.Get() || stream.flushing() {
+		return true
+	}
+	deadline := stream.getSizeDetectionDeadline()
+	if deadline.IsZero() {
+		return false
+	}
+	now := time.Now()
+	if !now.Before(deadline) {
+		return true
+	}
+	s.onSizeDetectionPending(deadline, now)
+	return false
+}
+
 func (sch *scheduler) choosePath(s *session, strID protocol.StreamID, priority uint8) *path {
+	sch.resetNotSelected()
 	// XXX Avoid using PathID 0 if there is more than 1 path
 	if len(s.paths) <= 1 {
 		if !s.paths[protocol.InitialPathID].SendingAllowed() {
@@ -552,16 +1019,19 @@ func (sch *scheduler) choosePath(s *session, strID protocol.StreamID, priority u
 	//  assign path only if the size of a flow is detected
 	if stream.checksize == false {
 		stream.size = stream.lenOfDataForWriting() //return Byte
-		if stream.size != 0 {
+		if stream.size != 0 && sch.sizeDetected(s, stream) {
 			stream.checksize = true
-			if utils.Debug() {
+			if s.logger.Debug() {
 				//TODO: Stream size limited with 32768 bytes
-				utils.Debugf("Detected: Stream %d with file size %d bytes\n", strID, stream.size)
+				s.logger.Debugf("Detected: Stream %d with file size %d bytes\n", strID, stream.size)
 			}
 
+		} else if stream.shouldSendFin() || s.streamFramer.hasFramesForRetransmissionOfStream(strID) {
+			//  an empty stream only has its FIN or retransmissions left to send, don't wait for a size that never comes
+			return sch.findPathLowLatency(s)
 		} else {
-			if utils.Debug() {
-				utils.Debugf("Not Detected: Stream %d not detected file size \n", strID)
+			if s.logger.Debug() {
+				s.logger.Debugf("Not Detected: Stream %d not detected file size \n", strID)
 			}
 			return nil //size value undetected, do not assign path
 
@@ -575,17 +1045,7 @@ func (sch *scheduler) choosePath(s *session, strID protocol.StreamID, priority u
 pathLoop:
 	for pathID, pth := range s.paths {
 
-		if !pth.SendingAllowed() {
-			continue pathLoop
-		}
-
-		// If this path is potentially failed, do not consider it for sending
-		if pth.potentiallyFailed.Get() {
-			continue pathLoop
-		}
-
-		// XXX Prevent using initial pathID if multiple paths
-		if pathID == protocol.InitialPathID {
+		if !sch.isPathAvailable(pathID, pth) {
 			continue pathLoop
 		}
 
@@ -593,8 +1053,8 @@ pathLoop:
 
 		prioritySum := float32(0)
 		for _, sid := range pth.streamIDs {
-			//    we ignore stream 1 and 3 as they are treated with absolute priority
-			if sid == 1 || sid == 3 {
+			//    we ignore stream 1, 3 and control streams as they are treated with absolute priority
+			if s.streamsMap.isControlStream(sid) {
 				continue
 			}
 			str := s.streamsMap.streams[sid]
@@ -604,11 +1064,11 @@ pathLoop:
 
 		bandwidthShare := (float64(priority) / (float64(priority) + float64(prioritySum))) * float64(pth.bdwStats.GetBandwidth())
 		//size: Byte
-		currentTime = (float64(stream.size)*8)/(bandwidthShare*1048576) + (pth.rttStats.SmoothedRTT().Seconds() / 2)
+		currentTime = (float64(stream.size)*8)/(bandwidthShare*1048576) + (pth.schedulingRTT().Seconds() / 2)
 		//bandwidthShare: Mbps, rtt: ms
 
-		utils.Infof("path %d, rtt %s ms,fullbandwidth %d Mbps, prioritySum %f", pth.pathID, pth.rttStats.SmoothedRTT().String(), pth.bdwStats.GetBandwidth(), prioritySum)
-		utils.Infof("stream %d, priority %d, size %d Byte, bandwidthshare %f Mbps, estimated time %f ", strID, priority, stream.size, bandwidthShare, currentTime)
+		s.logger.Infof("path %d, rtt %s ms,fullbandwidth %d Mbps, prioritySum %f", pth.pathID, pth.schedulingRTT().String(), pth.bdwStats.GetBandwidth(), prioritySum)
+		s.logger.Infof("stream %d, priority %d, size %d Byte, bandwidthshare %f Mbps, estimated time %f ", strID, priority, stream.size, bandwidthShare, currentTime)
 
 		if currentTime != 0 && lowerTime != 0 && selectedPath != nil && currentTime >= lowerTime {
 			continue pathLoop
@@ -622,24 +1082,105 @@ pathLoop:
 	return selectedPath
 }
 
+// warmUpFactor is the confidence in the RTT of the path, growing with its number of RTT samples
+func warmUpFactor(pth *path) float64 {
+	samples := pth.rttStats.NumSamples()
+	if samples > protocol.PathWarmUpRTTSamples {
+		samples = protocol.PathWarmUpRTTSamples
+	}
+	return float64(samples+1) / float64(protocol.PathWarmUpRTTSamples+1)
+}
+
+// applyWarmUp scales the bandwidth of the paths by the confidence in their RTT, relative to the most confident path.
+// Paths that are equally confident keep their share.
+func (sch *scheduler) applyWarmUp(paths []*path, pathsBdw map[protocol.PathID]float64) {
+	maxFactor := float64(0)
+	for _, pth := range paths {
+		maxFactor = math.Max(maxFactor, warmUpFactor(pth))
+	}
+	for _, pth := range paths {
+		pathsBdw[pth.pathID] *= warmUpFactor(pth) / maxFactor
+	}
+}
+
+// applyRecoveryDiscount reduces the bandwidth of the paths in loss recovery, as their congestion window was just reduced
+// and they can't take their steady-state share of a stream
+func (sch *scheduler) applyRecoveryDiscount(paths []*path, pathsBdw map[protocol.PathID]float64) {
+	for _, pth := range paths {
+		if pth.sentPacketHandler.InRecovery() {
+			pth.sess.logger.Infof("path %d in loss recovery, its bandwidth is discounted\n", pth.pathID)
+			pathsBdw[pth.pathID] *= protocol.RecoveryBandwidthFactor
+		}
+	}
+}
+
+// applyGroupBandwidthCap scales down the bandwidths of the paths of a group sharing a bottleneck, see Config.PathGroupBandwidth,
+// such that the paths of the group together don't get more than the bottleneck, instead of the sum of their estimates
+func (sch *scheduler) applyGroupBandwidthCap(s *session, paths []*path, pathsBdw map[protocol.PathID]float64) {
+	if s.config.PathGroupBandwidth == nil {
+		return
+	}
+	groupBdw := make(map[string]float64)
+	for _, pth := range paths {
+		if pth.group != "" {
+			groupBdw[pth.group] += float64(pth.bdwStats.GetBandwidthBps())
+		}
+	}
+	for group, bdw := range groupBdw {
+		limit := float64(s.config.PathGroupBandwidth(group)) * 8 // bit
+		if limit == 0 || bdw <= limit {
+			continue
+		}
+		s.logger.Infof("paths of group %s estimate %f Mbps, capped to their shared %f Mbps\n", group, bdw/1048576, limit/1048576)
+		for _, pth := range paths {
+			if pth.group == group {
+				pathsBdw[pth.pathID] *= limit / bdw
+			}
+		}
+	}
+}
+
+// choosePathsResult tells the caller of choosePaths whether the stream can be assigned later on
+type choosePathsResult uint8
+
+const (
+	// pathsChosen means that at least one path was selected
+	pathsChosen choosePathsResult = iota
+	// pathsRetryLater means that the size of the stream is not known yet, the stream is assigned in a later pass
+	pathsRetryLater
+	// pathsUnavailable means that no path can take the stream
+	pathsUnavailable
+)
+
 //choosePaths chooses paths for normal streams, and assign certain amount of data (/byte) to be transmitted on each path
-func (sch *scheduler) choosePaths(s *session, strID protocol.StreamID, priority uint8) (selectedPaths map[*path]float64) {
+func (sch *scheduler) choosePaths(s *session, strID protocol.StreamID, priority uint8) (map[*path]float64, choosePathsResult) {
+	sch.resetNotSelected()
 
 	stream := s.streamsMap.streams[strID]
 
-	//  assign path only if the size of a flow is detected
-	if stream.checksize == false {
+	//  assign path only if the size of a flow is detected, striped streams don't wait for it
+	striped := stream.striped.Get()
+	if stream.checksize == false && !striped {
 		stream.size = stream.lenOfDataForWriting() //return Byte
-		if stream.size != 0 {
+		if stream.size != 0 && sch.sizeDetected(s, stream) {
 			stream.checksize = true
 
 			//TODO: Stream size limited with 32768 bytes
-			utils.Infof("Detected: Stream %d with file size %d bytes\n", strID, stream.size)
-
+			s.logger.Infof("Detected: Stream %d with file size %d bytes\n", strID, stream.size)
+
+		} else if stream.shouldSendFin() || s.streamFramer.hasFramesForRetransmissionOfStream(strID) {
+			//  an empty stream only has its FIN left to send, or a rescheduled stream only retransmissions,
+			//  don't wait for a size that never comes
+			s.logger.Infof("Stream %d only has a FIN or retransmissions to send\n", strID)
+			pth := sch.findPathLowLatency(s)
+			if pth == nil {
+				return nil, pathsUnavailable
+			}
+			return map[*path]float64{pth: 0}, pathsChosen
 		} else {
-			utils.Infof("Not Detected: Stream %d not detected file size \n", strID)
+			s.logger.Infof("Not Detected: Stream %d not detected file size \n", strID)
 
-			return nil //size value undetected, do not assign path
+			return nil, pathsRetryLater //size value undetected, do not assign path
 
 		}
 	}
@@ -647,7 +1188,7 @@ func (sch *scheduler) choosePaths(s *session, strID protocol.StreamID, priority
 	// var currentTime float64 // second
 	var avalPaths []*path
 	var sortedPathsBdw []protocol.PathID // maps are unordered, thus use array
-	selectedPaths = make(map[*path]float64)
+	selectedPaths := make(map[*path]float64)
 	pathsOwd := make(map[protocol.PathID]float64)
 	pathsBdw := make(map[protocol.PathID]float64)
 	pathsVolume := make(map[protocol.PathID]float64)
@@ -657,39 +1198,63 @@ func (sch *scheduler) choosePaths(s *session, strID protocol.StreamID, priority
 	// XXX Avoid using PathID 0 if there is more than 1 path
 	if len(s.paths) <= 1 {
 		if !s.paths[protocol.InitialPathID].SendingAllowed() {
-			return nil
+			sch.setNotSelected(protocol.InitialPathID, PathCongestionLimited)
+			return nil, pathsUnavailable
 		}
 		selectedPaths[s.paths[protocol.InitialPathID]] = float64(stream.size) // assign all data of the stream onto the only path
-		return selectedPaths
+		return selectedPaths, pathsChosen
+	}
+
+	// data beyond the send window would stall at the receiver, only assign what it can take now
+	stream.windowLimited = false
+	if !striped {
+		if sendWindow, err := s.flowControlManager.SendWindowSize(strID); err == nil && sendWindow < stream.size {
+			s.logger.Infof("Stream %d limited by its send window of %d bytes\n", strID, sendWindow)
+			volume = float64(sendWindow) * 8
+			stream.windowLimited = true
+		}
 	}
 
 	//filter unavailable paths
 pathLoop:
 	for pathID, pth := range s.paths {
-
-		if !pth.SendingAllowed() {
+		if !sch.isPathAvailable(pathID, pth) {
 			continue pathLoop
 		}
-
-		// If this path is potentially failed, do not consider it for sending
-		if pth.potentiallyFailed.Get() {
+		if sch.reachedStreamCap(s, pathID) {
+			sch.setNotSelected(pathID, PathStreamCapReached)
 			continue pathLoop
 		}
+		avalPaths = append(avalPaths, pth)
+	}
+	// with the cost aware path scheduler, the high cost paths only take the data the low cost paths can't carry
+	var lowCostPaths []*path
+	var highCostOverflow float64
+	if sch.costAware {
+		dataLen := volume / 8
+		if striped {
+			dataLen = float64(stream.lenOfDataForWriting())
+		}
+		avalPaths, lowCostPaths, highCostOverflow = sch.filterHighCostPaths(avalPaths, dataLen)
+	}
 
-		// XXX Prevent using initial pathID if multiple paths
-		if pathID == protocol.InitialPathID {
-			continue pathLoop
+	// the one-way delays measured with timestamps can only be compared to each other
+	useTimestamps := true
+	for _, pth := range avalPaths {
+		if !pth.hasReverseDelay {
+			useTimestamps = false
+			break
 		}
-		avalPaths = append(avalPaths, pth)
 	}
 
 	for _, pth := range avalPaths {
 
 		//----------- priority sum of already scheduled stream on this path ------
 		prioritySum := float32(0)
+		highPrioritySum := float32(0)
 		for _, sid := range pth.streamIDs {
-			//    we ignore stream 1 and 3 as they are treated with absolute priority
-			if sid == 1 || sid == 3 {
+			//    we ignore stream 1, 3 and control streams as they are treated with absolute priority
+			if s.streamsMap.isControlStream(sid) {
 				continue
 			}
 
@@ -697,20 +1262,41 @@ pathLoop:
 
 			str := s.streamsMap.streams[sid]
 			prioritySum += float32(str.priority.Weight)
+			if str.priority.Weight >= s.config.HighPriorityWeight {
+				highPrioritySum += float32(str.priority.Weight)
+			}
 
 		}
 
-		pathsBdw[pth.pathID] = (float64(priority) / (float64(priority) + float64(prioritySum))) * float64(pth.bdwStats.GetBandwidth()) * 1048576 //bit
+		pathsBdw[pth.pathID] = sch.bandwidthShare(s, float64(pth.bdwStats.GetBandwidth())*1048576, priority, prioritySum, highPrioritySum) //bit
 		//------------------
 		//pathsBdw[pth.pathID] =  float64(pth.bdwStats.GetBandwidth() * 1048576) //bit
 
-		pathsOwd[pth.pathID] = float64(pth.rttStats.SmoothedRTT().Seconds() / 2) //second
+		pathsOwd[pth.pathID] = pth.oneWayDelay(useTimestamps).Seconds() //second
+		// a rescheduled stream sticks to its previous paths, such that it doesn't move back and forth between similar paths
+		if stream.previousPaths[pth.pathID] && s.config.PathSwitchMargin > 0 {
+			pathsOwd[pth.pathID] *= 1 - s.config.PathSwitchMargin
+		}
 		pathsVolume[pth.pathID] = 0
 
-		utils.Infof("path %d, shared bandwidth %f Mbps of stream %d, owd %f s\n", pth.pathID, pathsBdw[pth.pathID]/1048576, strID, pathsOwd[pth.pathID])
+		s.logger.Infof("path %d, shared bandwidth %f Mbps of stream %d, owd %f s\n", pth.pathID, pathsBdw[pth.pathID]/1048576, strID, pathsOwd[pth.pathID])
 
 	}
 
+	sch.applyGroupBandwidthCap(s, avalPaths, pathsBdw)
+	// the RTT of a path with only a few samples may be an outlier, don't trust it as much as the others yet
+	sch.applyWarmUp(avalPaths, pathsBdw)
+	sch.applyRecoveryDiscount(avalPaths, pathsBdw)
+
+	if striped {
+		if len(avalPaths) == 0 {
+			return nil, pathsUnavailable
+		}
+		selectedPaths = sch.stripeOnPaths(float64(stream.lenOfDataForWriting()), avalPaths, pathsBdw)
+		sch.limitHighCostVolume(selectedPaths, lowCostPaths, highCostOverflow, pathsBdw)
+		return selectedPaths, pathsChosen
+	}
+
 	var orders []pathOrder
 	for pid, owd := range pathsOwd {
 		orders = append(orders, pathOrder{pid, owd})
@@ -719,20 +1305,49 @@ pathLoop:
 	sort.Slice(orders, func(i, j int) bool {
 		return orders[i].Value < orders[j].Value
 	})
-	if utils.Debug() {
-		utils.Debugf("----- Step 1: ----- ")
-		utils.Debugf("sort paths by ascending order of one-way delay\n")
+
+	// too few usable paths to split the stream, send all of it on the path with the lowest one-way delay
+	if len(orders) > 0 && len(avalPaths) < s.config.MinPathsForSplit {
+		for _, order := range orders[1:] {
+			sch.setNotSelected(order.Key, PathHigherRTT)
+		}
+		selectedPaths[s.paths[orders[0].Key]] = volume / 8
+		sch.limitHighCostVolume(selectedPaths, lowCostPaths, highCostOverflow, pathsBdw)
+		if s.logger.Debug() {
+			s.logger.Debugf("%d usable paths, less than %d needed to split stream %d\n", len(avalPaths), s.config.MinPathsForSplit, strID)
+		}
+		return selectedPaths, pathsChosen
+	}
+
+	// keep the paths with the lowest one-way delays, the stream doesn't get any volume on the others
+	if s.config.MaxPathsPerStream > 0 && len(orders) > s.config.MaxPathsPerStream {
+		for _, order := range orders[s.config.MaxPathsPerStream:] {
+			sch.setNotSelected(order.Key, PathHigherRTT)
+			delete(pathsBdw, order.Key)
+			delete(pathsOwd, order.Key)
+			delete(pathsVolume, order.Key)
+		}
+		orders = orders[:s.config.MaxPathsPerStream]
+		avalPaths = avalPaths[:0]
+		for _, order := range orders {
+			avalPaths = append(avalPaths, s.paths[order.Key])
+		}
+	}
+
+	if s.logger.Debug() {
+		s.logger.Debugf("----- Step 1: ----- ")
+		s.logger.Debugf("sort paths by ascending order of one-way delay\n")
 	}
 	for _, order := range orders {
 		sortedPathsBdw = append(sortedPathsBdw, order.Key)
-		if utils.Debug() {
-			utils.Debugf("order.Key: %d, order.Value: %f\n", order.Key, order.Value)
+		if s.logger.Debug() {
+			s.logger.Debugf("order.Key: %d, order.Value: %f\n", order.Key, order.Value)
 		}
 	}
 
-	if utils.Debug() {
-		utils.Debugf("----- Step 2: ----- ")
-		utils.Debugf("close the gap between paths\n")
+	if s.logger.Debug() {
+		s.logger.Debugf("----- Step 2: ----- ")
+		s.logger.Debugf("close the gap between paths\n")
 	}
 	length := len(avalPaths)
 	for i := 0; i < length-1; i++ {
@@ -749,9 +1364,9 @@ pathLoop:
 
 		owdGap := pathsOwd[pathB] - pathsOwd[pathA]
 		if owdGap != 0 {
-			if utils.Debug() {
-				utils.Debugf("----- Step 2: ----- ")
-				utils.Debugf("Close the gap between Path %d and Path %d\n", pathA, pathB)
+			if s.logger.Debug() {
+				s.logger.Debugf("----- Step 2: ----- ")
+				s.logger.Debugf("Close the gap between Path %d and Path %d\n", pathA, pathB)
 			}
 			gap := float64(owdGap * bdwSum)
 			k = i
@@ -763,14 +1378,14 @@ pathLoop:
 					if volume <= 0 {
 						for k, v := range pathsVolume {
 							time := v/float64(pathsBdw[k]) + float64(pathsOwd[k])
-							if utils.Debug() {
-								utils.Debugf("----- Step 2: ----- ")
-								utils.Debugf("Path: %d, bandwidth %f bps, volume %f bits, time %f s\n", k, pathsBdw[k], v, time)
+							if s.logger.Debug() {
+								s.logger.Debugf("----- Step 2: ----- ")
+								s.logger.Debugf("Path: %d, bandwidth %f bps, volume %f bits, time %f s\n", k, pathsBdw[k], v, time)
 							}
 						}
-						if utils.Debug() {
-							utils.Debugf("----- Step 2: ----- ")
-							utils.Debugf("no volume left\n")
+						if s.logger.Debug() {
+							s.logger.Debugf("----- Step 2: ----- ")
+							s.logger.Debugf("no volume left\n")
 						}
 						break
 					}
@@ -788,14 +1403,14 @@ pathLoop:
 				if volume <= 0 {
 					for k, v := range pathsVolume {
 						time := v/float64(pathsBdw[k]) + float64(pathsOwd[k])
-						if utils.Debug() {
-							utils.Debugf("----- Step 2: ----- ")
-							utils.Debugf("Path: %d, bandwidth %f bps, volume %f bits, time %f s\n", k, pathsBdw[k], v, time)
+						if s.logger.Debug() {
+							s.logger.Debugf("----- Step 2: ----- ")
+							s.logger.Debugf("Path: %d, bandwidth %f bps, volume %f bits, time %f s\n", k, pathsBdw[k], v, time)
 						}
 					}
-					if utils.Debug() {
-						utils.Debugf("----- Step 2: ----- ")
-						utils.Debugf("no volume left\n")
+					if s.logger.Debug() {
+						s.logger.Debugf("----- Step 2: ----- ")
+						s.logger.Debugf("no volume left\n")
 					}
 					break
 				}
@@ -804,9 +1419,9 @@ pathLoop:
 
 			for k, v := range pathsVolume {
 				time := v/float64(pathsBdw[k]) + float64(pathsOwd[k])
-				if utils.Debug() {
-					utils.Debugf("----- Step 2: ----- ")
-					utils.Debugf("Path: %d, bandwidth %f bps, volume %f bits, time %f s\n", k, pathsBdw[k], v, time)
+				if s.logger.Debug() {
+					s.logger.Debugf("----- Step 2: ----- ")
+					s.logger.Debugf("Path: %d, bandwidth %f bps, volume %f bits, time %f s\n", k, pathsBdw[k], v, time)
 				}
 			}
 		} else {
@@ -816,12 +1431,12 @@ pathLoop:
 
 	//Step 3: distribute proportionally according to bandwidth
 	if volume > 0 {
-		if utils.Debug() {
-			utils.Debugf("----- Step 3: ----- ")
-			utils.Debugf("The rest volume %f bits\n", volume)
-			utils.Debugf("----- Step 3: ----- ")
+		if s.logger.Debug() {
+			s.logger.Debugf("----- Step 3: ----- ")
+			s.logger.Debugf("The rest volume %f bits\n", volume)
+			s.logger.Debugf("----- Step 3: ----- ")
 
-			utils.Debugf("distribute proportionally according to bandwidth\n\n")
+			s.logger.Debugf("distribute proportionally according to bandwidth\n\n")
 		}
 		all := float64(0)
 		for _, v := range pathsBdw {
@@ -834,27 +1449,185 @@ pathLoop:
 		}
 
 	}
-	if utils.Debug() {
-		utils.Debugf("----- Step 3: ----- ")
-		utils.Debugf("Final assignment result:\n")
+	if s.logger.Debug() {
+		s.logger.Debugf("----- Step 3: ----- ")
+		s.logger.Debugf("Final assignment result:\n")
 	}
 	for k, v := range pathsVolume {
 		time := v/float64(pathsBdw[k]) + float64(pathsOwd[k])
-		if utils.Debug() {
-			utils.Debugf("Path: %d, volume %f bits, time %f s\n", k, v, time)
+		if s.logger.Debug() {
+			s.logger.Debugf("Path: %d, volume %f bits, time %f s\n", k, v, time)
 		}
 		if v > 0 {
 			selectedPaths[s.paths[k]] = v / 8
+		} else {
+			// the gap to the lower delay paths was not closed
+			sch.setNotSelected(k, PathHigherRTT)
 		}
 
 	}
 
+	if len(selectedPaths) == 0 {
+		return nil, pathsUnavailable
+	}
+	sch.limitHighCostVolume(selectedPaths, lowCostPaths, highCostOverflow, pathsBdw)
+	return selectedPaths, pathsChosen
+}
+
+//   unassign a stream whose volume was capped by its send window, so that the next pass assigns the remainder
+func (sch *scheduler) releaseWindowLimitedStream(s *session, str *stream) {
+	if !str.windowLimited {
+		return
+	}
+	for pathID := range str.pathVolume {
+		s.streamToPath.DeleteOne(str.streamID, pathID)
+		pth, ok := s.paths[pathID]
+		if !ok {
+			continue
+		}
+		for i, sid := range pth.streamIDs {
+			if sid == str.streamID {
+				pth.streamIDs = append(pth.streamIDs[:i], pth.streamIDs[i+1:]...)
+				break
+			}
+		}
+		if sch.numstreams[pathID] > 0 {
+			sch.numstreams[pathID]--
+		}
+	}
+	str.previousPaths = make(map[protocol.PathID]bool, len(str.pathVolume))
+	for pathID := range str.pathVolume {
+		str.previousPaths[pathID] = true
+	}
+	str.pathVolume = make(map[protocol.PathID]float64)
+	str.checksize = false
+	str.windowLimited = false
+	s.logger.Infof("Unassigned stream %d after its send window grew", str.streamID)
+}
+
+//   share of the bandwidth of a path for a stream, given the weights of the streams already on the path
+//   with a reserved bandwidth, a high priority stream gets at least its share of the reserved part,
+//   and a low priority stream only competes with the other low priority streams for the rest
+func (sch *scheduler) bandwidthShare(s *session, bdw float64, priority uint8, prioritySum float32, highPrioritySum float32) float64 {
+	share := (float64(priority) / (float64(priority) + float64(prioritySum))) * bdw
+	reserved := s.config.ReservedBandwidth
+	if reserved <= 0 {
+		return share
+	}
+	if priority >= s.config.HighPriorityWeight {
+		return math.Max(share, reserved*bdw*float64(priority)/(float64(priority)+float64(highPrioritySum)))
+	}
+	lowPrioritySum := prioritySum - highPrioritySum
+	return math.Min(share, (1-reserved)*bdw*float64(priority)/(float64(priority)+float64(lowPrioritySum)))
+}
+
+//   split the buffered data of a striped stream across all paths, proportionally to their bandwidth
+func (sch *scheduler) stripeOnPaths(volume float64, paths []*path, pathsBdw map[protocol.PathID]float64) map[*path]float64 {
+	selectedPaths := make(map[*path]float64)
+	all := float64(0)
+	for _, pth := range paths {
+		all += pathsBdw[pth.pathID]
+	}
+	for _, pth := range paths {
+		if all > 0 {
+			selectedPaths[pth] = volume * pathsBdw[pth.pathID] / all
+		} else {
+			// no bandwidth estimates yet
+			selectedPaths[pth] = volume / float64(len(paths))
+		}
+	}
 	return selectedPaths
 }
 
+//   a striped stream only sends on its paths within their volumes, split the data written beyond them on these paths
+func (sch *scheduler) restripe(s *session, stream *stream) {
+	var paths []*path
+	pathsBdw := make(map[protocol.PathID]float64)
+	var scheduled float64
+	for pathID, vol := range stream.pathVolume {
+		pth, ok := s.paths[pathID]
+		if !ok {
+			continue
+		}
+		paths = append(paths, pth)
+		pathsBdw[pathID] = float64(pth.bdwStats.GetBandwidth())
+		scheduled += math.Max(vol, 0)
+	}
+	unscheduled := float64(stream.lenOfDataForWriting()) - scheduled
+	if len(paths) == 0 || unscheduled <= 0 {
+		return
+	}
+	for pth, vol := range sch.stripeOnPaths(unscheduled, paths, pathsBdw) {
+		stream.pathVolume[pth.pathID] = math.Max(stream.pathVolume[pth.pathID], 0) + vol
+	}
+	s.logger.Infof("Split %f bytes written to striped stream %d on its %d paths\n", unscheduled, stream.streamID, len(paths))
+}
+
+//   the low cost paths carry as much of the dataLen bytes as fits in their available congestion windows,
+//   the high cost paths are only kept for the overflow beyond that, in bytes
+func (sch *scheduler) filterHighCostPaths(paths []*path, dataLen float64) (filtered []*path, lowCostPaths []*path, overflow float64) {
+	var available float64
+	for _, pth := range paths {
+		if pth.cost == PathCostLow {
+			lowCostPaths = append(lowCostPaths, pth)
+			window := pth.sentPacketHandler.GetCongestionWindow() - pth.sentPacketHandler.GetBytesInFlight()
+			available += math.Max(float64(window), 0)
+		}
+	}
+	if len(lowCostPaths) == 0 || len(lowCostPaths) == len(paths) {
+		return paths, nil, 0
+	}
+	if dataLen > available {
+		return paths, lowCostPaths, dataLen - available
+	}
+	for _, pth := range paths {
+		if pth.cost != PathCostLow {
+			sch.setNotSelected(pth.pathID, PathHighCost)
+		}
+	}
+	return lowCostPaths, nil, 0
+}
+
+//   cap the volume of the high cost paths to the overflow of the low cost paths, the low cost paths get the rest
+func (sch *scheduler) limitHighCostVolume(selected map[*path]float64, lowCostPaths []*path, overflow float64, pathsBdw map[protocol.PathID]float64) {
+	if len(lowCostPaths) == 0 {
+		return
+	}
+	var highCostVolume float64
+	for pth, vol := range selected {
+		if pth.cost != PathCostLow {
+			highCostVolume += vol
+		}
+	}
+	if highCostVolume <= overflow {
+		return
+	}
+	for pth, vol := range selected {
+		if pth.cost != PathCostLow {
+			selected[pth] = vol * overflow / highCostVolume
+		}
+	}
+	excess := highCostVolume - overflow
+	var lowCostBdw float64
+	for _, pth := range lowCostPaths {
+		lowCostBdw += pathsBdw[pth.pathID]
+	}
+	for _, pth := range lowCostPaths {
+		share := excess / float64(len(lowCostPaths))
+		if lowCostBdw > 0 {
+			share = excess * pathsBdw[pth.pathID] / lowCostBdw
+		}
+		if share > 0 {
+			selected[pth] += share
+			delete(sch.notSelected, pth.pathID)
+		}
+	}
+}
+
 //   find path for stream according to priority : highest priority to smallest rtt path, second high priority to second small rtt path(controlled by numstreams per path)
 //      numstream per path round robin > path rtt > numpacket per path round robin
 func (sch *scheduler) findPath(s *session, strID protocol.StreamID, priority uint8) *path {
+	sch.resetNotSelected()
 	// XXX Avoid using PathID 0 if there is more than 1 path
 	if len(s.paths) <= 1 {
 		if !s.paths[protocol.InitialPathID].SendingAllowed() {
@@ -874,21 +1647,11 @@ func (sch *scheduler) findPath(s *session, strID protocol.StreamID, priority uin
 pathLoop:
 	for pathID, pth := range avalPath {
 
-		if !pth.SendingAllowed() {
+		if !sch.isPathAvailable(pathID, pth) {
 			continue pathLoop
 		}
 
-		// If this path is potentially failed, do not consider it for sending
-		if pth.potentiallyFailed.Get() {
-			continue pathLoop
-		}
-
-		// XXX Prevent using initial pathID if multiple paths
-		if pathID == protocol.InitialPathID {
-			continue pathLoop
-		}
-
-		currentRTT = pth.rttStats.SmoothedRTT()
+		currentRTT = pth.schedulingRTT()
 
 		// Prefer staying single-path if not blocked by current path
 		// Don't consider this sample if the smoothed RTT is 0
@@ -930,12 +1693,54 @@ func (sch *scheduler) selectPath(s *session, hasRetransmission bool, hasStreamRe
 	// return sch.selectPathRoundRobin(s, hasRetransmission, hasStreamRetransmission, fromPth)
 }
 
+// maybeQueueTimestampFrame queues a TIMESTAMP frame on the path about once per RTT, if enabled.
+// The packer only sends it along with other retransmittable frames.
+func (sch *scheduler) maybeQueueTimestampFrame(s *session, pth *path) {
+	if !s.config.SendTimestamps {
+		return
+	}
+	if !pth.lastTimestampSent.IsZero() && time.Since(pth.lastTimestampSent) < pth.rttStats.SmoothedRTT() {
+		return
+	}
+	s.packer.QueueControlFrame(&wire.TimestampFrame{}, pth)
+}
+
+// maybeQueueAckFrequencyFrame asks the peer to ack the packets of the path less often when its congestion window grows,
+// or more often when it shrinks, at most once per RTT, if enabled
+func (sch *scheduler) maybeQueueAckFrequencyFrame(s *session, pth *path) {
+	if s.config.MaxAckPacketTolerance == 0 {
+		return
+	}
+	now := time.Now()
+	if !pth.lastAckFrequencySent.IsZero() && now.Sub(pth.lastAckFrequencySent) < pth.rttStats.SmoothedRTT() {
+		return
+	}
+	current := pth.ackPacketTolerance
+	if current == 0 {
+		current = protocol.RetransmittablePacketsBeforeAck
+	}
+	tolerance := pth.desiredAckPacketTolerance(s.config.MaxAckPacketTolerance)
+	if tolerance == current {
+		return
+	}
+	pth.lastAckFrequencySent = now
+	pth.ackPacketTolerance = tolerance
+	pth.ackFrequencySent++
+	s.packer.QueueControlFrame(&wire.AckFrequencyFrame{
+		SequenceNumber:  pth.ackFrequencySent,
+		PacketTolerance: tolerance,
+		MaxAckDelay:     protocol.AckSendDelay,
+	}, pth)
+}
+
 // Lock of s.paths must be free (in case of log print)
 func (sch *scheduler) performPacketSending(s *session, windowUpdateFrames []*wire.WindowUpdateFrame, pth *path) (*ackhandler.Packet, bool, error) {
 	// add a retransmittable frame
 	if pth.sentPacketHandler.ShouldSendRetransmittablePacket() {
 		s.packer.QueueControlFrame(&wire.PingFrame{}, pth)
 	}
+	sch.maybeQueueTimestampFrame(s, pth)
+	sch.maybeQueueAckFrequencyFrame(s, pth)
 	packet, err := s.packer.PackPacketOfPath(pth)
 	if err != nil || packet == nil {
 
@@ -945,9 +1750,11 @@ func (sch *scheduler) performPacketSending(s *session, windowUpdateFrames []*wir
 		return nil, false, err
 	}
 
-	// send every window update twice
-	for _, f := range windowUpdateFrames {
-		s.packer.QueueControlFrame(f, pth)
+	// send every window update twice, unless configured otherwise
+	if !s.config.SendWindowUpdatesOnce {
+		for _, f := range windowUpdateFrames {
+			s.packer.QueueControlFrame(f, pth)
+		}
 	}
 
 	// Packet sent, so update its quota
@@ -960,11 +1767,11 @@ func (sch *scheduler) performPacketSending(s *session, windowUpdateFrames []*wir
 			if frame.FinBit {
 				// Last packet to send on the stream, print stats
 				s.pathsLock.RLock()
-				utils.Infof("Info for stream %d of %x", frame.StreamID, s.connectionID)
+				s.logger.Infof("Info for stream %d of %x", frame.StreamID, s.connectionID)
 				for pathID, pth := range s.paths {
 					sntPkts, sntRetrans, sntLost := pth.sentPacketHandler.GetStatistics()
 					rcvPkts := pth.receivedPacketHandler.GetStatistics()
-					utils.Infof("Path %x: sent %d retrans %d lost %d; rcv %d rtt %v", pathID, sntPkts, sntRetrans, sntLost, rcvPkts, pth.rttStats.SmoothedRTT())
+					s.logger.Infof("Path %x: sent %d retrans %d lost %d; rcv %d rtt %v", pathID, sntPkts, sntRetrans, sntLost, rcvPkts, pth.rttStats.SmoothedRTT())
 				}
 				s.pathsLock.RUnlock()
 			}
@@ -986,6 +1793,8 @@ func (sch *scheduler) performPacketSendingStream(s *session, windowUpdateFrames
 	if pth.sentPacketHandler.ShouldSendRetransmittablePacket() {
 		s.packer.QueueControlFrame(&wire.PingFrame{}, pth)
 	}
+	sch.maybeQueueTimestampFrame(s, pth)
+	sch.maybeQueueAckFrequencyFrame(s, pth)
 	packet, err := s.packer.PackPacketOfStream(pth, sid)
 	if err != nil || packet == nil {
 		return nil, false, err
@@ -994,9 +1803,11 @@ func (sch *scheduler) performPacketSendingStream(s *session, windowUpdateFrames
 		return nil, false, err
 	}
 
-	// send every window update twice
-	for _, f := range windowUpdateFrames {
-		s.packer.QueueControlFrame(f, pth)
+	// send every window update twice, unless configured otherwise
+	if !s.config.SendWindowUpdatesOnce {
+		for _, f := range windowUpdateFrames {
+			s.packer.QueueControlFrame(f, pth)
+		}
 	}
 
 	// Packet sent, so update its quota
@@ -1009,11 +1820,11 @@ func (sch *scheduler) performPacketSendingStream(s *session, windowUpdateFrames
 			if frame.FinBit {
 				// Last packet to send on the stream, print stats
 				s.pathsLock.RLock()
-				utils.Infof("Info for stream %d of %x", frame.StreamID, s.connectionID)
+				s.logger.Infof("Info for stream %d of %x", frame.StreamID, s.connectionID)
 				for pathID, pth := range s.paths {
 					sntPkts, sntRetrans, sntLost := pth.sentPacketHandler.GetStatistics()
 					rcvPkts := pth.receivedPacketHandler.GetStatistics()
-					utils.Infof("Path %x: sent %d retrans %d lost %d; rcv %d rtt %v", pathID, sntPkts, sntRetrans, sntLost, rcvPkts, pth.rttStats.SmoothedRTT())
+					s.logger.Infof("Path %x: sent %d retrans %d lost %d; rcv %d rtt %v", pathID, sntPkts, sntRetrans, sntLost, rcvPkts, pth.rttStats.SmoothedRTT())
 				}
 				s.pathsLock.RUnlock()
 			}
@@ -1052,11 +1863,11 @@ func (sch *scheduler) performACKPacketSending(s *session, pth *path) (*ackhandle
 			if frame.FinBit {
 				// Last packet to send on the stream, print stats
 				s.pathsLock.RLock()
-				utils.Infof("Info for stream %d of %x", frame.StreamID, s.connectionID)
+				s.logger.Infof("Info for stream %d of %x", frame.StreamID, s.connectionID)
 				for pathID, pth := range s.paths {
 					sntPkts, sntRetrans, sntLost := pth.sentPacketHandler.GetStatistics()
 					rcvPkts := pth.receivedPacketHandler.GetStatistics()
-					utils.Infof("Path %x: sent %d retrans %d lost %d; rcv %d rtt %v", pathID, sntPkts, sntRetrans, sntLost, rcvPkts, pth.rttStats.SmoothedRTT())
+					s.logger.Infof("Path %x: sent %d retrans %d lost %d; rcv %d rtt %v", pathID, sntPkts, sntRetrans, sntLost, rcvPkts, pth.rttStats.SmoothedRTT())
 				}
 				s.pathsLock.RUnlock()
 			}
@@ -1088,34 +1899,61 @@ func (sch *scheduler) ackRemainingPaths(s *session, totalWindowUpdateFrames []*w
 	if len(windowUpdateFrames) == 0 {
 		windowUpdateFrames = s.getWindowUpdateFrames(s.peerBlocked)
 	}
+	ackPolicy := s.config.AckPathPolicy
+	var lowestRTTPath *path
+	if ackPolicy == AckOnLowestRTTPath {
+		lowestRTTPath = sch.lowestRTTAckPath(s)
+	}
+	windowUpdatesQueued := false
 	for _, pthTmp := range s.paths {
 		ackTmp := pthTmp.GetAckFrame()
-		for _, wuf := range windowUpdateFrames {
-			s.packer.QueueControlFrame(wuf, pthTmp)
+		// the path on which the ACK of this path is sent
+		sendPth := pthTmp
+		if ackPolicy == AckOnAllPaths {
+			for _, wuf := range windowUpdateFrames {
+				s.packer.QueueControlFrame(wuf, pthTmp)
+			}
+		} else {
+			//   only paths with an ACK to send trigger a packet, the window updates are queued once
+			if ackTmp == nil {
+				continue
+			}
+			if lowestRTTPath != nil {
+				sendPth = lowestRTTPath
+			}
+			if !windowUpdatesQueued {
+				for _, wuf := range windowUpdateFrames {
+					s.packer.QueueControlFrame(wuf, sendPth)
+				}
+				windowUpdatesQueued = true
+			}
 		}
 		if ackTmp != nil || len(windowUpdateFrames) > 0 {
 			if pthTmp.pathID == protocol.InitialPathID && ackTmp == nil {
 				continue
 			}
+			// the STOP_WAITING frame refers to the packet numbers of the path it is sent on,
+			// if the ACK moves to another path it goes out with the next packet of its own path
 			swf := pthTmp.GetStopWaitingFrame(false)
 			if swf != nil {
 				s.packer.QueueControlFrame(swf, pthTmp)
 			}
-			s.packer.QueueControlFrame(ackTmp, pthTmp)
+			s.packer.QueueControlFrame(ackTmp, sendPth)
 			// XXX (QDC) should we instead call PackPacket to provides WUFs?
 			var packet *packedPacket
 			var err error
 			if ackTmp != nil {
 				// Avoid internal error bug
-				packet, err = s.packer.PackAckPacket(pthTmp)
+				packet, err = s.packer.PackAckPacket(sendPth)
 			} else {
 				//   change this also into only pack path related packet
-				packet, err = s.packer.PackPacketOfPath(pthTmp)
+				packet, err = s.packer.PackPacketOfPath(sendPth)
 			}
-			if err != nil {
-				return err
+			if err == nil && packet != nil {
+				// A write error on one path doesn't prevent sending on the others, see handleWriteError.
+				// It is only returned once no path can be used anymore.
+				err = s.sendPackedPacket(packet, sendPth)
 			}
-			err = s.sendPackedPacket(packet, pthTmp)
 			if err != nil {
 				return err
 			}
@@ -1125,6 +1963,26 @@ func (sch *scheduler) ackRemainingPaths(s *session, totalWindowUpdateFrames []*w
 	return nil
 }
 
+//   the path carrying the ACKs of all paths with AckOnLowestRTTPath, unprobed paths are only used if no RTT is known
+func (sch *scheduler) lowestRTTAckPath(s *session) *path {
+	var selectedPath *path
+	for _, pth := range s.paths {
+		if !pth.open.Get() || pth.potentiallyFailed.Get() || pth.connFailed.Get() {
+			continue
+		}
+		if selectedPath == nil {
+			selectedPath = pth
+			continue
+		}
+		currentRTT := pth.schedulingRTT()
+		lowerRTT := selectedPath.schedulingRTT()
+		if currentRTT != 0 && (lowerRTT == 0 || currentRTT < lowerRTT) {
+			selectedPath = pth
+		}
+	}
+	return selectedPath
+}
+
 func (sch *scheduler) ackRemainingOnePath(pthTmp *path, s *session, totalWindowUpdateFrames []*wire.WindowUpdateFrame) error {
 	// Either we run out of data, or CWIN of usable paths are full
 	// Send ACKs on paths not yet used, if needed. Either we have no data to send and
@@ -1164,20 +2022,24 @@ func (sch *scheduler) ackRemainingOnePath(pthTmp *path, s *session, totalWindowU
 		if ackTmp != nil {
 			// Avoid internal error bug
 
-			if utils.Debug() {
-				utils.Debugf(" ackRemainingOnePath: before s.packer.PackAckPacket(pthTmp) ")
+			if s.logger.Debug() {
+				s.logger.Debugf(" ackRemainingOnePath: before s.packer.PackAckPacket(pthTmp) ")
 			}
 			packet, err = s.packer.PackAckPacket(pthTmp)
 		} else {
 			//   TODO:  change this also into only pack path related packet
-			if utils.Debug() {
-				utils.Debugf(" ackRemainingOnePath: before s.packer.PackPacketOfPath(pthTmp)")
+			if s.logger.Debug() {
+				s.logger.Debugf(" ackRemainingOnePath: before s.packer.PackPacketOfPath(pthTmp)")
 			}
 			packet, err = s.packer.PackPacketOfPath(pthTmp)
 		}
 		if err != nil {
 			return err
 		}
+		if packet == nil {
+			s.peerBlocked = false
+			return nil
+		}
 
 		// if utils.Debug() {
 		// 	utils.Debugf(" ackRemainingOnePath: before s.sendPackedPacket(packet, pthTmp)")
@@ -1196,7 +2058,52 @@ func (sch *scheduler) ackRemainingOnePath(pthTmp *path, s *session, totalWindowU
 	return nil
 }
 
+// sendDatagrams sends the datagrams queued by the application on the lowest-RTT path, each in its own packet.
+// They stay queued while no path can send.
+func (sch *scheduler) sendDatagrams(s *session) error {
+	if !s.handshakeComplete {
+		return nil
+	}
+	for s.streamFramer.HasDatagramFrames() {
+		pth := sch.findPathLowLatency(s)
+		if pth == nil {
+			return nil
+		}
+		pth.SetLeastUnacked(pth.sentPacketHandler.GetLeastUnacked())
+		packet, err := s.packer.PackDatagram(s.streamFramer.PopDatagramFrame(), pth)
+		if err != nil {
+			return err
+		}
+		if err = s.sendPackedPacket(packet, pth); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nextReadyPathIndex returns the round-robin index of the first path from start on that carries streams and can send,
+// or start if no path can
+func (sch *scheduler) nextReadyPathIndex(s *session, start uint32, numOfPath uint32) uint32 {
+	for i := uint32(0); i < numOfPath; i++ {
+		index := (start + i) % numOfPath
+		pth := s.paths[s.openPaths[index]]
+		if len(pth.streamIDs) > 0 && pth.SendingAllowed() {
+			return index
+		}
+	}
+	return start
+}
+
 func (sch *scheduler) sendPacket(s *session) error {
+	defer sch.saveState()
+
+	if s.pathStatsReplayer != nil {
+		s.pathsLock.RLock()
+		s.pathStatsReplayer.replay(time.Now(), s.paths)
+		s.pathsLock.RUnlock()
+	}
+
+	sch.maybeSwapPathScheduler()
 
 	//   assign stream to path.
 	// path might not be assigned due to initial path congestion limited and we need to send ACK frames when congestion limited
@@ -1216,9 +2123,15 @@ func (sch *scheduler) sendPacket(s *session) error {
 		s.packer.QueueControlFrame(wuf, path)
 	}
 
+	if err = sch.sendDatagrams(s); err != nil {
+		return err
+	}
+
 	//  assgin path id
 	numOfPath := uint32(len(s.paths))
 
+	// start the passes on a path that can send data, the others are visited at the end of each pass
+	sch.roundRobinIndexPath = sch.nextReadyPathIndex(s, sch.roundRobinIndexPath, numOfPath)
 	startIndex := sch.roundRobinIndexPath
 
 	// Repeatedly try sending until all path don't have any more data, or run out of the congestion window
@@ -1236,10 +2149,13 @@ func (sch *scheduler) sendPacket(s *session) error {
 			path.SetLeastUnacked(path.sentPacketHandler.GetLeastUnacked())
 
 			streamNum := len(path.streamIDs)
+			if s.config.PathSendBatchSize > 1 {
+				streamNum *= s.config.PathSendBatchSize
+			}
 
 			//test begin
-			if utils.Debug() {
-				utils.Debugf("In test: path %d, rtt %s ms,fullbandwidth %d Mbps", path.pathID, path.rttStats.SmoothedRTT().String(), path.bdwStats.GetBandwidth())
+			if s.logger.Debug() {
+				s.logger.Debugf("In test: path %d, rtt %s ms,fullbandwidth %d Mbps", path.pathID, path.schedulingRTT().String(), path.bdwStats.GetBandwidth())
 			}
 			//test end
 
@@ -1247,15 +2163,15 @@ func (sch *scheduler) sendPacket(s *session) error {
 			if streamNum > 0 {
 
 				for streamNum > 0 { //   to provide fairness concern between paths
-					if utils.Debug() {
-						utils.Debugf("Path %d, sending the %d round", path.pathID, streamNum)
+					if s.logger.Debug() {
+						s.logger.Debugf("Path %d, sending the %d round", path.pathID, streamNum)
 					}
 					hasWindows = hasWindows || path.SendingAllowed()
 
 					// the path runs out of window, continue to next path
 					if !path.SendingAllowed() {
-						if utils.Debug() {
-							utils.Debugf("  sending not allowed on path %d", path.pathID)
+						if s.logger.Debug() {
+							s.logger.Debugf("  sending not allowed on path %d", path.pathID)
 						}
 
 						sch.roundRobinIndexPath = (sch.roundRobinIndexPath + 1) % numOfPath
@@ -1263,6 +2179,14 @@ func (sch *scheduler) sendPacket(s *session) error {
 						continue PATHLOOP
 					}
 
+					// the send rate of all paths together is limited, wait until the limiter allows sending again
+					if !s.sendRateAllowed() {
+						if s.logger.Debug() {
+							s.logger.Debugf("  sending not allowed by the send rate limit")
+						}
+						return sch.ackRemainingPaths(s, windowUpdateFrames)
+					}
+
 					//   We first check for retransmissions of this path in path.sentPacketHandler and put retransmit frames into streamframer
 					hasRetransmission, retransmitHandshakePacket := sch.getRetransmissionOfPath(s, path)
 					// XXX There might still be some stream frames to be retransmitted
@@ -1318,9 +2242,11 @@ func (sch *scheduler) sendPacket(s *session) error {
 
 					if !sent {
 						// this stream sending empty packets, continue to next path
-						if utils.Debug() {
-							utils.Debugf("  sending empty packets on path %d", path.pathID)
+						if s.logger.Debug() {
+							s.logger.Debugf("  sending empty packets on path %d", path.pathID)
 						}
+						// the window allowed sending, but the streams of the path had nothing to send
+						path.sentPacketHandler.OnAppLimited()
 						sch.roundRobinIndexPath = (sch.roundRobinIndexPath + 1) % numOfPath
 
 						continue PATHLOOP
@@ -1330,8 +2256,8 @@ func (sch *scheduler) sendPacket(s *session) error {
 					streamNum--
 				}
 			} else { // path without stream, ack path
-				if utils.Debug() {
-					utils.Debugf("  path %d without stream ", path.pathID)
+				if s.logger.Debug() {
+					s.logger.Debugf("  path %d without stream ", path.pathID)
 				}
 				sch.roundRobinIndexPath = (sch.roundRobinIndexPath + 1) % numOfPath
 
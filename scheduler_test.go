@@ -0,0 +1,1437 @@
+package quic
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/lucas-clemente/pstream/congestion"
+	"github.com/lucas-clemente/pstream/internal/mocks/mocks_fc"
+	"github.com/lucas-clemente/pstream/internal/protocol"
+	"github.com/lucas-clemente/pstream/internal/wire"
+)
+
+// an RTT estimator that ignores the samples
+type fixedRTTEstimator struct {
+	rtt     time.Duration
+	samples []time.Duration
+}
+
+func (e *fixedRTTEstimator) UpdateRTT(sendDelta, _ time.Duration, _ time.Time) {
+	e.samples = append(e.samples, sendDelta)
+}
+func (e *fixedRTTEstimator) SmoothedRTT() time.Duration { return e.rtt }
+func (e *fixedRTTEstimator) OnConnectionMigration()     {}
+
+var _ = Describe("Scheduler", func() {
+	var (
+		sch  *scheduler
+		sess *session
+	)
+
+	addPath := func(pathID protocol.PathID, rtt time.Duration) *path {
+		pth := &path{
+			pathID:            pathID,
+			sess:              sess,
+			rttStats:          congestion.NewRTTStatsWithSmoothedRTT(rtt),
+			bdwStats:          &congestion.BDWStats{},
+			sentPacketHandler: newMockSentPacketHandler(),
+		}
+		pth.open.Set(true)
+		sess.paths[pathID] = pth
+		sess.openPaths = append(sess.openPaths, pathID)
+		return pth
+	}
+
+	BeforeEach(func() {
+		sch = &scheduler{}
+		sch.setup(protocol.DefaultPathScheduler)
+		mockFcm := mocks_fc.NewMockFlowControlManager(mockCtrl)
+		mockFcm.EXPECT().SendWindowSize(gomock.Any()).Return(protocol.MaxByteCount, nil).AnyTimes()
+		mockFcm.EXPECT().RemainingConnectionWindowSize().Return(protocol.MaxByteCount).AnyTimes()
+		sess = &session{
+			paths:              make(map[protocol.PathID]*path),
+			version:            protocol.VersionMP,
+			config:             populateServerConfig(&Config{}),
+			flowControlManager: mockFcm,
+			streamFramer:       newStreamFramer(nil, mockFcm),
+		}
+		addPath(protocol.InitialPathID, 0)
+	})
+
+	Context("low latency path selection", func() {
+		var pthA, pthB *path
+
+		BeforeEach(func() {
+			pthA = addPath(1, 100*time.Millisecond)
+			pthB = addPath(3, 105*time.Millisecond)
+		})
+
+		It("selects the path with the lowest RTT", func() {
+			Expect(sch.findPathLowLatency(sess)).To(Equal(pthA))
+			Expect(sch.selectPathLowLatency(sess, false, false, nil)).To(Equal(pthA))
+		})
+
+		It("keeps the incumbent path if the other path is better within the margin", func() {
+			Expect(sch.findPathLowLatency(sess)).To(Equal(pthA))
+			pthB.rttStats = congestion.NewRTTStatsWithSmoothedRTT(95 * time.Millisecond)
+			Expect(sch.findPathLowLatency(sess)).To(Equal(pthA))
+			Expect(sch.selectPathLowLatency(sess, false, false, nil)).To(Equal(pthA))
+		})
+
+		It("switches the path if the other path is better by more than the margin", func() {
+			Expect(sch.findPathLowLatency(sess)).To(Equal(pthA))
+			pthB.rttStats = congestion.NewRTTStatsWithSmoothedRTT(80 * time.Millisecond)
+			Expect(sch.findPathLowLatency(sess)).To(Equal(pthB))
+			pthA.rttStats = congestion.NewRTTStatsWithSmoothedRTT(75 * time.Millisecond)
+			Expect(sch.selectPathLowLatency(sess, false, false, nil)).To(Equal(pthB))
+		})
+
+		It("switches to the lowest RTT path if the hysteresis is disabled", func() {
+			sess.config.PathSwitchMargin = -1
+			Expect(sch.findPathLowLatency(sess)).To(Equal(pthA))
+			pthB.rttStats = congestion.NewRTTStatsWithSmoothedRTT(95 * time.Millisecond)
+			Expect(sch.findPathLowLatency(sess)).To(Equal(pthB))
+		})
+
+		It("reports why paths were not selected", func() {
+			pthB.sentPacketHandler = &mockSentPacketHandler{congestionLimited: true}
+			pthC := addPath(5, 200*time.Millisecond)
+			pthD := addPath(7, 10*time.Millisecond)
+			pthD.potentiallyFailed.Set(true)
+			Expect(sch.findPathLowLatency(sess)).To(Equal(pthA))
+			Expect(sch.getPathsNotSelected()).To(Equal(map[protocol.PathID]PathNotSelectedReason{
+				protocol.InitialPathID: PathInitialAvoided,
+				pthB.pathID:            PathCongestionLimited,
+				pthC.pathID:            PathHigherRTT,
+				pthD.pathID:            PathPotentiallyFailed,
+			}))
+		})
+
+		It("reports a congestion-limited path in the next pass", func() {
+			Expect(sch.findPathLowLatency(sess)).To(Equal(pthA))
+			Expect(sch.getPathsNotSelected()[pthB.pathID]).To(Equal(PathHigherRTT))
+			pthA.sentPacketHandler = &mockSentPacketHandler{congestionLimited: true}
+			Expect(sch.findPathLowLatency(sess)).To(Equal(pthB))
+			Expect(sch.getPathsNotSelected()).To(HaveKeyWithValue(pthA.pathID, PathCongestionLimited))
+			Expect(sch.getPathsNotSelected()).ToNot(HaveKey(pthB.pathID))
+			Expect(PathCongestionLimited.String()).To(Equal("congestion-limited"))
+		})
+
+		It("does not select a path whose conn failed", func() {
+			Expect(sch.findPathLowLatency(sess)).To(Equal(pthA))
+			pthA.connFailed.Set(true)
+			Expect(sch.findPathLowLatency(sess)).To(Equal(pthB))
+			Expect(sch.getPathsNotSelected()).To(HaveKeyWithValue(pthA.pathID, PathConnFailed))
+			Expect(sch.selectPathLowLatency(sess, false, false, nil)).To(Equal(pthB))
+			Expect(sch.selectPathRoundRobin(sess, false, false, nil)).To(Equal(pthB))
+		})
+
+		It("does not keep the incumbent path if it is potentially failed", func() {
+			Expect(sch.findPathLowLatency(sess)).To(Equal(pthA))
+			pthB.rttStats = congestion.NewRTTStatsWithSmoothedRTT(95 * time.Millisecond)
+			pthA.potentiallyFailed.Set(true)
+			Expect(sch.findPathLowLatency(sess)).To(Equal(pthB))
+		})
+	})
+
+	Context("RTT estimators", func() {
+		It("selects the path with the lowest RTT estimate of a custom estimator", func() {
+			pthA := addPath(1, 0)
+			pthB := addPath(3, 0)
+			estimatorA := &fixedRTTEstimator{rtt: 80 * time.Millisecond}
+			pthA.rttStats.SetEstimator(estimatorA)
+			pthB.rttStats.SetEstimator(&fixedRTTEstimator{rtt: 40 * time.Millisecond})
+			// the samples would make path A the lower RTT one
+			pthA.rttStats.UpdateRTT(10*time.Millisecond, 0, time.Now())
+			pthB.rttStats.UpdateRTT(50*time.Millisecond, 0, time.Now())
+			Expect(estimatorA.samples).To(Equal([]time.Duration{10 * time.Millisecond}))
+			Expect(sch.findPathLowLatency(sess)).To(Equal(pthB))
+		})
+	})
+
+	Context("path groups", func() {
+		var wifiA, wifiB, cellular *path
+
+		BeforeEach(func() {
+			wifiA = addPath(1, 20*time.Millisecond)
+			wifiA.group = "wifi"
+			wifiB = addPath(3, 30*time.Millisecond)
+			wifiB.group = "wifi"
+			cellular = addPath(5, 60*time.Millisecond)
+			cellular.group = "cellular"
+		})
+
+		It("fails over to a path of another group", func() {
+			Expect(sch.findPathLowLatency(sess)).To(Equal(wifiA))
+			wifiA.potentiallyFailed.Set(true)
+			Expect(sch.findPathLowLatency(sess)).To(Equal(cellular))
+			Expect(sch.getPathsNotSelected()).To(HaveKeyWithValue(wifiB.pathID, PathHigherRTT))
+		})
+
+		It("fails over to a path of another group when the conn failed", func() {
+			Expect(sch.selectPathLowLatency(sess, false, false, nil)).To(Equal(wifiA))
+			wifiA.connFailed.Set(true)
+			Expect(sch.selectPathLowLatency(sess, false, false, nil)).To(Equal(cellular))
+		})
+
+		It("fails over to a path of the same group if there is no other one", func() {
+			Expect(sch.findPathLowLatency(sess)).To(Equal(wifiA))
+			cellular.connFailed.Set(true)
+			wifiA.potentiallyFailed.Set(true)
+			Expect(sch.findPathLowLatency(sess)).To(Equal(wifiB))
+		})
+
+		It("fails over to the lowest RTT path if the paths are not grouped", func() {
+			for _, pth := range []*path{wifiA, wifiB, cellular} {
+				pth.group = ""
+			}
+			Expect(sch.findPathLowLatency(sess)).To(Equal(wifiA))
+			wifiA.potentiallyFailed.Set(true)
+			Expect(sch.findPathLowLatency(sess)).To(Equal(wifiB))
+		})
+	})
+
+	Context("dumping the scheduling inputs", func() {
+		var dumped []*SchedulingInputs
+
+		BeforeEach(func() {
+			dumped = nil
+			sess.config.DumpSchedulingInputs = func(inputs *SchedulingInputs) {
+				dumped = append(dumped, inputs)
+			}
+			sess.streamToPath = make(StreamToPath)
+			sess.streamsMap = newStreamsMapTree(nil, protocol.PerspectiveServer, nil, newStreamTree())
+			sess.streamsMap.streams[1] = &stream{streamID: 1, priority: &protocol.Priority{Weight: 255}}
+			sess.streamsMap.streams[5] = &stream{streamID: 5, priority: &protocol.Priority{Weight: 200}, size: 1000, checksize: true}
+			sess.streamsMap.streams[7] = &stream{streamID: 7, priority: &protocol.Priority{Dependency: 5, Weight: 100}}
+			sess.streamsMap.openStreams = []protocol.StreamID{1, 7, 5}
+		})
+
+		It("dumps the paths and the streams waiting for a path", func() {
+			pthA := addPath(1, 100*time.Millisecond)
+			addPath(3, 50*time.Millisecond)
+			sess.streamToPath.Add(1, 1)
+			pthA.streamIDs = []protocol.StreamID{1}
+
+			_, err := sch.scheduleToMultiplePaths(sess)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(dumped).To(HaveLen(1))
+			Expect(dumped[0].Paths).To(Equal([]PathSchedulingInputs{
+				{PathID: 0, StreamIDs: []protocol.StreamID{}},
+				{PathID: 1, RTT: 100 * time.Millisecond, StreamIDs: []protocol.StreamID{1}},
+				{PathID: 3, RTT: 50 * time.Millisecond, StreamIDs: []protocol.StreamID{}},
+			}))
+			Expect(dumped[0].Streams).To(Equal([]StreamSchedulingInputs{
+				{StreamID: 5, Size: 1000, SizeKnown: true, Priority: protocol.Priority{Weight: 200}},
+				{StreamID: 7, Priority: protocol.Priority{Dependency: 5, Weight: 100}},
+			}))
+		})
+
+		It("doesn't build a record if not configured", func() {
+			sess.config.DumpSchedulingInputs = nil
+			_, err := sch.scheduleToMultiplePaths(sess)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(dumped).To(BeEmpty())
+		})
+	})
+
+	Context("minimum detected stream size", func() {
+		var pthA, pthB *path
+		var str *stream
+
+		BeforeEach(func() {
+			pthA = addPath(1, 40*time.Millisecond)
+			pthA.bdwStats = congestion.NewBDWStats(10 * 1048576)
+			pthB = addPath(3, 40*time.Millisecond)
+			pthB.bdwStats = congestion.NewBDWStats(10 * 1048576)
+			sess.config.MinDetectedStreamSize = 1000
+			sess.streamsMap = newStreamsMapTree(nil, protocol.PerspectiveServer, nil, newStreamTree())
+			str = &stream{streamID: 5, priority: &protocol.Priority{Weight: 200}}
+			sess.streamsMap.streams[5] = str
+		})
+
+		It("defers a trickle stream until it buffered enough data", func() {
+			str.dataForWriting = make([]byte, 100)
+			selected, result := sch.choosePaths(sess, 5, 200)
+			Expect(result).To(Equal(pathsRetryLater))
+			Expect(selected).To(BeEmpty())
+			Expect(str.checksize).To(BeFalse())
+			str.dataForWriting = make([]byte, 1200)
+			selected, result = sch.choosePaths(sess, 5, 200)
+			Expect(result).To(Equal(pathsChosen))
+			Expect(selected).To(HaveLen(2))
+			Expect(selected[pthA]).To(BeNumerically("~", 600, 1))
+			Expect(str.size).To(Equal(protocol.ByteCount(1200)))
+		})
+
+		It("schedules a closed stream with the data it buffered", func() {
+			str.dataForWriting = make([]byte, 100)
+			str.finishedWriting.Set(true)
+			_, result := sch.choosePaths(sess, 5, 200)
+			Expect(result).To(Equal(pathsChosen))
+			Expect(str.size).To(Equal(protocol.ByteCount(100)))
+		})
+
+		It("schedules a flushed stream with the data it buffered", func() {
+			str.dataForWriting = make([]byte, 100)
+			str.flushOffset = 100
+			_, result := sch.choosePaths(sess, 5, 200)
+			Expect(result).To(Equal(pathsChosen))
+			Expect(str.size).To(Equal(protocol.ByteCount(100)))
+		})
+
+		It("wakes up the session when it stops waiting for a trickle stream", func() {
+			deadline := time.Now().Add(50 * time.Millisecond)
+			str.dataForWriting = make([]byte, 100)
+			str.sizeDetectionDeadline = deadline
+			_, result := sch.choosePaths(sess, 5, 200)
+			Expect(result).To(Equal(pathsRetryLater))
+			Expect(sess.sizeDetectionDeadline).To(Equal(deadline))
+		})
+
+		It("schedules a trickle stream with the data it buffered after the deadline", func() {
+			str.dataForWriting = make([]byte, 100)
+			str.sizeDetectionDeadline = time.Now().Add(-time.Millisecond)
+			selected, result := sch.choosePaths(sess, 5, 200)
+			Expect(result).To(Equal(pathsChosen))
+			Expect(selected).ToNot(BeEmpty())
+			Expect(str.size).To(Equal(protocol.ByteCount(100)))
+		})
+
+		It("defers the stream in the single-path selection", func() {
+			str.dataForWriting = make([]byte, 100)
+			Expect(sch.choosePath(sess, 5, 200)).To(BeNil())
+			str.dataForWriting = make([]byte, 1000)
+			Expect(sch.choosePath(sess, 5, 200)).ToNot(BeNil())
+		})
+	})
+
+	Context("sessions without multipath", func() {
+		var str *stream
+
+		BeforeEach(func() {
+			sess.version = protocol.VersionWhatever
+			sess.perspective = protocol.PerspectiveServer
+			sess.streamToPath = make(StreamToPath)
+			sess.streamsMap = newStreamsMapTree(nil, protocol.PerspectiveServer, nil, newStreamTree())
+			str = &stream{streamID: 5, priority: &protocol.Priority{Weight: 200}, pathVolume: make(map[protocol.PathID]float64)}
+			Expect(sess.streamsMap.putStream(str)).To(Succeed())
+		})
+
+		It("assigns a stream to the only path before its size is known", func() {
+			_, err := sch.scheduleToMultiplePaths(sess)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(sess.streamToPath[5]).To(Equal([]protocol.PathID{protocol.InitialPathID}))
+			Expect(sess.paths[protocol.InitialPathID].streamIDs).To(Equal([]protocol.StreamID{5}))
+			Expect(sch.numstreams[protocol.InitialPathID]).To(Equal(uint(1)))
+			Expect(str.checksize).To(BeFalse())
+		})
+
+		It("lets the only path send all data of the stream", func() {
+			_, err := sch.scheduleToMultiplePaths(sess)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(str.pathVolume[protocol.InitialPathID]).To(BeNumerically(">", float64(protocol.MaxByteCount)))
+		})
+
+		It("assigns a stream only once", func() {
+			for i := 0; i < 3; i++ {
+				_, err := sch.scheduleToMultiplePaths(sess)
+				Expect(err).ToNot(HaveOccurred())
+			}
+			Expect(sess.paths[protocol.InitialPathID].streamIDs).To(HaveLen(1))
+			Expect(sch.numstreams[protocol.InitialPathID]).To(Equal(uint(1)))
+		})
+
+		It("keeps waiting for the other paths of a multipath session", func() {
+			sess.version = protocol.VersionMP
+			str.size = 1000
+			str.checksize = true
+			_, err := sch.scheduleToMultiplePaths(sess)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(sess.streamToPath).ToNot(HaveKey(protocol.StreamID(5)))
+		})
+
+		Measure("scheduling a pass over many assigned streams", func(b Benchmarker) {
+			for id := protocol.StreamID(7); id < 2007; id += 2 {
+				s := &stream{streamID: id, priority: &protocol.Priority{Weight: 16}, pathVolume: make(map[protocol.PathID]float64)}
+				Expect(sess.streamsMap.putStream(s)).To(Succeed())
+			}
+			_, err := sch.scheduleToMultiplePaths(sess)
+			Expect(err).ToNot(HaveOccurred())
+			b.Time("runtime", func() {
+				for i := 0; i < 100; i++ {
+					_, err = sch.scheduleToSinglePath(sess)
+				}
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(sess.paths[protocol.InitialPathID].streamIDs).To(HaveLen(1001))
+		}, 10)
+	})
+
+	Context("dependent streams", func() {
+		var parent, child *stream
+
+		BeforeEach(func() {
+			sess.streamToPath = make(StreamToPath)
+			sess.streamsMap = newStreamsMapTree(nil, protocol.PerspectiveServer, nil, newStreamTree())
+			parent = &stream{streamID: 5, priority: &protocol.Priority{Weight: 200}}
+			child = &stream{streamID: 7, priority: &protocol.Priority{Dependency: 5, Weight: 100}}
+			sess.streamsMap.streams[5] = parent
+			sess.streamsMap.streams[7] = child
+		})
+
+		It("holds a child stream until its parent is scheduled", func() {
+			Expect(sch.waitsForParent(sess, child)).To(BeTrue())
+			sess.streamToPath.Add(5, 1)
+			Expect(sch.waitsForParent(sess, child)).To(BeFalse())
+		})
+
+		It("doesn't hold a child stream if its parent is finished", func() {
+			parent.finishedWriting.Set(true)
+			parent.finSent.Set(true)
+			Expect(sch.waitsForParent(sess, child)).To(BeFalse())
+		})
+
+		It("doesn't hold streams depending on the root or the header stream", func() {
+			Expect(sch.waitsForParent(sess, parent)).To(BeFalse())
+			child.priority.Dependency = 3
+			Expect(sch.waitsForParent(sess, child)).To(BeFalse())
+		})
+
+		It("doesn't hold streams depending on a configured control stream", func() {
+			sess.streamsMap.addControlStreams([]protocol.StreamID{5})
+			Expect(sch.waitsForParent(sess, child)).To(BeFalse())
+		})
+	})
+
+	Context("control streams", func() {
+		var pthA, pthB *path
+
+		BeforeEach(func() {
+			pthA = addPath(1, 100*time.Millisecond)
+			pthB = addPath(3, 50*time.Millisecond)
+			sess.perspective = protocol.PerspectiveServer
+			sess.streamToPath = make(StreamToPath)
+			sess.streamTree = newStreamTree()
+			sess.streamsMap = newStreamsMapTree(nil, protocol.PerspectiveServer, nil, sess.streamTree)
+			sess.streamsMap.addControlStreams([]protocol.StreamID{9})
+		})
+
+		It("treats the crypto and header streams as control streams", func() {
+			Expect(sess.streamsMap.isControlStream(1)).To(BeTrue())
+			Expect(sess.streamsMap.isControlStream(3)).To(BeTrue())
+			Expect(sess.streamsMap.isControlStream(9)).To(BeTrue())
+			Expect(sess.streamsMap.isControlStream(5)).To(BeFalse())
+		})
+
+		It("places a configured control stream on the lowest RTT path", func() {
+			str := &stream{streamID: 9, priority: &protocol.Priority{Weight: 1}, pathVolume: make(map[protocol.PathID]float64)}
+			Expect(sess.streamsMap.putStream(str)).To(Succeed())
+
+			_, err := sch.scheduleToMultiplePaths(sess)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(sess.streamToPath[9]).To(Equal([]protocol.PathID{pthB.pathID}))
+			Expect(pthB.streamIDs).To(Equal([]protocol.StreamID{9}))
+			Expect(pthA.streamIDs).To(BeEmpty())
+			Expect(sch.numstreams[pthB.pathID]).To(BeZero())
+		})
+	})
+
+	Context("cost aware path selection", func() {
+		var cheapPath, costlyPath *path
+
+		BeforeEach(func() {
+			sch.setup(protocol.CostAwarePathScheduler)
+			cheapPath = addPath(1, 100*time.Millisecond)
+			cheapPath.bdwStats = congestion.NewBDWStats(10 * 1048576)
+			costlyPath = addPath(3, 50*time.Millisecond)
+			costlyPath.bdwStats = congestion.NewBDWStats(10 * 1048576)
+			costlyPath.cost = PathCostHigh
+			cheapPath.sentPacketHandler.(*mockSentPacketHandler).congestionWindow = 10000
+			sess.streamsMap = newStreamsMapTree(nil, protocol.PerspectiveServer, nil, newStreamTree())
+			sess.streamsMap.streams[5] = &stream{streamID: 5, priority: &protocol.Priority{Weight: 200}, size: 1000, checksize: true}
+		})
+
+		It("keeps the data on the low cost path", func() {
+			selected, _ := sch.choosePaths(sess, 5, 200)
+			Expect(selected).To(HaveLen(1))
+			Expect(selected).To(HaveKeyWithValue(cheapPath, float64(1000)))
+			Expect(sch.getPathsNotSelected()[costlyPath.pathID]).To(Equal(PathHighCost))
+		})
+
+		It("spills to the high cost path when the low cost path is congestion limited", func() {
+			cheapPath.sentPacketHandler = &mockSentPacketHandler{congestionLimited: true}
+			selected, _ := sch.choosePaths(sess, 5, 200)
+			Expect(selected).To(HaveLen(1))
+			Expect(selected).To(HaveKeyWithValue(costlyPath, float64(1000)))
+			Expect(sch.getPathsNotSelected()[cheapPath.pathID]).To(Equal(PathCongestionLimited))
+		})
+
+		It("only spills the data beyond the available window of the low cost path", func() {
+			sph := cheapPath.sentPacketHandler.(*mockSentPacketHandler)
+			sph.congestionWindow = 800
+			sph.bytesInFlight = 200
+			selected, _ := sch.choosePaths(sess, 5, 200)
+			Expect(selected).To(HaveLen(2))
+			Expect(selected[costlyPath]).To(BeNumerically("~", 400, 1))
+			Expect(selected[cheapPath]).To(BeNumerically("~", 600, 1))
+			Expect(sch.getPathsNotSelected()).ToNot(HaveKey(cheapPath.pathID))
+		})
+
+		It("uses all paths with the default path scheduler", func() {
+			sch.setup(protocol.DefaultPathScheduler)
+			selected, _ := sch.choosePaths(sess, 5, 200)
+			Expect(selected).To(HaveKey(costlyPath))
+		})
+
+		It("switches the path scheduler between two passes", func() {
+			sch.setup(protocol.DefaultPathScheduler)
+			selected, _ := sch.choosePaths(sess, 5, 200)
+			Expect(selected).To(HaveKey(costlyPath))
+			Expect(sch.setPathScheduler(protocol.CostAwarePathScheduler)).To(Succeed())
+			// the pass in progress still uses the previous path scheduler
+			selected, _ = sch.choosePaths(sess, 5, 200)
+			Expect(selected).To(HaveKey(costlyPath))
+			sch.maybeSwapPathScheduler()
+			selected, _ = sch.choosePaths(sess, 5, 200)
+			Expect(selected).To(HaveLen(1))
+			Expect(selected).To(HaveKey(cheapPath))
+		})
+	})
+
+	Context("one-way delays", func() {
+		var pthA, pthB *path
+
+		BeforeEach(func() {
+			pthA = addPath(1, 40*time.Millisecond)
+			pthA.bdwStats = congestion.NewBDWStats(10 * 1048576)
+			pthB = addPath(3, 40*time.Millisecond)
+			pthB.bdwStats = congestion.NewBDWStats(10 * 1048576)
+			sess.streamsMap = newStreamsMapTree(nil, protocol.PerspectiveServer, nil, newStreamTree())
+			sess.streamsMap.streams[5] = &stream{streamID: 5, priority: &protocol.Priority{Weight: 200}, size: 10000, checksize: true}
+		})
+
+		It("splits the data evenly on symmetric paths with the same RTT", func() {
+			selected, _ := sch.choosePaths(sess, 5, 200)
+			Expect(selected).To(HaveLen(2))
+			Expect(selected[pthA]).To(BeNumerically("~", 5000, 1))
+			Expect(selected[pthB]).To(BeNumerically("~", 5000, 1))
+		})
+
+		It("prefers the path with the lower one-way delay when timestamps are available", func() {
+			now := time.Now()
+			// A is fast towards the peer and slow back, B the other way around
+			pthA.onTimestampFrame(&wire.TimestampFrame{Timestamp: now.Add(-30 * time.Millisecond)}, now)
+			pthB.onTimestampFrame(&wire.TimestampFrame{Timestamp: now.Add(-10 * time.Millisecond)}, now)
+			selected, _ := sch.choosePaths(sess, 5, 200)
+			Expect(selected).To(HaveLen(1))
+			Expect(selected[pthA]).To(BeNumerically("~", 10000, 1))
+			Expect(sch.getPathsNotSelected()[pthB.pathID]).To(Equal(PathHigherRTT))
+		})
+
+		It("falls back to half of the RTT if a path has no timestamps", func() {
+			now := time.Now()
+			pthA.onTimestampFrame(&wire.TimestampFrame{Timestamp: now.Add(-30 * time.Millisecond)}, now)
+			selected, _ := sch.choosePaths(sess, 5, 200)
+			Expect(selected).To(HaveLen(2))
+			Expect(selected[pthA]).To(BeNumerically("~", 5000, 1))
+		})
+	})
+
+	Context("round-robin start of the send loop", func() {
+		var pthA, pthB *path
+
+		BeforeEach(func() {
+			// openPaths is 0, 1, 3
+			pthA = addPath(1, 40*time.Millisecond)
+			pthA.streamIDs = []protocol.StreamID{5}
+			pthB = addPath(3, 40*time.Millisecond)
+			pthB.streamIDs = []protocol.StreamID{7}
+		})
+
+		It("skips the paths without streams", func() {
+			Expect(sch.nextReadyPathIndex(sess, 0, 3)).To(Equal(uint32(1)))
+		})
+
+		It("starts on the next usable path if the first one is congestion limited", func() {
+			pthA.sentPacketHandler.(*mockSentPacketHandler).congestionLimited = true
+			Expect(sch.nextReadyPathIndex(sess, 1, 3)).To(Equal(uint32(2)))
+		})
+
+		It("wraps around", func() {
+			pthB.sentPacketHandler.(*mockSentPacketHandler).congestionLimited = true
+			Expect(sch.nextReadyPathIndex(sess, 2, 3)).To(Equal(uint32(1)))
+		})
+
+		It("keeps the index if no path can send", func() {
+			pthA.sentPacketHandler.(*mockSentPacketHandler).congestionLimited = true
+			pthB.sentPacketHandler.(*mockSentPacketHandler).congestionLimited = true
+			Expect(sch.nextReadyPathIndex(sess, 2, 3)).To(Equal(uint32(2)))
+		})
+	})
+
+	Context("shared bottlenecks", func() {
+		var pthA, pthB, pthC *path
+
+		BeforeEach(func() {
+			pthA = addPath(1, 40*time.Millisecond)
+			pthA.bdwStats = congestion.NewBDWStats(10 * 1048576)
+			pthA.group = "wifi"
+			pthB = addPath(3, 40*time.Millisecond)
+			pthB.bdwStats = congestion.NewBDWStats(10 * 1048576)
+			pthB.group = "wifi"
+			pthC = addPath(5, 40*time.Millisecond)
+			pthC.bdwStats = congestion.NewBDWStats(10 * 1048576)
+			sess.streamsMap = newStreamsMapTree(nil, protocol.PerspectiveServer, nil, newStreamTree())
+			sess.streamsMap.streams[5] = &stream{streamID: 5, priority: &protocol.Priority{Weight: 200}, size: 12000, checksize: true}
+		})
+
+		It("sums up the bandwidths of grouped paths if no bottleneck is configured", func() {
+			selected, _ := sch.choosePaths(sess, 5, 200)
+			Expect(selected).To(HaveLen(3))
+			Expect(selected[pthA]).To(BeNumerically("~", 4000, 1))
+			Expect(selected[pthB]).To(BeNumerically("~", 4000, 1))
+			Expect(selected[pthC]).To(BeNumerically("~", 4000, 1))
+		})
+
+		It("doesn't assign more than the bandwidth of their bottleneck to grouped paths", func() {
+			sess.config.PathGroupBandwidth = func(group string) uint64 {
+				Expect(group).To(Equal("wifi"))
+				return 10 * 1048576 / 8
+			}
+			selected, _ := sch.choosePaths(sess, 5, 200)
+			Expect(selected).To(HaveLen(3))
+			Expect(selected[pthA]).To(BeNumerically("~", 3000, 1))
+			Expect(selected[pthB]).To(BeNumerically("~", 3000, 1))
+			Expect(selected[pthC]).To(BeNumerically("~", 6000, 1))
+		})
+
+		It("caps the combined bandwidth of the grouped paths", func() {
+			sess.config.PathGroupBandwidth = func(string) uint64 { return 12 * 1048576 / 8 }
+			pathsBdw := map[protocol.PathID]float64{1: 10 * 1048576, 3: 10 * 1048576, 5: 10 * 1048576}
+			sch.applyGroupBandwidthCap(sess, []*path{pthA, pthB, pthC}, pathsBdw)
+			Expect(pathsBdw[1] + pathsBdw[3]).To(BeNumerically("~", 12*1048576, 1))
+			Expect(pathsBdw[1]).To(Equal(pathsBdw[3]))
+			Expect(pathsBdw[5]).To(BeNumerically("==", 10*1048576))
+		})
+
+		It("doesn't raise the bandwidths of grouped paths below their bottleneck", func() {
+			sess.config.PathGroupBandwidth = func(string) uint64 { return 100 * 1048576 / 8 }
+			pathsBdw := map[protocol.PathID]float64{1: 10 * 1048576, 3: 10 * 1048576}
+			sch.applyGroupBandwidthCap(sess, []*path{pthA, pthB}, pathsBdw)
+			Expect(pathsBdw[1]).To(BeNumerically("==", 10*1048576))
+			Expect(pathsBdw[3]).To(BeNumerically("==", 10*1048576))
+		})
+	})
+
+	Context("minimum number of paths for splitting", func() {
+		var pthA, pthB *path
+
+		BeforeEach(func() {
+			pthA = addPath(1, 40*time.Millisecond)
+			pthA.bdwStats = congestion.NewBDWStats(10 * 1048576)
+			pthB = addPath(3, 50*time.Millisecond)
+			pthB.bdwStats = congestion.NewBDWStats(10 * 1048576)
+			sess.streamsMap = newStreamsMapTree(nil, protocol.PerspectiveServer, nil, newStreamTree())
+			sess.streamsMap.streams[5] = &stream{streamID: 5, priority: &protocol.Priority{Weight: 200}, size: 10000, checksize: true}
+		})
+
+		It("splits a stream on two paths by default", func() {
+			Expect(sess.config.MinPathsForSplit).To(Equal(protocol.DefaultMinPathsForSplit))
+			selected, _ := sch.choosePaths(sess, 5, 200)
+			Expect(selected).To(HaveLen(2))
+		})
+
+		It("keeps a stream on the lowest delay path with fewer usable paths than needed", func() {
+			sess.config.MinPathsForSplit = 3
+			selected, result := sch.choosePaths(sess, 5, 200)
+			Expect(result).To(Equal(pathsChosen))
+			Expect(selected).To(HaveLen(1))
+			Expect(selected[pthA]).To(BeNumerically("~", 10000, 1))
+			Expect(sch.getPathsNotSelected()[pthB.pathID]).To(Equal(PathHigherRTT))
+		})
+
+		It("only counts the usable paths", func() {
+			sess.config.MinPathsForSplit = 3
+			pthC := addPath(5, 40*time.Millisecond)
+			pthC.bdwStats = congestion.NewBDWStats(10 * 1048576)
+			pthC.potentiallyFailed.Set(true)
+			selected, _ := sch.choosePaths(sess, 5, 200)
+			Expect(selected).To(HaveLen(1))
+			pthC.potentiallyFailed.Set(false)
+			selected, _ = sch.choosePaths(sess, 5, 200)
+			Expect(len(selected)).To(BeNumerically(">", 1))
+		})
+	})
+
+	Context("maximum number of paths per stream", func() {
+		var pthA, pthB, pthC *path
+
+		BeforeEach(func() {
+			pthA = addPath(1, 60*time.Millisecond)
+			pthB = addPath(3, 40*time.Millisecond)
+			pthC = addPath(5, 50*time.Millisecond)
+			for _, pth := range []*path{pthA, pthB, pthC} {
+				pth.bdwStats = congestion.NewBDWStats(10 * 1048576)
+			}
+			sess.streamsMap = newStreamsMapTree(nil, protocol.PerspectiveServer, nil, newStreamTree())
+			sess.streamsMap.streams[7] = &stream{streamID: 7, priority: &protocol.Priority{Weight: 200}, size: 1000000, checksize: true}
+		})
+
+		It("splits a stream on all paths by default", func() {
+			selected, _ := sch.choosePaths(sess, 7, 200)
+			Expect(selected).To(HaveLen(3))
+		})
+
+		It("only gives volume to the paths with the lowest one-way delays", func() {
+			sess.config.MaxPathsPerStream = 2
+			selected, result := sch.choosePaths(sess, 7, 200)
+			Expect(result).To(Equal(pathsChosen))
+			Expect(selected).To(HaveLen(2))
+			Expect(selected).To(HaveKey(pthB))
+			Expect(selected).To(HaveKey(pthC))
+			Expect(selected[pthB] + selected[pthC]).To(BeNumerically("~", 1000000, 1))
+			Expect(sch.getPathsNotSelected()[pthA.pathID]).To(Equal(PathHigherRTT))
+		})
+	})
+
+	Context("flow control limited streams", func() {
+		var pthA, pthB *path
+		var str *stream
+		var mockFcm *mocks_fc.MockFlowControlManager
+
+		BeforeEach(func() {
+			pthA = addPath(1, 40*time.Millisecond)
+			pthA.bdwStats = congestion.NewBDWStats(10 * 1048576)
+			pthB = addPath(3, 40*time.Millisecond)
+			pthB.bdwStats = congestion.NewBDWStats(10 * 1048576)
+			mockFcm = mocks_fc.NewMockFlowControlManager(mockCtrl)
+			sess.flowControlManager = mockFcm
+			sess.perspective = protocol.PerspectiveServer
+			sess.streamToPath = make(StreamToPath)
+			sess.streamTree = newStreamTree()
+			sess.streamsMap = newStreamsMapTree(nil, protocol.PerspectiveServer, nil, sess.streamTree)
+			str = &stream{streamID: 5, priority: &protocol.Priority{Weight: 200}, pathVolume: make(map[protocol.PathID]float64)}
+			str.dataForWriting = make([]byte, 10000)
+			Expect(sess.streamsMap.putStream(str)).To(Succeed())
+		})
+
+		It("doesn't assign more than the send window", func() {
+			mockFcm.EXPECT().SendWindowSize(protocol.StreamID(5)).Return(protocol.ByteCount(4000), nil)
+			str.size = 10000
+			str.checksize = true
+			selected, _ := sch.choosePaths(sess, 5, 200)
+			Expect(selected).To(HaveLen(2))
+			Expect(selected[pthA]).To(BeNumerically("~", 2000, 1))
+			Expect(selected[pthB]).To(BeNumerically("~", 2000, 1))
+			Expect(str.windowLimited).To(BeTrue())
+		})
+
+		It("assigns the whole stream if the window is large enough", func() {
+			mockFcm.EXPECT().SendWindowSize(protocol.StreamID(5)).Return(protocol.ByteCount(20000), nil)
+			str.size = 10000
+			str.checksize = true
+			selected, _ := sch.choosePaths(sess, 5, 200)
+			Expect(selected[pthA] + selected[pthB]).To(BeNumerically("~", 10000, 1))
+			Expect(str.windowLimited).To(BeFalse())
+		})
+
+		It("doesn't assign data streams while the connection is blocked", func() {
+			sess.streamsMap.addControlStreams([]protocol.StreamID{9})
+			ctrl := &stream{streamID: 9, priority: &protocol.Priority{Weight: 1}, pathVolume: make(map[protocol.PathID]float64)}
+			Expect(sess.streamsMap.putStream(ctrl)).To(Succeed())
+			mockFcm.EXPECT().RemainingConnectionWindowSize().Return(protocol.ByteCount(0))
+			_, err := sch.scheduleToMultiplePaths(sess)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(sess.streamToPath).ToNot(HaveKey(protocol.StreamID(5)))
+			Expect(sess.streamToPath).To(HaveKey(protocol.StreamID(9)))
+			// the stream is assigned as soon as the connection window grew
+			mockFcm.EXPECT().RemainingConnectionWindowSize().Return(protocol.ByteCount(1000))
+			mockFcm.EXPECT().SendWindowSize(protocol.StreamID(5)).Return(protocol.MaxByteCount, nil)
+			_, err = sch.scheduleToMultiplePaths(sess)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(sess.streamToPath[5]).To(ConsistOf(pthA.pathID, pthB.pathID))
+		})
+
+		It("assigns the remainder once the window grew", func() {
+			mockFcm.EXPECT().RemainingConnectionWindowSize().Return(protocol.MaxByteCount).AnyTimes()
+			mockFcm.EXPECT().SendWindowSize(protocol.StreamID(5)).Return(protocol.ByteCount(4000), nil)
+			_, err := sch.scheduleToMultiplePaths(sess)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(str.pathVolume[pthA.pathID] + str.pathVolume[pthB.pathID]).To(BeNumerically("~", 4000, 1))
+			// 1000 bytes were sent on path A
+			str.pathVolume[pthA.pathID] -= 1000
+			str.dataForWriting = str.dataForWriting[1000:]
+			sch.releaseWindowLimitedStream(sess, str)
+			Expect(sess.streamToPath).ToNot(HaveKey(protocol.StreamID(5)))
+			Expect(pthA.streamIDs).To(BeEmpty())
+			Expect(pthB.streamIDs).To(BeEmpty())
+			mockFcm.EXPECT().SendWindowSize(protocol.StreamID(5)).Return(protocol.ByteCount(20000), nil)
+			_, err = sch.scheduleToMultiplePaths(sess)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(sess.streamToPath[5]).To(ConsistOf(pthA.pathID, pthB.pathID))
+			Expect(str.pathVolume[pthA.pathID] + str.pathVolume[pthB.pathID]).To(BeNumerically("~", 9000, 1))
+			Expect(str.windowLimited).To(BeFalse())
+		})
+	})
+
+	Context("stream affinity", func() {
+		var pthA, pthB *path
+		var str *stream
+
+		BeforeEach(func() {
+			pthA = addPath(1, 100*time.Millisecond)
+			pthA.bdwStats = congestion.NewBDWStats(10 * 1048576)
+			pthB = addPath(3, 100*time.Millisecond)
+			pthB.bdwStats = congestion.NewBDWStats(10 * 1048576)
+			sess.streamToPath = make(StreamToPath)
+			sess.streamsMap = newStreamsMapTree(nil, protocol.PerspectiveServer, nil, newStreamTree())
+			str = &stream{streamID: 5, priority: &protocol.Priority{Weight: 16}, pathVolume: make(map[protocol.PathID]float64)}
+			str.dataForWriting = make([]byte, 1000)
+			sess.streamsMap.streams[5] = str
+			// the stream was limited by its send window on path A
+			sess.streamToPath.Add(5, pthA.pathID)
+			pthA.streamIDs = []protocol.StreamID{5}
+			str.pathVolume[pthA.pathID] = 500
+			str.windowLimited = true
+		})
+
+		It("keeps a rescheduled stream on its path if the other path is only marginally better", func() {
+			pthB.rttStats = congestion.NewRTTStatsWithSmoothedRTT(95 * time.Millisecond)
+			sch.releaseWindowLimitedStream(sess, str)
+			selected, _ := sch.choosePaths(sess, 5, 16)
+			Expect(selected).To(HaveLen(1))
+			Expect(selected[pthA]).To(BeNumerically("~", 1000, 1))
+		})
+
+		It("moves a rescheduled stream if the other path is clearly better", func() {
+			pthB.rttStats = congestion.NewRTTStatsWithSmoothedRTT(50 * time.Millisecond)
+			sch.releaseWindowLimitedStream(sess, str)
+			selected, _ := sch.choosePaths(sess, 5, 16)
+			Expect(selected).To(HaveLen(1))
+			Expect(selected[pthB]).To(BeNumerically("~", 1000, 1))
+		})
+
+		It("doesn't stick to a path if the hysteresis is disabled", func() {
+			sess.config.PathSwitchMargin = -1
+			pthB.rttStats = congestion.NewRTTStatsWithSmoothedRTT(95 * time.Millisecond)
+			sch.releaseWindowLimitedStream(sess, str)
+			selected, _ := sch.choosePaths(sess, 5, 16)
+			Expect(selected).To(HaveLen(1))
+			Expect(selected[pthB]).To(BeNumerically("~", 1000, 1))
+		})
+	})
+
+	Context("path selection result", func() {
+		var pthA, pthB *path
+		var str *stream
+
+		BeforeEach(func() {
+			pthA = addPath(1, 40*time.Millisecond)
+			pthA.bdwStats = congestion.NewBDWStats(10 * 1048576)
+			pthB = addPath(3, 40*time.Millisecond)
+			pthB.bdwStats = congestion.NewBDWStats(10 * 1048576)
+			sess.perspective = protocol.PerspectiveServer
+			sess.streamToPath = make(StreamToPath)
+			sess.streamTree = newStreamTree()
+			sess.streamsMap = newStreamsMapTree(nil, protocol.PerspectiveServer, nil, sess.streamTree)
+			str = &stream{streamID: 5, priority: &protocol.Priority{Weight: 16}, pathVolume: make(map[protocol.PathID]float64)}
+			Expect(sess.streamsMap.putStream(str)).To(Succeed())
+		})
+
+		It("chooses paths for a stream of known size", func() {
+			str.dataForWriting = make([]byte, 1000)
+			selected, result := sch.choosePaths(sess, 5, 16)
+			Expect(result).To(Equal(pathsChosen))
+			Expect(selected).To(HaveLen(2))
+		})
+
+		It("retries later if the size of the stream is not known yet", func() {
+			selected, result := sch.choosePaths(sess, 5, 16)
+			Expect(result).To(Equal(pathsRetryLater))
+			Expect(selected).To(BeNil())
+			Expect(str.checksize).To(BeFalse())
+
+			sendMore, err := sch.scheduleToMultiplePaths(sess)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(sendMore).To(BeTrue())
+			Expect(sess.streamToPath).ToNot(HaveKey(protocol.StreamID(5)))
+
+			str.dataForWriting = make([]byte, 1000)
+			_, err = sch.scheduleToMultiplePaths(sess)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(sess.streamToPath[5]).To(ConsistOf(pthA.pathID, pthB.pathID))
+		})
+
+		It("reports that no path is available", func() {
+			str.dataForWriting = make([]byte, 1000)
+			pthA.sentPacketHandler = &mockSentPacketHandler{congestionLimited: true}
+			pthB.potentiallyFailed.Set(true)
+			selected, result := sch.choosePaths(sess, 5, 16)
+			Expect(result).To(Equal(pathsUnavailable))
+			Expect(selected).To(BeNil())
+			Expect(str.checksize).To(BeTrue())
+		})
+
+		It("reports that no path is available for a striped stream", func() {
+			str.dataForWriting = make([]byte, 1000)
+			str.striped.Set(true)
+			pthA.sentPacketHandler = &mockSentPacketHandler{congestionLimited: true}
+			pthB.sentPacketHandler = &mockSentPacketHandler{congestionLimited: true}
+			_, result := sch.choosePaths(sess, 5, 16)
+			Expect(result).To(Equal(pathsUnavailable))
+		})
+	})
+
+	Context("state", func() {
+		var pthA, pthB *path
+
+		BeforeEach(func() {
+			pthA = addPath(1, 100*time.Millisecond)
+			pthA.bdwStats = congestion.NewBDWStats(10 * 1048576)
+			pthB = addPath(3, 10*time.Millisecond)
+			pthB.bdwStats = congestion.NewBDWStats(10 * 1048576)
+			sess.perspective = protocol.PerspectiveServer
+			sess.streamToPath = make(StreamToPath)
+			sess.streamTree = newStreamTree()
+			sess.streamsMap = newStreamsMapTree(nil, protocol.PerspectiveServer, nil, sess.streamTree)
+		})
+
+		It("reports the number of streams assigned to each path", func() {
+			for _, id := range []protocol.StreamID{5, 7, 9} {
+				str := &stream{streamID: id, priority: &protocol.Priority{Weight: 16}, pathVolume: make(map[protocol.PathID]float64)}
+				str.dataForWriting = make([]byte, 1000)
+				Expect(sess.streamsMap.putStream(str)).To(Succeed())
+			}
+			Expect(sch.getState().NumStreams).To(BeEmpty())
+			_, err := sch.scheduleToMultiplePaths(sess)
+			Expect(err).ToNot(HaveOccurred())
+			state := sch.getState()
+			Expect(state.NumStreams).To(HaveKeyWithValue(pthB.pathID, uint(3)))
+			Expect(state.NumStreams).ToNot(HaveKey(pthA.pathID))
+			// the state is a copy
+			sch.numstreams[pthB.pathID] = 0
+			Expect(state.NumStreams).To(HaveKeyWithValue(pthB.pathID, uint(3)))
+		})
+
+		It("reports the number of packets sent on each path", func() {
+			sch.quotas[pthA.pathID] = 2
+			sch.quotas[pthB.pathID] = 5
+			sch.saveState()
+			Expect(sch.getState().Quotas).To(Equal(map[protocol.PathID]uint{pthA.pathID: 2, pthB.pathID: 5}))
+		})
+
+		It("reports why the paths were not selected", func() {
+			pthA.potentiallyFailed.Set(true)
+			Expect(sch.findPathLowLatency(sess)).To(Equal(pthB))
+			sch.saveState()
+			state := sch.getState()
+			Expect(state.NotSelected).To(HaveKeyWithValue(pthA.pathID, PathPotentiallyFailed))
+			// the state is a copy
+			sch.resetNotSelected()
+			Expect(state.NotSelected).To(HaveKeyWithValue(pthA.pathID, PathPotentiallyFailed))
+		})
+	})
+
+	Context("reserved bandwidth", func() {
+		var pthA, pthB *path
+
+		BeforeEach(func() {
+			// A is crowded with low priority streams
+			pthA = addPath(1, 40*time.Millisecond)
+			pthA.bdwStats = congestion.NewBDWStats(10 * 1048576)
+			pthB = addPath(3, 40*time.Millisecond)
+			pthB.bdwStats = congestion.NewBDWStats(10 * 1048576)
+			sess.streamsMap = newStreamsMapTree(nil, protocol.PerspectiveServer, nil, newStreamTree())
+			for i := 0; i < 20; i++ {
+				id := protocol.StreamID(101 + 2*i)
+				sess.streamsMap.streams[id] = &stream{streamID: id, priority: &protocol.Priority{Weight: 31}}
+				pthA.streamIDs = append(pthA.streamIDs, id)
+			}
+			sess.streamsMap.streams[5] = &stream{streamID: 5, priority: &protocol.Priority{Weight: 200}, size: 30000, checksize: true}
+			sess.streamsMap.streams[7] = &stream{streamID: 7, priority: &protocol.Priority{Weight: 31}, size: 30000, checksize: true}
+		})
+
+		It("shares the bandwidth by weight if nothing is reserved", func() {
+			selected, _ := sch.choosePaths(sess, 5, 200)
+			// 200 / (200 + 20*31) of the bandwidth of A
+			Expect(selected[pthA]).To(BeNumerically("~", 30000*0.2439/1.2439, 10))
+		})
+
+		It("keeps the reserved share of a high priority stream despite many low priority streams", func() {
+			sess.config.ReservedBandwidth = 0.5
+			selected, _ := sch.choosePaths(sess, 5, 200)
+			Expect(selected[pthA]).To(BeNumerically("~", 10000, 1))
+			Expect(selected[pthB]).To(BeNumerically("~", 20000, 1))
+		})
+
+		It("keeps the low priority streams out of the reserved bandwidth", func() {
+			sess.config.ReservedBandwidth = 0.5
+			selected, _ := sch.choosePaths(sess, 7, 31)
+			// half of 31 / (31 + 20*31) of the bandwidth of A, and half of the bandwidth of B
+			Expect(selected[pthA]).To(BeNumerically("~", 30000*0.2381/5.2381, 10))
+			Expect(selected[pthB]).To(BeNumerically("~", 30000*5/5.2381, 10))
+		})
+	})
+
+	Context("path warm-up", func() {
+		var pthA, pthB *path
+
+		BeforeEach(func() {
+			pthA = addPath(1, 40*time.Millisecond)
+			pthA.bdwStats = congestion.NewBDWStats(10 * 1048576)
+			for i := 0; i < protocol.PathWarmUpRTTSamples; i++ {
+				pthA.rttStats.UpdateRTT(40*time.Millisecond, 0, time.Now())
+			}
+			pthB = addPath(3, 40*time.Millisecond)
+			pthB.bdwStats = congestion.NewBDWStats(10 * 1048576)
+			sess.streamsMap = newStreamsMapTree(nil, protocol.PerspectiveServer, nil, newStreamTree())
+			sess.streamsMap.streams[5] = &stream{streamID: 5, priority: &protocol.Priority{Weight: 16}, size: 30000, checksize: true}
+		})
+
+		It("reduces the share of a freshly probed path, and grows it with its RTT samples", func() {
+			pthB.rttStats.UpdateRTT(40*time.Millisecond, 0, time.Now())
+			selected, _ := sch.choosePaths(sess, 5, 16)
+			// B only has 2/5 of the confidence of A
+			Expect(selected[pthB]).To(BeNumerically("~", 30000*0.4/1.4, 1))
+			Expect(selected[pthA]).To(BeNumerically("~", 30000/1.4, 1))
+
+			pthB.rttStats.UpdateRTT(40*time.Millisecond, 0, time.Now())
+			pthB.rttStats.UpdateRTT(40*time.Millisecond, 0, time.Now())
+			selected, _ = sch.choosePaths(sess, 5, 16)
+			Expect(selected[pthB]).To(BeNumerically("~", 30000*0.8/1.8, 1))
+
+			pthB.rttStats.UpdateRTT(40*time.Millisecond, 0, time.Now())
+			selected, _ = sch.choosePaths(sess, 5, 16)
+			Expect(selected[pthB]).To(BeNumerically("~", 15000, 1))
+			Expect(selected[pthA]).To(BeNumerically("~", 15000, 1))
+		})
+
+		It("doesn't change the shares of paths that are equally confident", func() {
+			pthA.rttStats.OnConnectionMigration()
+			pthA.rttStats.UpdateSessionRTT(40 * time.Millisecond)
+			selected, _ := sch.choosePaths(sess, 5, 16)
+			Expect(selected[pthA]).To(BeNumerically("~", 15000, 1))
+			Expect(selected[pthB]).To(BeNumerically("~", 15000, 1))
+		})
+	})
+
+	Context("loss recovery", func() {
+		var pthA, pthB *path
+
+		BeforeEach(func() {
+			pthA = addPath(1, 40*time.Millisecond)
+			pthA.bdwStats = congestion.NewBDWStats(10 * 1048576)
+			pthB = addPath(3, 40*time.Millisecond)
+			pthB.bdwStats = congestion.NewBDWStats(10 * 1048576)
+			sess.streamsMap = newStreamsMapTree(nil, protocol.PerspectiveServer, nil, newStreamTree())
+			sess.streamsMap.streams[5] = &stream{streamID: 5, priority: &protocol.Priority{Weight: 16}, size: 30000, checksize: true}
+		})
+
+		It("reduces the share of a path in recovery until it exits recovery", func() {
+			pthA.sentPacketHandler.(*mockSentPacketHandler).inRecovery = true
+			selected, _ := sch.choosePaths(sess, 5, 16)
+			Expect(selected[pthA]).To(BeNumerically("~", 10000, 1))
+			Expect(selected[pthB]).To(BeNumerically("~", 20000, 1))
+
+			pthA.sentPacketHandler.(*mockSentPacketHandler).inRecovery = false
+			selected, _ = sch.choosePaths(sess, 5, 16)
+			Expect(selected[pthA]).To(BeNumerically("~", 15000, 1))
+			Expect(selected[pthB]).To(BeNumerically("~", 15000, 1))
+		})
+	})
+
+	Context("recorded path statistics", func() {
+		var pthA, pthB *path
+
+		BeforeEach(func() {
+			pthA = addPath(1, 40*time.Millisecond)
+			pthA.bdwStats = congestion.NewBDWStats(10 * 1048576)
+			pthB = addPath(3, 40*time.Millisecond)
+			pthB.bdwStats = congestion.NewBDWStats(10 * 1048576)
+			sess.streamsMap = newStreamsMapTree(nil, protocol.PerspectiveServer, nil, newStreamTree())
+			sess.streamsMap.streams[5] = &stream{streamID: 5, priority: &protocol.Priority{Weight: 16}, size: 30000, checksize: true}
+		})
+
+		It("chooses the same paths when replaying a recorded series", func() {
+			buf := &bytes.Buffer{}
+			start := time.Now()
+			recorder := newPathStatsRecorder(buf, start)
+			Expect(recorder.record(start, pthA)).To(Succeed())
+			Expect(recorder.record(start, pthB)).To(Succeed())
+			first, _ := sch.choosePaths(sess, 5, 16)
+			pthA.rttStats = congestion.NewRTTStatsWithSmoothedRTT(10 * time.Millisecond)
+			pthA.bdwStats = congestion.NewBDWStats(20 * 1048576)
+			Expect(recorder.record(start.Add(time.Second), pthA)).To(Succeed())
+			Expect(recorder.flush()).To(Succeed())
+			second, _ := sch.choosePaths(sess, 5, 16)
+			Expect(second).ToNot(Equal(first))
+
+			// the live conditions are different when replaying
+			pthA.rttStats = congestion.NewRTTStatsWithSmoothedRTT(200 * time.Millisecond)
+			pthA.bdwStats = congestion.NewBDWStats(1048576)
+			pthB.bdwStats = congestion.NewBDWStats(5 * 1048576)
+			replayStart := time.Now()
+			replayer, err := newPathStatsReplayer(buf, replayStart)
+			Expect(err).ToNot(HaveOccurred())
+			replayer.replay(replayStart, sess.paths)
+			selected, _ := sch.choosePaths(sess, 5, 16)
+			Expect(selected).To(Equal(first))
+			replayer.replay(replayStart.Add(time.Second), sess.paths)
+			selected, _ = sch.choosePaths(sess, 5, 16)
+			Expect(selected).To(Equal(second))
+		})
+	})
+
+	Context("stream cap per path", func() {
+		var pthA, pthB *path
+
+		BeforeEach(func() {
+			pthA = addPath(1, 10*time.Millisecond)
+			pthA.bdwStats = congestion.NewBDWStats(10 * 1048576)
+			pthB = addPath(3, 40*time.Millisecond)
+			pthB.bdwStats = congestion.NewBDWStats(10 * 1048576)
+			sess.config.MaxStreamsPerPath = 2
+			sess.config.MaxPathsPerStream = 1
+			sess.streamsMap = newStreamsMapTree(nil, protocol.PerspectiveServer, nil, newStreamTree())
+			sess.streamsMap.streams[5] = &stream{streamID: 5, priority: &protocol.Priority{Weight: 16}, size: 30000, checksize: true}
+			sch.numstreams = map[protocol.PathID]uint{1: 1, 3: 1}
+		})
+
+		It("assigns new streams to the lowest-RTT path until it reaches the cap", func() {
+			selected, result := sch.choosePaths(sess, 5, 16)
+			Expect(result).To(Equal(pathsChosen))
+			Expect(selected).To(HaveLen(1))
+			Expect(selected).To(HaveKey(pthA))
+		})
+
+		It("skips a path that reached the cap", func() {
+			sch.numstreams[1] = 2
+			selected, result := sch.choosePaths(sess, 5, 16)
+			Expect(result).To(Equal(pathsChosen))
+			Expect(selected).To(HaveLen(1))
+			Expect(selected).To(HaveKey(pthB))
+			Expect(sch.getPathsNotSelected()[pthA.pathID]).To(Equal(PathStreamCapReached))
+		})
+
+		It("doesn't find a path once all paths reached the cap", func() {
+			Expect(sch.findPath(sess, 5, 16)).To(Equal(pthA))
+			sch.numstreams[1] = 2
+			sch.numstreams[3] = 2
+			Expect(sch.findPath(sess, 5, 16)).To(BeNil())
+		})
+
+		It("applies the cap to the initial path if it is used like any other path", func() {
+			sess.config.InitialPathPolicy = InitialPathNormal
+			sch.numstreams = map[protocol.PathID]uint{protocol.InitialPathID: 2, 1: 2, 3: 2}
+			Expect(sch.checkPathQuota(sess)).To(BeEmpty())
+			Expect(sch.findPath(sess, 5, 16)).To(BeNil())
+		})
+	})
+
+	Context("striped streams", func() {
+		var pthA, pthB *path
+		var str *stream
+
+		BeforeEach(func() {
+			pthA = addPath(1, 100*time.Millisecond)
+			pthA.bdwStats = congestion.NewBDWStats(30 * 1048576)
+			pthB = addPath(3, 10*time.Millisecond)
+			pthB.bdwStats = congestion.NewBDWStats(10 * 1048576)
+			sess.perspective = protocol.PerspectiveServer
+			sess.streamToPath = make(StreamToPath)
+			sess.streamTree = newStreamTree()
+			sess.streamsMap = newStreamsMapTree(nil, protocol.PerspectiveServer, nil, sess.streamTree)
+			str = &stream{streamID: 5, priority: &protocol.Priority{Weight: 16}, pathVolume: make(map[protocol.PathID]float64)}
+			Expect(sess.streamsMap.putStream(str)).To(Succeed())
+		})
+
+		It("doesn't assign a normal stream before its size is detected", func() {
+			_, err := sch.scheduleToMultiplePaths(sess)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(sess.streamToPath).ToNot(HaveKey(protocol.StreamID(5)))
+		})
+
+		It("splits a striped stream across all paths on the first pass", func() {
+			str.striped.Set(true)
+			str.dataForWriting = make([]byte, 4000)
+			_, err := sch.scheduleToMultiplePaths(sess)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(sess.streamToPath[5]).To(ConsistOf(pthA.pathID, pthB.pathID))
+			Expect(str.pathVolume[pthA.pathID]).To(BeNumerically("~", 3000, 1))
+			Expect(str.pathVolume[pthB.pathID]).To(BeNumerically("~", 1000, 1))
+			Expect(str.checksize).To(BeFalse())
+		})
+
+		It("splits a striped stream before it has data", func() {
+			str.striped.Set(true)
+			_, err := sch.scheduleToMultiplePaths(sess)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(sess.streamToPath[5]).To(ConsistOf(pthA.pathID, pthB.pathID))
+		})
+
+		It("splits the data written after the first pass on the paths of a striped stream", func() {
+			str.striped.Set(true)
+			_, err := sch.scheduleToMultiplePaths(sess)
+			Expect(err).ToNot(HaveOccurred())
+			str.dataForWriting = make([]byte, 4000)
+			_, err = sch.scheduleToMultiplePaths(sess)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(str.pathVolume[pthA.pathID]).To(BeNumerically("~", 3000, 1))
+			Expect(str.pathVolume[pthB.pathID]).To(BeNumerically("~", 1000, 1))
+			// the volumes left still cover the buffered data
+			_, err = sch.scheduleToMultiplePaths(sess)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(str.pathVolume[pthA.pathID]).To(BeNumerically("~", 3000, 1))
+		})
+
+		It("doesn't split a striped stream on a path it isn't striped on", func() {
+			str.striped.Set(true)
+			_, err := sch.scheduleToMultiplePaths(sess)
+			Expect(err).ToNot(HaveOccurred())
+			pthC := addPath(5, 10*time.Millisecond)
+			pthC.bdwStats = congestion.NewBDWStats(10 * 1048576)
+			str.dataForWriting = make([]byte, 4000)
+			_, err = sch.scheduleToMultiplePaths(sess)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(str.pathVolume).ToNot(HaveKey(pthC.pathID))
+			Expect(sess.streamToPath[5]).ToNot(ContainElement(pthC.pathID))
+		})
+	})
+
+	Context("empty streams", func() {
+		var pthA, pthB *path
+		var str *stream
+
+		BeforeEach(func() {
+			pthA = addPath(1, 100*time.Millisecond)
+			pthA.bdwStats = congestion.NewBDWStats(30 * 1048576)
+			pthB = addPath(3, 10*time.Millisecond)
+			pthB.bdwStats = congestion.NewBDWStats(10 * 1048576)
+			sess.perspective = protocol.PerspectiveServer
+			sess.streamToPath = make(StreamToPath)
+			sess.streamTree = newStreamTree()
+			sess.streamsMap = newStreamsMapTree(nil, protocol.PerspectiveServer, nil, sess.streamTree)
+			str = &stream{streamID: 5, priority: &protocol.Priority{Weight: 16}, pathVolume: make(map[protocol.PathID]float64)}
+			Expect(sess.streamsMap.putStream(str)).To(Succeed())
+		})
+
+		It("doesn't assign an open stream without data", func() {
+			_, result := sch.choosePaths(sess, 5, 16)
+			Expect(result).To(Equal(pathsRetryLater))
+		})
+
+		It("assigns a stream that only has its FIN to send to the lowest RTT path", func() {
+			str.finishedWriting.Set(true)
+			selected, result := sch.choosePaths(sess, 5, 16)
+			Expect(result).To(Equal(pathsChosen))
+			Expect(selected).To(Equal(map[*path]float64{pthB: 0}))
+		})
+
+		It("sends the FIN of a stream closed without data on the lowest RTT path", func() {
+			mockFcm := mocks_fc.NewMockFlowControlManager(mockCtrl)
+			mockFcm.EXPECT().AddBytesSent(protocol.StreamID(5), protocol.ByteCount(0))
+			mockFcm.EXPECT().RemainingConnectionWindowSize().Return(protocol.MaxByteCount)
+			sess.streamFramer = newStreamFramer(sess.streamsMap, mockFcm)
+			str.finishedWriting.Set(true)
+			_, err := sch.scheduleToMultiplePaths(sess)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(sess.streamToPath[5]).To(Equal([]protocol.PathID{pthB.pathID}))
+			Expect(sess.streamFramer.PopStreamFramesOfPath(1000, pthA)).To(BeEmpty())
+			frames := sess.streamFramer.PopStreamFramesOfPath(1000, pthB)
+			Expect(frames).To(HaveLen(1))
+			Expect(frames[0].StreamID).To(Equal(protocol.StreamID(5)))
+			Expect(frames[0].FinBit).To(BeTrue())
+			Expect(frames[0].Data).To(BeEmpty())
+		})
+	})
+
+	Context("rescheduled streams", func() {
+		var pthA, pthB *path
+		var str *stream
+
+		BeforeEach(func() {
+			pthA = addPath(1, 100*time.Millisecond)
+			pthA.bdwStats = congestion.NewBDWStats(30 * 1048576)
+			pthB = addPath(3, 10*time.Millisecond)
+			pthB.bdwStats = congestion.NewBDWStats(10 * 1048576)
+			sess.perspective = protocol.PerspectiveServer
+			sess.streamToPath = make(StreamToPath)
+			sess.streamTree = newStreamTree()
+			sess.streamsMap = newStreamsMapTree(nil, protocol.PerspectiveServer, nil, sess.streamTree)
+			mockFcm := mocks_fc.NewMockFlowControlManager(mockCtrl)
+			mockFcm.EXPECT().SendWindowSize(gomock.Any()).Return(protocol.MaxByteCount, nil).AnyTimes()
+			mockFcm.EXPECT().AddBytesSent(gomock.Any(), gomock.Any()).AnyTimes()
+			mockFcm.EXPECT().AddBytesRetrans(gomock.Any(), gomock.Any()).AnyTimes()
+			mockFcm.EXPECT().RemainingConnectionWindowSize().Return(protocol.MaxByteCount).AnyTimes()
+			sess.flowControlManager = mockFcm
+			sess.streamFramer = newStreamFramer(sess.streamsMap, mockFcm)
+			// stream 5 is in flight on path A
+			str = &stream{streamID: 5, priority: &protocol.Priority{Weight: 16}, writeOffset: 100, checksize: true, windowLimited: true}
+			str.pathVolume = map[protocol.PathID]float64{pthA.pathID: 0}
+			Expect(sess.streamsMap.putStream(str)).To(Succeed())
+			sess.streamToPath.Add(5, pthA.pathID)
+			pthA.streamIDs = []protocol.StreamID{5}
+			sch.numstreams = map[protocol.PathID]uint{pthA.pathID: 1}
+			sess.streamFramer.AddFrameForRetransmission(&wire.StreamFrame{StreamID: 5, Offset: 20, Data: bytes.Repeat([]byte{'r'}, 30)})
+		})
+
+		popAll := func(pth *path) []*wire.StreamFrame {
+			var frames []*wire.StreamFrame
+			for {
+				fs := sess.streamFramer.PopStreamFramesOfPath(1000, pth)
+				if len(fs) == 0 {
+					return frames
+				}
+				frames = append(frames, fs...)
+			}
+		}
+
+		It("hands the data of a stream over to its new paths, without sending on the old path after the move", func() {
+			str.dataForWriting = bytes.Repeat([]byte{'f'}, 3000)
+			sch.releaseWindowLimitedStream(sess, str)
+			Expect(sess.streamToPath).ToNot(HaveKey(protocol.StreamID(5)))
+			Expect(popAll(pthA)).To(BeEmpty())
+			Expect(popAll(pthB)).To(BeEmpty())
+
+			_, err := sch.scheduleToMultiplePaths(sess)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(sess.streamToPath).To(HaveKey(protocol.StreamID(5)))
+			var frames []*wire.StreamFrame
+			for _, pathID := range sess.streamToPath[5] {
+				frames = append(frames, popAll(sess.paths[pathID])...)
+			}
+			// the retransmission and all new data are sent exactly once
+			received := make(map[protocol.ByteCount]bool)
+			for _, f := range frames {
+				Expect(f.StreamID).To(Equal(protocol.StreamID(5)))
+				for o := f.Offset; o < f.Offset+f.DataLen(); o++ {
+					Expect(received).ToNot(HaveKey(o))
+					received[o] = true
+				}
+			}
+			Expect(received).To(HaveLen(30 + 3000))
+			for o := protocol.ByteCount(20); o < 50; o++ {
+				Expect(received).To(HaveKey(o))
+			}
+			for o := protocol.ByteCount(100); o < 3100; o++ {
+				Expect(received).To(HaveKey(o))
+			}
+		})
+
+		It("assigns a rescheduled stream that only has retransmissions left to the lowest RTT path", func() {
+			sch.releaseWindowLimitedStream(sess, str)
+			Expect(popAll(pthA)).To(BeEmpty())
+			_, err := sch.scheduleToMultiplePaths(sess)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(sess.streamToPath[5]).To(Equal([]protocol.PathID{pthB.pathID}))
+			Expect(popAll(pthA)).To(BeEmpty())
+			frames := popAll(pthB)
+			Expect(frames).To(HaveLen(1))
+			Expect(frames[0].Offset).To(Equal(protocol.ByteCount(20)))
+			Expect(frames[0].DataLen()).To(Equal(protocol.ByteCount(30)))
+		})
+	})
+
+	Context("initial path policy", func() {
+		var initialPath, pthA *path
+
+		BeforeEach(func() {
+			initialPath = sess.paths[protocol.InitialPathID]
+			initialPath.rttStats = congestion.NewRTTStatsWithSmoothedRTT(50 * time.Millisecond)
+			pthA = addPath(1, 100*time.Millisecond)
+			sess.perspective = protocol.PerspectiveClient
+			sess.streamToPath = make(StreamToPath)
+			sess.streamTree = newStreamTree()
+			sess.streamsMap = newStreamsMapTree(nil, protocol.PerspectiveClient, nil, sess.streamTree)
+		})
+
+		It("avoids the initial path by default", func() {
+			Expect(sch.findPathLowLatency(sess)).To(Equal(pthA))
+			Expect(sch.getPathsNotSelected()[protocol.InitialPathID]).To(Equal(PathInitialAvoided))
+		})
+
+		Context("early data of the client", func() {
+			var pthB *path
+			var str *stream
+
+			BeforeEach(func() {
+				initialPath.rttStats.UpdateRTT(50*time.Millisecond, 0, time.Now())
+				pthA.rttStats = &congestion.RTTStats{}
+				pthB = addPath(3, 0)
+				sch.quotas = make(map[protocol.PathID]uint)
+				str = &stream{streamID: 5, priority: &protocol.Priority{Weight: 1}, pathVolume: make(map[protocol.PathID]float64)}
+				Expect(sess.streamsMap.putStream(str)).To(Succeed())
+			})
+
+			It("assigns streams to the handshake path while the other paths are unprobed", func() {
+				_, err := sch.scheduleToMultiplePaths(sess)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(sess.streamToPath[5]).To(Equal([]protocol.PathID{protocol.InitialPathID}))
+				Expect(initialPath.streamIDs).To(Equal([]protocol.StreamID{5}))
+			})
+
+			It("assigns streams to the handshake path while the other paths only have an estimated RTT", func() {
+				pthA.rttStats = congestion.NewRTTStatsWithSmoothedRTT(10 * time.Millisecond)
+				_, err := sch.scheduleToMultiplePaths(sess)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(sess.streamToPath[5]).To(Equal([]protocol.PathID{protocol.InitialPathID}))
+			})
+
+			It("assigns streams to the lowest RTT path once another path got an RTT sample", func() {
+				pthB.rttStats.UpdateRTT(80*time.Millisecond, 0, time.Now())
+				_, err := sch.scheduleToMultiplePaths(sess)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(sess.streamToPath[5]).To(Equal([]protocol.PathID{pthB.pathID}))
+			})
+
+			It("doesn't assign streams to the handshake path if it only carries ACKs", func() {
+				sess.config.InitialPathPolicy = InitialPathAckOnly
+				_, err := sch.scheduleToMultiplePaths(sess)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(sess.streamToPath[5]).To(HaveLen(1))
+				Expect(sess.streamToPath[5][0]).ToNot(Equal(protocol.InitialPathID))
+			})
+		})
+
+		It("treats the initial path like any other path", func() {
+			sess.config.InitialPathPolicy = InitialPathNormal
+			Expect(sch.findPathLowLatency(sess)).To(Equal(initialPath))
+			str := &stream{streamID: 5, priority: &protocol.Priority{Weight: 1}, pathVolume: make(map[protocol.PathID]float64)}
+			Expect(sess.streamsMap.putStream(str)).To(Succeed())
+			_, err := sch.scheduleToMultiplePaths(sess)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(sess.streamToPath[5]).To(Equal([]protocol.PathID{protocol.InitialPathID}))
+			Expect(initialPath.streamIDs).To(Equal([]protocol.StreamID{5}))
+		})
+
+		It("keeps the streams assigned to the initial path before the other paths existed", func() {
+			str := &stream{streamID: 5, priority: &protocol.Priority{Weight: 1}, pathVolume: map[protocol.PathID]float64{protocol.InitialPathID: 0}}
+			Expect(sess.streamsMap.putStream(str)).To(Succeed())
+			sess.streamToPath.Add(5, protocol.InitialPathID)
+			initialPath.streamIDs = []protocol.StreamID{5}
+			_, err := sch.scheduleToMultiplePaths(sess)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(sess.streamToPath[5]).To(Equal([]protocol.PathID{protocol.InitialPathID}))
+			Expect(initialPath.streamIDs).To(Equal([]protocol.StreamID{5}))
+		})
+
+		It("moves the streams of the initial path to the other paths if it only carries ACKs", func() {
+			sess.config.InitialPathPolicy = InitialPathAckOnly
+			str := &stream{streamID: 5, priority: &protocol.Priority{Weight: 1}, pathVolume: map[protocol.PathID]float64{protocol.InitialPathID: 0}}
+			Expect(sess.streamsMap.putStream(str)).To(Succeed())
+			sess.streamToPath.Add(5, protocol.InitialPathID)
+			initialPath.streamIDs = []protocol.StreamID{5}
+			_, err := sch.scheduleToMultiplePaths(sess)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(initialPath.streamIDs).To(BeEmpty())
+			Expect(sess.streamToPath[5]).To(Equal([]protocol.PathID{pthA.pathID}))
+			Expect(pthA.streamIDs).To(Equal([]protocol.StreamID{5}))
+			Expect(str.pathVolume).To(Equal(map[protocol.PathID]float64{pthA.pathID: 0}))
+		})
+	})
+})
@@ -0,0 +1,65 @@
+package quic
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/lucas-clemente/pstream/internal/protocol"
+)
+
+// A sendRateLimiter is a token bucket limiting the rate of the packets sent on all paths together,
+// or of the data of a stream, see Stream.SetMaxSendRate
+type sendRateLimiter struct {
+	mutex sync.Mutex
+
+	rate       float64 // bytes per second
+	burst      float64 // bytes
+	tokens     float64
+	lastUpdate time.Time
+}
+
+func newSendRateLimiter(rate uint64) *sendRateLimiter {
+	burst := math.Max(float64(rate)*protocol.SendRateBurstDuration.Seconds(), float64(protocol.MaxPacketSize))
+	return &sendRateLimiter{
+		rate:   float64(rate),
+		burst:  burst,
+		tokens: burst,
+	}
+}
+
+// the caller holds the mutex
+func (l *sendRateLimiter) refill(now time.Time) {
+	if !l.lastUpdate.IsZero() && now.After(l.lastUpdate) {
+		l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.lastUpdate).Seconds()*l.rate)
+	}
+	l.lastUpdate = now
+}
+
+// sendingAllowed says if a packet may be sent now.
+// A packet may be larger than the tokens left, the following packets then wait until the debt is paid back.
+func (l *sendRateLimiter) sendingAllowed(now time.Time) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.refill(now)
+	return l.tokens > 0
+}
+
+func (l *sendRateLimiter) onPacketSent(now time.Time, length protocol.ByteCount) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.refill(now)
+	l.tokens -= float64(length)
+}
+
+// timeUntilSend returns the time at which sending is allowed again, or a zero time if it is allowed now
+func (l *sendRateLimiter) timeUntilSend(now time.Time) time.Time {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.refill(now)
+	if l.tokens > 0 {
+		return time.Time{}
+	}
+	// wait a bit longer than the debt, such that some tokens are available
+	return now.Add(time.Duration(-l.tokens/l.rate*float64(time.Second)) + time.Microsecond)
+}
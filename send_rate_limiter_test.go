@@ -0,0 +1,69 @@
+package quic
+
+import (
+	"time"
+
+	"github.com/lucas-clemente/pstream/internal/protocol"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Send rate limiter", func() {
+	var (
+		limiter *sendRateLimiter
+		now     time.Time
+	)
+
+	BeforeEach(func() {
+		// 1 MB/s, such that 10 kB can be sent in a burst
+		limiter = newSendRateLimiter(1000000)
+		now = time.Now()
+	})
+
+	It("allows sending a burst", func() {
+		for i := 0; i < 10; i++ {
+			Expect(limiter.sendingAllowed(now)).To(BeTrue())
+			limiter.onPacketSent(now, 1000)
+		}
+		Expect(limiter.sendingAllowed(now)).To(BeFalse())
+	})
+
+	It("allows sending once the tokens are refilled", func() {
+		limiter.onPacketSent(now, 11000)
+		Expect(limiter.sendingAllowed(now)).To(BeFalse())
+		Expect(limiter.timeUntilSend(now)).To(BeTemporally("~", now.Add(time.Millisecond), time.Microsecond))
+		Expect(limiter.sendingAllowed(now.Add(999 * time.Microsecond))).To(BeFalse())
+		Expect(limiter.timeUntilSend(now.Add(2 * time.Millisecond)).IsZero()).To(BeTrue())
+		Expect(limiter.sendingAllowed(now.Add(2 * time.Millisecond))).To(BeTrue())
+	})
+
+	It("doesn't accumulate more tokens than the burst", func() {
+		Expect(limiter.sendingAllowed(now)).To(BeTrue())
+		now = now.Add(time.Hour)
+		limiter.onPacketSent(now, 10000)
+		Expect(limiter.sendingAllowed(now)).To(BeFalse())
+	})
+
+	It("allows a burst of at least one packet at low rates", func() {
+		limiter = newSendRateLimiter(1000)
+		Expect(limiter.burst).To(BeNumerically("==", protocol.MaxPacketSize))
+	})
+
+	It("keeps the bytes sent on all paths under the rate", func() {
+		sent := make(map[protocol.PathID]protocol.ByteCount)
+		start := now
+		for i := 0; now.Before(start.Add(time.Second)); now = now.Add(100 * time.Microsecond) {
+			for limiter.sendingAllowed(now) {
+				// the packets are sent round-robin on two paths
+				sent[protocol.PathID(1+2*(i%2))] += protocol.MaxPacketSize
+				limiter.onPacketSent(now, protocol.MaxPacketSize)
+				i++
+			}
+		}
+		total := sent[1] + sent[3]
+		Expect(total).To(BeNumerically("<=", 1000000+10000+protocol.MaxPacketSize))
+		Expect(total).To(BeNumerically(">", 990000))
+		Expect(sent[1]).To(BeNumerically("~", sent[3], protocol.MaxPacketSize))
+	})
+})
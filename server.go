@@ -20,7 +20,6 @@ import (
 type packetHandler interface {
 	Session
 	handlePacket(*receivedPacket)
-	GetVersion() protocol.VersionNumber
 	run() error
 	closeRemote(error)
 }
@@ -69,7 +68,7 @@ func ListenAddrImpl(addr string, tlsConf *tls.Config, config *Config, pconnMgrAr
 
 	if pconnMgrArg == nil {
 		// Create the pconnManager here. It will be used to start udp connections
-		pconnMgr = &pconnManager{perspective: protocol.PerspectiveServer}
+		pconnMgr = &pconnManager{perspective: protocol.PerspectiveServer, config: config}
 		// XXX (QDC): make this cleaner
 		pconn, err := net.ListenUDP("udp", udpAddr)
 		if err != nil {
@@ -93,7 +92,7 @@ func ListenAddrImpl(addr string, tlsConf *tls.Config, config *Config, pconnMgrAr
 // The tls.Config must not be nil, the quic.Config may be nil.
 func Listen(pconn net.PacketConn, tlsConf *tls.Config, config *Config) (Listener, error) {
 	// Create the pconnManager here. It will be used to start udp connections
-	pconnMgr := &pconnManager{perspective: protocol.PerspectiveServer}
+	pconnMgr := &pconnManager{perspective: protocol.PerspectiveServer, config: config}
 	err := pconnMgr.setup(pconn, nil)
 	if err != nil {
 		return nil, err
@@ -119,7 +118,7 @@ func ListenImpl(pconn net.PacketConn, tlsConf *tls.Config, config *Config, pconn
 	var pconnMgr *pconnManager
 
 	if pconnMgrArg == nil {
-		pconnMgr = &pconnManager{perspective: protocol.PerspectiveServer}
+		pconnMgr = &pconnManager{perspective: protocol.PerspectiveServer, config: config}
 		err := pconnMgr.setup(pconn, nil)
 		if err != nil {
 			return nil, err
@@ -173,6 +172,7 @@ func populateServerConfig(config *Config) *Config {
 	if len(versions) == 0 {
 		versions = protocol.SupportedVersions
 	}
+	versions = protocol.PreferMultipath(versions, !config.DisableMultipath)
 
 	vsa := defaultAcceptCookie
 	if config.AcceptCookie != nil {
@@ -201,8 +201,29 @@ func populateServerConfig(config *Config) *Config {
 	if pathScheduler == "" {
 		pathScheduler = protocol.DefaultPathScheduler
 	}
+	pathSwitchMargin := config.PathSwitchMargin
+	if pathSwitchMargin == 0 {
+		pathSwitchMargin = protocol.DefaultPathSwitchMargin
+	}
+	highPriorityWeight := config.HighPriorityWeight
+	if highPriorityWeight == 0 {
+		highPriorityWeight = protocol.DefaultHighPriorityWeight
+	}
+	minPathsForSplit := config.MinPathsForSplit
+	if minPathsForSplit == 0 {
+		minPathsForSplit = protocol.DefaultMinPathsForSplit
+	}
+	pathSendBatchSize := config.PathSendBatchSize
+	if pathSendBatchSize == 0 {
+		pathSendBatchSize = protocol.DefaultPathSendBatchSize
+	}
+	maxRemotePaths := config.MaxRemotePaths
+	if maxRemotePaths == 0 {
+		maxRemotePaths = protocol.DefaultMaxRemotePaths
+	}
 	return &Config{
 		Versions:                              versions,
+		DisableMultipath:                      config.DisableMultipath,
 		HandshakeTimeout:                      handshakeTimeout,
 		IdleTimeout:                           idleTimeout,
 		AcceptCookie:                          vsa,
@@ -210,6 +231,50 @@ func populateServerConfig(config *Config) *Config {
 		MaxReceiveStreamFlowControlWindow:     maxReceiveStreamFlowControlWindow,
 		MaxReceiveConnectionFlowControlWindow: maxReceiveConnectionFlowControlWindow,
 		PathScheduler:                         pathScheduler,
+		PathCost:                              config.PathCost,
+		PathGroup:                             config.PathGroup,
+		PathGroupBandwidth:                    config.PathGroupBandwidth,
+		PathMTU:                               config.PathMTU,
+		RTTEstimator:                          config.RTTEstimator,
+		Metrics:                               config.Metrics,
+		InitialPathPolicy:                     config.InitialPathPolicy,
+		AckPathPolicy:                         config.AckPathPolicy,
+		PathsFrameOrder:                       config.PathsFrameOrder,
+		PathSwitchMargin:                      pathSwitchMargin,
+		ReservedBandwidth:                     config.ReservedBandwidth,
+		HighPriorityWeight:                    highPriorityWeight,
+		DumpSchedulingInputs:                  config.DumpSchedulingInputs,
+		ControlStreams:                        config.ControlStreams,
+		SocketReceiveBufferSize:               config.SocketReceiveBufferSize,
+		SocketSendBufferSize:                  config.SocketSendBufferSize,
+		MaxCoalescedPackets:                   config.MaxCoalescedPackets,
+		ResetCongestionOnMigration:            config.ResetCongestionOnMigration,
+		PacketReorderingThreshold:             config.PacketReorderingThreshold,
+		IgnoreClosePathLosses:                 config.IgnoreClosePathLosses,
+		StreamFrameChecksums:                  config.StreamFrameChecksums,
+		PotentiallyFailedRTOs:                 config.PotentiallyFailedRTOs,
+		MaxTrackedSkippedPackets:              config.MaxTrackedSkippedPackets,
+		MaxReassemblyBuffer:                   config.MaxReassemblyBuffer,
+		PacingGain:                            config.PacingGain,
+		LostPacketHandler:                     config.LostPacketHandler,
+		MaxSendRate:                           config.MaxSendRate,
+		SendWindowUpdatesOnce:                 config.SendWindowUpdatesOnce,
+		SendTimestamps:                        config.SendTimestamps,
+		MaxAckPacketTolerance:                 config.MaxAckPacketTolerance,
+		MinPathsForSplit:                      minPathsForSplit,
+		MinDetectedStreamSize:                 config.MinDetectedStreamSize,
+		MaxPathsPerStream:                     config.MaxPathsPerStream,
+		MaxStreamsPerPath:                     config.MaxStreamsPerPath,
+		PathKeepAlivePeriod:                   config.PathKeepAlivePeriod,
+		PathSendBatchSize:                     pathSendBatchSize,
+		MaxPathRetransmissionRate:             config.MaxPathRetransmissionRate,
+		MaxRemotePaths:                        maxRemotePaths,
+		RequireAdvertisedRemotePaths:          config.RequireAdvertisedRemotePaths,
+		DatagramHandler:                       config.DatagramHandler,
+		StreamResetHandler:                    config.StreamResetHandler,
+		PathStatsRecorder:                     config.PathStatsRecorder,
+		PathStatsReplay:                       config.PathStatsReplay,
+		TraceWriter:                           config.TraceWriter,
 	}
 }
 
@@ -302,7 +367,7 @@ func (s *server) handlePacket(rcvRawPacket *receivedRawPacket) error {
 
 	version := protocol.VersionUnknown
 	if ok {
-		version = session.GetVersion()
+		version = session.Version()
 	}
 
 	hdr, err := wire.ParsePublicHeader(r, protocol.PerspectiveClient, version)
@@ -63,16 +63,66 @@ func (s *mockSession) OpenStream() (Stream, error) {
 }
 func (s *mockSession) AcceptStream() (Stream, error)   { panic("not implemented") }
 func (s *mockSession) OpenStreamSync() (Stream, error) { panic("not implemented") }
+func (s *mockSession) AcceptStreamContext(context.Context) (Stream, error) {
+	panic("not implemented")
+}
 func (s *mockSession) OpenStreamPrioritySync(*protocol.Priority) (Stream, error) {
 	panic("not implemented")
 }
 func (s *mockSession) OpenStreamPrioritySizeSync(*protocol.Priority) (Stream, error) {
 	panic("not implemented")
 }
-func (s *mockSession) LocalAddr() net.Addr              { panic("not implemented") }
-func (s *mockSession) RemoteAddr() net.Addr             { return s.remoteAddr }
-func (*mockSession) Context() context.Context           { panic("not implemented") }
-func (*mockSession) GetVersion() protocol.VersionNumber { return protocol.VersionWhatever }
+func (s *mockSession) OpenStreamStriped() (Stream, error) {
+	panic("not implemented")
+}
+func (s *mockSession) CloseGracefully(time.Duration) error {
+	return s.Close(nil)
+}
+func (s *mockSession) PathCongestionWindow(protocol.PathID) (protocol.ByteCount, error) {
+	panic("not implemented")
+}
+func (s *mockSession) PathAckRanges(protocol.PathID) ([]AckRange, error) {
+	panic("not implemented")
+}
+func (s *mockSession) Paths() []PathInfo {
+	panic("not implemented")
+}
+func (s *mockSession) PathStats() []PathStats {
+	panic("not implemented")
+}
+func (s *mockSession) StreamPending(protocol.StreamID) protocol.ByteCount {
+	panic("not implemented")
+}
+func (s *mockSession) BytesInFlight() protocol.ByteCount {
+	panic("not implemented")
+}
+func (s *mockSession) SendDatagram([]byte) error {
+	panic("not implemented")
+}
+func (s *mockSession) SmoothedRTT() time.Duration {
+	panic("not implemented")
+}
+func (s *mockSession) SchedulerState() SchedulerState {
+	panic("not implemented")
+}
+func (s *mockSession) SetCongestionWindow(protocol.PathID, protocol.ByteCount) error {
+	panic("not implemented")
+}
+func (s *mockSession) SetScheduler(string) error {
+	panic("not implemented")
+}
+func (s *mockSession) Version() protocol.VersionNumber {
+	return protocol.VersionWhatever
+}
+func (s *mockSession) HandshakeDuration() time.Duration {
+	panic("not implemented")
+}
+func (s *mockSession) MultipathEnabled() bool {
+	return false
+}
+func (s *mockSession) LocalAddr() net.Addr    { panic("not implemented") }
+func (s *mockSession) RemoteAddr() net.Addr   { return s.remoteAddr }
+func (*mockSession) Context() context.Context { panic("not implemented") }
 
 var _ Session = &mockSession{}
 var _ NonFWSession = &mockSession{}
@@ -6,7 +6,10 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"os"
+	"sort"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/lucas-clemente/pstream/ackhandler"
@@ -34,8 +37,16 @@ type receivedPacket struct {
 var (
 	errRstStreamOnInvalidStream   = errors.New("RST_STREAM received for unknown stream")
 	errWindowUpdateOnClosedStream = errors.New("WINDOW_UPDATE received for an already closed stream")
+	errUnknownPath                = errors.New("Unknown path ID")
+	errCongestionWindowOverride   = errors.New("Overriding the congestion window requires debug logging")
+	errUnknownPathScheduler       = errors.New("Unknown path scheduler")
+	errDatagramTooLarge           = errors.New("Datagram too large to fit in a packet")
+	errTooManyQueuedDatagrams     = errors.New("Too many datagrams waiting to be sent")
 )
 
+// drainCheckInterval is how often CloseGracefully checks if all buffered stream data was sent
+const drainCheckInterval = 5 * time.Millisecond
+
 var (
 	newCryptoSetup       = handshake.NewCryptoSetup
 	newCryptoSetupClient = handshake.NewCryptoSetupClient
@@ -76,6 +87,15 @@ type session struct {
 	remoteRTTs         map[protocol.PathID]time.Duration
 	lastPathsFrameSent time.Time
 
+	// the state of the paths reported to the application, saved by the run loop
+	pathsSnapshotMutex sync.RWMutex
+	pathsSnapshot      pathsSnapshot
+	// set when a PATHS frame is needed by code that may hold the paths lock, the run loop schedules it
+	pathsFrameNeeded utils.AtomicBool
+	// the congestion windows set by SetCongestionWindow, the run loop applies them to the paths
+	congestionWindowOverridesMutex sync.Mutex
+	congestionWindowOverrides      map[protocol.PathID]protocol.ByteCount
+
 	streamFramer *streamFramer
 
 	flowControlManager flowcontrol.FlowControlManager
@@ -117,6 +137,9 @@ type session struct {
 
 	sessionCreationTime     time.Time
 	lastNetworkActivityTime time.Time
+	// time the handshake completed, zero until then
+	handshakeCompleteTimeMutex sync.RWMutex
+	handshakeCompleteTime      time.Time
 
 	timer *utils.Timer
 	// keepAlivePingSent stores whether a Ping frame was sent to the peer or not
@@ -129,8 +152,20 @@ type session struct {
 	pathManagerLaunched bool
 
 	scheduler *scheduler
+	// limits the rate of all paths together, nil if Config.MaxSendRate is not set
+	sendRateLimiter *sendRateLimiter
+	// the earliest time a stream waiting for Config.MinDetectedStreamSize is scheduled with the data it buffered
+	sizeDetectionDeadline time.Time
+	// when the first gap in the received data of a stream times out, see maybeQueueFastRetransmitFrame
+	streamGaps map[protocol.StreamID]time.Time
+	// nil if Config.PathStatsRecorder, respectively Config.PathStatsReplay, is not set
+	pathStatsRecorder *pathStatsRecorder
+	pathStatsReplayer *pathStatsReplayer
 
 	streamTree *streamTree
+
+	// logs the traces of this session, see Config.TraceWriter
+	logger utils.Logger
 }
 
 var _ Session = &session{}
@@ -151,6 +186,7 @@ func newSession(
 		openPaths:    make([]protocol.PathID, 0),
 		closedPaths:  make(map[protocol.PathID]bool),
 		streamToPath: make(map[protocol.StreamID][]protocol.PathID),
+		streamGaps:   make(map[protocol.StreamID]time.Time),
 		createPaths:  createPaths,
 		remoteRTTs:   make(map[protocol.PathID]time.Duration),
 		connectionID: connectionID,
@@ -178,6 +214,7 @@ var newClientSession = func(
 		openPaths:    make([]protocol.PathID, 0),
 		closedPaths:  make(map[protocol.PathID]bool),
 		streamToPath: make(map[protocol.StreamID][]protocol.PathID),
+		streamGaps:   make(map[protocol.StreamID]time.Time),
 		createPaths:  createPaths,
 		remoteRTTs:   make(map[protocol.PathID]time.Duration),
 		connectionID: connectionID,
@@ -207,6 +244,9 @@ func (s *session) setup(
 	s.undecryptablePackets = make([]*receivedPacket, 0, protocol.MaxUndecryptablePackets)
 	s.ctx, s.ctxCancel = context.WithCancel(context.Background())
 
+	if s.config.TraceWriter != nil {
+		s.logger = utils.NewWriterLogger(s.config.TraceWriter)
+	}
 	s.timer = utils.NewTimer()
 	now := time.Now()
 	s.lastNetworkActivityTime = now
@@ -222,6 +262,19 @@ func (s *session) setup(
 
 	s.scheduler = &scheduler{}
 	s.scheduler.setup(s.config.PathScheduler)
+	if s.config.MaxSendRate > 0 {
+		s.sendRateLimiter = newSendRateLimiter(s.config.MaxSendRate)
+	}
+	if s.config.PathStatsRecorder != nil {
+		s.pathStatsRecorder = newPathStatsRecorder(s.config.PathStatsRecorder, now)
+	}
+	if s.config.PathStatsReplay != nil {
+		var err error
+		s.pathStatsReplayer, err = newPathStatsReplayer(s.config.PathStatsReplay, now)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
 
 	if pconnMgr == nil && conn != nil {
 		// XXX ONLY VALID FOR BENCHMARK!
@@ -250,7 +303,9 @@ func (s *session) setup(
 	// 	utils.Debugf("session.go  Line 250 runloop initiate streamsMap\n")
 	// }
 	s.streamsMap = newStreamsMapTree(s.newStreamPrioritySize, s.perspective, s.connectionParameters, s.streamTree)
+	s.streamsMap.addControlStreams(s.config.ControlStreams)
 	s.streamFramer = newStreamFramerTree(s.streamsMap, s.flowControlManager, s.streamTree)
+	s.streamFramer.checksums = s.config.StreamFrameChecksums
 	// if utils.Debug() {
 	// 	utils.Debugf("session.go  Line 255 runloop initiate streamsMap\n")
 	// }
@@ -363,8 +418,9 @@ func (s *session) setup(
 		s.streamFramer,
 		s.perspective,
 		s.version,
+		s.logger,
 	)
-	s.unpacker = &packetUnpacker{aead: s.cryptoSetup, version: s.version}
+	s.unpacker = &packetUnpacker{aead: s.cryptoSetup, version: s.version, streamFrameChecksums: s.config.StreamFrameChecksums}
 
 	return s, handshakeChan, nil
 }
@@ -388,6 +444,7 @@ func (s *session) run() error {
 	aeadChanged := s.aeadChanged
 
 	var timerPth *path
+	var timerFired bool
 
 runLoop:
 	for {
@@ -419,6 +476,7 @@ runLoop:
 			break runLoop
 		case <-s.timer.Chan():
 			s.timer.SetRead()
+			timerFired = true
 			// We do all the interesting stuff after the switch statement, so
 			// nothing to see here.
 		case <-s.sendingScheduled:
@@ -444,6 +502,9 @@ runLoop:
 		case l, ok := <-aeadChanged:
 			if !ok { // the aeadChanged chan was closed. This means that the handshake is completed.
 				s.handshakeComplete = true
+				s.handshakeCompleteTimeMutex.Lock()
+				s.handshakeCompleteTime = time.Now()
+				s.handshakeCompleteTimeMutex.Unlock()
 				aeadChanged = nil // prevent this case from ever being selected again
 				close(s.handshakeChan)
 				close(s.handshakeCompleteChan)
@@ -462,6 +523,18 @@ runLoop:
 			}
 			timerPth = nil
 		}
+		if timerFired {
+			// The timer also fires for the loss alarms of all paths, and for the gaps in the received stream data
+			s.onPathAlarms(now)
+			s.onStreamGapTimeouts(now)
+			timerFired = false
+		}
+		if err := s.sendPathKeepAlives(now); err != nil {
+			s.closeLocal(err)
+		}
+		if err := s.retryFailedPaths(now); err != nil {
+			s.closeLocal(err)
+		}
 
 		if !s.pathManagerLaunched && s.handshakeComplete {
 			// XXX (QDC): for benchmark tests
@@ -485,6 +558,9 @@ runLoop:
 		if err := s.sendPacket(); err != nil {
 			s.closeLocal(err)
 		}
+		if err := s.closeExhaustedPaths(); err != nil {
+			s.closeLocal(err)
+		}
 		if !s.receivedTooManyUndecrytablePacketsTime.IsZero() && s.receivedTooManyUndecrytablePacketsTime.Add(protocol.PublicResetTimeout).Before(now) && len(s.undecryptablePackets) != 0 {
 			s.closeLocal(qerr.Error(qerr.DecryptionFailure, "too many undecryptable packets received"))
 		}
@@ -495,12 +571,14 @@ runLoop:
 			s.closeLocal(qerr.Error(qerr.NetworkIdleTimeout, "No recent network activity."))
 		}
 
+		s.maybeSchedulePathsFrame()
 		// Check if we should send a PATHS frame (currently hardcoded at 200 ms) only when at least one stream is open (not counting streams 1 and 3 never closed...)
 		if s.handshakeComplete && s.version >= protocol.VersionMP && now.Sub(s.lastPathsFrameSent) >= 200*time.Millisecond && len(s.streamsMap.openStreams) > 2 {
 			s.schedulePathsFrame()
 		}
 
 		s.garbageCollectStreams()
+		s.savePathsSnapshot()
 	}
 
 	// only send the error the handshakeChan when the handshake is not completed yet
@@ -509,6 +587,11 @@ runLoop:
 		s.handshakeCompleteChan <- closeErr.err
 		s.handshakeChan <- handshakeEvent{err: closeErr.err}
 	}
+	if s.pathStatsRecorder != nil {
+		if err := s.pathStatsRecorder.flush(); err != nil {
+			s.logger.Errorf("Error writing the path statistics: %s", err)
+		}
+	}
 	s.handleCloseError(closeErr)
 	defer s.ctxCancel()
 	return closeErr.err
@@ -533,10 +616,137 @@ func (s *session) maybeResetTimer() {
 	if !s.receivedTooManyUndecrytablePacketsTime.IsZero() {
 		deadline = utils.MinTime(deadline, s.receivedTooManyUndecrytablePacketsTime.Add(protocol.PublicResetTimeout))
 	}
+	if pathDeadline := s.nextPathDeadline(); !pathDeadline.IsZero() {
+		deadline = utils.MinTime(deadline, pathDeadline)
+	}
+	if s.sendRateLimiter != nil {
+		if sendTime := s.sendRateLimiter.timeUntilSend(time.Now()); !sendTime.IsZero() {
+			deadline = utils.MinTime(deadline, sendTime)
+		}
+	}
+	if sendTime := s.streamFramer.nextRateLimitedSendTime(time.Now()); !sendTime.IsZero() {
+		deadline = utils.MinTime(deadline, sendTime)
+	}
+	if s.sizeDetectionDeadline.After(time.Now()) {
+		deadline = utils.MinTime(deadline, s.sizeDetectionDeadline)
+	}
+	for _, gapDeadline := range s.streamGaps {
+		deadline = utils.MinTime(deadline, gapDeadline)
+	}
 
 	s.timer.Reset(deadline)
 }
 
+// nextPathDeadline returns the earliest loss alarm, pacing time, end of a write backoff or keep-alive of all open paths,
+// or a zero time if there is none
+func (s *session) nextPathDeadline() time.Time {
+	var deadline time.Time
+	now := time.Now()
+	s.pathsLock.RLock()
+	defer s.pathsLock.RUnlock()
+	for _, pth := range s.paths {
+		if !pth.open.Get() {
+			continue
+		}
+		times := []time.Time{pth.sentPacketHandler.GetAlarmTimeout(), pth.sentPacketHandler.TimeUntilSend()}
+		if pth.writeBlocked(now) {
+			times = append(times, pth.writeBlockedUntil)
+		}
+		if pth.heldRetransmission != nil {
+			times = append(times, pth.retransmissionAllowedAt(now, s.config.MaxPathRetransmissionRate))
+		}
+		if s.handshakeComplete && !pth.connFailed.Get() {
+			times = append(times, pth.keepAliveDeadline(s.config.PathKeepAlivePeriod))
+		}
+		times = append(times, pth.retryDeadline())
+		for _, t := range times {
+			if !t.IsZero() && (deadline.IsZero() || t.Before(deadline)) {
+				deadline = t
+			}
+		}
+	}
+	return deadline
+}
+
+// onPathAlarms triggers the loss alarms of all paths that expired
+func (s *session) onPathAlarms(now time.Time) {
+	var expired []*path
+	s.pathsLock.RLock()
+	for _, pth := range s.paths {
+		if timeout := pth.sentPacketHandler.GetAlarmTimeout(); pth.open.Get() && !timeout.IsZero() && timeout.Before(now) {
+			expired = append(expired, pth)
+		}
+	}
+	s.pathsLock.RUnlock()
+	for _, pth := range expired {
+		pth.sentPacketHandler.OnAlarm()
+	}
+}
+
+// onStreamGapTimeouts checks the streams whose gap in the received data timed out without a STREAM frame arriving
+func (s *session) onStreamGapTimeouts(now time.Time) {
+	for streamID, deadline := range s.streamGaps {
+		if deadline.After(now) {
+			continue
+		}
+		// a timer must not open a stream, the stream may have been closed in the meantime
+		s.streamsMap.mutex.RLock()
+		str := s.streamsMap.streams[streamID]
+		s.streamsMap.mutex.RUnlock()
+		if str == nil {
+			delete(s.streamGaps, streamID)
+			continue
+		}
+		s.maybeQueueFastRetransmitFrame(str)
+	}
+}
+
+// sendPathKeepAlives sends a PING on the paths without streams that didn't send any packet for Config.PathKeepAlivePeriod
+func (s *session) sendPathKeepAlives(now time.Time) error {
+	if !s.handshakeComplete || s.config.PathKeepAlivePeriod <= 0 {
+		return nil
+	}
+	var idle []*path
+	s.pathsLock.RLock()
+	for _, pth := range s.paths {
+		if deadline := pth.keepAliveDeadline(s.config.PathKeepAlivePeriod); pth.open.Get() && !pth.connFailed.Get() && !deadline.IsZero() && !deadline.After(now) {
+			idle = append(idle, pth)
+		}
+	}
+	s.pathsLock.RUnlock()
+	for _, pth := range idle {
+		if s.logger.Debug() {
+			s.logger.Debugf("Sending a keep-alive PING on idle path %x", pth.pathID)
+		}
+		if err := s.sendPing(pth); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// retryFailedPaths probes again with a PING the paths whose conn write failed protocol.FailedPathRetryPeriod ago.
+// If the write fails again, the path is marked as failed for another period.
+func (s *session) retryFailedPaths(now time.Time) error {
+	var failed []*path
+	s.pathsLock.RLock()
+	for _, pth := range s.paths {
+		if deadline := pth.retryDeadline(); pth.open.Get() && !deadline.IsZero() && !deadline.After(now) {
+			failed = append(failed, pth)
+		}
+	}
+	s.pathsLock.RUnlock()
+	for _, pth := range failed {
+		s.logger.Infof("Path %x of %x: probing the failed conn again", pth.pathID, s.connectionID)
+		pth.connFailed.Set(false)
+		s.pathsFrameNeeded.Set(true)
+		if err := s.sendPing(pth); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (s *session) idleTimeout() time.Duration {
 	return s.connectionParameters.GetIdleConnectionStateLifetime()
 }
@@ -566,9 +776,19 @@ func (s *session) handlePacketImpl(p *receivedPacket) error {
 	if !ok {
 		// It's a new path initiated from remote host
 		pth, err = s.pathManager.createPathFromRemote(p)
+		if err == errRemotePathRefused {
+			// don't let packets with a spoofed path ID close the connection
+			s.logger.Debugf("Dropping packet %x on unknown path %x from %s: %s", p.publicHeader.PacketNumber, p.publicHeader.PathID, p.remoteAddr, err)
+			return nil
+		}
 		if err != nil {
 			return err
 		}
+		err = pth.handlePacketImpl(p)
+		if qErr, ok := err.(*qerr.QuicError); ok && qErr.ErrorCode == qerr.DecryptionFailure {
+			s.pathManager.dropRemotePath(pth)
+		}
+		return err
 	}
 	return pth.handlePacketImpl(p)
 }
@@ -582,9 +802,9 @@ func (s *session) handleFramesNew(fs []wire.Frame, p *path, localPconn net.Packe
 		case *wire.StreamFrame:
 			err = s.handleStreamFrame(frame)
 		case *wire.AckFrame:
-			err = s.handleAckFrame(frame)
+			err = s.handleAckFrame(frame, p)
 		case *wire.ConnectionCloseFrame:
-			s.closeRemote(qerr.Error(frame.ErrorCode, frame.ReasonPhrase))
+			s.closeRemote(&qerr.PeerClosedError{ErrorCode: frame.ErrorCode, ReasonPhrase: frame.ReasonPhrase})
 		case *wire.GoawayFrame:
 			err = errors.New("unimplemented: handling GOAWAY frames")
 		case *wire.StopWaitingFrame:
@@ -605,6 +825,18 @@ func (s *session) handleFramesNew(fs []wire.Frame, p *path, localPconn net.Packe
 			}
 		case *wire.ClosePathFrame:
 			s.handleClosePathFrame(frame)
+		case *wire.FastRetransmitFrame:
+			s.handleFastRetransmitFrame(frame)
+		case *wire.SkipStreamDataFrame:
+			err = s.handleSkipStreamDataFrame(frame)
+		case *wire.TimestampFrame:
+			p.onTimestampFrame(frame, time.Now())
+		case *wire.AckFrequencyFrame:
+			p.onAckFrequencyFrame(frame)
+		case *wire.DatagramFrame:
+			if s.config.DatagramHandler != nil {
+				s.config.DatagramHandler(frame.Data)
+			}
 		case *wire.PathsFrame:
 			// So far, do nothing, no actual use of s.remoteRTTs
 			s.pathsLock.RLock()
@@ -615,11 +847,11 @@ func (s *session) handleFramesNew(fs []wire.Frame, p *path, localPconn net.Packe
 					s.paths[frame.PathIDs[i]].potentiallyFailed.Set(true)
 				}
 			}
+			s.pathsLock.RUnlock()
 			//   server check if there are new paths to create
 			if s.perspective == protocol.PerspectiveServer {
 				s.pathManager.createPathsFromRemotePathsFrame(frame, localPconn)
 			}
-			s.pathsLock.RUnlock()
 		default:
 			return errors.New("Session BUG: unexpected frame type")
 		}
@@ -627,10 +859,11 @@ func (s *session) handleFramesNew(fs []wire.Frame, p *path, localPconn net.Packe
 		if err != nil {
 			switch err {
 			case ackhandler.ErrDuplicateOrOutOfOrderAck:
-				// Can happen e.g. when packets thought missing arrive late
+				// Can happen e.g. when packets thought missing arrive late, or when the reverse path reorders packets
+				s.logger.Debugf("Ignoring duplicate or out-of-order ACK received on path %x", p.pathID)
 			case errRstStreamOnInvalidStream:
 				// Can happen when RST_STREAMs arrive early or late (?)
-				utils.Errorf("Ignoring error in session: %s", err.Error())
+				s.logger.Errorf("Ignoring error in session: %s", err.Error())
 			case errWindowUpdateOnClosedStream:
 				// Can happen when we already sent the last StreamFrame with the FinBit, but the client already sent a WindowUpdate for this Stream
 			default:
@@ -649,9 +882,9 @@ func (s *session) handleFrames(fs []wire.Frame, p *path) error {
 		case *wire.StreamFrame:
 			err = s.handleStreamFrame(frame)
 		case *wire.AckFrame:
-			err = s.handleAckFrame(frame)
+			err = s.handleAckFrame(frame, p)
 		case *wire.ConnectionCloseFrame:
-			s.closeRemote(qerr.Error(frame.ErrorCode, frame.ReasonPhrase))
+			s.closeRemote(&qerr.PeerClosedError{ErrorCode: frame.ErrorCode, ReasonPhrase: frame.ReasonPhrase})
 		case *wire.GoawayFrame:
 			err = errors.New("unimplemented: handling GOAWAY frames")
 		case *wire.StopWaitingFrame:
@@ -672,6 +905,18 @@ func (s *session) handleFrames(fs []wire.Frame, p *path) error {
 			}
 		case *wire.ClosePathFrame:
 			s.handleClosePathFrame(frame)
+		case *wire.FastRetransmitFrame:
+			s.handleFastRetransmitFrame(frame)
+		case *wire.SkipStreamDataFrame:
+			err = s.handleSkipStreamDataFrame(frame)
+		case *wire.TimestampFrame:
+			p.onTimestampFrame(frame, time.Now())
+		case *wire.AckFrequencyFrame:
+			p.onAckFrequencyFrame(frame)
+		case *wire.DatagramFrame:
+			if s.config.DatagramHandler != nil {
+				s.config.DatagramHandler(frame.Data)
+			}
 		case *wire.PathsFrame:
 			// So far, do nothing, no actual use of s.remoteRTTs
 			s.pathsLock.RLock()
@@ -691,10 +936,11 @@ func (s *session) handleFrames(fs []wire.Frame, p *path) error {
 		if err != nil {
 			switch err {
 			case ackhandler.ErrDuplicateOrOutOfOrderAck:
-				// Can happen e.g. when packets thought missing arrive late
+				// Can happen e.g. when packets thought missing arrive late, or when the reverse path reorders packets
+				s.logger.Debugf("Ignoring duplicate or out-of-order ACK received on path %x", p.pathID)
 			case errRstStreamOnInvalidStream:
 				// Can happen when RST_STREAMs arrive early or late (?)
-				utils.Errorf("Ignoring error in session: %s", err.Error())
+				s.logger.Errorf("Ignoring error in session: %s", err.Error())
 			case errWindowUpdateOnClosedStream:
 				// Can happen when we already sent the last StreamFrame with the FinBit, but the client already sent a WindowUpdate for this Stream
 			default:
@@ -732,20 +978,91 @@ func (s *session) handleStreamFrame(frame *wire.StreamFrame) error {
 		// Receiving end of stream, print stats about it
 		// Print client statistics about its paths
 		s.pathsLock.RLock()
-		utils.Infof("Info for stream %d of %x", frame.StreamID, s.connectionID)
+		s.logger.Infof("Info for stream %d of %x", frame.StreamID, s.connectionID)
 		for pathID, pth := range s.paths {
 			sntPkts, sntRetrans, sntLost := pth.sentPacketHandler.GetStatistics()
 			rcvPkts := pth.receivedPacketHandler.GetStatistics()
-			utils.Infof("Path %x: sent %d retrans %d lost %d; rcv %d", pathID, sntPkts, sntRetrans, sntLost, rcvPkts)
+			s.logger.Infof("Path %x: sent %d retrans %d lost %d; rcv %d", pathID, sntPkts, sntRetrans, sntLost, rcvPkts)
 		}
 		s.pathsLock.RUnlock()
 	}
-	return str.AddStreamFrame(frame)
+	if err := str.AddStreamFrame(frame); err != nil {
+		return err
+	}
+	if s.config.MaxReassemblyBuffer > 0 {
+		// data received after a gap has to be buffered until the gap is filled, e.g. by a slow path.
+		// Don't let the peer send more than the buffer beyond the gap, such that the other paths are throttled instead.
+		limit := str.contiguousReceiveOffset() + protocol.ByteCount(s.config.MaxReassemblyBuffer)
+		if err := s.flowControlManager.SetReceiveWindowLimit(frame.StreamID, limit); err != nil {
+			return err
+		}
+	}
+	s.maybeQueueFastRetransmitFrame(str)
+	return nil
+}
+
+// maybeQueueFastRetransmitFrame asks the peer to retransmit data of the stream that is missing for too long,
+// while data sent later (e.g. on a faster path) was already received
+func (s *session) maybeQueueFastRetransmitFrame(str *stream) {
+	timeout := s.rttStats.SmoothedRTT()
+	if timeout == 0 {
+		timeout = protocol.DefaultStreamGapTimeout
+	}
+	gap, ok := str.getMissingData(time.Now(), timeout)
+	// check the gap again when it times out, even if no more data arrives on the stream
+	if deadline := str.gapDeadline(timeout); deadline.IsZero() {
+		delete(s.streamGaps, str.streamID)
+	} else {
+		s.streamGaps[str.streamID] = deadline
+	}
+	if !ok {
+		return
+	}
+	if s.logger.Debug() {
+		s.logger.Debugf("Stream %d: data missing at offset 0x%x, length 0x%x", str.streamID, gap.Start, gap.End-gap.Start)
+	}
+	s.pathsLock.RLock()
+	pth := s.paths[protocol.InitialPathID]
+	s.pathsLock.RUnlock()
+	s.packer.QueueControlFrame(&wire.FastRetransmitFrame{
+		StreamID: str.streamID,
+		Offset:   gap.Start,
+		Length:   gap.End - gap.Start,
+	}, pth)
+	s.scheduleSending()
+}
+
+func (s *session) handleFastRetransmitFrame(frame *wire.FastRetransmitFrame) {
+	var queued bool
+	s.pathsLock.RLock()
+	for _, pth := range s.paths {
+		if pth.sentPacketHandler.RetransmitStreamData(frame.StreamID, frame.Offset, frame.Length) {
+			queued = true
+		}
+	}
+	s.pathsLock.RUnlock()
+	if queued {
+		s.scheduleSending()
+	}
+}
+
+func (s *session) handleSkipStreamDataFrame(frame *wire.SkipStreamDataFrame) error {
+	str, err := s.streamsMap.GetOrOpenStream(frame.StreamID)
+	if err != nil {
+		return err
+	}
+	if str == nil {
+		// Stream is closed and already garbage collected
+		return nil
+	}
+	return str.SkipStreamData(frame.Offset, frame.Length)
 }
 
 func (s *session) handleWindowUpdateFrame(frame *wire.WindowUpdateFrame) error {
+	var str *stream
 	if frame.StreamID != 0 {
-		str, err := s.streamsMap.GetOrOpenStream(frame.StreamID)
+		var err error
+		str, err = s.streamsMap.GetOrOpenStream(frame.StreamID)
 		if err != nil {
 			return err
 		}
@@ -753,8 +1070,19 @@ func (s *session) handleWindowUpdateFrame(frame *wire.WindowUpdateFrame) error {
 			return errWindowUpdateOnClosedStream
 		}
 	}
-	_, err := s.flowControlManager.UpdateWindow(frame.StreamID, frame.ByteOffset)
-	return err
+	updated, err := s.flowControlManager.UpdateWindow(frame.StreamID, frame.ByteOffset)
+	if err != nil || !updated {
+		return err
+	}
+	// the streams whose volume was capped by the window get the remainder assigned in the next pass
+	if str != nil {
+		s.scheduler.releaseWindowLimitedStream(s, str)
+		return nil
+	}
+	return s.streamsMap.Iterate(func(str *stream) (bool, error) {
+		s.scheduler.releaseWindowLimitedStream(s, str)
+		return true, nil
+	})
 }
 
 func (s *session) handleRstStreamFrame(frame *wire.RstStreamFrame) error {
@@ -766,17 +1094,45 @@ func (s *session) handleRstStreamFrame(frame *wire.RstStreamFrame) error {
 		return errRstStreamOnInvalidStream
 	}
 
+	// a retransmitted RST_STREAM frame doesn't reset the stream again
+	alreadyReset := str.resetRemotely.Get()
 	str.RegisterRemoteError(fmt.Errorf("RST_STREAM received with code %d", frame.ErrorCode))
-	return s.flowControlManager.ResetStream(frame.StreamID, frame.ByteOffset)
+	if err := s.flowControlManager.ResetStream(frame.StreamID, frame.ByteOffset); err != nil {
+		return err
+	}
+	if !alreadyReset && s.config.StreamResetHandler != nil {
+		s.config.StreamResetHandler(frame.StreamID, frame.ErrorCode)
+	}
+	return nil
 }
 
-func (s *session) handleAckFrame(frame *wire.AckFrame) error {
+func (s *session) handleAckFrame(frame *wire.AckFrame, rcvPath *path) error {
 	pth := s.paths[frame.PathID]
-	err := pth.sentPacketHandler.ReceivedAck(frame, pth.lastRcvdPacketNumber, pth.lastNetworkActivityTime)
+	var err error
+	if rcvPath != nil && rcvPath != pth {
+		// The packet number of an ACK received on another path can't be compared to the ones of this path,
+		// so the ACKs received on each other path are ordered separately
+		if rcvPath.lastRcvdPacketNumber < pth.largestCrossPathAcks[rcvPath.pathID] {
+			return ackhandler.ErrDuplicateOrOutOfOrderAck
+		}
+		if pth.largestCrossPathAcks == nil {
+			pth.largestCrossPathAcks = make(map[protocol.PathID]protocol.PacketNumber)
+		}
+		pth.largestCrossPathAcks[rcvPath.pathID] = rcvPath.lastRcvdPacketNumber
+		err = pth.sentPacketHandler.ReceivedCrossPathAck(frame, pth.lastNetworkActivityTime)
+	} else {
+		err = pth.sentPacketHandler.ReceivedAck(frame, pth.lastRcvdPacketNumber, pth.lastNetworkActivityTime)
+	}
+	pth.updateUtilization(time.Now())
 	if err == nil && pth.rttStats.SmoothedRTT() > s.rttStats.SmoothedRTT() {
 		// Update the session RTT, which comes to take the max RTT on all paths
 		s.rttStats.UpdateSessionRTT(pth.rttStats.SmoothedRTT())
 	}
+	if err == nil && s.pathStatsRecorder != nil {
+		if rerr := s.pathStatsRecorder.record(time.Now(), pth); rerr != nil {
+			s.logger.Errorf("Error recording the statistics of path %x: %s", pth.pathID, rerr)
+		}
+	}
 	return err
 }
 
@@ -818,6 +1174,15 @@ func (s *session) knowAllPathRTT() bool {
 // }
 
 func (s *session) closePath(pthID protocol.PathID, sendClosePathFrame bool) error {
+	// A retransmission held back by Config.MaxPathRetransmissionRate isn't sent on the closed path anymore.
+	// Its frames are queued once the paths lock is released, since requeueing a PATHS frame takes the lock.
+	var held *ackhandler.Packet
+	var heldPath *path
+	defer func() {
+		if held != nil {
+			s.scheduler.queueFramesForRetransmission(s, heldPath, held)
+		}
+	}()
 	s.pathsLock.RLock()
 	defer s.pathsLock.RUnlock()
 
@@ -837,6 +1202,8 @@ func (s *session) closePath(pthID protocol.PathID, sendClosePathFrame bool) erro
 	}
 
 	s.closedPaths[pthID] = true
+	held, heldPath = pth.heldRetransmission, pth
+	pth.heldRetransmission = nil
 
 	for _, streamID := range s.paths[pthID].streamIDs {
 		//for each stream in this path
@@ -879,11 +1246,53 @@ func (s *session) closePath(pthID protocol.PathID, sendClosePathFrame bool) erro
 	return nil
 }
 
+// closeExhaustedPaths closes the paths running out of packet numbers, the other paths carry their streams from now on.
+// It returns an error if no other path is left.
+func (s *session) closeExhaustedPaths() error {
+	var exhausted []protocol.PathID
+	usable := 0
+	s.pathsLock.RLock()
+	for pathID, pth := range s.paths {
+		if _, ok := s.closedPaths[pathID]; ok {
+			continue
+		}
+		if pth.sentPacketHandler.PacketNumbersExhausted() {
+			exhausted = append(exhausted, pathID)
+		} else if pth.open.Get() {
+			usable++
+		}
+	}
+	s.pathsLock.RUnlock()
+
+	if len(exhausted) == 0 {
+		return nil
+	}
+	if usable == 0 {
+		return qerr.Error(qerr.InternalError, "packet numbers exhausted on all paths")
+	}
+	for _, pathID := range exhausted {
+		s.logger.Infof("Closing path %x of %x: its packet numbers are exhausted", pathID, s.connectionID)
+		if err := s.closePath(pathID, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (s *session) schedulePathsFrame() {
 	s.lastPathsFrameSent = time.Now()
 	s.streamFramer.AddPathsFrameForTransmission(s)
 }
 
+// maybeSchedulePathsFrame schedules the PATHS frame requested through pathsFrameNeeded.
+// The caller must not hold the paths lock.
+func (s *session) maybeSchedulePathsFrame() {
+	if s.pathsFrameNeeded.Get() {
+		s.pathsFrameNeeded.Set(false)
+		s.schedulePathsFrame()
+	}
+}
+
 func (s *session) closePaths() {
 	// XXX (QDC): still for tests
 	if s.pathManager != nil {
@@ -930,6 +1339,46 @@ func (s *session) Close(e error) error {
 	return nil
 }
 
+// CloseGracefully sends the data buffered in the streams before closing the connection.
+// If the data can't be sent before the timeout expires, the connection is closed anyway.
+func (s *session) CloseGracefully(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for s.hasBufferedStreamData() {
+		remaining := deadline.Sub(time.Now())
+		if remaining <= 0 {
+			s.logger.Infof("Timeout while draining connection %x, closing with unsent data", s.connectionID)
+			break
+		}
+		s.scheduleSending()
+		select {
+		case <-s.ctx.Done():
+			return nil
+		case <-time.After(utils.MinDuration(drainCheckInterval, remaining)):
+		}
+	}
+	return s.Close(nil)
+}
+
+// hasBufferedStreamData checks if any stream still has data or a FIN to send,
+// or if data lost on a path waits for its retransmission as saved by the run loop
+func (s *session) hasBufferedStreamData() bool {
+	s.pathsSnapshotMutex.RLock()
+	retransmissionsQueued := s.pathsSnapshot.retransmissionsQueued
+	s.pathsSnapshotMutex.RUnlock()
+	if retransmissionsQueued {
+		return true
+	}
+	var buffered bool
+	s.streamsMap.Iterate(func(str *stream) (bool, error) {
+		if str.lenOfDataForWriting() > 0 || str.shouldSendFin() {
+			buffered = true
+			return false, nil
+		}
+		return true, nil
+	})
+	return buffered
+}
+
 func (s *session) handleCloseError(closeErr closeError) error {
 	if closeErr.err == nil {
 		closeErr.err = qerr.PeerGoingAway
@@ -942,12 +1391,17 @@ func (s *session) handleCloseError(closeErr closeError) error {
 	}
 	// Don't log 'normal' reasons
 	if quicErr.ErrorCode == qerr.PeerGoingAway || quicErr.ErrorCode == qerr.NetworkIdleTimeout {
-		utils.Infof("Closing connection %x", s.connectionID)
+		s.logger.Infof("Closing connection %x", s.connectionID)
 	} else {
-		utils.Errorf("Closing session with error: %s", closeErr.err.Error())
+		s.logger.Errorf("Closing session with error: %s", closeErr.err.Error())
 	}
 
-	s.streamsMap.CloseWithError(quicErr)
+	// the streams of a connection closed by the peer return its error code and reason
+	if peerErr, ok := closeErr.err.(*qerr.PeerClosedError); ok {
+		s.streamsMap.CloseWithError(peerErr)
+	} else {
+		s.streamsMap.CloseWithError(quicErr)
+	}
 
 	if closeErr.err == errCloseSessionForNewVersion {
 		return nil
@@ -970,7 +1424,13 @@ func (s *session) handleCloseError(closeErr closeError) error {
 }
 
 func (s *session) sendPacket() error {
-	return s.scheduler.sendPacket(s)
+	s.applyCongestionWindowOverrides()
+	err := s.scheduler.sendPacket(s)
+	// write the packets still waiting to be batched, even if sending stopped on an error
+	if flushErr := s.flushPackets(); err == nil {
+		err = flushErr
+	}
+	return err
 }
 
 func (s *session) sendPackedPacket(packet *packedPacket, pth *path) error {
@@ -985,9 +1445,12 @@ func (s *session) sendPackedPacket(packet *packedPacket, pth *path) error {
 		return err
 	}
 	pth.sentPacket <- struct{}{}
+	pth.lastPacketSentTime = time.Now()
+	pth.updateUtilization(pth.lastPacketSentTime)
+	s.addPathMetric(MetricBytesSent, pth.pathID, int64(len(packet.raw)))
 
 	s.logPacket(packet, pth.pathID)
-	return pth.conn.Write(packet.raw)
+	return s.writePacket(packet.raw, pth)
 }
 
 func (s *session) sendPackedPacketOfStream(packet *packedPacket, pth *path, id protocol.StreamID) error {
@@ -1002,9 +1465,90 @@ func (s *session) sendPackedPacketOfStream(packet *packedPacket, pth *path, id p
 		return err
 	}
 	pth.sentPacket <- struct{}{}
+	pth.lastPacketSentTime = time.Now()
+	pth.updateUtilization(pth.lastPacketSentTime)
+	s.addPathMetric(MetricBytesSent, pth.pathID, int64(len(packet.raw)))
 
 	s.logPacketOfStream(packet, pth.pathID, id)
-	return pth.conn.Write(packet.raw)
+	return s.writePacket(packet.raw, pth)
+}
+
+// writePacket writes the packet on the conn of the path.
+// With Config.MaxCoalescedPackets, or while the path is blocked by a transient write error,
+// the packet is queued and written in a batch with the following ones.
+func (s *session) writePacket(raw []byte, pth *path) error {
+	if s.sendRateLimiter != nil {
+		s.sendRateLimiter.onPacketSent(time.Now(), protocol.ByteCount(len(raw)))
+	}
+	if s.config.MaxCoalescedPackets > 1 || pth.writeBlocked(time.Now()) {
+		if !pth.queuePacket(raw, s.config.MaxCoalescedPackets) {
+			return nil
+		}
+		return s.handleWriteError(pth.flushPackets(), pth)
+	}
+	err := pth.conn.Write(raw)
+	if isTransientWriteError(err) {
+		// written again by flushPackets once the backoff ended
+		pth.queuePacket(raw, 1)
+	}
+	return s.handleWriteError(err, pth)
+}
+
+// sendRateAllowed says if Config.MaxSendRate allows sending another packet now
+func (s *session) sendRateAllowed() bool {
+	return s.sendRateLimiter == nil || s.sendRateLimiter.sendingAllowed(time.Now())
+}
+
+// flushPackets writes the packets waiting to be batched on all paths
+func (s *session) flushPackets() error {
+	s.pathsLock.RLock()
+	paths := make([]*path, 0, len(s.paths))
+	for _, pth := range s.paths {
+		paths = append(paths, pth)
+	}
+	s.pathsLock.RUnlock()
+	for _, pth := range paths {
+		if err := s.handleWriteError(pth.flushPackets(), pth); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handleWriteError handles an error returned when writing on the conn of the path.
+// After a transient error, the path is not used for a short time and the packets are written again afterwards.
+// Otherwise, if another path can still be used, the path is marked as failed and the packets are handled
+// like lost ones instead of closing the connection.
+func (s *session) handleWriteError(err error, pth *path) error {
+	if err == nil {
+		return nil
+	}
+	if isTransientWriteError(err) {
+		pth.onTransientWriteError(err, time.Now())
+		return nil
+	}
+	pth.onWriteError(err, time.Now())
+	for pathID, p := range s.paths {
+		if pathID != pth.pathID && p.open.Get() && !p.connFailed.Get() {
+			return nil
+		}
+	}
+	return err
+}
+
+// isTransientWriteError says if writing may succeed again shortly, e.g. once the socket buffer drained
+func isTransientWriteError(err error) bool {
+	if opErr, ok := err.(*net.OpError); ok {
+		err = opErr.Err
+	}
+	if sysErr, ok := err.(*os.SyscallError); ok {
+		err = sysErr.Err
+	}
+	switch err {
+	case syscall.ENOBUFS, syscall.EAGAIN, syscall.EINTR, syscall.ENOMEM:
+		return true
+	}
+	return false
 }
 
 func (s *session) sendConnectionClose(quicErr *qerr.QuicError) error {
@@ -1017,6 +1561,8 @@ func (s *session) sendConnectionClose(quicErr *qerr.QuicError) error {
 		return err
 	}
 	s.logPacket(packet, protocol.InitialPathID)
+	// the CONNECTION_CLOSE must not overtake packets waiting to be batched
+	s.flushPackets()
 	// XXX (QDC): seems reasonable to send on pathID 0, but this can change
 	return s.paths[protocol.InitialPathID].conn.Write(packet.raw)
 }
@@ -1029,7 +1575,10 @@ func (s *session) sendPing(pth *path) error {
 	if packet == nil {
 		return errors.New("Session BUG: expected ping packet not to be nil")
 	}
-	return s.sendPackedPacket(packet, pth)
+	if err := s.sendPackedPacket(packet, pth); err != nil {
+		return err
+	}
+	return s.handleWriteError(pth.flushPackets(), pth)
 }
 
 //  send ping for all paths on a low-rtt path
@@ -1049,22 +1598,22 @@ func (s *session) sendPing(pth *path) error {
 // }
 
 func (s *session) logPacket(packet *packedPacket, pathID protocol.PathID) {
-	if !utils.Debug() {
+	if !s.logger.Debug() {
 		// We don't need to allocate the slices for calling the format functions
 		return
 	}
-	utils.Debugf("-> %s, Sending packet 0x%x (%d bytes) for connection %x on path %x, %s", time.Now(), packet.number, len(packet.raw), s.connectionID, pathID, packet.encryptionLevel)
+	s.logger.Debugf("-> %s, Sending packet 0x%x (%d bytes) for connection %x on path %x, %s", time.Now(), packet.number, len(packet.raw), s.connectionID, pathID, packet.encryptionLevel)
 	for _, frame := range packet.frames {
 		wire.LogFrame(frame, true)
 	}
 }
 
 func (s *session) logPacketOfStream(packet *packedPacket, pathID protocol.PathID, sid protocol.StreamID) {
-	if !utils.Debug() {
+	if !s.logger.Debug() {
 		// We don't need to allocate the slices for calling the format functions
 		return
 	}
-	utils.Debugf("-> %s, Sending packet 0x%x (%d bytes) of Stream %d for connection %x on path %x, %s", time.Now(), packet.number, len(packet.raw), sid, s.connectionID, pathID, packet.encryptionLevel)
+	s.logger.Debugf("-> %s, Sending packet 0x%x (%d bytes) of Stream %d for connection %x on path %x, %s", time.Now(), packet.number, len(packet.raw), sid, s.connectionID, pathID, packet.encryptionLevel)
 	for _, frame := range packet.frames {
 		wire.LogFrame(frame, true)
 	}
@@ -1097,8 +1646,8 @@ func (s *session) GetOrOpenStreamPriority(id protocol.StreamID, priority *protoc
 func (s *session) GetOrOpenStreamPrioritySize(id protocol.StreamID, priority *protocol.Priority) (Stream, error) {
 	str, err := s.streamsMap.GetOrOpenStreamPrioritySize(id, priority)
 	if s.perspective == protocol.PerspectiveServer {
-		if utils.Debug() {
-			utils.Debugf("GetOrOpenStreamPrioritySize in Server: Weight %d, Dependency %d, Exclusive %t\n", priority.Weight, priority.Dependency, priority.Exclusive)
+		if s.logger.Debug() {
+			s.logger.Debugf("GetOrOpenStreamPrioritySize in Server: Weight %d, Dependency %d, Exclusive %t\n", priority.Weight, priority.Dependency, priority.Exclusive)
 		}
 	}
 	if str != nil {
@@ -1112,11 +1661,16 @@ func (s *session) GetOrOpenStreamPrioritySize(id protocol.StreamID, priority *pr
 	return nil, err
 }
 
-// AcceptStream returns the next stream openend by the peer
+// AcceptStream returns the next stream opened by the peer
 func (s *session) AcceptStream() (Stream, error) {
 	return s.streamsMap.AcceptStream()
 }
 
+// AcceptStreamContext returns the next stream opened by the peer, or the error of ctx once it is done
+func (s *session) AcceptStreamContext(ctx context.Context) (Stream, error) {
+	return s.streamsMap.AcceptStreamContext(ctx)
+}
+
 // OpenStream opens a stream
 func (s *session) OpenStream() (Stream, error) {
 	return s.streamsMap.OpenStream()
@@ -1134,6 +1688,21 @@ func (s *session) OpenStreamPrioritySizeSync(priority *protocol.Priority) (Strea
 	return s.streamsMap.OpenStreamPrioritySizeSync(priority)
 }
 
+func (s *session) OpenStreamStriped() (Stream, error) {
+	return s.streamsMap.OpenStreamStriped()
+}
+
+func (s *session) SendDatagram(data []byte) error {
+	if protocol.ByteCount(len(data)) > protocol.MaxDatagramSize {
+		return errDatagramTooLarge
+	}
+	if err := s.streamFramer.AddDatagramForTransmission(data); err != nil {
+		return err
+	}
+	s.scheduleSending()
+	return nil
+}
+
 func (s *session) SetStreamPriority(id protocol.StreamID, priority *protocol.Priority) error {
 	if s.streamTree == nil {
 		return nil
@@ -1172,34 +1741,49 @@ func (s *session) queueResetStreamFrame(id protocol.StreamID, offset protocol.By
 
 func (s *session) newStream(id protocol.StreamID) *stream {
 	// TODO: find a better solution for determining which streams contribute to connection level flow control
-	if id == 1 || id == 3 {
+	if s.streamsMap.isControlStream(id) {
 		s.flowControlManager.NewStream(id, false)
 	} else {
 		s.flowControlManager.NewStream(id, true)
 	}
-	return newStream(id, s.scheduleSending, s.queueResetStreamFrame, s.flowControlManager)
+	return s.setupSizeDetection(newStream(id, s.scheduleSending, s.queueResetStreamFrame, s.flowControlManager))
 }
 
 func (s *session) newStreamPriority(id protocol.StreamID, priority *protocol.Priority) *stream {
 	// TODO: find a better solution for determining which streams contribute to connection level flow control
-	if id == 1 || id == 3 {
+	if s.streamsMap.isControlStream(id) {
 		s.flowControlManager.NewStream(id, false)
 	} else {
 		s.flowControlManager.NewStream(id, true)
 	}
-	return newStreamPriority(id, priority, s.scheduleSending, s.queueResetStreamFrame, s.flowControlManager)
+	return s.setupSizeDetection(newStreamPriority(id, priority, s.scheduleSending, s.queueResetStreamFrame, s.flowControlManager))
 }
 
 func (s *session) newStreamPrioritySize(id protocol.StreamID, priority *protocol.Priority) *stream {
 	//fmt.Printf("session.newStreamPrioritySize(): weight %d\n", priority.Weight)
 
 	// TODO: find a better solution for determining which streams contribute to connection level flow control
-	if id == 1 || id == 3 {
+	if s.streamsMap.isControlStream(id) {
 		s.flowControlManager.NewStream(id, false)
 	} else {
 		s.flowControlManager.NewStream(id, true)
 	}
-	return newStreamPrioritySize(id, priority, s.scheduleSending, s.queueResetStreamFrame, s.flowControlManager)
+	return s.setupSizeDetection(newStreamPrioritySize(id, priority, s.scheduleSending, s.queueResetStreamFrame, s.flowControlManager))
+}
+
+// onSizeDetectionPending wakes up the run loop when a stream waiting for its size is scheduled anyway at deadline
+func (s *session) onSizeDetectionPending(deadline, now time.Time) {
+	if s.sizeDetectionDeadline.Before(now) || deadline.Before(s.sizeDetectionDeadline) {
+		s.sizeDetectionDeadline = deadline
+	}
+}
+
+//   the server splits a stream by its size, let it buffer the data of the first writes, see Config.MinDetectedStreamSize
+func (s *session) setupSizeDetection(str *stream) *stream {
+	if s.perspective == protocol.PerspectiveServer && !s.streamsMap.isControlStream(str.streamID) {
+		str.minDetectedSize = protocol.ByteCount(s.config.MinDetectedStreamSize)
+	}
+	return str
 }
 
 // garbageCollectStreams goes through all streams and removes EOF'ed streams
@@ -1214,8 +1798,8 @@ func (s *session) garbageCollectStreams() {
 			//  delete records about this stream assigned to path
 			pthIDs := s.streamToPath[id]
 			s.streamToPath.Delete(id)
-			if utils.Debug() {
-				utils.Debugf("garbageCollectStreams() delete stream %d", id)
+			if s.logger.Debug() {
+				s.logger.Debugf("garbageCollectStreams() delete stream %d", id)
 			}
 
 			for j := 0; j >= 0 && j < len(pthIDs); j++ {
@@ -1251,7 +1835,7 @@ func (s *session) garbageCollectStreams() {
 }
 
 func (s *session) sendPublicReset(rejectedPacketNumber protocol.PacketNumber) error {
-	utils.Infof("Sending public reset for connection %x, packet number %d", s.connectionID, rejectedPacketNumber)
+	s.logger.Infof("Sending public reset for connection %x, packet number %d", s.connectionID, rejectedPacketNumber)
 	// XXX: seems reasonable to send on the pathID 0, but this can change
 	return s.paths[protocol.InitialPathID].conn.Write(wire.WritePublicReset(s.connectionID, rejectedPacketNumber, 0))
 }
@@ -1266,7 +1850,7 @@ func (s *session) scheduleSending() {
 
 func (s *session) tryQueueingUndecryptablePacket(p *receivedPacket) {
 	if s.handshakeComplete {
-		utils.Debugf("Received undecryptable packet from %s after the handshake: %#v, %d bytes data", p.remoteAddr.String(), p.publicHeader, len(p.data))
+		s.logger.Debugf("Received undecryptable packet from %s after the handshake: %#v, %d bytes data", p.remoteAddr.String(), p.publicHeader, len(p.data))
 		return
 	}
 	if len(s.undecryptablePackets)+1 > protocol.MaxUndecryptablePackets {
@@ -1275,10 +1859,10 @@ func (s *session) tryQueueingUndecryptablePacket(p *receivedPacket) {
 			s.receivedTooManyUndecrytablePacketsTime = time.Now()
 			s.maybeResetTimer()
 		}
-		utils.Infof("Dropping undecrytable packet 0x%x (undecryptable packet queue full)", p.publicHeader.PacketNumber)
+		s.logger.Infof("Dropping undecrytable packet 0x%x (undecryptable packet queue full)", p.publicHeader.PacketNumber)
 		return
 	}
-	utils.Infof("Queueing packet 0x%x for later decryption", p.publicHeader.PacketNumber)
+	s.logger.Infof("Queueing packet 0x%x for later decryption", p.publicHeader.PacketNumber)
 	s.undecryptablePackets = append(s.undecryptablePackets, p)
 }
 
@@ -1309,6 +1893,190 @@ func (s *session) RemoteAddr() net.Addr {
 	return s.paths[0].conn.RemoteAddr()
 }
 
-func (s *session) GetVersion() protocol.VersionNumber {
+func (s *session) PathCongestionWindow(pathID protocol.PathID) (protocol.ByteCount, error) {
+	s.pathsSnapshotMutex.RLock()
+	defer s.pathsSnapshotMutex.RUnlock()
+	for _, stats := range s.pathsSnapshot.stats {
+		if stats.PathID == pathID {
+			return stats.CongestionWindow, nil
+		}
+	}
+	return 0, errUnknownPath
+}
+
+func (s *session) PathAckRanges(pathID protocol.PathID) ([]AckRange, error) {
+	s.pathsSnapshotMutex.RLock()
+	defer s.pathsSnapshotMutex.RUnlock()
+	ackRanges, ok := s.pathsSnapshot.ackRanges[pathID]
+	if !ok {
+		return nil, errUnknownPath
+	}
+	return append([]AckRange(nil), ackRanges...), nil
+}
+
+func (s *session) StreamPending(streamID protocol.StreamID) protocol.ByteCount {
+	s.streamsMap.mutex.RLock()
+	str := s.streamsMap.streams[streamID]
+	s.streamsMap.mutex.RUnlock()
+	if str == nil {
+		return 0
+	}
+	return str.lenOfDataForWriting()
+}
+
+func (s *session) Paths() []PathInfo {
+	s.pathsLock.RLock()
+	defer s.pathsLock.RUnlock()
+	paths := make([]PathInfo, 0, len(s.paths))
+	for pathID, pth := range s.paths {
+		paths = append(paths, PathInfo{
+			PathID:     pathID,
+			LocalAddr:  pth.conn.LocalAddr(),
+			RemoteAddr: pth.conn.RemoteAddr(),
+		})
+	}
+	sort.Slice(paths, func(i, j int) bool { return paths[i].PathID < paths[j].PathID })
+	return paths
+}
+
+// pathsSnapshot is the state of the paths reported to the application.
+// The handlers of the paths are only used by the run loop, which saves a copy of their state after each iteration.
+type pathsSnapshot struct {
+	stats         []PathStats
+	ackRanges     map[protocol.PathID][]AckRange
+	bytesInFlight protocol.ByteCount
+	smoothedRTT   time.Duration
+	// set if STREAM frames wait for their retransmission on any path
+	retransmissionsQueued bool
+}
+
+// savePathsSnapshot is only called by the run loop
+func (s *session) savePathsSnapshot() {
+	s.pathsLock.RLock()
+	snapshot := pathsSnapshot{
+		stats:     make([]PathStats, 0, len(s.paths)),
+		ackRanges: make(map[protocol.PathID][]AckRange, len(s.paths)),
+	}
+	for pathID, pth := range s.paths {
+		snapshot.stats = append(snapshot.stats, pth.stats())
+		snapshot.ackRanges[pathID] = pth.receivedPacketHandler.GetAckRanges()
+		snapshot.bytesInFlight += pth.sentPacketHandler.GetBytesInFlight()
+	}
+	snapshot.smoothedRTT = s.lowestSmoothedRTT()
+	s.pathsLock.RUnlock()
+	snapshot.retransmissionsQueued = s.streamFramer.HasFramesForRetransmission()
+	sort.Slice(snapshot.stats, func(i, j int) bool { return snapshot.stats[i].PathID < snapshot.stats[j].PathID })
+
+	s.pathsSnapshotMutex.Lock()
+	s.pathsSnapshot = snapshot
+	s.pathsSnapshotMutex.Unlock()
+}
+
+func (s *session) PathStats() []PathStats {
+	s.pathsSnapshotMutex.RLock()
+	defer s.pathsSnapshotMutex.RUnlock()
+	stats := make([]PathStats, len(s.pathsSnapshot.stats))
+	copy(stats, s.pathsSnapshot.stats)
+	return stats
+}
+
+func (s *session) BytesInFlight() protocol.ByteCount {
+	s.pathsSnapshotMutex.RLock()
+	defer s.pathsSnapshotMutex.RUnlock()
+	return s.pathsSnapshot.bytesInFlight
+}
+
+func (s *session) SmoothedRTT() time.Duration {
+	s.pathsSnapshotMutex.RLock()
+	defer s.pathsSnapshotMutex.RUnlock()
+	return s.pathsSnapshot.smoothedRTT
+}
+
+// lowestSmoothedRTT is the lowest smoothed RTT of the paths used for sending, the caller holds the paths lock
+func (s *session) lowestSmoothedRTT() time.Duration {
+	var rtt time.Duration
+	for pathID, pth := range s.paths {
+		if len(s.paths) > 1 && s.scheduler.avoidsInitialPath(s, pathID) {
+			continue
+		}
+		if !pth.open.Get() || pth.potentiallyFailed.Get() || pth.connFailed.Get() {
+			continue
+		}
+		pthRTT := pth.rttStats.SmoothedRTT()
+		// paths without an RTT estimate yet don't count
+		if pthRTT != 0 && (rtt == 0 || pthRTT < rtt) {
+			rtt = pthRTT
+		}
+	}
+	return rtt
+}
+
+func (s *session) SchedulerState() SchedulerState {
+	return s.scheduler.getState()
+}
+
+func (s *session) SetCongestionWindow(pathID protocol.PathID, window protocol.ByteCount) error {
+	if !s.logger.Debug() {
+		return errCongestionWindowOverride
+	}
+	s.pathsLock.RLock()
+	_, ok := s.paths[pathID]
+	s.pathsLock.RUnlock()
+	if !ok {
+		return errUnknownPath
+	}
+	s.congestionWindowOverridesMutex.Lock()
+	if s.congestionWindowOverrides == nil {
+		s.congestionWindowOverrides = make(map[protocol.PathID]protocol.ByteCount)
+	}
+	s.congestionWindowOverrides[pathID] = window
+	s.congestionWindowOverridesMutex.Unlock()
+	s.scheduleSending()
+	return nil
+}
+
+// applyCongestionWindowOverrides sets the congestion windows passed to SetCongestionWindow, only called by the run loop
+func (s *session) applyCongestionWindowOverrides() {
+	s.congestionWindowOverridesMutex.Lock()
+	overrides := s.congestionWindowOverrides
+	s.congestionWindowOverrides = nil
+	s.congestionWindowOverridesMutex.Unlock()
+	if len(overrides) == 0 {
+		return
+	}
+	s.pathsLock.RLock()
+	defer s.pathsLock.RUnlock()
+	for pathID, window := range overrides {
+		pth, ok := s.paths[pathID]
+		if !ok {
+			continue
+		}
+		s.logger.Debugf("Overriding the congestion window of path %x with %d bytes", pathID, window)
+		pth.sentPacketHandler.SetCongestionWindow(window)
+	}
+}
+
+func (s *session) SetScheduler(name string) error {
+	if err := s.scheduler.setPathScheduler(name); err != nil {
+		return err
+	}
+	s.scheduleSending()
+	return nil
+}
+
+func (s *session) Version() protocol.VersionNumber {
 	return s.version
 }
+
+func (s *session) HandshakeDuration() time.Duration {
+	s.handshakeCompleteTimeMutex.RLock()
+	defer s.handshakeCompleteTimeMutex.RUnlock()
+	if s.handshakeCompleteTime.IsZero() {
+		return 0
+	}
+	return s.handshakeCompleteTime.Sub(s.sessionCreationTime)
+}
+
+func (s *session) MultipathEnabled() bool {
+	return s.version >= protocol.VersionMP
+}
@@ -5,10 +5,17 @@ import (
 	"context"
 	"crypto/tls"
 	"errors"
+	"expvar"
 	"io"
+	"io/ioutil"
+	"log"
 	"net"
+	"os"
 	"runtime/pprof"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	. "github.com/onsi/ginkgo"
@@ -43,6 +50,9 @@ type mockConnection struct {
 	remoteAddr net.Addr
 	localAddr  net.Addr
 	written    chan []byte
+	writeErr   error
+	// number of calls to WriteBatch
+	batches int
 }
 
 func newMockConnection() *mockConnection {
@@ -53,6 +63,9 @@ func newMockConnection() *mockConnection {
 }
 
 func (m *mockConnection) Write(p []byte) error {
+	if m.writeErr != nil {
+		return m.writeErr
+	}
 	b := make([]byte, len(p))
 	copy(b, p)
 	select {
@@ -62,6 +75,15 @@ func (m *mockConnection) Write(p []byte) error {
 	}
 	return nil
 }
+func (m *mockConnection) WriteBatch(packets [][]byte) error {
+	m.batches++
+	for _, p := range packets {
+		if err := m.Write(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 func (m *mockConnection) Read([]byte) (int, net.Addr, error) { panic("not implemented") }
 
 func (m *mockConnection) SetCurrentRemoteAddr(addr net.Addr) {
@@ -71,6 +93,18 @@ func (m *mockConnection) LocalAddr() net.Addr  { return m.localAddr }
 func (m *mockConnection) RemoteAddr() net.Addr { return m.remoteAddr }
 func (*mockConnection) Close() error           { panic("not implemented") }
 
+// recordingConnection records the path of every packet written, in the order of the writes on all paths
+type recordingConnection struct {
+	*mockConnection
+	pathID protocol.PathID
+	writes *[]protocol.PathID
+}
+
+func (c *recordingConnection) Write(p []byte) error {
+	*c.writes = append(*c.writes, c.pathID)
+	return c.mockConnection.Write(p)
+}
+
 type mockUnpacker struct {
 	unpackErr error
 }
@@ -90,9 +124,23 @@ type mockSentPacketHandler struct {
 	congestionLimited               bool
 	requestedStopWaiting            bool
 	shouldSendRetransmittablePacket bool
+	retransmitRequests              []wire.FastRetransmitFrame
+	migrated                        bool
+	congestionWindow                protocol.ByteCount
+	bytesInFlight                   protocol.ByteCount
+	alarm                           time.Time
+	pacingTime                      time.Time
+	alarmFired                      bool
+	inRecovery                      bool
+	appLimited                      bool
+	packetNumbersExhausted          bool
+	sentPacketErr                   error
 }
 
 func (h *mockSentPacketHandler) SentPacket(packet *ackhandler.Packet) error {
+	if h.sentPacketErr != nil {
+		return h.sentPacketErr
+	}
 	h.sentPackets = append(h.sentPackets, packet)
 	return nil
 }
@@ -101,13 +149,18 @@ func (h *mockSentPacketHandler) ReceivedAck(ackFrame *wire.AckFrame, withPacketN
 	return nil
 }
 
+func (h *mockSentPacketHandler) ReceivedCrossPathAck(ackFrame *wire.AckFrame, recvTime time.Time) error {
+	return nil
+}
+
 func (h *mockSentPacketHandler) ReceivedClosePath(f *wire.ClosePathFrame, withPacketNumber protocol.PacketNumber, recvTime time.Time) error {
 	return nil
 }
 
 func (h *mockSentPacketHandler) GetLeastUnacked() protocol.PacketNumber { return 1 }
-func (h *mockSentPacketHandler) GetAlarmTimeout() time.Time             { return time.Now() }
-func (h *mockSentPacketHandler) OnAlarm()                               { panic("not implemented") }
+func (h *mockSentPacketHandler) GetAlarmTimeout() time.Time             { return h.alarm }
+func (h *mockSentPacketHandler) OnAlarm()                               { h.alarmFired = true }
+func (h *mockSentPacketHandler) TimeUntilSend() time.Time               { return h.pacingTime }
 func (h *mockSentPacketHandler) DuplicatePacket(_ *ackhandler.Packet)   { panic("not implemented") }
 func (h *mockSentPacketHandler) SendingAllowed() bool                   { return !h.congestionLimited }
 func (h *mockSentPacketHandler) ShouldSendRetransmittablePacket() bool {
@@ -116,6 +169,9 @@ func (h *mockSentPacketHandler) ShouldSendRetransmittablePacket() bool {
 	return b
 }
 func (h *mockSentPacketHandler) GetStatistics() (uint64, uint64, uint64) { panic("not implemented") }
+func (h *mockSentPacketHandler) GetLossRate() float64                    { panic("not implemented") }
+func (h *mockSentPacketHandler) InRecovery() bool                        { return h.inRecovery }
+func (h *mockSentPacketHandler) PacketNumbersExhausted() bool            { return h.packetNumbersExhausted }
 
 func (h *mockSentPacketHandler) GetStopWaitingFrame(force bool) *wire.StopWaitingFrame {
 	h.requestedStopWaiting = true
@@ -136,6 +192,38 @@ func (h *mockSentPacketHandler) SetInflightAsLost() {
 	h.sentPackets = nil
 }
 
+func (h *mockSentPacketHandler) RetransmitStreamData(streamID protocol.StreamID, offset protocol.ByteCount, length protocol.ByteCount) bool {
+	h.retransmitRequests = append(h.retransmitRequests, wire.FastRetransmitFrame{StreamID: streamID, Offset: offset, Length: length})
+	return false
+}
+
+func (h *mockSentPacketHandler) GetCongestionWindow() protocol.ByteCount { return h.congestionWindow }
+func (h *mockSentPacketHandler) SetCongestionWindow(window protocol.ByteCount) {
+	h.congestionWindow = window
+}
+func (h *mockSentPacketHandler) GetBytesInFlight() protocol.ByteCount { return h.bytesInFlight }
+func (h *mockSentPacketHandler) GetPacketsInFlight() int              { return len(h.sentPackets) }
+
+func (h *mockSentPacketHandler) OnConnectionMigration() {
+	h.migrated = true
+}
+
+func (h *mockSentPacketHandler) GetSkippedPackets() []protocol.PacketNumber { panic("not implemented") }
+func (h *mockSentPacketHandler) SetMaxTrackedSkippedPackets(int)            { panic("not implemented") }
+func (h *mockSentPacketHandler) SetPacketReorderingThreshold(protocol.PacketNumber) {
+	panic("not implemented")
+}
+func (h *mockSentPacketHandler) SetIgnoreClosePathLosses(bool)   { panic("not implemented") }
+func (h *mockSentPacketHandler) SetPotentiallyFailedRTOs(uint32) { panic("not implemented") }
+func (h *mockSentPacketHandler) SetPacingGain(float64)           { panic("not implemented") }
+func (h *mockSentPacketHandler) SetLostPacketCallback(func(*ackhandler.Packet)) {
+	panic("not implemented")
+}
+func (h *mockSentPacketHandler) SetAckedPacketCallback(func(*ackhandler.Packet)) {
+	panic("not implemented")
+}
+func (h *mockSentPacketHandler) OnAppLimited() { h.appLimited = true }
+
 func newMockSentPacketHandler() ackhandler.SentPacketHandler {
 	return &mockSentPacketHandler{}
 }
@@ -158,6 +246,9 @@ func (m *mockReceivedPacketHandler) ReceivedPacket(packetNumber protocol.PacketN
 func (m *mockReceivedPacketHandler) SetLowerLimit(protocol.PacketNumber) {
 	panic("not implemented")
 }
+func (m *mockReceivedPacketHandler) SetAckFrequency(int, time.Duration) {
+	panic("not implemented")
+}
 func (m *mockReceivedPacketHandler) GetAlarmTimeout() time.Time { return m.ackAlarm }
 func (m *mockReceivedPacketHandler) GetStatistics() uint64 {
 	panic("not implemented")
@@ -166,6 +257,15 @@ func (m *mockReceivedPacketHandler) GetStatistics() uint64 {
 func (m *mockReceivedPacketHandler) GetClosePathFrame() *wire.ClosePathFrame {
 	panic("not implemented")
 }
+func (m *mockReceivedPacketHandler) GetLargestObserved() protocol.PacketNumber {
+	panic("not implemented")
+}
+func (m *mockReceivedPacketHandler) GetLargestInOrder() protocol.PacketNumber {
+	panic("not implemented")
+}
+func (m *mockReceivedPacketHandler) GetAckRanges() []wire.AckRange {
+	panic("not implemented")
+}
 
 var _ ackhandler.ReceivedPacketHandler = &mockReceivedPacketHandler{}
 
@@ -312,6 +412,26 @@ var _ = Describe("Session", func() {
 			Expect(p).To(Equal([]byte{0xde, 0xca, 0xfb, 0xad}))
 		})
 
+		It("delivers a single copy of the data received on two paths", func() {
+			frames := []*wire.StreamFrame{
+				{StreamID: 5, Data: []byte("foo")},
+				{StreamID: 5, Offset: 3, Data: []byte("bar"), FinBit: true},
+			}
+			for _, f := range frames {
+				Expect(sess.handleStreamFrame(&wire.StreamFrame{StreamID: f.StreamID, Offset: f.Offset, Data: f.Data, FinBit: f.FinBit})).To(Succeed())
+			}
+			// the redundant copies arrive in reverse order
+			for i := len(frames) - 1; i >= 0; i-- {
+				f := frames[i]
+				Expect(sess.handleStreamFrame(&wire.StreamFrame{StreamID: f.StreamID, Offset: f.Offset, Data: f.Data, FinBit: f.FinBit})).To(Succeed())
+			}
+			str, err := sess.streamsMap.GetOrOpenStream(5)
+			Expect(err).ToNot(HaveOccurred())
+			data, err := ioutil.ReadAll(str)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(data).To(Equal([]byte("foobar")))
+		})
+
 		It("does not reject existing streams with even StreamIDs", func() {
 			_, err := sess.GetOrOpenStream(5)
 			Expect(err).ToNot(HaveOccurred())
@@ -544,6 +664,39 @@ var _ = Describe("Session", func() {
 			Expect(str.(*stream).finished()).To(BeTrue())
 		})
 
+		It("calls the StreamResetHandler with the stream ID and error code", func() {
+			var resetIDs []protocol.StreamID
+			var resetCodes []uint32
+			sess.config.StreamResetHandler = func(id StreamID, errorCode uint32) {
+				resetIDs = append(resetIDs, id)
+				resetCodes = append(resetCodes, errorCode)
+			}
+			_, err := sess.GetOrOpenStream(5)
+			Expect(err).ToNot(HaveOccurred())
+			err = sess.handleRstStreamFrame(&wire.RstStreamFrame{
+				StreamID:  5,
+				ErrorCode: 42,
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(resetIDs).To(Equal([]protocol.StreamID{5}))
+			Expect(resetCodes).To(Equal([]uint32{42}))
+		})
+
+		It("calls the StreamResetHandler only once for a retransmitted RST_STREAM", func() {
+			var resets int
+			sess.config.StreamResetHandler = func(StreamID, uint32) { resets++ }
+			_, err := sess.GetOrOpenStream(5)
+			Expect(err).ToNot(HaveOccurred())
+			for i := 0; i < 2; i++ {
+				err = sess.handleRstStreamFrame(&wire.RstStreamFrame{
+					StreamID:  5,
+					ErrorCode: 42,
+				})
+				Expect(err).ToNot(HaveOccurred())
+			}
+			Expect(resets).To(Equal(1))
+		})
+
 		It("doesn't queue a RST_STREAM for a stream that it already sent a FIN on", func() {
 			str, err := sess.GetOrOpenStream(5)
 			Expect(err).NotTo(HaveOccurred())
@@ -632,6 +785,23 @@ var _ = Describe("Session", func() {
 			Expect(sess.flowControlManager.SendWindowSize(5)).To(Equal(protocol.ByteCount(100)))
 		})
 
+		It("unassigns a stream whose volume was capped by the window", func() {
+			str, err := sess.GetOrOpenStream(5)
+			Expect(err).ToNot(HaveOccurred())
+			str.(*stream).windowLimited = true
+			str.(*stream).checksize = true
+			str.(*stream).pathVolume[0] = 100
+			sess.streamToPath.Add(5, 0)
+			err = sess.handleWindowUpdateFrame(&wire.WindowUpdateFrame{
+				StreamID:   5,
+				ByteOffset: 100,
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(str.(*stream).checksize).To(BeFalse())
+			Expect(str.(*stream).pathVolume).To(BeEmpty())
+			Expect(sess.streamToPath).ToNot(HaveKey(protocol.StreamID(5)))
+		})
+
 		It("updates the Flow Control Window of the connection", func() {
 			err := sess.handleWindowUpdateFrame(&wire.WindowUpdateFrame{
 				StreamID:   0,
@@ -677,6 +847,349 @@ var _ = Describe("Session", func() {
 		})
 	})
 
+	Context("waking up for the paths", func() {
+		var sphA, sphB *mockSentPacketHandler
+
+		BeforeEach(func() {
+			sphA = &mockSentPacketHandler{}
+			sphB = &mockSentPacketHandler{}
+			sess.paths[protocol.InitialPathID].sentPacketHandler = sphA
+			pth := &path{pathID: 1, sess: sess, sentPacketHandler: sphB}
+			pth.open.Set(true)
+			sess.paths[1] = pth
+		})
+
+		It("wakes at the pacing time of a path if it is earlier than the loss alarm of another path", func() {
+			now := time.Now()
+			sphA.alarm = now.Add(200 * time.Millisecond)
+			sphB.pacingTime = now.Add(50 * time.Millisecond)
+			Expect(sess.nextPathDeadline()).To(Equal(sphB.pacingTime))
+			sess.maybeResetTimer()
+			Eventually(sess.timer.Chan()).Should(Receive())
+			Expect(time.Now()).To(BeTemporally("~", sphB.pacingTime, 30*time.Millisecond))
+		})
+
+		It("wakes at the loss alarm of a path if it is earlier than the pacing time of another path", func() {
+			now := time.Now()
+			sphA.alarm = now.Add(50 * time.Millisecond)
+			sphB.pacingTime = now.Add(200 * time.Millisecond)
+			Expect(sess.nextPathDeadline()).To(Equal(sphA.alarm))
+			sess.maybeResetTimer()
+			Eventually(sess.timer.Chan()).Should(Receive())
+			Expect(time.Now()).To(BeTemporally("~", sphA.alarm, 30*time.Millisecond))
+			sess.onPathAlarms(time.Now())
+			Expect(sphA.alarmFired).To(BeTrue())
+			Expect(sphB.alarmFired).To(BeFalse())
+		})
+
+		It("ignores closed paths", func() {
+			sphB.pacingTime = time.Now().Add(50 * time.Millisecond)
+			sess.paths[1].open.Set(false)
+			Expect(sess.nextPathDeadline().IsZero()).To(BeTrue())
+		})
+	})
+
+	Context("congestion window of a path", func() {
+		BeforeEach(func() {
+			sess.savePathsSnapshot()
+		})
+
+		It("reads the congestion window of the congestion controller", func() {
+			cwnd, err := sess.PathCongestionWindow(protocol.InitialPathID)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cwnd).To(Equal(protocol.InitialCongestionWindow * protocol.DefaultTCPMSS))
+			_, err = sess.PathCongestionWindow(7)
+			Expect(err).To(MatchError(errUnknownPath))
+		})
+
+		It("refuses to override the congestion window without debug logging", func() {
+			err := sess.SetCongestionWindow(protocol.InitialPathID, protocol.DefaultTCPMSS)
+			Expect(err).To(MatchError(errCongestionWindowOverride))
+			cwnd, err := sess.PathCongestionWindow(protocol.InitialPathID)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cwnd).To(Equal(protocol.InitialCongestionWindow * protocol.DefaultTCPMSS))
+		})
+
+		Context("with debug logging", func() {
+			BeforeEach(func() {
+				utils.SetLogLevel(utils.LogLevelDebug)
+				log.SetOutput(ioutil.Discard)
+			})
+
+			AfterEach(func() {
+				utils.SetLogLevel(utils.LogLevelNothing)
+				log.SetOutput(os.Stdout)
+			})
+
+			It("overrides the congestion window", func() {
+				sph := sess.paths[protocol.InitialPathID].sentPacketHandler
+				err := sph.SentPacket(&ackhandler.Packet{
+					PacketNumber: 1,
+					Frames:       []wire.Frame{&wire.PingFrame{}},
+					Length:       2 * protocol.DefaultTCPMSS,
+				})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(sph.SendingAllowed()).To(BeTrue())
+				err = sess.SetCongestionWindow(protocol.InitialPathID, protocol.DefaultTCPMSS)
+				Expect(err).ToNot(HaveOccurred())
+				// the run loop applies the window
+				Expect(sph.SendingAllowed()).To(BeTrue())
+				sess.applyCongestionWindowOverrides()
+				sess.savePathsSnapshot()
+				Expect(sess.PathCongestionWindow(protocol.InitialPathID)).To(Equal(protocol.DefaultTCPMSS))
+				Expect(sph.SendingAllowed()).To(BeFalse())
+				Expect(sess.SetCongestionWindow(7, protocol.DefaultTCPMSS)).To(MatchError(errUnknownPath))
+			})
+		})
+	})
+
+	Context("ACK ranges of a path", func() {
+		It("reports the ranges of received packets", func() {
+			rph := sess.paths[protocol.InitialPathID].receivedPacketHandler
+			for _, pn := range []protocol.PacketNumber{1, 3, 4} {
+				Expect(rph.ReceivedPacket(pn, true)).To(Succeed())
+			}
+			sess.savePathsSnapshot()
+			ranges, err := sess.PathAckRanges(protocol.InitialPathID)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ranges).To(Equal([]AckRange{{First: 3, Last: 4}, {First: 1, Last: 1}}))
+			_, err = sess.PathAckRanges(7)
+			Expect(err).To(MatchError(errUnknownPath))
+		})
+
+		It("returns a copy of the ranges saved by the run loop", func() {
+			rph := sess.paths[protocol.InitialPathID].receivedPacketHandler
+			Expect(rph.ReceivedPacket(1, true)).To(Succeed())
+			sess.savePathsSnapshot()
+			ranges, err := sess.PathAckRanges(protocol.InitialPathID)
+			Expect(err).ToNot(HaveOccurred())
+			ranges[0].Last = 100
+			Expect(rph.ReceivedPacket(3, true)).To(Succeed())
+			ranges, err = sess.PathAckRanges(protocol.InitialPathID)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ranges).To(Equal([]AckRange{{First: 1, Last: 1}}))
+		})
+	})
+
+	Context("switching the path scheduler", func() {
+		It("swaps the path scheduler before the next pass", func() {
+			Expect(sess.SetScheduler(protocol.CostAwarePathScheduler)).To(Succeed())
+			Expect(sess.scheduler.costAware).To(BeFalse())
+			sess.scheduler.maybeSwapPathScheduler()
+			Expect(sess.scheduler.costAware).To(BeTrue())
+		})
+
+		It("rejects an unknown path scheduler", func() {
+			Expect(sess.SetScheduler("foobar")).To(MatchError(errUnknownPathScheduler))
+		})
+	})
+
+	Context("pending stream data", func() {
+		It("reports the bytes written but not yet packed", func() {
+			str, err := sess.GetOrOpenStream(5)
+			Expect(err).ToNot(HaveOccurred())
+			done := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				_, err := str.Write(make([]byte, 2000))
+				Expect(err).ToNot(HaveOccurred())
+				close(done)
+			}()
+			Eventually(func() protocol.ByteCount { return sess.StreamPending(5) }).Should(Equal(protocol.ByteCount(2000)))
+			frames := sess.streamFramer.PopStreamFramesOfOneStream(500, 5)
+			Expect(frames).To(HaveLen(1))
+			Expect(frames[0].Data).ToNot(BeEmpty())
+			Expect(sess.StreamPending(5)).To(Equal(protocol.ByteCount(2000 - len(frames[0].Data))))
+			for sess.StreamPending(5) > 0 {
+				pending := sess.StreamPending(5)
+				frames = sess.streamFramer.PopStreamFramesOfOneStream(protocol.MaxPacketSize, 5)
+				Expect(frames).To(HaveLen(1))
+				Expect(sess.StreamPending(5)).To(BeNumerically("<", pending))
+			}
+			Eventually(done).Should(BeClosed())
+		})
+
+		It("reports 0 for an unknown stream", func() {
+			Expect(sess.StreamPending(101)).To(BeZero())
+		})
+	})
+
+	Context("path statistics", func() {
+		var pth *path
+
+		BeforeEach(func() {
+			pth = &path{pathID: 1, sess: sess, rttStats: &congestion.RTTStats{}, bdwStats: &congestion.BDWStats{}}
+			pth.sentPacketHandler = ackhandler.NewSentPacketHandler(1, pth.rttStats, pth.bdwStats, nil, nil, utils.Logger{})
+			pth.receivedPacketHandler = ackhandler.NewReceivedPacketHandler(sess.version)
+			sess.paths[1] = pth
+		})
+
+		It("reports the loss rate of each path", func() {
+			for _, p := range sess.paths {
+				for i := protocol.PacketNumber(1); i <= 4; i++ {
+					err := p.sentPacketHandler.SentPacket(&ackhandler.Packet{
+						PacketNumber: i,
+						Frames:       []wire.Frame{&wire.StreamFrame{StreamID: 5, Offset: protocol.ByteCount(i) * 10, Data: make([]byte, 10)}},
+						Length:       100,
+					})
+					Expect(err).ToNot(HaveOccurred())
+				}
+			}
+			// packets 2 and 3 are lost
+			pth.sentPacketHandler.SetPacketReorderingThreshold(1)
+			ack := &wire.AckFrame{LargestAcked: 4, LowestAcked: 1, AckRanges: []wire.AckRange{{First: 4, Last: 4}, {First: 1, Last: 1}}}
+			Expect(pth.sentPacketHandler.ReceivedAck(ack, 1, time.Now())).To(Succeed())
+
+			sess.savePathsSnapshot()
+			stats := sess.PathStats()
+			Expect(stats).To(HaveLen(2))
+			Expect(stats[0].PathID).To(BeEquivalentTo(protocol.InitialPathID))
+			Expect(stats[0].Packets).To(BeEquivalentTo(4))
+			Expect(stats[0].LossRate).To(BeZero())
+			Expect(stats[1].PathID).To(Equal(protocol.PathID(1)))
+			Expect(stats[1].Losses).To(BeEquivalentTo(2))
+			Expect(stats[1].LossRate).To(Equal(0.5))
+		})
+
+		It("sums the bytes in flight of all paths", func() {
+			for i := protocol.PacketNumber(1); i <= 3; i++ {
+				err := sess.paths[protocol.InitialPathID].sentPacketHandler.SentPacket(&ackhandler.Packet{
+					PacketNumber: i,
+					Frames:       []wire.Frame{&wire.StreamFrame{StreamID: 5, Offset: protocol.ByteCount(i) * 10, Data: make([]byte, 10)}},
+					Length:       100,
+				})
+				Expect(err).ToNot(HaveOccurred())
+			}
+			err := pth.sentPacketHandler.SentPacket(&ackhandler.Packet{
+				PacketNumber: 1,
+				Frames:       []wire.Frame{&wire.StreamFrame{StreamID: 7, Data: make([]byte, 10)}},
+				Length:       50,
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(sess.paths[protocol.InitialPathID].sentPacketHandler.GetBytesInFlight()).To(Equal(protocol.ByteCount(300)))
+			Expect(pth.sentPacketHandler.GetBytesInFlight()).To(Equal(protocol.ByteCount(50)))
+			Expect(sess.BytesInFlight()).To(BeZero())
+			sess.savePathsSnapshot()
+			Expect(sess.BytesInFlight()).To(Equal(protocol.ByteCount(350)))
+		})
+
+		It("reports the packets in flight and the streams of each path", func() {
+			pth.streamIDs = []protocol.StreamID{5, 7}
+			sess.savePathsSnapshot()
+			Expect(sess.PathStats()[1].PacketsInFlight).To(BeZero())
+			for i := protocol.PacketNumber(1); i <= 3; i++ {
+				err := pth.sentPacketHandler.SentPacket(&ackhandler.Packet{
+					PacketNumber: i,
+					Frames:       []wire.Frame{&wire.StreamFrame{StreamID: 5, Offset: protocol.ByteCount(i) * 10, Data: make([]byte, 10)}},
+					Length:       100,
+				})
+				Expect(err).ToNot(HaveOccurred())
+			}
+			sess.savePathsSnapshot()
+			stats := sess.PathStats()
+			Expect(stats[0].PacketsInFlight).To(BeZero())
+			Expect(stats[1].PacketsInFlight).To(Equal(3))
+			Expect(stats[1].Streams).To(Equal(2))
+			err := pth.sentPacketHandler.ReceivedAck(&wire.AckFrame{PathID: 1, LargestAcked: 1, LowestAcked: 1}, 1, time.Now())
+			Expect(err).ToNot(HaveOccurred())
+			sess.savePathsSnapshot()
+			Expect(sess.PathStats()[1].PacketsInFlight).To(Equal(2))
+		})
+
+		It("reports the largest received packet numbers of each path", func() {
+			for _, p := range []protocol.PacketNumber{1, 2, 3, 5, 6} {
+				err := pth.receivedPacketHandler.ReceivedPacket(p, true)
+				Expect(err).ToNot(HaveOccurred())
+			}
+			sess.savePathsSnapshot()
+			stats := sess.PathStats()
+			Expect(stats).To(HaveLen(2))
+			Expect(stats[1].LargestReceived).To(Equal(protocol.PacketNumber(6)))
+			Expect(stats[1].LargestInOrderReceived).To(Equal(protocol.PacketNumber(3)))
+		})
+
+		It("reports the state saved by the run loop", func() {
+			sess.savePathsSnapshot()
+			err := pth.sentPacketHandler.SentPacket(&ackhandler.Packet{
+				PacketNumber: 1,
+				Frames:       []wire.Frame{&wire.StreamFrame{StreamID: 5, Data: make([]byte, 10)}},
+				Length:       100,
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(sess.PathStats()[1].Packets).To(BeZero())
+			sess.savePathsSnapshot()
+			Expect(sess.PathStats()[1].Packets).To(BeEquivalentTo(1))
+		})
+	})
+
+	Context("handling missing stream data", func() {
+		It("asks the peer to retransmit data that is missing for too long", func() {
+			err := sess.handleStreamFrame(&wire.StreamFrame{StreamID: 5, Offset: 4, Data: []byte{0xBE, 0xEF}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(sess.packer.controlFrames).To(BeEmpty())
+			str, _ := sess.streamsMap.GetOrOpenStream(5)
+			str.gapSince = time.Now().Add(-time.Second)
+			err = sess.handleStreamFrame(&wire.StreamFrame{StreamID: 5, Offset: 10, Data: []byte{0x13, 0x37}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(sess.packer.controlFrames).To(Equal([]wire.Frame{
+				&wire.FastRetransmitFrame{StreamID: 5, Offset: 0, Length: 4},
+			}))
+		})
+
+		It("asks for the retransmission once the gap times out, without more data arriving", func() {
+			err := sess.handleStreamFrame(&wire.StreamFrame{StreamID: 5, Offset: 4, Data: []byte{0xBE, 0xEF}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(sess.streamGaps).To(HaveKey(protocol.StreamID(5)))
+			deadline := sess.streamGaps[5]
+			Expect(deadline).To(BeTemporally(">", time.Now()))
+			sess.onStreamGapTimeouts(deadline.Add(-time.Millisecond))
+			Expect(sess.packer.controlFrames).To(BeEmpty())
+			str, _ := sess.streamsMap.GetOrOpenStream(5)
+			str.gapSince = time.Now().Add(-time.Second)
+			sess.onStreamGapTimeouts(time.Now())
+			Expect(sess.packer.controlFrames).To(Equal([]wire.Frame{
+				&wire.FastRetransmitFrame{StreamID: 5, Offset: 0, Length: 4},
+			}))
+		})
+
+		It("stops checking a gap once it is filled", func() {
+			err := sess.handleStreamFrame(&wire.StreamFrame{StreamID: 5, Offset: 4, Data: []byte{0xBE, 0xEF}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(sess.streamGaps).To(HaveKey(protocol.StreamID(5)))
+			err = sess.handleStreamFrame(&wire.StreamFrame{StreamID: 5, Offset: 0, Data: []byte{0xDE, 0xAD, 0xCA, 0xFE}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(sess.streamGaps).To(BeEmpty())
+		})
+
+		It("doesn't ask for a retransmission if the gap is only short-lived", func() {
+			err := sess.handleStreamFrame(&wire.StreamFrame{StreamID: 5, Offset: 4, Data: []byte{0xBE, 0xEF}})
+			Expect(err).ToNot(HaveOccurred())
+			err = sess.handleStreamFrame(&wire.StreamFrame{StreamID: 5, Offset: 0, Data: []byte{0xDE, 0xAD, 0xCA, 0xFE}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(sess.packer.controlFrames).To(BeEmpty())
+		})
+
+		It("passes FAST_RETRANSMIT frames to the sent packet handlers", func() {
+			sph := &mockSentPacketHandler{}
+			sess.paths[0].sentPacketHandler = sph
+			err := sess.handleFrames([]wire.Frame{&wire.FastRetransmitFrame{StreamID: 5, Offset: 0x100, Length: 0x10}}, sess.paths[0])
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sph.retransmitRequests).To(Equal([]wire.FastRetransmitFrame{{StreamID: 5, Offset: 0x100, Length: 0x10}}))
+		})
+
+		It("skips stream data that expired at the peer", func() {
+			err := sess.handleStreamFrame(&wire.StreamFrame{StreamID: 5, Offset: 4, Data: []byte{0xBE, 0xEF}})
+			Expect(err).ToNot(HaveOccurred())
+			err = sess.handleFrames([]wire.Frame{&wire.SkipStreamDataFrame{StreamID: 5, Offset: 0, Length: 4}}, sess.paths[0])
+			Expect(err).NotTo(HaveOccurred())
+			str, _ := sess.streamsMap.GetOrOpenStream(5)
+			b := make([]byte, 6)
+			n, err := str.Read(b)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(b[:n]).To(Equal([]byte{0xBE, 0xEF}))
+		})
+	})
+
 	It("handles PING frames", func() {
 		// XXX (QDC): adapted to multiple paths
 		err := sess.handleFrames([]wire.Frame{&wire.PingFrame{}}, sess.paths[0])
@@ -709,13 +1222,41 @@ var _ = Describe("Session", func() {
 		Expect(err).NotTo(HaveOccurred())
 		Eventually(sess.Context().Done()).Should(BeClosed())
 		_, err = str.Read([]byte{0})
-		Expect(err).To(MatchError(qerr.Error(42, "foobar")))
+		Expect(err).To(MatchError(&qerr.PeerClosedError{ErrorCode: 42, ReasonPhrase: "foobar"}))
+		close(done)
+	})
+
+	It("surfaces the error code and reason of a CONNECTION_CLOSE to a blocked Read", func(done Done) {
+		go sess.run()
+		str, _ := sess.GetOrOpenStream(5)
+		readErr := make(chan error)
+		go func() {
+			defer GinkgoRecover()
+			_, err := str.Read([]byte{0})
+			readErr <- err
+		}()
+		Consistently(readErr).ShouldNot(Receive())
+		err := sess.handleFrames([]wire.Frame{&wire.ConnectionCloseFrame{ErrorCode: 0x1337, ReasonPhrase: "foobar"}}, sess.paths[0])
+		Expect(err).NotTo(HaveOccurred())
+		Eventually(readErr).Should(Receive(&err))
+		Expect(err).To(BeAssignableToTypeOf(&qerr.PeerClosedError{}))
+		Expect(err.(*qerr.PeerClosedError).ErrorCode).To(Equal(qerr.ErrorCode(0x1337)))
+		Expect(err.(*qerr.PeerClosedError).ReasonPhrase).To(Equal("foobar"))
+		_, err = sess.AcceptStream()
+		Expect(err).To(MatchError(&qerr.PeerClosedError{ErrorCode: 0x1337, ReasonPhrase: "foobar"}))
 		close(done)
 	})
 
 	It("tells its versions", func() {
 		sess.version = 4242
-		Expect(sess.GetVersion()).To(Equal(protocol.VersionNumber(4242)))
+		Expect(sess.Version()).To(Equal(protocol.VersionNumber(4242)))
+	})
+
+	It("tells if multipath is enabled", func() {
+		sess.version = protocol.VersionMP
+		Expect(sess.MultipathEnabled()).To(BeTrue())
+		sess.version = protocol.Version39
+		Expect(sess.MultipathEnabled()).To(BeFalse())
 	})
 
 	Context("waiting until the handshake completes", func() {
@@ -761,6 +1302,18 @@ var _ = Describe("Session", func() {
 			close(done)
 		})
 
+		It("measures the duration of the handshake", func(done Done) {
+			go sess.run()
+			Expect(sess.HandshakeDuration()).To(BeZero())
+			time.Sleep(50 * time.Millisecond)
+			close(aeadChanged)
+			Expect(sess.WaitUntilHandshakeComplete()).To(Succeed())
+			Expect(sess.HandshakeDuration()).To(BeNumerically(">=", 50*time.Millisecond))
+			Expect(sess.HandshakeDuration()).To(BeNumerically("<=", time.Since(sess.sessionCreationTime)))
+			Expect(sess.Close(nil)).To(Succeed())
+			close(done)
+		})
+
 		It("doesn't wait if the handshake is already completed", func(done Done) {
 			go sess.run()
 			close(aeadChanged)
@@ -814,6 +1367,71 @@ var _ = Describe("Session", func() {
 			Expect(err).To(MatchError(qerr.Error(qerr.InternalError, errCloseSessionForNewVersion.Error())))
 			Eventually(sess.Context().Done()).Should(BeClosed())
 		})
+
+		Context("with a context", func() {
+			It("accepts a stream before the context is done", func() {
+				ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+				defer cancel()
+				var str Stream
+				go func() {
+					defer GinkgoRecover()
+					var err error
+					str, err = sess.AcceptStreamContext(ctx)
+					Expect(err).ToNot(HaveOccurred())
+				}()
+				Consistently(func() Stream { return str }).Should(BeNil())
+				sess.handleStreamFrame(&wire.StreamFrame{
+					StreamID: 3,
+				})
+				Eventually(func() Stream { return str }).ShouldNot(BeNil())
+				Expect(str.StreamID()).To(Equal(protocol.StreamID(3)))
+			})
+
+			It("stops waiting when the context is cancelled", func() {
+				ctx, cancel := context.WithCancel(context.Background())
+				done := make(chan error, 1)
+				go func() {
+					_, err := sess.AcceptStreamContext(ctx)
+					done <- err
+				}()
+				Consistently(done).ShouldNot(Receive())
+				cancel()
+				Eventually(done).Should(Receive(MatchError(context.Canceled)))
+			})
+
+			It("stops waiting when the deadline of the context passes", func() {
+				ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+				defer cancel()
+				start := time.Now()
+				_, err := sess.AcceptStreamContext(ctx)
+				Expect(err).To(MatchError(context.DeadlineExceeded))
+				Expect(time.Since(start)).To(BeNumerically(">=", 50*time.Millisecond))
+			})
+
+			It("still accepts the stream with a later call after the context is done", func() {
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				_, err := sess.AcceptStreamContext(ctx)
+				Expect(err).To(MatchError(context.Canceled))
+				sess.handleStreamFrame(&wire.StreamFrame{
+					StreamID: 3,
+				})
+				str, err := sess.AcceptStream()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(str.StreamID()).To(Equal(protocol.StreamID(3)))
+			})
+		})
+	})
+
+	Context("buffered stream data", func() {
+		It("counts the STREAM frames queued for retransmission", func() {
+			Expect(sess.hasBufferedStreamData()).To(BeFalse())
+			sess.streamFramer.AddFrameForRetransmission(&wire.StreamFrame{StreamID: 5, Data: []byte("foobar")})
+			// only the state saved by the run loop is read
+			Expect(sess.hasBufferedStreamData()).To(BeFalse())
+			sess.savePathsSnapshot()
+			Expect(sess.hasBufferedStreamData()).To(BeTrue())
+		})
 	})
 
 	Context("closing", func() {
@@ -839,6 +1457,53 @@ var _ = Describe("Session", func() {
 			Expect(sess.Context().Done()).To(BeClosed())
 		})
 
+		Context("gracefully", func() {
+			var str *stream
+
+			BeforeEach(func() {
+				sess.packer.cryptoSetup = &mockCryptoSetup{encLevelSeal: protocol.EncryptionForwardSecure}
+				s, err := sess.GetOrOpenStream(3)
+				Expect(err).NotTo(HaveOccurred())
+				str = s.(*stream)
+			})
+
+			It("sends the buffered data before the CONNECTION_CLOSE", func() {
+				str.mutex.Lock()
+				str.dataForWriting = []byte("foobar")
+				str.mutex.Unlock()
+				err := sess.CloseGracefully(time.Second)
+				Expect(err).NotTo(HaveOccurred())
+				Eventually(areSessionsRunning).Should(BeFalse())
+				Expect(len(mconn.written)).To(BeNumerically(">=", 2))
+				var packets [][]byte
+				for len(mconn.written) > 0 {
+					packets = append(packets, <-mconn.written)
+				}
+				Expect(packets[0]).To(ContainSubstring("foobar"))
+				Expect(packets[len(packets)-1]).To(ContainSubstring(string([]byte{0x02, byte(qerr.PeerGoingAway), 0, 0, 0, 0, 0})))
+				Expect(str.lenOfDataForWriting()).To(BeZero())
+				Expect(sess.Context().Done()).To(BeClosed())
+			})
+
+			It("closes without the buffered data if the timeout expires", func() {
+				str.mutex.Lock()
+				str.dataForWriting = []byte("foobar")
+				str.mutex.Unlock()
+				err := sess.CloseGracefully(0)
+				Expect(err).NotTo(HaveOccurred())
+				Eventually(areSessionsRunning).Should(BeFalse())
+				Expect(mconn.written).To(HaveLen(1))
+				Expect(mconn.written).To(Receive(ContainSubstring(string([]byte{0x02, byte(qerr.PeerGoingAway), 0, 0, 0, 0, 0}))))
+			})
+
+			It("closes immediately if there's no buffered data", func() {
+				err := sess.CloseGracefully(time.Hour)
+				Expect(err).NotTo(HaveOccurred())
+				Eventually(areSessionsRunning).Should(BeFalse())
+				Expect(mconn.written).To(HaveLen(1))
+			})
+		})
+
 		It("closes streams with proper error", func() {
 			testErr := errors.New("test error")
 			s, err := sess.GetOrOpenStream(5)
@@ -935,6 +1600,15 @@ var _ = Describe("Session", func() {
 			Expect(sess.paths[0].largestRcvdPacketNumber).To(Equal(protocol.PacketNumber(5)))
 		})
 
+		It("drops a packet with a corrupted STREAM frame without acking it", func() {
+			hdr.PacketNumber = 5
+			sess.unpacker.(*mockUnpacker).unpackErr = errStreamFrameChecksumMismatch
+			err := sess.handlePacketImpl(&receivedPacket{publicHeader: hdr})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(sess.paths[0].largestRcvdPacketNumber).To(BeZero())
+			Expect(sess.paths[0].receivedPacketHandler.GetAckFrame()).To(BeNil())
+		})
+
 		It("handles duplicate packets", func() {
 			hdr.PacketNumber = 5
 			err := sess.handlePacketImpl(&receivedPacket{publicHeader: hdr})
@@ -958,11 +1632,28 @@ var _ = Describe("Session", func() {
 				Expect(sess.paths[0].conn.(*mockConnection).remoteAddr).To(Equal(remoteIP))
 			})
 
-			It("doesn't change the remote address if authenticating the packet fails", func() {
+			It("doesn't move the path back for a packet reordered from the old address", func() {
+				oldIP := sess.paths[0].conn.(*mockConnection).remoteAddr
 				remoteIP := &net.IPAddr{IP: net.IPv4(192, 168, 0, 100)}
-				attackerIP := &net.IPAddr{IP: net.IPv4(192, 168, 0, 102)}
-				// XXX (QDC): ugly...
-				sess.paths[0].conn.(*mockConnection).remoteAddr = remoteIP
+				p := receivedPacket{
+					remoteAddr:   remoteIP,
+					publicHeader: &wire.PublicHeader{PacketNumber: 1337, PacketNumberLen: protocol.PacketNumberLen6},
+				}
+				Expect(sess.handlePacketImpl(&p)).To(Succeed())
+				Expect(sess.paths[0].conn.(*mockConnection).remoteAddr).To(Equal(remoteIP))
+				p = receivedPacket{
+					remoteAddr:   oldIP,
+					publicHeader: &wire.PublicHeader{PacketNumber: 1336, PacketNumberLen: protocol.PacketNumberLen6},
+				}
+				Expect(sess.handlePacketImpl(&p)).To(Succeed())
+				Expect(sess.paths[0].conn.(*mockConnection).remoteAddr).To(Equal(remoteIP))
+			})
+
+			It("doesn't change the remote address if authenticating the packet fails", func() {
+				remoteIP := &net.IPAddr{IP: net.IPv4(192, 168, 0, 100)}
+				attackerIP := &net.IPAddr{IP: net.IPv4(192, 168, 0, 102)}
+				// XXX (QDC): ugly...
+				sess.paths[0].conn.(*mockConnection).remoteAddr = remoteIP
 				// use the real packetUnpacker here, to make sure this test fails if the error code for failed decryption changes
 				sess.unpacker = &packetUnpacker{}
 				sess.unpacker.(*packetUnpacker).aead = &mockAEAD{}
@@ -1076,6 +1767,31 @@ var _ = Describe("Session", func() {
 			Expect(mconn.written).To(HaveLen(1))
 			Expect(mconn.written).To(Receive(ContainSubstring(string([]byte{0x5E, 0x03}))))
 		})
+		It("traces only the session with a TraceWriter", func() {
+			global := &bytes.Buffer{}
+			log.SetOutput(global)
+			defer log.SetOutput(os.Stdout)
+			trace := &bytes.Buffer{}
+			pSess, _, err := newSession(
+				newMockConnection(),
+				nil,
+				true, // Try doing multipath
+				protocol.Version37,
+				0,
+				scfg,
+				nil,
+				populateServerConfig(&Config{TraceWriter: trace}),
+			)
+			Expect(err).NotTo(HaveOccurred())
+			tracedSess := pSess.(*session)
+			for _, s := range []*session{sess, tracedSess} {
+				s.paths[0].receivedPacketHandler.ReceivedPacket(0x035E, true)
+				Expect(s.sendPacket()).To(Succeed())
+			}
+			Expect(trace.String()).To(ContainSubstring("Sending packet 0x1"))
+			Expect(global.String()).To(BeEmpty())
+		})
+
 		It("sends ACK frames when congestion limited", func() {
 			sess.paths[0].sentPacketHandler = &mockSentPacketHandler{congestionLimited: true}
 			sess.paths[0].packetNumberGenerator.next = 0x1338
@@ -1087,6 +1803,143 @@ var _ = Describe("Session", func() {
 			Expect(mconn.written).To(Receive(ContainSubstring(string([]byte{0x5E, 0x03}))))
 		})
 
+		Context("timestamps", func() {
+			var sph *mockSentPacketHandler
+
+			BeforeEach(func() {
+				sess.packer.cryptoSetup = &mockCryptoSetup{encLevelSeal: protocol.EncryptionForwardSecure}
+				sph = newMockSentPacketHandler().(*mockSentPacketHandler)
+				sess.paths[0].sentPacketHandler = sph
+				sess.paths[0].rttStats.UpdateRTT(time.Second, 0, time.Now())
+			})
+
+			It("doesn't send TIMESTAMP frames by default", func() {
+				_, sent, err := sess.scheduler.performPacketSending(sess, nil, sess.paths[0])
+				Expect(err).ToNot(HaveOccurred())
+				Expect(sent).To(BeFalse())
+			})
+
+			It("sends a TIMESTAMP frame once per RTT", func() {
+				sess.config.SendTimestamps = true
+				sess.packer.QueueControlFrame(&wire.WindowUpdateFrame{StreamID: 5, ByteOffset: 0x1000}, sess.paths[0])
+				_, sent, err := sess.scheduler.performPacketSending(sess, nil, sess.paths[0])
+				Expect(err).ToNot(HaveOccurred())
+				Expect(sent).To(BeTrue())
+				Expect(sph.sentPackets).To(HaveLen(1))
+				Expect(sph.sentPackets[0].Frames).To(HaveLen(2))
+				f, ok := sph.sentPackets[0].Frames[1].(*wire.TimestampFrame)
+				Expect(ok).To(BeTrue())
+				Expect(f.Timestamp).To(BeTemporally("~", time.Now(), 10*time.Millisecond))
+				sess.packer.QueueControlFrame(&wire.WindowUpdateFrame{StreamID: 7, ByteOffset: 0x1000}, sess.paths[0])
+				_, sent, err = sess.scheduler.performPacketSending(sess, nil, sess.paths[0])
+				Expect(err).ToNot(HaveOccurred())
+				Expect(sent).To(BeTrue())
+				Expect(sph.sentPackets).To(HaveLen(2))
+				Expect(sph.sentPackets[1].Frames).To(HaveLen(1))
+			})
+
+			It("doesn't send a TIMESTAMP frame on an idle path", func() {
+				sess.config.SendTimestamps = true
+				_, sent, err := sess.scheduler.performPacketSending(sess, nil, sess.paths[0])
+				Expect(err).ToNot(HaveOccurred())
+				Expect(sent).To(BeFalse())
+				Expect(sess.paths[0].lastTimestampSent.IsZero()).To(BeTrue())
+			})
+
+			It("measures the delay from the peer with TIMESTAMP frames", func() {
+				err := sess.handleFrames([]wire.Frame{&wire.TimestampFrame{Timestamp: time.Now().Add(-300 * time.Millisecond)}}, sess.paths[0])
+				Expect(err).ToNot(HaveOccurred())
+				Expect(sess.paths[0].hasReverseDelay).To(BeTrue())
+				Expect(sess.paths[0].reverseDelay).To(BeNumerically("~", 300*time.Millisecond, 10*time.Millisecond))
+			})
+
+			It("doesn't retransmit TIMESTAMP frames", func() {
+				sph.retransmissionQueue = []*ackhandler.Packet{{
+					Frames:          []wire.Frame{&wire.TimestampFrame{Timestamp: time.Now()}},
+					EncryptionLevel: protocol.EncryptionForwardSecure,
+				}}
+				hasRetransmission, _ := sess.scheduler.getRetransmissionOfPath(sess, sess.paths[0])
+				Expect(hasRetransmission).To(BeTrue())
+				Expect(sess.packer.controlFrames).To(BeEmpty())
+			})
+		})
+
+		Context("ACK frequency", func() {
+			var sph *mockSentPacketHandler
+
+			BeforeEach(func() {
+				sess.packer.cryptoSetup = &mockCryptoSetup{encLevelSeal: protocol.EncryptionForwardSecure}
+				sph = newMockSentPacketHandler().(*mockSentPacketHandler)
+				sph.congestionWindow = 100 * protocol.DefaultTCPMSS
+				sess.paths[0].sentPacketHandler = sph
+				sess.paths[0].rttStats.UpdateRTT(time.Second, 0, time.Now())
+			})
+
+			It("doesn't send ACK_FREQUENCY frames by default", func() {
+				_, sent, err := sess.scheduler.performPacketSending(sess, nil, sess.paths[0])
+				Expect(err).ToNot(HaveOccurred())
+				Expect(sent).To(BeFalse())
+			})
+
+			It("asks the peer to ack about 4 times per congestion window, once per RTT", func() {
+				sess.config.MaxAckPacketTolerance = 20
+				_, sent, err := sess.scheduler.performPacketSending(sess, nil, sess.paths[0])
+				Expect(err).ToNot(HaveOccurred())
+				Expect(sent).To(BeTrue())
+				Expect(sph.sentPackets).To(HaveLen(1))
+				Expect(sph.sentPackets[0].Frames).To(Equal([]wire.Frame{&wire.AckFrequencyFrame{
+					SequenceNumber:  1,
+					PacketTolerance: 20,
+					MaxAckDelay:     protocol.AckSendDelay,
+				}}))
+				// the congestion window shrinks, but the last frame was sent less than an RTT ago
+				sph.congestionWindow = 40 * protocol.DefaultTCPMSS
+				_, sent, err = sess.scheduler.performPacketSending(sess, nil, sess.paths[0])
+				Expect(err).ToNot(HaveOccurred())
+				Expect(sent).To(BeFalse())
+				sess.paths[0].lastAckFrequencySent = time.Now().Add(-time.Second)
+				_, sent, err = sess.scheduler.performPacketSending(sess, nil, sess.paths[0])
+				Expect(err).ToNot(HaveOccurred())
+				Expect(sent).To(BeTrue())
+				Expect(sph.sentPackets[1].Frames).To(Equal([]wire.Frame{&wire.AckFrequencyFrame{
+					SequenceNumber:  2,
+					PacketTolerance: 10,
+					MaxAckDelay:     protocol.AckSendDelay,
+				}}))
+			})
+
+			It("doesn't send an ACK_FREQUENCY frame for a small congestion window", func() {
+				sess.config.MaxAckPacketTolerance = 20
+				sph.congestionWindow = 8 * protocol.DefaultTCPMSS
+				_, sent, err := sess.scheduler.performPacketSending(sess, nil, sess.paths[0])
+				Expect(err).ToNot(HaveOccurred())
+				Expect(sent).To(BeFalse())
+			})
+
+			It("acks less often after receiving an ACK_FREQUENCY frame", func() {
+				pth := sess.paths[0]
+				pth.receivedPacketHandler = ackhandler.NewReceivedPacketHandler(sess.version)
+				countAcks := func(from, to protocol.PacketNumber) int {
+					var acks int
+					for i := from; i <= to; i++ {
+						Expect(pth.receivedPacketHandler.ReceivedPacket(i, true)).To(Succeed())
+						if pth.receivedPacketHandler.GetAckFrame() != nil {
+							acks++
+						}
+					}
+					return acks
+				}
+				Expect(countAcks(1, 41)).To(Equal(21))
+				err := sess.handleFrames([]wire.Frame{&wire.AckFrequencyFrame{SequenceNumber: 1, PacketTolerance: 10}}, pth)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(countAcks(42, 141)).To(Equal(10))
+				// a reordered older frame is ignored
+				err = sess.handleFrames([]wire.Frame{&wire.AckFrequencyFrame{SequenceNumber: 1, PacketTolerance: 2}}, pth)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(countAcks(142, 241)).To(Equal(10))
+			})
+		})
+
 		It("sends a retransmittable packet when required by the SentPacketHandler", func() {
 			sess.paths[0].sentPacketHandler = &mockSentPacketHandler{shouldSendRetransmittablePacket: true}
 			err := sess.sendPacket()
@@ -1143,6 +1996,1105 @@ var _ = Describe("Session", func() {
 		})
 	})
 
+	Context("sending ACKs on the remaining paths", func() {
+		var pth *path
+
+		BeforeEach(func() {
+			sess.packer.cryptoSetup = &mockCryptoSetup{encLevelSeal: protocol.EncryptionForwardSecure}
+			pth = &path{pathID: 1, sess: sess, conn: newMockConnection()}
+			pth.setup(nil)
+			sess.paths[1] = pth
+			Expect(sess.paths[0].receivedPacketHandler.ReceivedPacket(1, true)).To(Succeed())
+			Expect(pth.receivedPacketHandler.ReceivedPacket(1, true)).To(Succeed())
+		})
+
+		AfterEach(func() {
+			pth.closeChan <- nil
+			Eventually(pth.runClosed).Should(Receive())
+		})
+
+		It("sends an ACK on every path", func() {
+			err := sess.scheduler.ackRemainingPaths(sess, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(mconn.written).To(HaveLen(1))
+			Expect(pth.conn.(*mockConnection).written).To(HaveLen(1))
+		})
+
+		It("sends the ACKs at the end of a sending pass without stream data", func() {
+			Expect(sess.sendPacket()).To(Succeed())
+			Expect(mconn.written).ToNot(BeEmpty())
+			Expect(pth.conn.(*mockConnection).written).ToNot(BeEmpty())
+		})
+
+		It("keeps sending on the other paths when one path fails", func() {
+			pth.conn.(*mockConnection).writeErr = errors.New("write failed")
+			err := sess.scheduler.ackRemainingPaths(sess, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(mconn.written).To(HaveLen(1))
+		})
+
+		It("returns the error if all paths fail", func() {
+			testErr := errors.New("write failed")
+			mconn.writeErr = testErr
+			pth.conn.(*mockConnection).writeErr = testErr
+			err := sess.scheduler.ackRemainingPaths(sess, nil)
+			Expect(err).To(MatchError(testErr))
+		})
+
+		It("returns other errors than write errors immediately", func() {
+			testErr := errors.New("too many tracked packets")
+			pth.sentPacketHandler = &mockSentPacketHandler{sentPacketErr: testErr}
+			err := sess.scheduler.ackRemainingPaths(sess, nil)
+			Expect(err).To(MatchError(testErr))
+		})
+
+		Context("ACK path policies", func() {
+			var sph0, sph1 *mockSentPacketHandler
+
+			ackedPaths := func(sph *mockSentPacketHandler) []protocol.PathID {
+				var pathIDs []protocol.PathID
+				for _, p := range sph.sentPackets {
+					for _, f := range p.Frames {
+						if ack, ok := f.(*wire.AckFrame); ok {
+							pathIDs = append(pathIDs, ack.PathID)
+						}
+					}
+				}
+				return pathIDs
+			}
+
+			BeforeEach(func() {
+				sph0 = newMockSentPacketHandler().(*mockSentPacketHandler)
+				sess.paths[0].sentPacketHandler = sph0
+				sph1 = newMockSentPacketHandler().(*mockSentPacketHandler)
+				pth.sentPacketHandler = sph1
+				// the mock STOP_WAITING frames have a LeastUnacked of 0x1337
+				sess.paths[0].packetNumberGenerator.next = 0x1337 + 10
+				pth.packetNumberGenerator.next = 0x1337 + 10
+			})
+
+			It("sends the ACK of a path on that path", func() {
+				sess.config.AckPathPolicy = AckOnSamePath
+				err := sess.scheduler.ackRemainingPaths(sess, nil)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(ackedPaths(sph0)).To(Equal([]protocol.PathID{0}))
+				Expect(ackedPaths(sph1)).To(Equal([]protocol.PathID{1}))
+			})
+
+			It("doesn't send a packet on a path without ACK", func() {
+				sess.config.AckPathPolicy = AckOnSamePath
+				sess.paths[0].GetAckFrame()
+				wuf := &wire.WindowUpdateFrame{StreamID: 5, ByteOffset: 0x1000}
+				err := sess.scheduler.ackRemainingPaths(sess, []*wire.WindowUpdateFrame{wuf})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(mconn.written).To(BeEmpty())
+				Expect(ackedPaths(sph1)).To(Equal([]protocol.PathID{1}))
+			})
+
+			It("sends all ACKs on the lowest RTT path", func() {
+				sess.config.AckPathPolicy = AckOnLowestRTTPath
+				sess.paths[0].rttStats.UpdateRTT(50*time.Millisecond, 0, time.Now())
+				pth.rttStats.UpdateRTT(10*time.Millisecond, 0, time.Now())
+				err := sess.scheduler.ackRemainingPaths(sess, nil)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(mconn.written).To(BeEmpty())
+				Expect(ackedPaths(sph1)).To(ConsistOf(protocol.PathID(0), protocol.PathID(1)))
+			})
+
+			It("queues the STOP_WAITING frame of a path whose ACK moves to another path", func() {
+				sess.config.AckPathPolicy = AckOnLowestRTTPath
+				sess.paths[0].rttStats.UpdateRTT(50*time.Millisecond, 0, time.Now())
+				pth.rttStats.UpdateRTT(10*time.Millisecond, 0, time.Now())
+				err := sess.scheduler.ackRemainingPaths(sess, nil)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(sph0.requestedStopWaiting).To(BeTrue())
+				Expect(sess.packer.stopWaiting[0]).ToNot(BeNil())
+				Expect(sess.packer.stopWaiting[0].LeastUnacked).To(Equal(protocol.PacketNumber(0x1337)))
+				// it goes out with the next packet sent on the path
+				sess.packer.QueueControlFrame(&wire.WindowUpdateFrame{StreamID: 5, ByteOffset: 0x1000}, sess.paths[0])
+				packet, err := sess.packer.PackPacketOfPath(sess.paths[0])
+				Expect(err).ToNot(HaveOccurred())
+				Expect(packet.frames).To(ContainElement(BeAssignableToTypeOf(&wire.StopWaitingFrame{})))
+			})
+		})
+	})
+
+	Context("creating paths", func() {
+		createPath := func() *path {
+			pm := &pathManager{sess: sess}
+			pth, err := pm.createPathFromRemote(&receivedPacket{
+				remoteAddr:   &net.UDPAddr{IP: net.IPv4(192, 168, 13, 37), Port: 1234},
+				publicHeader: &wire.PublicHeader{PathID: 1},
+				rcvPconn:     &mockPacketConn{},
+			})
+			Expect(err).ToNot(HaveOccurred())
+			return pth
+		}
+
+		AfterEach(func() {
+			pth := sess.paths[1]
+			pth.closeChan <- nil
+			Eventually(pth.runClosed).Should(Receive())
+		})
+
+		It("seeds the RTT of a new path with the RTT of the initial path", func() {
+			sess.paths[0].rttStats.UpdateRTT(30*time.Millisecond, 0, time.Now())
+			pth := createPath()
+			Expect(pth.rttStats.SmoothedRTT()).To(Equal(30 * time.Millisecond))
+			// the estimate is replaced by the first RTT measured on the path
+			pth.rttStats.UpdateRTT(80*time.Millisecond, 0, time.Now())
+			Expect(pth.rttStats.SmoothedRTT()).To(Equal(80 * time.Millisecond))
+		})
+
+		It("starts with no RTT if the initial path has no estimate yet", func() {
+			pth := createPath()
+			Expect(pth.rttStats.SmoothedRTT()).To(BeZero())
+		})
+	})
+
+	Context("probing new paths", func() {
+		var (
+			pm      *pathManager
+			pconn   *mockPacketConn
+			locAddr net.UDPAddr
+			remAddr net.UDPAddr
+		)
+
+		BeforeEach(func() {
+			locAddr = net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 4433}
+			remAddr = net.UDPAddr{IP: net.IPv4(192, 168, 13, 37), Port: 1234}
+			pconn = &mockPacketConn{addr: &locAddr}
+			pm = &pathManager{
+				sess:      sess,
+				pconnMgr:  &pconnManager{pconns: map[string]net.PacketConn{locAddr.String(): pconn}},
+				nxtPathID: 1,
+			}
+		})
+
+		It("sends a PING on a new path", func() {
+			Expect(pm.createPath(locAddr, remAddr)).To(Succeed())
+			Expect(sess.paths).To(HaveKey(protocol.PathID(1)))
+			Expect(sess.openPaths).To(ContainElement(protocol.PathID(1)))
+			Expect(pconn.writes).To(Equal(1))
+			pth := sess.paths[1]
+			pth.closeChan <- nil
+			Eventually(pth.runClosed).Should(Receive())
+		})
+
+		It("removes a path if its PING can't be sent", func() {
+			pconn.writeErr = errors.New("no route to host")
+			Expect(pm.createPath(locAddr, remAddr)).To(Succeed())
+			Expect(sess.paths).ToNot(HaveKey(protocol.PathID(1)))
+			Expect(sess.openPaths).ToNot(ContainElement(protocol.PathID(1)))
+			// the PATHS frame was scheduled after the paths lock was released
+			Expect(sess.pathsFrameNeeded.Get()).To(BeFalse())
+			Expect(sess.streamFramer.pathsFrame).ToNot(BeNil())
+			// the next path gets a new path ID
+			pconn.writeErr = nil
+			Expect(pm.createPath(locAddr, remAddr)).To(Succeed())
+			Expect(sess.paths).To(HaveKey(protocol.PathID(3)))
+			pth := sess.paths[3]
+			pth.closeChan <- nil
+			Eventually(pth.runClosed).Should(Receive())
+		})
+
+		It("keeps a path if its PING can only be sent later", func() {
+			pconn.writeErr = syscall.ENOBUFS
+			Expect(pm.createPath(locAddr, remAddr)).To(Succeed())
+			Expect(sess.paths).To(HaveKey(protocol.PathID(1)))
+			pth := sess.paths[1]
+			Expect(pth.writeBlockedUntil).ToNot(BeZero())
+			pth.closeChan <- nil
+			Eventually(pth.runClosed).Should(Receive())
+		})
+	})
+
+	Context("deduplicating paths", func() {
+		var (
+			pm         *pathManager
+			pconn      *mockPacketConn
+			remoteAddr *net.UDPAddr
+		)
+
+		createPath := func(pathID protocol.PathID) error {
+			_, err := pm.createPathFromRemote(&receivedPacket{
+				remoteAddr:   remoteAddr,
+				publicHeader: &wire.PublicHeader{PathID: pathID},
+				rcvPconn:     pconn,
+			})
+			return err
+		}
+
+		pathsFrame := func(pathID protocol.PathID) *wire.PathsFrame {
+			return &wire.PathsFrame{
+				PathIDs:         []protocol.PathID{pathID},
+				RemoteAddrsIP:   []string{remoteAddr.IP.String()},
+				RemoteAddrsPort: []string{strconv.Itoa(remoteAddr.Port)},
+			}
+		}
+
+		BeforeEach(func() {
+			pm = &pathManager{sess: sess}
+			pconn = &mockPacketConn{addr: &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 4433}}
+			remoteAddr = &net.UDPAddr{IP: net.IPv4(192, 168, 13, 37), Port: 1234}
+		})
+
+		AfterEach(func() {
+			for id, pth := range sess.paths {
+				if id != protocol.InitialPathID {
+					pth.closeChan <- nil
+					Eventually(pth.runClosed).Should(Receive())
+				}
+			}
+		})
+
+		It("rejects a path with an existing PathID", func() {
+			Expect(createPath(1)).To(Succeed())
+			remoteAddr = &net.UDPAddr{IP: net.IPv4(192, 168, 13, 38), Port: 1234}
+			Expect(createPath(1)).To(MatchError(errPathExists))
+			Expect(sess.paths).To(HaveLen(2))
+		})
+
+		It("rejects a path between the addresses of an existing path", func() {
+			Expect(createPath(1)).To(Succeed())
+			Expect(createPath(3)).To(MatchError(errPathExists))
+			Expect(pm.createPathsFromRemotePathsFrame(pathsFrame(5), pconn)).To(Succeed())
+			Expect(sess.paths).To(HaveLen(2))
+			Expect(sess.paths).To(HaveKey(protocol.PathID(1)))
+		})
+
+		It("creates exactly one path when creations race", func() {
+			var wg sync.WaitGroup
+			var mutex sync.Mutex
+			var created int
+			for i := 0; i < 10; i++ {
+				wg.Add(2)
+				go func(pathID protocol.PathID) {
+					defer GinkgoRecover()
+					defer wg.Done()
+					if createPath(pathID) == nil {
+						mutex.Lock()
+						created++
+						mutex.Unlock()
+					}
+				}(protocol.PathID(2*i + 1))
+				go func(pathID protocol.PathID) {
+					defer GinkgoRecover()
+					defer wg.Done()
+					Expect(pm.createPathsFromRemotePathsFrame(pathsFrame(pathID), pconn)).To(Succeed())
+				}(protocol.PathID(2*i + 1))
+			}
+			wg.Wait()
+			Expect(sess.paths).To(HaveLen(2))
+			Expect(created).To(BeNumerically("<=", 1))
+		})
+	})
+
+	Context("limiting remote paths", func() {
+		var pconn *mockPacketConn
+
+		createPath := func(pathID protocol.PathID, ip net.IP) error {
+			_, err := sess.pathManager.createPathFromRemote(&receivedPacket{
+				remoteAddr:   &net.UDPAddr{IP: ip, Port: 1000 + int(pathID)},
+				publicHeader: &wire.PublicHeader{PathID: pathID},
+				rcvPconn:     pconn,
+			})
+			return err
+		}
+
+		BeforeEach(func() {
+			sess.pathManager = &pathManager{sess: sess}
+			pconn = &mockPacketConn{addr: &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 4433}}
+		})
+
+		AfterEach(func() {
+			for id, pth := range sess.paths {
+				if id != protocol.InitialPathID {
+					pth.closeChan <- nil
+					Eventually(pth.runClosed).Should(Receive())
+				}
+			}
+		})
+
+		It("limits the paths opened by the peer by default", func() {
+			var created int
+			for i := 0; i < 50; i++ {
+				if createPath(protocol.PathID(2*i+1), net.IPv4(192, 168, 13, 37)) == nil {
+					created++
+				}
+			}
+			Expect(created).To(Equal(protocol.DefaultMaxRemotePaths))
+			Expect(sess.paths).To(HaveLen(protocol.DefaultMaxRemotePaths + 1))
+		})
+
+		It("refuses paths beyond MaxRemotePaths", func() {
+			sess.config.MaxRemotePaths = 2
+			Expect(createPath(1, net.IPv4(192, 168, 13, 37))).To(Succeed())
+			Expect(createPath(3, net.IPv4(192, 168, 13, 37))).To(Succeed())
+			Expect(createPath(5, net.IPv4(192, 168, 13, 37))).To(MatchError(errRemotePathRefused))
+			Expect(sess.paths).To(HaveLen(3))
+		})
+
+		It("drops packets on new path IDs beyond the limit without closing the session", func() {
+			sess.config.MaxRemotePaths = 1
+			sess.pathManager.nbRemotePaths = 1
+			err := sess.handlePacketImpl(&receivedPacket{
+				remoteAddr:   &net.UDPAddr{IP: net.IPv4(192, 168, 13, 37), Port: 1234},
+				publicHeader: &wire.PublicHeader{PathID: 7, PacketNumber: 1, PacketNumberLen: protocol.PacketNumberLen6},
+				rcvPconn:     pconn,
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(sess.paths).To(HaveLen(1))
+		})
+
+		It("releases the slot of a path whose first packet can't be decrypted", func() {
+			sess.config.MaxRemotePaths = 1
+			sess.unpacker = &mockUnpacker{unpackErr: qerr.Error(qerr.DecryptionFailure, "")}
+			err := sess.handlePacketImpl(&receivedPacket{
+				remoteAddr:   &net.UDPAddr{IP: net.IPv4(192, 168, 13, 37), Port: 1234},
+				publicHeader: &wire.PublicHeader{PathID: 7, PacketNumber: 1, PacketNumberLen: protocol.PacketNumberLen6},
+				rcvPconn:     pconn,
+			})
+			Expect(err).To(HaveOccurred())
+			Expect(sess.paths).To(HaveLen(1))
+			Expect(sess.openPaths).ToNot(ContainElement(protocol.PathID(7)))
+			Expect(sess.pathManager.nbRemotePaths).To(BeZero())
+			Expect(createPath(9, net.IPv4(192, 168, 13, 37))).To(Succeed())
+		})
+
+		It("releases the slot of a closed path", func() {
+			sess.config.MaxRemotePaths = 1
+			Expect(createPath(1, net.IPv4(192, 168, 13, 37))).To(Succeed())
+			Expect(createPath(3, net.IPv4(192, 168, 13, 38))).To(MatchError(errRemotePathRefused))
+			Expect(sess.pathManager.closePath(1)).To(Succeed())
+			Expect(sess.pathManager.nbRemotePaths).To(BeZero())
+			Expect(createPath(3, net.IPv4(192, 168, 13, 38))).To(Succeed())
+		})
+
+		It("only opens paths from advertised addresses if required", func() {
+			sess.config.RequireAdvertisedRemotePaths = true
+			sess.createPaths = false
+			Expect(createPath(1, net.IPv4(192, 168, 13, 37))).To(MatchError(errRemotePathRefused))
+			Expect(sess.pathManager.handleAddAddressFrame(&wire.AddAddressFrame{
+				IPVersion: 4,
+				Addr:      net.UDPAddr{IP: net.IPv4(192, 168, 13, 37), Port: 4433},
+			})).To(Succeed())
+			Expect(createPath(1, net.IPv4(192, 168, 13, 37))).To(Succeed())
+			Expect(createPath(3, net.IPv4(192, 168, 13, 38))).To(MatchError(errRemotePathRefused))
+			Expect(sess.paths).To(HaveLen(2))
+		})
+	})
+
+	Context("receiving ACKs on another path", func() {
+		var pth *path
+
+		BeforeEach(func() {
+			pth = &path{pathID: 1, sess: sess, conn: newMockConnection()}
+			pth.setup(nil)
+			sess.paths[1] = pth
+			for pn := protocol.PacketNumber(1); pn <= 2; pn++ {
+				Expect(pth.sentPacketHandler.SentPacket(&ackhandler.Packet{
+					PacketNumber: pn,
+					Frames:       []wire.Frame{&wire.PingFrame{}},
+					Length:       1,
+				})).To(Succeed())
+			}
+		})
+
+		AfterEach(func() {
+			pth.closeChan <- nil
+			Eventually(pth.runClosed).Should(Receive())
+		})
+
+		It("doesn't consider them as out-of-order", func() {
+			sess.paths[0].lastRcvdPacketNumber = 10
+			err := sess.handleAckFrame(&wire.AckFrame{PathID: 1, LargestAcked: 1, LowestAcked: 1}, sess.paths[0])
+			Expect(err).ToNot(HaveOccurred())
+			sess.paths[0].lastRcvdPacketNumber = 11
+			err = sess.handleAckFrame(&wire.AckFrame{PathID: 1, LargestAcked: 2, LowestAcked: 1}, sess.paths[0])
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pth.sentPacketHandler.GetLeastUnacked()).To(Equal(protocol.PacketNumber(3)))
+		})
+
+		It("still accepts the ACKs received on the path itself", func() {
+			sess.paths[0].lastRcvdPacketNumber = 100
+			err := sess.handleAckFrame(&wire.AckFrame{PathID: 1, LargestAcked: 1, LowestAcked: 1}, sess.paths[0])
+			Expect(err).ToNot(HaveOccurred())
+			pth.lastRcvdPacketNumber = 1
+			err = sess.handleAckFrame(&wire.AckFrame{PathID: 1, LargestAcked: 2, LowestAcked: 1}, pth)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pth.sentPacketHandler.GetLeastUnacked()).To(Equal(protocol.PacketNumber(3)))
+		})
+
+		It("ignores an ACK reordered on the other path", func() {
+			sess.paths[0].lastRcvdPacketNumber = 11
+			err := sess.handleAckFrame(&wire.AckFrame{PathID: 1, LargestAcked: 2, LowestAcked: 2}, sess.paths[0])
+			Expect(err).ToNot(HaveOccurred())
+			sess.paths[0].lastRcvdPacketNumber = 10
+			err = sess.handleAckFrame(&wire.AckFrame{PathID: 1, LargestAcked: 1, LowestAcked: 1}, sess.paths[0])
+			Expect(err).To(MatchError(ackhandler.ErrDuplicateOrOutOfOrderAck))
+			Expect(pth.sentPacketHandler.GetLeastUnacked()).To(Equal(protocol.PacketNumber(1)))
+		})
+	})
+
+	Context("conn failures", func() {
+		var pth *path
+
+		BeforeEach(func() {
+			sess.config.InitialPathPolicy = InitialPathNormal
+			pth = &path{pathID: 1, sess: sess, conn: newMockConnection()}
+			pth.setup(nil)
+			sess.paths[1] = pth
+		})
+
+		AfterEach(func() {
+			pth.closeChan <- nil
+			Eventually(pth.runClosed).Should(Receive())
+		})
+
+		It("excludes a path whose conn write failed from the next selections", func() {
+			pth.conn.(*mockConnection).writeErr = errors.New("write failed")
+			Expect(sess.writePacket([]byte("foobar"), pth)).To(Succeed())
+			Expect(pth.connFailed.Get()).To(BeTrue())
+			for i := 0; i < 3; i++ {
+				Expect(sess.scheduler.selectPathLowLatency(sess, false, false, nil)).To(Equal(sess.paths[0]))
+			}
+			Expect(sess.writePacket([]byte("foobar"), sess.paths[0])).To(Succeed())
+			Expect(mconn.written).To(HaveLen(1))
+		})
+
+		It("returns the error if no other path can be used", func() {
+			testErr := errors.New("write failed")
+			pth.conn.(*mockConnection).writeErr = testErr
+			mconn.writeErr = testErr
+			Expect(sess.writePacket([]byte("foobar"), pth)).To(Succeed())
+			Expect(sess.writePacket([]byte("foobar"), sess.paths[0])).To(MatchError(testErr))
+		})
+
+		It("only schedules the PATHS frame from the run loop after a write error", func() {
+			pth.conn.(*mockConnection).writeErr = errors.New("write failed")
+			sess.pathsLock.Lock()
+			Expect(sess.writePacket([]byte("foobar"), pth)).To(Succeed())
+			sess.pathsLock.Unlock()
+			Expect(sess.pathsFrameNeeded.Get()).To(BeTrue())
+			Expect(sess.streamFramer.pathsFrame).To(BeNil())
+			sess.maybeSchedulePathsFrame()
+			Expect(sess.pathsFrameNeeded.Get()).To(BeFalse())
+			Expect(sess.streamFramer.pathsFrame).ToNot(BeNil())
+		})
+
+		It("probes a failed path again after a while", func() {
+			pth.conn.(*mockConnection).writeErr = errors.New("write failed")
+			Expect(sess.writePacket([]byte("foobar"), pth)).To(Succeed())
+			Expect(pth.connFailed.Get()).To(BeTrue())
+			Expect(sess.nextPathDeadline()).To(BeTemporally("<=", pth.retryDeadline()))
+			pth.conn.(*mockConnection).writeErr = nil
+			Expect(sess.retryFailedPaths(time.Now())).To(Succeed())
+			Expect(pth.connFailed.Get()).To(BeTrue())
+			Expect(pth.conn.(*mockConnection).written).To(BeEmpty())
+			Expect(sess.retryFailedPaths(time.Now().Add(protocol.FailedPathRetryPeriod))).To(Succeed())
+			Expect(pth.connFailed.Get()).To(BeFalse())
+			Expect(pth.retryDeadline()).To(BeZero())
+			Expect(pth.conn.(*mockConnection).written).To(HaveLen(1))
+		})
+
+		It("keeps a path failed if probing it fails again", func() {
+			pth.conn.(*mockConnection).writeErr = errors.New("write failed")
+			Expect(sess.writePacket([]byte("foobar"), pth)).To(Succeed())
+			retryTime := time.Now().Add(protocol.FailedPathRetryPeriod)
+			Expect(sess.retryFailedPaths(retryTime)).To(Succeed())
+			Expect(pth.connFailed.Get()).To(BeTrue())
+			Expect(pth.retryDeadline()).To(BeTemporally(">", time.Now()))
+		})
+
+		It("uses the path again once it migrated", func() {
+			pth.connFailed.Set(true)
+			pth.migrate(&net.UDPAddr{IP: net.IPv4(192, 168, 13, 37), Port: 1234}, false)
+			Expect(pth.connFailed.Get()).To(BeFalse())
+		})
+
+		It("doesn't use a path for a short time after a transient write error", func() {
+			transientErr := &net.OpError{Op: "write", Net: "udp", Err: os.NewSyscallError("sendto", syscall.ENOBUFS)}
+			pth.conn.(*mockConnection).writeErr = transientErr
+			mconn.writeErr = transientErr
+			Expect(sess.writePacket([]byte("foobar"), pth)).To(Succeed())
+			Expect(sess.writePacket([]byte("foobar"), sess.paths[0])).To(Succeed())
+			Expect(pth.connFailed.Get()).To(BeFalse())
+			Expect(sess.paths[0].connFailed.Get()).To(BeFalse())
+			Expect(pth.writeBlocked(time.Now())).To(BeTrue())
+			Expect(pth.SendingAllowed()).To(BeFalse())
+			Expect(sess.nextPathDeadline()).To(BeTemporally("<=", time.Now().Add(protocol.TransientWriteErrorBackoff)))
+			Expect(pth.writeBlocked(time.Now().Add(protocol.TransientWriteErrorBackoff))).To(BeFalse())
+			Eventually(pth.SendingAllowed).Should(BeTrue())
+		})
+
+		It("writes the packets again once the backoff after a transient write error ended", func() {
+			conn := pth.conn.(*mockConnection)
+			conn.writeErr = syscall.ENOBUFS
+			Expect(sess.writePacket([]byte("foo"), pth)).To(Succeed())
+			conn.writeErr = nil
+			// packets sent during the backoff wait behind the blocked one
+			Expect(sess.writePacket([]byte("bar"), pth)).To(Succeed())
+			Expect(pth.flushPackets()).To(Succeed())
+			Expect(conn.written).To(BeEmpty())
+			Eventually(func() bool { return pth.writeBlocked(time.Now()) }).Should(BeFalse())
+			Expect(pth.flushPackets()).To(Succeed())
+			Expect(conn.written).To(Receive(Equal([]byte("foo"))))
+			Expect(conn.written).To(Receive(Equal([]byte("bar"))))
+			Expect(pth.pendingPackets).To(BeEmpty())
+		})
+
+		It("keeps the batched packets after a transient write error", func() {
+			sess.config.MaxCoalescedPackets = 2
+			conn := pth.conn.(*mockConnection)
+			conn.writeErr = syscall.EAGAIN
+			Expect(sess.writePacket([]byte("foo"), pth)).To(Succeed())
+			Expect(sess.writePacket([]byte("bar"), pth)).To(Succeed())
+			Expect(pth.writeBlocked(time.Now())).To(BeTrue())
+			Expect(pth.pendingPackets).To(HaveLen(2))
+			conn.writeErr = nil
+			Eventually(func() bool { return pth.writeBlocked(time.Now()) }).Should(BeFalse())
+			Expect(sess.flushPackets()).To(Succeed())
+			Expect(conn.written).To(Receive(Equal([]byte("foo"))))
+			Expect(conn.written).To(Receive(Equal([]byte("bar"))))
+		})
+
+		It("recognizes transient write errors", func() {
+			Expect(isTransientWriteError(syscall.ENOBUFS)).To(BeTrue())
+			Expect(isTransientWriteError(os.NewSyscallError("sendmsg", syscall.EAGAIN))).To(BeTrue())
+			Expect(isTransientWriteError(&net.OpError{Op: "write", Err: os.NewSyscallError("sendto", syscall.EINTR)})).To(BeTrue())
+			Expect(isTransientWriteError(&net.OpError{Op: "write", Err: os.NewSyscallError("sendto", syscall.ENETUNREACH)})).To(BeFalse())
+			Expect(isTransientWriteError(errors.New("write failed"))).To(BeFalse())
+		})
+	})
+
+	Context("keeping idle paths alive", func() {
+		const period = 100 * time.Millisecond
+		var (
+			idlePth  *path
+			idleConn *mockConnection
+		)
+
+		BeforeEach(func() {
+			sess.handshakeComplete = true
+			sess.packer.cryptoSetup = &mockCryptoSetup{encLevelSeal: protocol.EncryptionForwardSecure}
+			idleConn = newMockConnection()
+			idlePth = &path{pathID: 1, sess: sess, conn: idleConn}
+			idlePth.setup(nil)
+			sess.paths[1] = idlePth
+			sess.paths[0].streamIDs = []protocol.StreamID{5}
+			idlePth.lastPacketSentTime = time.Now().Add(-2 * period)
+			sess.paths[0].lastPacketSentTime = time.Now().Add(-2 * period)
+		})
+
+		AfterEach(func() {
+			idlePth.closeChan <- nil
+			Eventually(idlePth.runClosed).Should(Receive())
+		})
+
+		It("doesn't send PINGs by default", func() {
+			Expect(sess.sendPathKeepAlives(time.Now())).To(Succeed())
+			Expect(idleConn.written).To(BeEmpty())
+			Expect(mconn.written).To(BeEmpty())
+		})
+
+		It("sends PINGs periodically on a path without streams, but not on a busy path", func() {
+			sess.config.PathKeepAlivePeriod = period
+			Expect(sess.sendPathKeepAlives(time.Now())).To(Succeed())
+			Expect(idleConn.written).To(HaveLen(1))
+			Expect(mconn.written).To(BeEmpty())
+			// the PING was just sent
+			Expect(sess.sendPathKeepAlives(time.Now())).To(Succeed())
+			Expect(idleConn.written).To(HaveLen(1))
+			Expect(sess.nextPathDeadline()).To(BeTemporally("<=", idlePth.lastPacketSentTime.Add(period)))
+			Expect(sess.sendPathKeepAlives(time.Now().Add(period))).To(Succeed())
+			Expect(idleConn.written).To(HaveLen(2))
+			Expect(mconn.written).To(BeEmpty())
+		})
+
+		It("doesn't send PINGs before the handshake completes", func() {
+			sess.config.PathKeepAlivePeriod = period
+			sess.handshakeComplete = false
+			Expect(sess.sendPathKeepAlives(time.Now())).To(Succeed())
+			Expect(idleConn.written).To(BeEmpty())
+		})
+	})
+
+	Context("batched writes", func() {
+		It("writes every packet on its own by default", func() {
+			for i := 0; i < 10; i++ {
+				Expect(sess.writePacket([]byte("foobar"), sess.paths[0])).To(Succeed())
+			}
+			Expect(mconn.written).To(HaveLen(10))
+			Expect(mconn.batches).To(BeZero())
+		})
+
+		It("writes the packets in batches", func() {
+			sess.config.MaxCoalescedPackets = 4
+			for i := 0; i < 10; i++ {
+				Expect(sess.writePacket([]byte{byte(i)}, sess.paths[0])).To(Succeed())
+			}
+			Expect(mconn.written).To(HaveLen(8))
+			Expect(mconn.batches).To(Equal(2))
+			Expect(sess.flushPackets()).To(Succeed())
+			Expect(mconn.written).To(HaveLen(10))
+			Expect(mconn.batches).To(Equal(3))
+			for i := 0; i < 10; i++ {
+				Expect(mconn.written).To(Receive(Equal([]byte{byte(i)})))
+			}
+		})
+
+		It("writes the waiting packets at the end of the sending pass", func() {
+			sess.config.MaxCoalescedPackets = 4
+			Expect(sess.writePacket([]byte("foobar"), sess.paths[0])).To(Succeed())
+			Expect(mconn.written).To(BeEmpty())
+			Expect(sess.sendPacket()).To(Succeed())
+			Expect(mconn.written).To(Receive(Equal([]byte("foobar"))))
+			Expect(mconn.batches).To(Equal(1))
+		})
+
+		It("handles an error of a batched write like the one of a single write", func() {
+			testErr := errors.New("write failed")
+			sess.config.MaxCoalescedPackets = 2
+			mconn.writeErr = testErr
+			Expect(sess.writePacket([]byte("foo"), sess.paths[0])).To(Succeed())
+			Expect(sess.writePacket([]byte("bar"), sess.paths[0])).To(MatchError(testErr))
+			Expect(sess.paths[0].connFailed.Get()).To(BeTrue())
+			Expect(sess.paths[0].pendingPackets).To(BeEmpty())
+		})
+	})
+
+	Context("limiting the send rate", func() {
+		var (
+			pthA, pthB   *path
+			connA, connB *mockConnection
+		)
+
+		addPath := func(pathID protocol.PathID, conn *mockConnection, streamID protocol.StreamID) *path {
+			pth := &path{pathID: pathID, sess: sess, conn: conn}
+			pth.setup(nil)
+			pth.rttStats.UpdateRTT(10*time.Millisecond, 0, time.Now())
+			sess.paths[pathID] = pth
+			sess.openPaths = append(sess.openPaths, pathID)
+			str, err := sess.GetOrOpenStream(streamID)
+			Expect(err).ToNot(HaveOccurred())
+			str.(*stream).dataForWriting = make([]byte, 10000)
+			str.(*stream).pathVolume[pathID] = 10000
+			sess.streamToPath.Add(streamID, pathID)
+			pth.streamIDs = []protocol.StreamID{streamID}
+			return pth
+		}
+
+		BeforeEach(func() {
+			sess.packer.cryptoSetup = &mockCryptoSetup{encLevelSeal: protocol.EncryptionForwardSecure}
+			connA = newMockConnection()
+			connB = newMockConnection()
+			pthA = addPath(1, connA, 5)
+			pthB = addPath(3, connB, 7)
+		})
+
+		AfterEach(func() {
+			for _, pth := range []*path{pthA, pthB} {
+				pth.closeChan <- nil
+				Eventually(pth.runClosed).Should(Receive())
+			}
+		})
+
+		It("doesn't limit the send rate by default", func() {
+			Expect(sess.sendPacket()).To(Succeed())
+			Expect(len(connA.written) + len(connB.written)).To(BeNumerically(">", 6))
+		})
+
+		It("stops sending on all paths once the rate is reached, and resumes later", func() {
+			// a burst of 5 packets is allowed
+			sess.sendRateLimiter = newSendRateLimiter(uint64(5 * protocol.MaxPacketSize * 100))
+			Expect(sess.sendPacket()).To(Succeed())
+			sent := len(connA.written) + len(connB.written)
+			Expect(sent).To(BeNumerically(">", 0))
+			Expect(sent).To(BeNumerically("<=", 6))
+			Expect(sess.sendRateAllowed()).To(BeFalse())
+			Expect(sess.sendPacket()).To(Succeed())
+			Expect(len(connA.written) + len(connB.written)).To(Equal(sent))
+
+			time.Sleep(10 * time.Millisecond)
+			Expect(sess.sendPacket()).To(Succeed())
+			Expect(len(connA.written) + len(connB.written)).To(BeNumerically(">", sent))
+			Expect(len(connA.written) + len(connB.written)).To(BeNumerically("<=", 2*6))
+			Expect(connA.written).ToNot(BeEmpty())
+			Expect(connB.written).ToNot(BeEmpty())
+		})
+
+		It("sets the timer to the time sending is allowed again", func() {
+			sess.sendRateLimiter = newSendRateLimiter(uint64(protocol.MaxPacketSize * 100))
+			// the second packet is sent on credit, the next one may be sent after 10ms
+			sess.sendRateLimiter.onPacketSent(time.Now(), 2*protocol.MaxPacketSize)
+			allowed := time.Now().Add(10 * time.Millisecond)
+			sess.maybeResetTimer()
+			Eventually(sess.timer.Chan()).Should(Receive())
+			Expect(time.Now()).To(BeTemporally("~", allowed, 5*time.Millisecond))
+			Expect(sess.sendRateAllowed()).To(BeTrue())
+		})
+	})
+
+	Context("batching the sends of a path", func() {
+		var (
+			pthA, pthB *path
+			writes     []protocol.PathID
+		)
+
+		addPath := func(pathID protocol.PathID, streamID protocol.StreamID) *path {
+			conn := &recordingConnection{mockConnection: newMockConnection(), pathID: pathID, writes: &writes}
+			pth := &path{pathID: pathID, sess: sess, conn: conn}
+			pth.setup(nil)
+			pth.rttStats.UpdateRTT(10*time.Millisecond, 0, time.Now())
+			sess.paths[pathID] = pth
+			sess.openPaths = append(sess.openPaths, pathID)
+			str, err := sess.GetOrOpenStream(streamID)
+			Expect(err).ToNot(HaveOccurred())
+			str.(*stream).dataForWriting = make([]byte, 10000)
+			str.(*stream).pathVolume[pathID] = 10000
+			sess.streamToPath.Add(streamID, pathID)
+			pth.streamIDs = []protocol.StreamID{streamID}
+			return pth
+		}
+
+		BeforeEach(func() {
+			writes = nil
+			// keep every path on its single stream
+			sess.scheduler.pathScheduler = func(*session) (bool, error) { return false, nil }
+			sess.packer.cryptoSetup = &mockCryptoSetup{encLevelSeal: protocol.EncryptionForwardSecure}
+			pthA = addPath(1, 5)
+			pthB = addPath(3, 7)
+		})
+
+		AfterEach(func() {
+			for _, pth := range []*path{pthA, pthB} {
+				pth.closeChan <- nil
+				Eventually(pth.runClosed).Should(Receive())
+			}
+		})
+
+		It("alternates between the paths after every packet by default", func() {
+			Expect(sess.sendPacket()).To(Succeed())
+			Expect(len(writes)).To(BeNumerically(">", 6))
+			Expect(writes[:6]).To(Equal([]protocol.PathID{1, 3, 1, 3, 1, 3}))
+		})
+
+		It("sends a batch of packets on a path before moving on to the next path", func() {
+			sess.config.PathSendBatchSize = 3
+			Expect(sess.sendPacket()).To(Succeed())
+			Expect(len(writes)).To(BeNumerically(">", 6))
+			Expect(writes[:6]).To(Equal([]protocol.PathID{1, 1, 1, 3, 3, 3}))
+		})
+	})
+
+	Context("metrics", func() {
+		It("counts the assigned streams and the bytes sent and acked", func() {
+			metrics := new(expvar.Map).Init()
+			sess.config.Metrics = metrics
+			sess.packer.cryptoSetup = &mockCryptoSetup{encLevelSeal: protocol.EncryptionForwardSecure}
+			s, err := sess.GetOrOpenStream(5)
+			Expect(err).ToNot(HaveOccurred())
+			str := s.(*stream)
+			str.mutex.Lock()
+			str.dataForWriting = []byte("foobar")
+			str.mutex.Unlock()
+			Expect(sess.sendPacket()).To(Succeed())
+			Expect(mconn.written).ToNot(BeEmpty())
+			Expect(metrics.Get(MetricStreamsAssigned + "." + protocol.DefaultPathScheduler).(*expvar.Int).Value()).To(BeEquivalentTo(1))
+			bytesSent := metrics.Get(pathMetricKey(MetricBytesSent, protocol.InitialPathID)).(*expvar.Int).Value()
+			Expect(bytesSent).To(BeNumerically(">", 0))
+			Expect(metrics.Get(pathMetricKey(MetricBytesAcked, protocol.InitialPathID))).To(BeNil())
+
+			pth := sess.paths[protocol.InitialPathID]
+			largestSent := pth.packetNumberGenerator.Peek() - 1
+			err = pth.sentPacketHandler.ReceivedAck(&wire.AckFrame{LargestAcked: largestSent, LowestAcked: 1}, 1, time.Now())
+			Expect(err).ToNot(HaveOccurred())
+			bytesAcked := metrics.Get(pathMetricKey(MetricBytesAcked, protocol.InitialPathID)).(*expvar.Int).Value()
+			Expect(bytesAcked).To(BeNumerically(">", 0))
+			Expect(bytesAcked).To(BeNumerically("<=", bytesSent))
+		})
+	})
+
+	Context("exhausting packet numbers", func() {
+		var pth *path
+
+		BeforeEach(func() {
+			pth = &path{pathID: 1, sess: sess, sentPacketHandler: &mockSentPacketHandler{}, receivedPacketHandler: ackhandler.NewReceivedPacketHandler(sess.version)}
+			pth.open.Set(true)
+			Expect(pth.receivedPacketHandler.ReceivedPacket(1, true)).To(Succeed())
+			sess.paths[1] = pth
+		})
+
+		It("closes a path running out of packet numbers", func() {
+			Expect(sess.closeExhaustedPaths()).To(Succeed())
+			Expect(sess.closedPaths).To(BeEmpty())
+			pth.sentPacketHandler.(*mockSentPacketHandler).packetNumbersExhausted = true
+			Expect(sess.closeExhaustedPaths()).To(Succeed())
+			Expect(sess.closedPaths).To(HaveKey(protocol.PathID(1)))
+			Expect(sess.streamFramer.PopClosePathFrame()).ToNot(BeNil())
+		})
+
+		It("closes the connection if no other path is left", func() {
+			sess.paths[0].open.Set(false)
+			pth.sentPacketHandler.(*mockSentPacketHandler).packetNumbersExhausted = true
+			err := sess.closeExhaustedPaths()
+			Expect(err).To(HaveOccurred())
+			Expect(err.(*qerr.QuicError).ErrorCode).To(Equal(qerr.InternalError))
+			Expect(sess.closedPaths).To(BeEmpty())
+		})
+	})
+
+	Context("ordering the paths of PATHS frames", func() {
+		probedRTT := func(rtt time.Duration) *congestion.RTTStats {
+			rttStats := congestion.NewRTTStats()
+			rttStats.UpdateRTT(rtt, 0, time.Now())
+			return rttStats
+		}
+
+		sampledBandwidth := func(bytesPerSecond protocol.ByteCount) *congestion.BDWStats {
+			bdwStats := congestion.NewBDWStats(0)
+			bdwStats.UpdateBDW(bytesPerSecond, time.Second, false)
+			return bdwStats
+		}
+
+		BeforeEach(func() {
+			sess.paths[0].rttStats = probedRTT(50 * time.Millisecond)
+			sess.paths[0].bdwStats = sampledBandwidth(1000000)
+			sess.paths[1] = &path{pathID: 1, rttStats: probedRTT(10 * time.Millisecond), bdwStats: sampledBandwidth(100000)}
+			sess.paths[3] = &path{pathID: 3, rttStats: probedRTT(30 * time.Millisecond), bdwStats: sampledBandwidth(2000000)}
+			// only seeded, e.g. from the statistics of a previous connection
+			sess.paths[5] = &path{pathID: 5, rttStats: congestion.NewRTTStatsWithSmoothedRTT(5 * time.Millisecond), bdwStats: congestion.NewBDWStats(100 * 1048576)}
+		})
+
+		advertisedPaths := func() []protocol.PathID {
+			sess.streamFramer.AddPathsFrameForTransmission(sess)
+			return sess.streamFramer.PopPathsFrame().PathIDs
+		}
+
+		It("lists the paths by path ID by default", func() {
+			Expect(advertisedPaths()).To(Equal([]protocol.PathID{0, 1, 3, 5}))
+		})
+
+		It("lists the paths with the lowest RTT first", func() {
+			sess.config.PathsFrameOrder = PathsFrameLowestRTTFirst
+			Expect(advertisedPaths()).To(Equal([]protocol.PathID{1, 3, 0, 5}))
+		})
+
+		It("lists the paths with the highest bandwidth first", func() {
+			sess.config.PathsFrameOrder = PathsFrameHighestBandwidthFirst
+			Expect(advertisedPaths()).To(Equal([]protocol.PathID{3, 0, 1, 5}))
+		})
+
+		It("lists the failed paths last", func() {
+			sess.config.PathsFrameOrder = PathsFrameLowestRTTFirst
+			sess.paths[1].potentiallyFailed.Set(true)
+			Expect(advertisedPaths()).To(Equal([]protocol.PathID{3, 0, 5, 1}))
+		})
+	})
+
+	Context("aggregate RTT", func() {
+		var pthA, pthB *path
+
+		// the RTT is read from the snapshot saved by the run loop
+		smoothedRTT := func() time.Duration {
+			sess.savePathsSnapshot()
+			return sess.SmoothedRTT()
+		}
+
+		newPath := func(pathID protocol.PathID, rtt time.Duration) *path {
+			pth := &path{pathID: pathID, rttStats: congestion.NewRTTStatsWithSmoothedRTT(rtt), bdwStats: &congestion.BDWStats{}}
+			pth.sentPacketHandler = ackhandler.NewSentPacketHandler(pathID, pth.rttStats, pth.bdwStats, nil, nil, utils.Logger{})
+			pth.receivedPacketHandler = ackhandler.NewReceivedPacketHandler(sess.version)
+			pth.open.Set(true)
+			return pth
+		}
+
+		BeforeEach(func() {
+			sess.paths[0].rttStats = congestion.NewRTTStatsWithSmoothedRTT(5 * time.Millisecond)
+			pthA = newPath(1, 50*time.Millisecond)
+			pthB = newPath(3, 30*time.Millisecond)
+		})
+
+		It("returns the RTT of the initial path if it is the only one", func() {
+			Expect(smoothedRTT()).To(Equal(5 * time.Millisecond))
+		})
+
+		It("returns the lowest RTT of the paths, and updates it", func() {
+			sess.paths[1] = pthA
+			sess.paths[3] = pthB
+			// the initial path is avoided
+			Expect(smoothedRTT()).To(Equal(30 * time.Millisecond))
+			pthA.rttStats.UpdateSessionRTT(20 * time.Millisecond)
+			Expect(smoothedRTT()).To(Equal(20 * time.Millisecond))
+			pthB.rttStats.UpdateSessionRTT(10 * time.Millisecond)
+			Expect(smoothedRTT()).To(Equal(10 * time.Millisecond))
+		})
+
+		It("ignores potentially failed paths and paths without estimate", func() {
+			sess.paths[1] = pthA
+			sess.paths[3] = pthB
+			pthB.potentiallyFailed.Set(true)
+			Expect(smoothedRTT()).To(Equal(50 * time.Millisecond))
+			pthA.rttStats = &congestion.RTTStats{}
+			Expect(smoothedRTT()).To(BeZero())
+		})
+
+		It("uses the initial path if the policy doesn't avoid it", func() {
+			sess.config.InitialPathPolicy = InitialPathNormal
+			sess.paths[1] = pthA
+			Expect(smoothedRTT()).To(Equal(5 * time.Millisecond))
+		})
+	})
+
+	Context("datagrams", func() {
+		var pthA, pthB *path
+
+		BeforeEach(func() {
+			sess.handshakeComplete = true
+			sess.packer.cryptoSetup = &mockCryptoSetup{encLevelSeal: protocol.EncryptionForwardSecure}
+			pthA = &path{pathID: 1, sess: sess, conn: newMockConnection()}
+			pthA.setup(nil)
+			pthA.rttStats = congestion.NewRTTStatsWithSmoothedRTT(50 * time.Millisecond)
+			pthB = &path{pathID: 3, sess: sess, conn: newMockConnection()}
+			pthB.setup(nil)
+			pthB.rttStats = congestion.NewRTTStatsWithSmoothedRTT(10 * time.Millisecond)
+			sess.paths[1] = pthA
+			sess.paths[3] = pthB
+		})
+
+		AfterEach(func() {
+			for _, pth := range []*path{pthA, pthB} {
+				pth.closeChan <- nil
+				Eventually(pth.runClosed).Should(Receive())
+			}
+		})
+
+		It("sends a datagram on the lowest-RTT path", func() {
+			Expect(sess.SendDatagram([]byte("foobar"))).To(Succeed())
+			Expect(sess.scheduler.sendDatagrams(sess)).To(Succeed())
+			Expect(pthB.conn.(*mockConnection).written).To(Receive(ContainSubstring("foobar")))
+			Expect(pthA.conn.(*mockConnection).written).To(BeEmpty())
+			Expect(mconn.written).To(BeEmpty())
+			Expect(sess.streamFramer.HasDatagramFrames()).To(BeFalse())
+		})
+
+		It("keeps the datagrams queued until the handshake is complete", func() {
+			sess.handshakeComplete = false
+			Expect(sess.SendDatagram([]byte("foobar"))).To(Succeed())
+			Expect(sess.scheduler.sendDatagrams(sess)).To(Succeed())
+			Expect(pthB.conn.(*mockConnection).written).To(BeEmpty())
+			Expect(sess.streamFramer.HasDatagramFrames()).To(BeTrue())
+		})
+
+		It("never retransmits a lost datagram", func() {
+			sph := newMockSentPacketHandler().(*mockSentPacketHandler)
+			pthB.sentPacketHandler = sph
+			Expect(sess.SendDatagram([]byte("foobar"))).To(Succeed())
+			Expect(sess.scheduler.sendDatagrams(sess)).To(Succeed())
+			Expect(sph.sentPackets).To(HaveLen(1))
+			Expect(sph.sentPackets[0].Frames).To(Equal([]wire.Frame{&wire.DatagramFrame{Data: []byte("foobar")}}))
+			// declare the packet lost
+			sph.retransmissionQueue = sph.sentPackets
+			hasRetransmission, _, _ := sess.scheduler.getRetransmission(sess)
+			Expect(hasRetransmission).To(BeTrue())
+			Expect(sess.packer.controlFrames).To(BeEmpty())
+			Expect(sess.streamFramer.HasDatagramFrames()).To(BeFalse())
+		})
+
+		It("refuses datagrams that don't fit in a packet", func() {
+			Expect(sess.SendDatagram(make([]byte, protocol.MaxDatagramSize+1))).To(MatchError(errDatagramTooLarge))
+		})
+
+		It("limits the number of queued datagrams", func() {
+			sess.handshakeComplete = false
+			for i := 0; i < protocol.MaxQueuedDatagrams; i++ {
+				Expect(sess.SendDatagram([]byte("foobar"))).To(Succeed())
+			}
+			Expect(sess.SendDatagram([]byte("foobar"))).To(MatchError(errTooManyQueuedDatagrams))
+		})
+
+		It("delivers the received datagrams to the handler", func() {
+			var received [][]byte
+			sess.config.DatagramHandler = func(data []byte) {
+				received = append(received, data)
+			}
+			err := sess.handleFrames([]wire.Frame{
+				&wire.DatagramFrame{Data: []byte("foo")},
+				&wire.DatagramFrame{Data: []byte("bar")},
+			}, sess.paths[0])
+			Expect(err).ToNot(HaveOccurred())
+			Expect(received).To(Equal([][]byte{[]byte("foo"), []byte("bar")}))
+		})
+
+		It("drops the received datagrams without handler", func() {
+			err := sess.handleFrames([]wire.Frame{&wire.DatagramFrame{Data: []byte("foo")}}, sess.paths[0])
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+
+	Context("reinjecting data on failover", func() {
+		var pthA, pthB *path
+
+		BeforeEach(func() {
+			sess.handshakeComplete = true
+			pthA = &path{pathID: 1, sess: sess, conn: newMockConnection()}
+			pthA.setup(nil)
+			pthB = &path{pathID: 3, sess: sess, conn: newMockConnection()}
+			pthB.setup(nil)
+			sess.paths[1] = pthA
+			sess.paths[3] = pthB
+		})
+
+		AfterEach(func() {
+			for _, pth := range []*path{pthA, pthB} {
+				pth.closeChan <- nil
+				Eventually(pth.runClosed).Should(Receive())
+			}
+		})
+
+		It("only reinjects the data the peer didn't ack on another path", func() {
+			_, err := sess.GetOrOpenStream(5)
+			Expect(err).ToNot(HaveOccurred())
+			// the end of the stream data was duplicated on path B, and acked there
+			err = pthB.sentPacketHandler.SentPacket(&ackhandler.Packet{
+				PacketNumber:    1,
+				Frames:          []wire.Frame{&wire.StreamFrame{StreamID: 5, Offset: 3, Data: []byte("bar")}},
+				Length:          100,
+				EncryptionLevel: protocol.EncryptionForwardSecure,
+			})
+			Expect(err).ToNot(HaveOccurred())
+			err = pthB.sentPacketHandler.ReceivedAck(&wire.AckFrame{PathID: 3, LargestAcked: 1, LowestAcked: 1}, 1, time.Now())
+			Expect(err).ToNot(HaveOccurred())
+			// path A fails, and its packet is queued for retransmission
+			sph := newMockSentPacketHandler().(*mockSentPacketHandler)
+			sph.retransmissionQueue = []*ackhandler.Packet{{
+				Frames:          []wire.Frame{&wire.StreamFrame{StreamID: 5, Data: []byte("foobar")}},
+				EncryptionLevel: protocol.EncryptionForwardSecure,
+			}}
+			pthA.sentPacketHandler = sph
+			hasRetransmission, _, _ := sess.scheduler.getRetransmission(sess)
+			Expect(hasRetransmission).To(BeTrue())
+			Expect(sess.streamFramer.retransmissionQueue).To(Equal([]*wire.StreamFrame{
+				{StreamID: 5, Offset: 0, Data: []byte("foo")},
+			}))
+		})
+
+		It("reinjects the whole frame if nothing was acked", func() {
+			_, err := sess.GetOrOpenStream(5)
+			Expect(err).ToNot(HaveOccurred())
+			frame := &wire.StreamFrame{StreamID: 5, Data: []byte("foobar")}
+			sph := newMockSentPacketHandler().(*mockSentPacketHandler)
+			sph.retransmissionQueue = []*ackhandler.Packet{{
+				Frames:          []wire.Frame{frame},
+				EncryptionLevel: protocol.EncryptionForwardSecure,
+			}}
+			pthA.sentPacketHandler = sph
+			sess.scheduler.getRetransmission(sess)
+			Expect(sess.streamFramer.retransmissionQueue).To(Equal([]*wire.StreamFrame{frame}))
+		})
+	})
+
 	Context("retransmissions", func() {
 		var sph *mockSentPacketHandler
 		BeforeEach(func() {
@@ -1325,6 +3277,72 @@ var _ = Describe("Session", func() {
 				Expect(sph.sentPackets).To(BeEmpty())
 			})
 
+			Context("limiting the retransmission rate", func() {
+				queueLosses := func(n int) {
+					_, err := sess.GetOrOpenStream(5)
+					Expect(err).ToNot(HaveOccurred())
+					for i := 0; i < n; i++ {
+						sph.retransmissionQueue = append(sph.retransmissionQueue, &ackhandler.Packet{
+							PacketNumber:    protocol.PacketNumber(i + 1),
+							Frames:          []wire.Frame{&wire.StreamFrame{StreamID: 5, Offset: protocol.ByteCount(10 * i), Data: []byte("0123456789")}},
+							EncryptionLevel: protocol.EncryptionForwardSecure,
+						})
+					}
+				}
+
+				It("reports the retransmission rate of a path", func() {
+					queueLosses(3)
+					hasRetransmission, _ := sess.scheduler.getRetransmissionOfPath(sess, sess.paths[0])
+					Expect(hasRetransmission).To(BeTrue())
+					Expect(sess.streamFramer.retransmissionQueue).To(HaveLen(3))
+					Expect(sess.paths[0].retransmissionRate(time.Now())).To(Equal(float64(3)))
+				})
+
+				It("delays the retransmissions beyond the maximum rate", func() {
+					sess.config.MaxPathRetransmissionRate = 2
+					pth := sess.paths[0]
+					queueLosses(3)
+					sess.scheduler.getRetransmissionOfPath(sess, pth)
+					Expect(sess.streamFramer.retransmissionQueue).To(HaveLen(2))
+					Expect(pth.heldRetransmission.PacketNumber).To(Equal(protocol.PacketNumber(3)))
+					Expect(sph.retransmissionQueue).To(BeEmpty())
+					Expect(sess.nextPathDeadline()).To(BeTemporally("~", time.Now().Add(protocol.RetransmissionRateWindow), 50*time.Millisecond))
+					// still limited
+					sess.scheduler.getRetransmissionOfPath(sess, pth)
+					Expect(sess.streamFramer.retransmissionQueue).To(HaveLen(2))
+					// the earlier retransmissions leave the window
+					for i := range pth.retransmissionTimes {
+						pth.retransmissionTimes[i] = pth.retransmissionTimes[i].Add(-protocol.RetransmissionRateWindow)
+					}
+					hasRetransmission, _ := sess.scheduler.getRetransmissionOfPath(sess, pth)
+					Expect(hasRetransmission).To(BeTrue())
+					Expect(sess.streamFramer.retransmissionQueue).To(HaveLen(3))
+					Expect(pth.heldRetransmission).To(BeNil())
+				})
+
+				It("limits the retransmissions dequeued from all paths", func() {
+					sess.config.MaxPathRetransmissionRate = 2
+					queueLosses(3)
+					hasRetransmission, _, _ := sess.scheduler.getRetransmission(sess)
+					Expect(hasRetransmission).To(BeTrue())
+					Expect(sess.streamFramer.retransmissionQueue).To(HaveLen(2))
+					Expect(sess.paths[0].heldRetransmission.PacketNumber).To(Equal(protocol.PacketNumber(3)))
+					Expect(sess.paths[0].retransmissionRate(time.Now())).To(Equal(float64(2)))
+				})
+
+				It("requeues the held retransmission when its path closes", func() {
+					sess.config.MaxPathRetransmissionRate = 2
+					pth := sess.paths[0]
+					queueLosses(3)
+					sess.scheduler.getRetransmissionOfPath(sess, pth)
+					Expect(pth.heldRetransmission).ToNot(BeNil())
+					Expect(sess.closePath(protocol.InitialPathID, false)).To(Succeed())
+					Expect(pth.heldRetransmission).To(BeNil())
+					Expect(sess.streamFramer.retransmissionQueue).To(HaveLen(3))
+					Expect(sess.streamFramer.retransmissionQueue[2].Offset).To(Equal(protocol.ByteCount(20)))
+				})
+			})
+
 			It("doesn't retransmit WindowUpdates for closed streams", func() {
 				str, err := sess.GetOrOpenStream(5)
 				Expect(err).ToNot(HaveOccurred())
@@ -1837,6 +3855,30 @@ var _ = Describe("Session", func() {
 			}}, sess.paths[0])
 			Expect(err).NotTo(HaveOccurred())
 		})
+
+		It("ignores ACKs received out of order, and applies the later ones", func() {
+			pth := sess.paths[0]
+			for pn := protocol.PacketNumber(1); pn <= 3; pn++ {
+				Expect(pth.sentPacketHandler.SentPacket(&ackhandler.Packet{
+					PacketNumber: pn,
+					Frames:       []wire.Frame{&wire.PingFrame{}},
+					Length:       1,
+				})).To(Succeed())
+			}
+			// the packet with the ACK for packet 2 overtakes the one with the ACK for packet 1
+			pth.lastRcvdPacketNumber = 11
+			err := sess.handleFrames([]wire.Frame{&wire.AckFrame{LargestAcked: 2, LowestAcked: 1}}, pth)
+			Expect(err).NotTo(HaveOccurred())
+			pth.lastRcvdPacketNumber = 10
+			err = sess.handleFrames([]wire.Frame{&wire.AckFrame{LargestAcked: 1, LowestAcked: 1}}, pth)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pth.sentPacketHandler.GetLeastUnacked()).To(Equal(protocol.PacketNumber(3)))
+			pth.lastRcvdPacketNumber = 12
+			err = sess.handleFrames([]wire.Frame{&wire.AckFrame{LargestAcked: 3, LowestAcked: 1}}, pth)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pth.sentPacketHandler.GetLeastUnacked()).To(Equal(protocol.PacketNumber(4)))
+			Expect(sess.Context().Err()).ToNot(HaveOccurred())
+		})
 	})
 
 	Context("window updates", func() {
@@ -1859,6 +3901,91 @@ var _ = Describe("Session", func() {
 			Expect(frames[0].StreamID).To(Equal(protocol.StreamID(0)))
 			Expect(frames[0].ByteOffset).To(BeEquivalentTo(protocol.ReceiveConnectionFlowControlWindow * 2))
 		})
+
+		Context("bounding the reassembly buffer", func() {
+			streamWindowUpdates := func() []protocol.ByteCount {
+				var offsets []protocol.ByteCount
+				for _, f := range sess.getWindowUpdateFrames(false) {
+					if f.StreamID == 5 {
+						offsets = append(offsets, f.ByteOffset)
+					}
+				}
+				return offsets
+			}
+
+			receive := func(offset, length protocol.ByteCount) {
+				err := sess.handleStreamFrame(&wire.StreamFrame{StreamID: 5, Offset: offset, Data: make([]byte, length)})
+				Expect(err).ToNot(HaveOccurred())
+			}
+
+			read := func(n protocol.ByteCount) {
+				str, err := sess.streamsMap.GetOrOpenStream(5)
+				Expect(err).ToNot(HaveOccurred())
+				_, err = io.ReadFull(str, make([]byte, n))
+				Expect(err).ToNot(HaveOccurred())
+			}
+
+			It("throttles the fast path while data of a slow path is missing", func() {
+				sess.config.MaxReassemblyBuffer = 0x2000
+				receive(0, 0x7000)
+				read(0x7000)
+				Expect(streamWindowUpdates()).To(Equal([]protocol.ByteCount{0x9000}))
+				// the data between 0x7000 and 0x8000 is still in flight on a slow path
+				receive(0x8000, 0x1000)
+				Expect(streamWindowUpdates()).To(BeEmpty())
+				// the slow path catches up
+				receive(0x7000, 0x1000)
+				read(0x2000)
+				Expect(streamWindowUpdates()).To(Equal([]protocol.ByteCount{0xb000}))
+			})
+
+			It("doesn't bound the window if not configured", func() {
+				receive(0, 0x7000)
+				read(0x7000)
+				Expect(streamWindowUpdates()).To(Equal([]protocol.ByteCount{0x7000 + protocol.ReceiveStreamFlowControlWindow}))
+			})
+		})
+
+		Context("sending", func() {
+			var sph *mockSentPacketHandler
+
+			BeforeEach(func() {
+				sess.packer.cryptoSetup = &mockCryptoSetup{encLevelSeal: protocol.EncryptionForwardSecure}
+				sph = newMockSentPacketHandler().(*mockSentPacketHandler)
+				sess.paths[0].sentPacketHandler = sph
+			})
+
+			sendWindowUpdate := func() int {
+				wuf := &wire.WindowUpdateFrame{StreamID: 5, ByteOffset: 0x1000}
+				sess.packer.QueueControlFrame(wuf, sess.paths[0])
+				_, sent, err := sess.scheduler.performPacketSending(sess, []*wire.WindowUpdateFrame{wuf}, sess.paths[0])
+				Expect(err).ToNot(HaveOccurred())
+				Expect(sent).To(BeTrue())
+				// send the next packet, which may repeat the window update
+				_, _, err = sess.scheduler.performPacketSending(sess, nil, sess.paths[0])
+				Expect(err).ToNot(HaveOccurred())
+				var count int
+				for _, p := range sph.sentPackets {
+					for _, f := range p.Frames {
+						if _, ok := f.(*wire.WindowUpdateFrame); ok {
+							count++
+						}
+					}
+				}
+				return count
+			}
+
+			It("sends every window update twice by default", func() {
+				Expect(sendWindowUpdate()).To(Equal(2))
+				Expect(sph.sentPackets).To(HaveLen(2))
+			})
+
+			It("sends every window update once, if configured", func() {
+				sess.config.SendWindowUpdatesOnce = true
+				Expect(sendWindowUpdate()).To(Equal(1))
+				Expect(sph.sentPackets).To(HaveLen(1))
+			})
+		})
 	})
 
 	It("returns the local address", func() {
@@ -1872,6 +3999,20 @@ var _ = Describe("Session", func() {
 		mconn.remoteAddr = addr
 		Expect(sess.RemoteAddr()).To(Equal(addr))
 	})
+
+	It("returns the addresses of all paths", func() {
+		localAddrA := &net.UDPAddr{IP: net.IPv4(192, 168, 1, 2), Port: 1337}
+		remoteAddrA := &net.UDPAddr{IP: net.IPv4(1, 2, 7, 1), Port: 443}
+		localAddrB := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 4242}
+		remoteAddrB := &net.UDPAddr{IP: net.IPv4(1, 2, 7, 2), Port: 443}
+		mconn.localAddr = localAddrA
+		mconn.remoteAddr = remoteAddrA
+		sess.paths[1] = &path{pathID: 1, conn: &mockConnection{localAddr: localAddrB, remoteAddr: remoteAddrB}}
+		Expect(sess.Paths()).To(Equal([]PathInfo{
+			{PathID: protocol.InitialPathID, LocalAddr: localAddrA, RemoteAddr: remoteAddrA},
+			{PathID: 1, LocalAddr: localAddrB, RemoteAddr: remoteAddrB},
+		}))
+	})
 })
 
 var _ = Describe("Client Session", func() {
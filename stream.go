@@ -12,6 +12,7 @@ import (
 	"github.com/lucas-clemente/pstream/internal/protocol"
 	"github.com/lucas-clemente/pstream/internal/utils"
 	"github.com/lucas-clemente/pstream/internal/wire"
+	"github.com/lucas-clemente/pstream/qerr"
 )
 
 // A Stream assembles the data from StreamFrames and provides a super-convenient Read-Interface
@@ -30,6 +31,12 @@ type stream struct {
 	priority   *protocol.Priority
 	size       protocol.ByteCount //Byte
 	checksize  bool               //whether the size is recorded
+	// the volume assigned to paths was capped by the send window, the remainder is assigned once it grows
+	windowLimited bool
+	// paths the stream was assigned to before it was rescheduled, it stays on them unless another path is clearly better
+	previousPaths map[protocol.PathID]bool
+	// striped streams are split across all paths without waiting for their size
+	striped utils.AtomicBool
 
 	onData func()
 	// onReset is a callback that should send a RST_STREAM
@@ -57,6 +64,23 @@ type stream struct {
 	readChan     chan struct{}
 	readDeadline time.Time
 
+	// the start of the first gap in the received data, and since when it is missing
+	gapStart protocol.ByteCount
+	gapSince time.Time
+
+	// data sent longer than expiry ago is not retransmitted anymore, if set
+	expiry time.Duration
+	// the data written before this offset was flushed, the paths of the stream send it even beyond their volume
+	flushOffset protocol.ByteCount
+	// limits the rate of the data of the stream, nil if SetMaxSendRate was not called
+	sendRateLimiter *sendRateLimiter
+	// Write only buffers the data until the stream holds this much, as long as nothing was sent, see Config.MinDetectedStreamSize
+	minDetectedSize protocol.ByteCount
+	// when the scheduler stops waiting for minDetectedSize, zero until Write buffered data for it
+	sizeDetectionDeadline time.Time
+	// ranges of the sent data acked by the peer on any path, sorted and not overlapping
+	ackedData []utils.ByteInterval
+
 	dataForWriting []byte
 	finSent        utils.AtomicBool
 	rstSent        utils.AtomicBool
@@ -164,7 +188,8 @@ func (s *stream) Read(p []byte) (int, error) {
 	for bytesRead < len(p) {
 		s.mutex.Lock()
 		frame := s.frameQueue.Head()
-		if frame == nil && bytesRead > 0 {
+		skipped := s.frameQueue.HeadSkipped()
+		if frame == nil && skipped == 0 && bytesRead > 0 {
 			err = s.err
 			s.mutex.Unlock()
 			return bytesRead, err
@@ -184,6 +209,11 @@ func (s *stream) Read(p []byte) (int, error) {
 				break
 			}
 
+			// data that expired at the peer comes before a FIN at the same offset
+			if skipped != 0 {
+				break
+			}
+
 			if frame != nil {
 				s.readPosInFrame = int(s.readOffset - frame.Offset)
 				break
@@ -200,6 +230,7 @@ func (s *stream) Read(p []byte) (int, error) {
 			}
 			s.mutex.Lock()
 			frame = s.frameQueue.Head()
+			skipped = s.frameQueue.HeadSkipped()
 		}
 		s.mutex.Unlock()
 
@@ -207,6 +238,11 @@ func (s *stream) Read(p []byte) (int, error) {
 			return bytesRead, err
 		}
 
+		if skipped != 0 {
+			s.skipData()
+			continue
+		}
+
 		m := utils.Min(len(p)-bytesRead, int(frame.DataLen())-s.readPosInFrame)
 
 		if bytesRead > len(p) {
@@ -242,6 +278,19 @@ func (s *stream) Read(p []byte) (int, error) {
 	return bytesRead, nil
 }
 
+// skipData consumes the skipped range at the read position without delivering any data to the application
+func (s *stream) skipData() {
+	s.mutex.Lock()
+	n := s.frameQueue.PopSkipped()
+	s.mutex.Unlock()
+
+	s.readOffset += n
+	if !s.resetRemotely.Get() {
+		s.flowControlManager.AddBytesRead(s.streamID, n)
+	}
+	s.onData() // so that a possible WINDOW_UPDATE is sent
+}
+
 func (s *stream) Write(p []byte) (int, error) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
@@ -256,9 +305,15 @@ func (s *stream) Write(p []byte) (int, error) {
 		return 0, nil
 	}
 
-	s.dataForWriting = make([]byte, len(p))
-	copy(s.dataForWriting, p)
+	s.dataForWriting = append(s.dataForWriting, p...)
 	s.onData()
+	// the scheduler waits for more data to split the stream, don't wait for it to be sent
+	if s.writeOffset == 0 && protocol.ByteCount(len(s.dataForWriting)) < s.minDetectedSize {
+		if s.sizeDetectionDeadline.IsZero() {
+			s.sizeDetectionDeadline = time.Now().Add(protocol.MaxStreamSizeDetectionDelay)
+		}
+		return len(p), nil
+	}
 
 	var err error
 	for {
@@ -287,7 +342,8 @@ func (s *stream) Write(p []byte) (int, error) {
 		return 0, err
 	}
 	if s.err != nil {
-		return len(p) - len(s.dataForWriting), s.err
+		// the data buffered by previous writes is sent first
+		return utils.Max(0, len(p)-len(s.dataForWriting)), s.err
 	}
 	return len(p), nil
 }
@@ -335,6 +391,12 @@ func (s *stream) getDataForWriting(maxBytes protocol.ByteCount) []byte {
 
 // Close implements io.Closer
 func (s *stream) Close() error {
+	return s.CloseWrite()
+}
+
+// CloseWrite closes the write side of the stream.
+// The FIN is sent with the last data written, on whichever path of the stream sends it.
+func (s *stream) CloseWrite() error {
 	s.finishedWriting.Set(true)
 	s.ctxCancel()
 	s.onData()
@@ -359,7 +421,9 @@ func (s *stream) sentFin() {
 	s.finSent.Set(true)
 }
 
-// AddStreamFrame adds a new stream frame
+// AddStreamFrame adds a new stream frame.
+// Data received more than once, e.g. sent on two paths, is delivered once: the frame sorter drops the ranges
+// already received, and flow control only counts the highest offset.
 func (s *stream) AddStreamFrame(frame *wire.StreamFrame) error {
 	maxOffset := frame.Offset + frame.DataLen()
 	err := s.flowControlManager.UpdateHighestReceived(s.streamID, maxOffset)
@@ -377,6 +441,197 @@ func (s *stream) AddStreamFrame(frame *wire.StreamFrame) error {
 	return nil
 }
 
+// SkipStreamData handles a range of data that expired at the peer. The range is never delivered to the application.
+// Like data, the range must fit in the receive window, and only the range is recorded, not a buffer of its length.
+func (s *stream) SkipStreamData(offset, length protocol.ByteCount) error {
+	if length == 0 {
+		return nil
+	}
+	if offset+length < offset {
+		return qerr.Error(qerr.InvalidStreamData, "skipped data overflows maximum offset")
+	}
+	err := s.flowControlManager.UpdateHighestReceived(s.streamID, offset+length)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	err = s.frameQueue.Skip(offset, length)
+	if err == errDuplicateStreamData {
+		// all data of the range was already received
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	s.signalRead()
+	return nil
+}
+
+// SetExpiry sets the time after which lost data is not retransmitted anymore
+func (s *stream) SetExpiry(d time.Duration) {
+	s.mutex.Lock()
+	s.expiry = d
+	s.mutex.Unlock()
+}
+
+// SetMaxSendRate limits the rate of the data of the stream on all its paths together, in bytes per second.
+// A rate of 0 removes the limit.
+func (s *stream) SetMaxSendRate(rate uint64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if rate == 0 {
+		s.sendRateLimiter = nil
+		return
+	}
+	s.sendRateLimiter = newSendRateLimiter(rate)
+}
+
+func (s *stream) getSendRateLimiter() *sendRateLimiter {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.sendRateLimiter
+}
+
+// sendRateLimited says if the stream has data to send, but has to wait for its send rate
+func (s *stream) sendRateLimited(now time.Time) bool {
+	limiter := s.getSendRateLimiter()
+	return limiter != nil && s.lenOfDataForWriting() != 0 && !limiter.sendingAllowed(now)
+}
+
+// Flush makes all paths of the stream send the data written so far in the next send cycle,
+// even the paths that already sent the volume assigned to them
+func (s *stream) Flush() {
+	s.mutex.Lock()
+	s.flushOffset = s.writeOffset + protocol.ByteCount(len(s.dataForWriting))
+	s.mutex.Unlock()
+	s.onData()
+}
+
+// getSizeDetectionDeadline returns when the scheduler stops waiting for Config.MinDetectedStreamSize,
+// or a zero time if the stream did not buffer data for it
+func (s *stream) getSizeDetectionDeadline() time.Time {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.sizeDetectionDeadline
+}
+
+// flushing checks if flushed data is left to send
+func (s *stream) flushing() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.writeOffset < s.flushOffset
+}
+
+// dataExpired checks if data sent at sendTime expired
+func (s *stream) dataExpired(sendTime, now time.Time) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.expiry > 0 && now.Sub(sendTime) > s.expiry
+}
+
+// onDataAcked records that the peer acked the sent data in [offset, offset+length)
+func (s *stream) onDataAcked(offset, length protocol.ByteCount) {
+	if length == 0 {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	acked := utils.ByteInterval{Start: offset, End: offset + length}
+	ackedData := make([]utils.ByteInterval, 0, len(s.ackedData)+1)
+	inserted := false
+	for _, intv := range s.ackedData {
+		if intv.End < acked.Start {
+			ackedData = append(ackedData, intv)
+			continue
+		}
+		if intv.Start > acked.End {
+			if !inserted {
+				ackedData = append(ackedData, acked)
+				inserted = true
+			}
+			ackedData = append(ackedData, intv)
+			continue
+		}
+		// merge overlapping and adjacent ranges
+		acked.Start = utils.MinByteCount(acked.Start, intv.Start)
+		acked.End = utils.MaxByteCount(acked.End, intv.End)
+	}
+	if !inserted {
+		ackedData = append(ackedData, acked)
+	}
+	s.ackedData = ackedData
+}
+
+// unackedRanges returns the parts of [offset, offset+length) the peer didn't ack yet
+func (s *stream) unackedRanges(offset, length protocol.ByteCount) []utils.ByteInterval {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var ranges []utils.ByteInterval
+	start, end := offset, offset+length
+	for _, intv := range s.ackedData {
+		if intv.End <= start {
+			continue
+		}
+		if intv.Start >= end {
+			break
+		}
+		if intv.Start > start {
+			ranges = append(ranges, utils.ByteInterval{Start: start, End: intv.Start})
+		}
+		start = intv.End
+		if start >= end {
+			return ranges
+		}
+	}
+	return append(ranges, utils.ByteInterval{Start: start, End: end})
+}
+
+// contiguousReceiveOffset returns the offset up to which all data of the stream was received
+func (s *stream) contiguousReceiveOffset() protocol.ByteCount {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.frameQueue.ContiguousOffset()
+}
+
+// getMissingData returns the first range of data that has been missing for longer than timeout,
+// while data at higher offsets was already received. The same range is returned again once the timeout
+// expires another time.
+func (s *stream) getMissingData(now time.Time, timeout time.Duration) (utils.ByteInterval, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	gap, ok := s.frameQueue.FirstGap()
+	if !ok {
+		s.gapSince = time.Time{}
+		return utils.ByteInterval{}, false
+	}
+	if s.gapSince.IsZero() || gap.Start != s.gapStart {
+		s.gapStart = gap.Start
+		s.gapSince = now
+		return utils.ByteInterval{}, false
+	}
+	if now.Sub(s.gapSince) < timeout {
+		return utils.ByteInterval{}, false
+	}
+	s.gapSince = now
+	return gap, true
+}
+
+// gapDeadline returns when the first gap in the received data times out, see getMissingData,
+// or a zero time if there is no gap
+func (s *stream) gapDeadline(timeout time.Duration) time.Time {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.gapSince.IsZero() {
+		return time.Time{}
+	}
+	return s.gapSince.Add(timeout)
+}
+
 // signalRead performs a non-blocking send on the readChan
 func (s *stream) signalRead() {
 	select {
@@ -12,6 +12,8 @@ type streamFrameSorter struct {
 	queuedFrames map[protocol.ByteCount]*wire.StreamFrame
 	readPosition protocol.ByteCount
 	gaps         *utils.ByteIntervalList
+	// lengths of the ranges that expired at the peer, by offset. No data is stored for them
+	skipped map[protocol.ByteCount]protocol.ByteCount
 }
 
 var (
@@ -90,10 +92,19 @@ func (s *streamFrameSorter) Push(frame *wire.StreamFrame) error {
 		}
 		// delete queued frames completely covered by the current frame
 		delete(s.queuedFrames, endGap.Value.End)
+		delete(s.skipped, endGap.Value.End)
 		endGap = nextEndGap
 	}
 
 	if end > endGap.Value.End {
+		// the end of the frame was already received, e.g. on another path.
+		// Its FinBit is kept at the end of the stream, and not at the end of the cut frame.
+		if frame.FinBit {
+			if _, ok := s.queuedFrames[end]; !ok {
+				s.queuedFrames[end] = &wire.StreamFrame{StreamID: frame.StreamID, Offset: end, FinBit: true}
+			}
+			frame.FinBit = false
+		}
 		cutLen := end - endGap.Value.End
 		len := frame.DataLen() - cutLen
 		end -= cutLen
@@ -143,6 +154,82 @@ func (s *streamFrameSorter) Push(frame *wire.StreamFrame) error {
 	return nil
 }
 
+// Skip marks the missing data of a range that expired at the peer as received, without storing any data for it.
+// It returns errDuplicateStreamData if all data of the range was already received.
+func (s *streamFrameSorter) Skip(offset, length protocol.ByteCount) error {
+	start := offset
+	end := offset + length
+	var skipped bool
+	for gap := s.gaps.Front(); gap != nil && gap.Value.Start < end; {
+		next := gap.Next()
+		if gap.Value.End <= start {
+			gap = next
+			continue
+		}
+		from := utils.MaxByteCount(start, gap.Value.Start)
+		to := utils.MinByteCount(end, gap.Value.End)
+		if s.skipped == nil {
+			s.skipped = make(map[protocol.ByteCount]protocol.ByteCount)
+		}
+		s.skipped[from] = to - from
+		skipped = true
+
+		if from == gap.Value.Start && to == gap.Value.End {
+			s.gaps.Remove(gap)
+		} else if from == gap.Value.Start {
+			gap.Value.Start = to
+		} else if to == gap.Value.End {
+			gap.Value.End = from
+		} else {
+			// the range lies within the gap, splitting it into two
+			s.gaps.InsertAfter(utils.ByteInterval{Start: to, End: gap.Value.End}, gap)
+			gap.Value.End = from
+		}
+		gap = next
+	}
+
+	if !skipped {
+		return errDuplicateStreamData
+	}
+	if s.gaps.Len() > protocol.MaxStreamFrameSorterGaps {
+		return errTooManyGapsInReceivedStreamData
+	}
+	return nil
+}
+
+// HeadSkipped returns the length of the skipped range at the read position, or 0 if there is none
+func (s *streamFrameSorter) HeadSkipped() protocol.ByteCount {
+	return s.skipped[s.readPosition]
+}
+
+// PopSkipped moves the read position past the skipped range at the read position
+func (s *streamFrameSorter) PopSkipped() protocol.ByteCount {
+	length := s.HeadSkipped()
+	if length != 0 {
+		delete(s.skipped, s.readPosition)
+		s.readPosition += length
+	}
+	return length
+}
+
+// ContiguousOffset returns the offset up to which all data was received
+func (s *streamFrameSorter) ContiguousOffset() protocol.ByteCount {
+	gap := s.gaps.Front()
+	if gap == nil {
+		return protocol.MaxByteCount
+	}
+	return gap.Value.Start
+}
+
+// FirstGap returns the first range of missing data that lies before data that was already received
+func (s *streamFrameSorter) FirstGap() (utils.ByteInterval, bool) {
+	gap := s.gaps.Front()
+	if gap == nil || gap.Value.End == protocol.MaxByteCount {
+		return utils.ByteInterval{}, false
+	}
+	return gap.Value, true
+}
+
 func (s *streamFrameSorter) Pop() *wire.StreamFrame {
 	frame := s.Head()
 	if frame != nil {
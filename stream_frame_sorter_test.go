@@ -30,6 +30,82 @@ var _ = Describe("StreamFrame sorter", func() {
 		Expect(s.Head()).To(BeNil())
 	})
 
+	Context("FirstGap", func() {
+		It("doesn't report a gap if all data was received in order", func() {
+			err := s.Push(&wire.StreamFrame{Offset: 0, Data: []byte("foo")})
+			Expect(err).ToNot(HaveOccurred())
+			_, ok := s.FirstGap()
+			Expect(ok).To(BeFalse())
+		})
+
+		It("reports the first gap before received data", func() {
+			err := s.Push(&wire.StreamFrame{Offset: 3, Data: []byte("bar")})
+			Expect(err).ToNot(HaveOccurred())
+			err = s.Push(&wire.StreamFrame{Offset: 10, Data: []byte("baz")})
+			Expect(err).ToNot(HaveOccurred())
+			gap, ok := s.FirstGap()
+			Expect(ok).To(BeTrue())
+			Expect(gap).To(Equal(utils.ByteInterval{Start: 0, End: 3}))
+			err = s.Push(&wire.StreamFrame{Offset: 0, Data: []byte("foo")})
+			Expect(err).ToNot(HaveOccurred())
+			gap, ok = s.FirstGap()
+			Expect(ok).To(BeTrue())
+			Expect(gap).To(Equal(utils.ByteInterval{Start: 6, End: 10}))
+		})
+	})
+
+	Context("skipping data", func() {
+		It("skips a range without storing data", func() {
+			err := s.Skip(0, 1000)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(s.queuedFrames).To(BeEmpty())
+			Expect(s.Head()).To(BeNil())
+			Expect(s.HeadSkipped()).To(Equal(protocol.ByteCount(1000)))
+			Expect(s.PopSkipped()).To(Equal(protocol.ByteCount(1000)))
+			Expect(s.readPosition).To(Equal(protocol.ByteCount(1000)))
+			Expect(s.HeadSkipped()).To(BeZero())
+			checkGaps([]utils.ByteInterval{{Start: 1000, End: protocol.MaxByteCount}})
+		})
+
+		It("only skips the missing parts of a range", func() {
+			err := s.Push(&wire.StreamFrame{Offset: 3, Data: []byte("bar")})
+			Expect(err).ToNot(HaveOccurred())
+			err = s.Skip(0, 10)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(s.skipped).To(Equal(map[protocol.ByteCount]protocol.ByteCount{0: 3, 6: 4}))
+			checkGaps([]utils.ByteInterval{{Start: 10, End: protocol.MaxByteCount}})
+			Expect(s.PopSkipped()).To(Equal(protocol.ByteCount(3)))
+			Expect(s.Pop().Data).To(Equal([]byte("bar")))
+			Expect(s.PopSkipped()).To(Equal(protocol.ByteCount(4)))
+		})
+
+		It("splits a gap when skipping a range in its middle", func() {
+			err := s.Skip(5, 5)
+			Expect(err).ToNot(HaveOccurred())
+			checkGaps([]utils.ByteInterval{
+				{Start: 0, End: 5},
+				{Start: 10, End: protocol.MaxByteCount},
+			})
+			Expect(s.HeadSkipped()).To(BeZero())
+		})
+
+		It("cuts data received for a skipped range", func() {
+			err := s.Skip(0, 3)
+			Expect(err).ToNot(HaveOccurred())
+			err = s.Push(&wire.StreamFrame{Offset: 0, Data: []byte("foobar")})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(s.PopSkipped()).To(Equal(protocol.ByteCount(3)))
+			Expect(s.Pop().Data).To(Equal([]byte("bar")))
+		})
+
+		It("detects a range that was already received", func() {
+			err := s.Push(&wire.StreamFrame{Offset: 0, Data: []byte("foobar")})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(s.Skip(2, 3)).To(MatchError(errDuplicateStreamData))
+			Expect(s.skipped).To(BeEmpty())
+		})
+	})
+
 	Context("Push", func() {
 		It("inserts and pops a single frame", func() {
 			f := &wire.StreamFrame{
@@ -94,6 +170,28 @@ var _ = Describe("StreamFrame sorter", func() {
 				Expect(s.Pop()).To(Equal(f1))
 				Expect(s.Pop()).To(Equal(f2))
 			})
+
+			It("keeps the FinBit at the end of the stream when cutting a frame that overlaps at the end", func() {
+				f1 := &wire.StreamFrame{
+					Offset: 3,
+					Data:   []byte("bar"),
+					FinBit: true,
+				}
+				err := s.Push(f1)
+				Expect(err).ToNot(HaveOccurred())
+				f2 := &wire.StreamFrame{
+					Offset: 0,
+					Data:   []byte("foobar"),
+					FinBit: true,
+				}
+				err = s.Push(f2)
+				Expect(err).ToNot(HaveOccurred())
+				frame := s.Pop()
+				Expect(frame.Data).To(Equal([]byte("foo")))
+				Expect(frame.FinBit).To(BeFalse())
+				Expect(s.Pop()).To(Equal(f1))
+				Expect(s.queuedFrames).To(HaveKey(protocol.ByteCount(6)))
+			})
 		})
 
 		Context("Gap handling", func() {
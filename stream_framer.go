@@ -2,7 +2,9 @@ package quic
 
 import (
 	"net"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/lucas-clemente/pstream/internal/flowcontrol"
@@ -22,7 +24,17 @@ type streamFramer struct {
 	closePathFrameQueue  []*wire.ClosePathFrame
 	pathsFrame           *wire.PathsFrame
 
+	// datagrams are queued by the application, outside of the session's run loop
+	datagramMutex      sync.Mutex
+	datagramFrameQueue []*wire.DatagramFrame
+
+	// the earliest time a stream limited by its send rate may send again, see Stream.SetMaxSendRate
+	rateLimitedUntil time.Time
+
 	streamTree *streamTree
+
+	// if set, the STREAM frames carry a checksum of their data, see Config.StreamFrameChecksums
+	checksums bool
 }
 
 func newStreamFramer(streamsMap *streamsMap, flowControlManager flowcontrol.FlowControlManager) *streamFramer {
@@ -40,8 +52,63 @@ func newStreamFramerTree(streamsMap *streamsMap, flowControlManager flowcontrol.
 	}
 }
 
+// AddFrameForRetransmission queues a frame for retransmission.
+// The queue is ordered by stream ID and offset, such that the earliest missing data of a stream is retransmitted first.
 func (f *streamFramer) AddFrameForRetransmission(frame *wire.StreamFrame) {
-	f.retransmissionQueue = append(f.retransmissionQueue, frame)
+	i := sort.Search(len(f.retransmissionQueue), func(i int) bool {
+		queued := f.retransmissionQueue[i]
+		return queued.StreamID > frame.StreamID || (queued.StreamID == frame.StreamID && queued.Offset > frame.Offset)
+	})
+	f.retransmissionQueue = append(f.retransmissionQueue, nil)
+	copy(f.retransmissionQueue[i+1:], f.retransmissionQueue[i:])
+	f.retransmissionQueue[i] = frame
+}
+
+// AddFrameForRetransmissionUnlessExpired queues a frame for retransmission, unless the data of its stream expired.
+// It returns false if the frame was dropped, in which case the peer has to be told to skip the data.
+// Frames carrying a FIN are always retransmitted.
+// Data that the peer already acked, e.g. in a packet sent on another path, is not retransmitted.
+func (f *streamFramer) AddFrameForRetransmissionUnlessExpired(frame *wire.StreamFrame, sendTime time.Time) bool {
+	str, err := f.streamsMap.GetOrOpenStream(frame.StreamID)
+	if err != nil || str == nil {
+		f.AddFrameForRetransmission(frame)
+		return true
+	}
+	if !frame.FinBit && str.dataExpired(sendTime, time.Now()) {
+		return false
+	}
+	for _, unacked := range unackedStreamFrames(str, frame) {
+		f.AddFrameForRetransmission(unacked)
+	}
+	return true
+}
+
+// unackedStreamFrames splits a frame into the parts carrying data not acked yet.
+// A FIN is always kept, if needed in a frame without data.
+func unackedStreamFrames(str *stream, frame *wire.StreamFrame) []*wire.StreamFrame {
+	if frame.DataLen() == 0 {
+		return []*wire.StreamFrame{frame}
+	}
+	ranges := str.unackedRanges(frame.Offset, frame.DataLen())
+	if len(ranges) == 1 && ranges[0].Start == frame.Offset && ranges[0].End == frame.Offset+frame.DataLen() {
+		return []*wire.StreamFrame{frame}
+	}
+	end := frame.Offset + frame.DataLen()
+	frames := make([]*wire.StreamFrame, 0, len(ranges)+1)
+	for _, r := range ranges {
+		frames = append(frames, &wire.StreamFrame{
+			StreamID:        frame.StreamID,
+			Offset:          r.Start,
+			Data:            frame.Data[r.Start-frame.Offset : r.End-frame.Offset],
+			DataLenPresent:  frame.DataLenPresent,
+			ChecksumPresent: frame.ChecksumPresent,
+			FinBit:          frame.FinBit && r.End == end,
+		})
+	}
+	if frame.FinBit && (len(ranges) == 0 || ranges[len(ranges)-1].End != end) {
+		frames = append(frames, &wire.StreamFrame{StreamID: frame.StreamID, Offset: end, FinBit: true, ChecksumPresent: frame.ChecksumPresent})
+	}
+	return frames
 }
 
 func (f *streamFramer) PopStreamFrames(maxLen protocol.ByteCount) []*wire.StreamFrame {
@@ -110,10 +177,9 @@ func (f *streamFramer) AddPathsFrameForTransmission(s *session) {
 		port = make([]string, 0)
 	}
 
-	i := 0
-	for pathID := range s.paths {
+	for i, pathID := range sortedPathsForPathsFrame(s) {
 		paths[i] = pathID
-		if s.paths[pathID].potentiallyFailed.Get() {
+		if s.paths[pathID].potentiallyFailed.Get() || s.paths[pathID].connFailed.Get() {
 			remoteRTTs[i] = time.Hour
 		} else {
 			remoteRTTs[i] = s.paths[pathID].rttStats.SmoothedRTT()
@@ -122,12 +188,49 @@ func (f *streamFramer) AddPathsFrameForTransmission(s *session) {
 			//  fill info about path initiated by the client, and send this to server to trigger remote path creation
 			IP[i], port[i] = parseIPAndPort(s.paths[pathID].conn.LocalAddr().String())
 		}
-
-		i++
 	}
 	f.pathsFrame = &wire.PathsFrame{MaxNumPaths: 255, NumPaths: uint8(len(paths)), NumIPs: numIPs, PathIDs: paths, RemoteRTTs: remoteRTTs, RemoteAddrsIP: IP, RemoteAddrsPort: port}
 }
 
+// sortedPathsForPathsFrame orders the paths as configured by Config.PathsFrameOrder, the failed paths last.
+// The caller holds the paths lock.
+func sortedPathsForPathsFrame(s *session) []protocol.PathID {
+	pathIDs := make([]protocol.PathID, 0, len(s.paths))
+	for pathID := range s.paths {
+		pathIDs = append(pathIDs, pathID)
+	}
+	failed := func(pth *path) bool {
+		return pth.potentiallyFailed.Get() || pth.connFailed.Get()
+	}
+	sort.Slice(pathIDs, func(i, j int) bool {
+		pi, pj := s.paths[pathIDs[i]], s.paths[pathIDs[j]]
+		if failed(pi) != failed(pj) {
+			return failed(pj)
+		}
+		// the estimate of a path without samples yet is at most seeded, such paths come after the probed ones
+		switch s.config.PathsFrameOrder {
+		case PathsFrameLowestRTTFirst:
+			probedI, probedJ := pi.rttStats.NumSamples() != 0, pj.rttStats.NumSamples() != 0
+			if probedI != probedJ {
+				return probedI
+			}
+			if rttI, rttJ := pi.rttStats.SmoothedRTT(), pj.rttStats.SmoothedRTT(); probedI && rttI != rttJ {
+				return rttI < rttJ
+			}
+		case PathsFrameHighestBandwidthFirst:
+			probedI, probedJ := pi.bdwStats.NumSamples() != 0, pj.bdwStats.NumSamples() != 0
+			if probedI != probedJ {
+				return probedI
+			}
+			if bdwI, bdwJ := pi.bdwStats.GetBandwidthBps(), pj.bdwStats.GetBandwidthBps(); probedI && bdwI != bdwJ {
+				return bdwI > bdwJ
+			}
+		}
+		return pathIDs[i] < pathIDs[j]
+	})
+	return pathIDs
+}
+
 func (f *streamFramer) PopPathsFrame() *wire.PathsFrame {
 	if f.pathsFrame == nil {
 		return nil
@@ -150,13 +253,54 @@ func (f *streamFramer) PopClosePathFrame() *wire.ClosePathFrame {
 	return frame
 }
 
+// AddDatagramForTransmission queues a DATAGRAM frame with a copy of the data
+func (f *streamFramer) AddDatagramForTransmission(data []byte) error {
+	f.datagramMutex.Lock()
+	defer f.datagramMutex.Unlock()
+	if len(f.datagramFrameQueue) >= protocol.MaxQueuedDatagrams {
+		return errTooManyQueuedDatagrams
+	}
+	frame := &wire.DatagramFrame{Data: make([]byte, len(data))}
+	copy(frame.Data, data)
+	f.datagramFrameQueue = append(f.datagramFrameQueue, frame)
+	return nil
+}
+
+func (f *streamFramer) HasDatagramFrames() bool {
+	f.datagramMutex.Lock()
+	defer f.datagramMutex.Unlock()
+	return len(f.datagramFrameQueue) > 0
+}
+
+func (f *streamFramer) PopDatagramFrame() *wire.DatagramFrame {
+	f.datagramMutex.Lock()
+	defer f.datagramMutex.Unlock()
+	if len(f.datagramFrameQueue) == 0 {
+		return nil
+	}
+	frame := f.datagramFrameQueue[0]
+	f.datagramFrameQueue = f.datagramFrameQueue[1:]
+	return frame
+}
+
 func (f *streamFramer) HasFramesForRetransmission() bool {
 	return len(f.retransmissionQueue) > 0
 }
 
+// hasFramesForRetransmissionOfStream checks if frames of the stream wait for retransmission.
+// They are popped by the paths the stream is assigned to, a stream moved to other paths takes them along.
+func (f *streamFramer) hasFramesForRetransmissionOfStream(streamID protocol.StreamID) bool {
+	for _, frame := range f.retransmissionQueue {
+		if frame.StreamID == streamID {
+			return true
+		}
+	}
+	return false
+}
+
 func (f *streamFramer) HasCryptoStreamFrame() bool {
 	// TODO(#657): Flow control
-	cs, _ := f.streamsMap.GetOrOpenStream(1)
+	cs, _ := f.streamsMap.GetOrOpenStream(cryptoStreamID)
 	return cs.lenOfDataForWriting() > 0
 }
 
@@ -166,10 +310,11 @@ func (f *streamFramer) PopCryptoStreamFrame(maxLen protocol.ByteCount) *wire.Str
 	if !f.HasCryptoStreamFrame() {
 		return nil
 	}
-	cs, _ := f.streamsMap.GetOrOpenStream(1)
+	cs, _ := f.streamsMap.GetOrOpenStream(cryptoStreamID)
 	frame := &wire.StreamFrame{
-		StreamID: 1,
-		Offset:   cs.writeOffset,
+		StreamID:        cryptoStreamID,
+		Offset:          cs.writeOffset,
+		ChecksumPresent: f.checksums,
 	}
 	frameHeaderBytes, _ := frame.MinLength(protocol.VersionWhatever) // can never error
 	frame.Data = cs.getDataForWriting(maxLen - frameHeaderBytes)
@@ -215,9 +360,10 @@ func (f *streamFramer) maybePopFramesForRetransmission(maxLen protocol.ByteCount
 //  return all retransmission frames of the path if maxLen allows
 func (f *streamFramer) maybePopFramesForRetransmissionOfPath(maxLen protocol.ByteCount, pth *path) (res []*wire.StreamFrame, currentLen protocol.ByteCount) {
 	//loop to find frames of streamID belong to path
+	// the crypto stream is retransmitted on any path, before the other streams as the queue is ordered by stream ID
 	for i := 0; i >= 0 && i < len(f.retransmissionQueue); i++ {
 		frame := f.retransmissionQueue[i]
-		ok := false
+		ok := frame.StreamID == cryptoStreamID
 		for _, streamID := range pth.streamIDs {
 			if frame.StreamID == streamID {
 				ok = true
@@ -266,10 +412,10 @@ func (f *streamFramer) maybePopFramesForRetransmissionOfPath(maxLen protocol.Byt
 
 //  return all retransmission frames of streamID if maxLen allows
 func (f *streamFramer) maybePopFramesForRetransmissionOfOneStream(maxLen protocol.ByteCount, streamID protocol.StreamID) (res []*wire.StreamFrame, currentLen protocol.ByteCount) {
-	//loop to find frames of streamID
+	//loop to find frames of streamID, and of the crypto stream which is retransmitted first
 	for i := 0; i >= 0 && i < len(f.retransmissionQueue); i++ {
 		frame := f.retransmissionQueue[i]
-		if frame.StreamID != streamID {
+		if frame.StreamID != streamID && frame.StreamID != cryptoStreamID {
 			continue
 		}
 
@@ -311,11 +457,12 @@ func (f *streamFramer) maybePopFramesForRetransmissionOfOneStream(maxLen protoco
 }
 
 func (f *streamFramer) maybePopNormalFrames(maxBytes protocol.ByteCount) (res []*wire.StreamFrame) {
-	frame := &wire.StreamFrame{DataLenPresent: true}
+	frame := &wire.StreamFrame{DataLenPresent: true, ChecksumPresent: f.checksums}
 	var currentLen protocol.ByteCount
 
+	now := time.Now()
 	fn := func(s *stream) (bool, error) {
-		if s == nil || s.streamID == 1 /* crypto stream is handled separately */ {
+		if s == nil || s.streamID == cryptoStreamID /* crypto stream is handled separately */ {
 			return true, nil
 		}
 
@@ -330,6 +477,12 @@ func (f *streamFramer) maybePopNormalFrames(maxBytes protocol.ByteCount) (res []
 
 		var sendWindowSize protocol.ByteCount
 		lenStreamData := s.lenOfDataForWriting()
+		// a stream above its send rate leaves the path to the other streams
+		limiter, allowed := f.checkSendRate(s, lenStreamData, now)
+		if !allowed {
+			return true, nil
+		}
+
 		if lenStreamData != 0 {
 			sendWindowSize, _ = f.flowControlManager.SendWindowSize(s.streamID)
 			maxLen = utils.MinByteCount(maxLen, sendWindowSize)
@@ -345,6 +498,9 @@ func (f *streamFramer) maybePopNormalFrames(maxBytes protocol.ByteCount) (res []
 			// don't send without FC approval (if a Write() raced).
 			data = s.getDataForWriting(maxLen)
 		}
+		if limiter != nil && len(data) > 0 {
+			limiter.onPacketSent(now, protocol.ByteCount(len(data)))
+		}
 
 		// This is unlikely, but check it nonetheless, the scheduler might have jumped in. Seems to happen in ~20% of cases in the tests.
 		shouldSendFin := s.shouldSendFin()
@@ -376,7 +532,7 @@ func (f *streamFramer) maybePopNormalFrames(maxBytes protocol.ByteCount) (res []
 			return false, nil
 		}
 
-		frame = &wire.StreamFrame{DataLenPresent: true}
+		frame = &wire.StreamFrame{DataLenPresent: true, ChecksumPresent: f.checksums}
 		return true, nil
 	}
 
@@ -386,12 +542,39 @@ func (f *streamFramer) maybePopNormalFrames(maxBytes protocol.ByteCount) (res []
 }
 
 //SHI
+// checkSendRate returns the send rate limiter of the stream, and false if the stream has data but has to wait for its send rate
+func (f *streamFramer) checkSendRate(s *stream, lenStreamData protocol.ByteCount, now time.Time) (*sendRateLimiter, bool) {
+	limiter := s.getSendRateLimiter()
+	if lenStreamData != 0 && limiter != nil && !limiter.sendingAllowed(now) {
+		f.onRateLimited(limiter.timeUntilSend(now), now)
+		return limiter, false
+	}
+	return limiter, true
+}
+
+// onRateLimited records that a stream may send again at sendTime
+func (f *streamFramer) onRateLimited(sendTime, now time.Time) {
+	if f.rateLimitedUntil.Before(now) || sendTime.Before(f.rateLimitedUntil) {
+		f.rateLimitedUntil = sendTime
+	}
+}
+
+// nextRateLimitedSendTime returns when a stream limited by its send rate may send again,
+// or a zero time if no stream is waiting
+func (f *streamFramer) nextRateLimitedSendTime(now time.Time) time.Time {
+	if f.rateLimitedUntil.Before(now) {
+		return time.Time{}
+	}
+	return f.rateLimitedUntil
+}
+
 func (f *streamFramer) maybePopNormalFramesOfPath(maxBytes protocol.ByteCount, pth *path) (res []*wire.StreamFrame) {
-	frame := &wire.StreamFrame{DataLenPresent: true}
+	frame := &wire.StreamFrame{DataLenPresent: true, ChecksumPresent: f.checksums}
 	var currentLen protocol.ByteCount
 
+	now := time.Now()
 	fn := func(s *stream) (bool, error) {
-		if s == nil || s.streamID == 1 /* crypto stream is handled separately */ {
+		if s == nil || s.streamID == cryptoStreamID /* crypto stream is handled separately */ {
 			return true, nil
 		}
 
@@ -409,6 +592,12 @@ func (f *streamFramer) maybePopNormalFramesOfPath(maxBytes protocol.ByteCount, p
 		if utils.Debug() {
 			utils.Debugf("========= stream %d, lenStreamData = %d\n", s.streamID, lenStreamData)
 		}
+		// a stream above its send rate leaves the path to the other streams
+		limiter, allowed := f.checkSendRate(s, lenStreamData, now)
+		if !allowed {
+			return true, nil
+		}
+
 		if lenStreamData != 0 {
 			sendWindowSize, _ = f.flowControlManager.SendWindowSize(s.streamID)
 			maxLen = utils.MinByteCount(maxLen, sendWindowSize)
@@ -422,13 +611,16 @@ func (f *streamFramer) maybePopNormalFramesOfPath(maxBytes protocol.ByteCount, p
 
 		if lenStreamData != 0 {
 			pathScheduler := pth.sess.config.PathScheduler
-			if (pathScheduler == "MultiPath" && (s.pathVolume[pth.pathID] > 0 || lenStreamData < maxLen)) || pathScheduler == "SinglePath" {
+			if ((pathScheduler == "MultiPath" || pathScheduler == protocol.CostAwarePathScheduler) && (s.pathVolume[pth.pathID] > 0 || lenStreamData < maxLen || s.flushing())) || pathScheduler == "SinglePath" {
 				//if lenStreamData < maxLen, it is the last packet of stream
 				// Only getDataForWriting() if we didn't have data earlier, so that we
 				// don't send without FC approval (if a Write() raced).
 				data = s.getDataForWriting(maxLen)
 			}
 		}
+		if limiter != nil && len(data) > 0 {
+			limiter.onPacketSent(now, protocol.ByteCount(len(data)))
+		}
 
 		// This is unlikely, but check it nonetheless, the scheduler might have jumped in. Seems to happen in ~20% of cases in the tests.
 		shouldSendFin := s.shouldSendFin()
@@ -470,7 +662,7 @@ func (f *streamFramer) maybePopNormalFramesOfPath(maxBytes protocol.ByteCount, p
 			return false, nil
 		}
 
-		frame = &wire.StreamFrame{DataLenPresent: true}
+		frame = &wire.StreamFrame{DataLenPresent: true, ChecksumPresent: f.checksums}
 		return true, nil
 	}
 
@@ -481,11 +673,12 @@ func (f *streamFramer) maybePopNormalFramesOfPath(maxBytes protocol.ByteCount, p
 
 //SHI
 func (f *streamFramer) maybePopNormalFramesOfOneStream(maxBytes protocol.ByteCount, streamID protocol.StreamID) (res []*wire.StreamFrame) {
-	frame := &wire.StreamFrame{DataLenPresent: true}
+	frame := &wire.StreamFrame{DataLenPresent: true, ChecksumPresent: f.checksums}
 	var currentLen protocol.ByteCount
 
+	now := time.Now()
 	fn := func(s *stream) (bool, error) {
-		if s == nil || s.streamID == 1 /* crypto stream is handled separately */ {
+		if s == nil || s.streamID == cryptoStreamID /* crypto stream is handled separately */ {
 			return true, nil
 		}
 
@@ -500,6 +693,12 @@ func (f *streamFramer) maybePopNormalFramesOfOneStream(maxBytes protocol.ByteCou
 
 		var sendWindowSize protocol.ByteCount
 		lenStreamData := s.lenOfDataForWriting()
+		// a stream above its send rate leaves the path to the other streams
+		limiter, allowed := f.checkSendRate(s, lenStreamData, now)
+		if !allowed {
+			return true, nil
+		}
+
 		if lenStreamData != 0 {
 			sendWindowSize, _ = f.flowControlManager.SendWindowSize(s.streamID)
 			maxLen = utils.MinByteCount(maxLen, sendWindowSize)
@@ -515,6 +714,9 @@ func (f *streamFramer) maybePopNormalFramesOfOneStream(maxBytes protocol.ByteCou
 			// don't send without FC approval (if a Write() raced).
 			data = s.getDataForWriting(maxLen)
 		}
+		if limiter != nil && len(data) > 0 {
+			limiter.onPacketSent(now, protocol.ByteCount(len(data)))
+		}
 
 		// This is unlikely, but check it nonetheless, the scheduler might have jumped in. Seems to happen in ~20% of cases in the tests.
 		shouldSendFin := s.shouldSendFin()
@@ -546,7 +748,7 @@ func (f *streamFramer) maybePopNormalFramesOfOneStream(maxBytes protocol.ByteCou
 			return false, nil
 		}
 
-		frame = &wire.StreamFrame{DataLenPresent: true}
+		frame = &wire.StreamFrame{DataLenPresent: true, ChecksumPresent: f.checksums}
 		return true, nil
 	}
 
@@ -567,10 +769,11 @@ func maybeSplitOffFrame(frame *wire.StreamFrame, n protocol.ByteCount) *wire.Str
 	}()
 
 	return &wire.StreamFrame{
-		FinBit:         false,
-		StreamID:       frame.StreamID,
-		Offset:         frame.Offset,
-		Data:           frame.Data[:n],
-		DataLenPresent: frame.DataLenPresent,
+		FinBit:          false,
+		StreamID:        frame.StreamID,
+		Offset:          frame.Offset,
+		Data:            frame.Data[:n],
+		DataLenPresent:  frame.DataLenPresent,
+		ChecksumPresent: frame.ChecksumPresent,
 	}
 }
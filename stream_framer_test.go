@@ -2,7 +2,9 @@ package quic
 
 import (
 	"bytes"
+	"time"
 
+	"github.com/golang/mock/gomock"
 	"github.com/lucas-clemente/pstream/internal/mocks/mocks_fc"
 	"github.com/lucas-clemente/pstream/internal/protocol"
 	"github.com/lucas-clemente/pstream/internal/wire"
@@ -69,6 +71,19 @@ var _ = Describe("Stream Framer", func() {
 		Expect(fs[0].DataLenPresent).To(BeTrue())
 	})
 
+	It("adds checksums to normal frames, and makes room for them", func() {
+		framer.checksums = true
+		mockFcm.EXPECT().SendWindowSize(id1).Return(protocol.MaxByteCount, nil)
+		mockFcm.EXPECT().AddBytesSent(id1, gomock.Any())
+		mockFcm.EXPECT().RemainingConnectionWindowSize().Return(protocol.MaxByteCount)
+		stream1.dataForWriting = bytes.Repeat([]byte{'f'}, 100)
+		fs := framer.PopStreamFrames(50)
+		Expect(fs).To(HaveLen(1))
+		Expect(fs[0].ChecksumPresent).To(BeTrue())
+		minLength, _ := fs[0].MinLength(0)
+		Expect(minLength + fs[0].DataLen()).To(Equal(protocol.ByteCount(50)))
+	})
+
 	Context("Popping", func() {
 		It("returns nil when popping an empty framer", func() {
 			Expect(framer.PopStreamFrames(1000)).To(BeEmpty())
@@ -86,6 +101,29 @@ var _ = Describe("Stream Framer", func() {
 			Expect(framer.PopStreamFrames(1000)).To(BeEmpty())
 		})
 
+		It("pops frames for retransmission ordered by stream ID and offset", func() {
+			f1 := &wire.StreamFrame{StreamID: 5, Offset: 0x300, Data: []byte{0x01}}
+			f2 := &wire.StreamFrame{StreamID: 5, Offset: 0x100, Data: []byte{0x02}}
+			f3 := &wire.StreamFrame{StreamID: 3, Offset: 0x200, Data: []byte{0x03}}
+			f4 := &wire.StreamFrame{StreamID: 5, Offset: 0x200, Data: []byte{0x04}}
+			mockFcm.EXPECT().AddBytesRetrans(protocol.StreamID(3), protocol.ByteCount(1))
+			mockFcm.EXPECT().AddBytesRetrans(protocol.StreamID(5), protocol.ByteCount(1)).Times(3)
+			framer.AddFrameForRetransmission(f1)
+			framer.AddFrameForRetransmission(f2)
+			framer.AddFrameForRetransmission(f3)
+			framer.AddFrameForRetransmission(f4)
+			fs := framer.PopStreamFrames(1000)
+			Expect(fs).To(Equal([]*wire.StreamFrame{f3, f2, f4, f1}))
+		})
+
+		It("keeps the order of retransmission frames with the same offset", func() {
+			f1 := &wire.StreamFrame{StreamID: 5, Offset: 0x100, Data: []byte{0x01}}
+			f2 := &wire.StreamFrame{StreamID: 5, Offset: 0x100, Data: []byte{0x02}}
+			framer.AddFrameForRetransmission(f1)
+			framer.AddFrameForRetransmission(f2)
+			Expect(framer.retransmissionQueue).To(Equal([]*wire.StreamFrame{f1, f2}))
+		})
+
 		It("returns normal frames", func() {
 			mockFcm.EXPECT().SendWindowSize(id1).Return(protocol.MaxByteCount, nil)
 			mockFcm.EXPECT().AddBytesSent(id1, protocol.ByteCount(6))
@@ -395,6 +433,276 @@ var _ = Describe("Stream Framer", func() {
 		})
 	})
 
+	Context("expiring data", func() {
+		It("doesn't retransmit frames that expired", func() {
+			stream1.SetExpiry(100 * time.Millisecond)
+			frame := &wire.StreamFrame{StreamID: id1, Data: []byte("foobar")}
+			Expect(framer.AddFrameForRetransmissionUnlessExpired(frame, time.Now().Add(-time.Second))).To(BeFalse())
+			Expect(framer.HasFramesForRetransmission()).To(BeFalse())
+		})
+
+		It("retransmits frames that didn't expire yet", func() {
+			stream1.SetExpiry(time.Second)
+			frame := &wire.StreamFrame{StreamID: id1, Data: []byte("foobar")}
+			Expect(framer.AddFrameForRetransmissionUnlessExpired(frame, time.Now().Add(-100*time.Millisecond))).To(BeTrue())
+			Expect(framer.HasFramesForRetransmission()).To(BeTrue())
+		})
+
+		It("retransmits all frames if no expiry is set", func() {
+			frame := &wire.StreamFrame{StreamID: id1, Data: []byte("foobar")}
+			Expect(framer.AddFrameForRetransmissionUnlessExpired(frame, time.Now().Add(-time.Hour))).To(BeTrue())
+			Expect(framer.HasFramesForRetransmission()).To(BeTrue())
+		})
+
+		It("always retransmits frames with a FIN", func() {
+			stream1.SetExpiry(100 * time.Millisecond)
+			frame := &wire.StreamFrame{StreamID: id1, Data: []byte("foobar"), FinBit: true}
+			Expect(framer.AddFrameForRetransmissionUnlessExpired(frame, time.Now().Add(-time.Second))).To(BeTrue())
+			Expect(framer.HasFramesForRetransmission()).To(BeTrue())
+		})
+	})
+
+	Context("acked data", func() {
+		It("only retransmits the data that wasn't acked", func() {
+			stream1.onDataAcked(2, 2)
+			frame := &wire.StreamFrame{StreamID: id1, Offset: 0, Data: []byte("foobar"), DataLenPresent: true}
+			Expect(framer.AddFrameForRetransmissionUnlessExpired(frame, time.Now())).To(BeTrue())
+			Expect(framer.retransmissionQueue).To(Equal([]*wire.StreamFrame{
+				{StreamID: id1, Offset: 0, Data: []byte("fo"), DataLenPresent: true},
+				{StreamID: id1, Offset: 4, Data: []byte("ar"), DataLenPresent: true},
+			}))
+		})
+
+		It("doesn't retransmit a frame whose data was acked", func() {
+			stream1.onDataAcked(0, 10)
+			frame := &wire.StreamFrame{StreamID: id1, Offset: 2, Data: []byte("foobar")}
+			Expect(framer.AddFrameForRetransmissionUnlessExpired(frame, time.Now())).To(BeTrue())
+			Expect(framer.HasFramesForRetransmission()).To(BeFalse())
+		})
+
+		It("keeps the FIN if the end of the data was acked", func() {
+			stream1.onDataAcked(3, 3)
+			frame := &wire.StreamFrame{StreamID: id1, Offset: 0, Data: []byte("foobar"), FinBit: true}
+			Expect(framer.AddFrameForRetransmissionUnlessExpired(frame, time.Now())).To(BeTrue())
+			Expect(framer.retransmissionQueue).To(Equal([]*wire.StreamFrame{
+				{StreamID: id1, Offset: 0, Data: []byte("foo")},
+				{StreamID: id1, Offset: 6, FinBit: true},
+			}))
+		})
+
+		It("keeps the FIN on the last unacked part", func() {
+			stream1.onDataAcked(0, 3)
+			frame := &wire.StreamFrame{StreamID: id1, Offset: 0, Data: []byte("foobar"), FinBit: true}
+			Expect(framer.AddFrameForRetransmissionUnlessExpired(frame, time.Now())).To(BeTrue())
+			Expect(framer.retransmissionQueue).To(Equal([]*wire.StreamFrame{
+				{StreamID: id1, Offset: 3, Data: []byte("bar"), FinBit: true},
+			}))
+		})
+	})
+
+	Context("striped streams", func() {
+		It("doesn't send the data of a striped stream beyond the volume of a path", func() {
+			pth := &path{pathID: 1, sess: &session{config: &Config{PathScheduler: "MultiPath"}}}
+			pth.streamIDs = []protocol.StreamID{id1}
+			stream1.priority = &protocol.Priority{Weight: 16}
+			stream1.striped.Set(true)
+			stream1.pathVolume = map[protocol.PathID]float64{1: 0}
+			stream1.dataForWriting = bytes.Repeat([]byte{'f'}, 1000)
+			mockFcm.EXPECT().SendWindowSize(id1).Return(protocol.MaxByteCount, nil).Times(2)
+			mockFcm.EXPECT().AddBytesSent(id1, protocol.ByteCount(96))
+			mockFcm.EXPECT().RemainingConnectionWindowSize().Return(protocol.MaxByteCount)
+			Expect(framer.PopStreamFramesOfPath(100, pth)).To(BeEmpty())
+			stream1.pathVolume[1] = 500
+			fs := framer.PopStreamFramesOfPath(100, pth)
+			Expect(fs).To(HaveLen(1))
+			Expect(fs[0].DataLen()).To(Equal(protocol.ByteCount(96)))
+		})
+	})
+
+	Context("flushed streams", func() {
+		It("sends the flushed data on all paths of the stream without volume left", func() {
+			sess := &session{config: &Config{PathScheduler: "MultiPath"}}
+			pthA := &path{pathID: 1, sess: sess}
+			pthA.streamIDs = []protocol.StreamID{id1}
+			pthB := &path{pathID: 3, sess: sess}
+			pthB.streamIDs = []protocol.StreamID{id1}
+			stream1.priority = &protocol.Priority{Weight: 16}
+			stream1.pathVolume = map[protocol.PathID]float64{1: 0, 3: 0}
+			stream1.dataForWriting = bytes.Repeat([]byte{'f'}, 150)
+			mockFcm.EXPECT().SendWindowSize(id1).Return(protocol.MaxByteCount, nil).Times(4)
+			mockFcm.EXPECT().AddBytesSent(id1, protocol.ByteCount(96))
+			mockFcm.EXPECT().AddBytesSent(id1, protocol.ByteCount(54))
+			mockFcm.EXPECT().RemainingConnectionWindowSize().Return(protocol.MaxByteCount).Times(2)
+			Expect(framer.PopStreamFramesOfPath(100, pthA)).To(BeEmpty())
+			Expect(framer.PopStreamFramesOfPath(100, pthB)).To(BeEmpty())
+			stream1.onData = func() {}
+			stream1.Flush()
+			fs := framer.PopStreamFramesOfPath(100, pthA)
+			Expect(fs).To(HaveLen(1))
+			Expect(fs[0].DataLen()).To(Equal(protocol.ByteCount(96)))
+			fs = framer.PopStreamFramesOfPath(100, pthB)
+			Expect(fs).To(HaveLen(1))
+			Expect(fs[0].Offset).To(Equal(protocol.ByteCount(96)))
+			Expect(fs[0].DataLen()).To(Equal(protocol.ByteCount(54)))
+			Expect(stream1.flushing()).To(BeFalse())
+		})
+
+		It("doesn't send data written after the flush beyond the volume", func() {
+			pth := &path{pathID: 1, sess: &session{config: &Config{PathScheduler: "MultiPath"}}}
+			pth.streamIDs = []protocol.StreamID{id1}
+			stream1.priority = &protocol.Priority{Weight: 16}
+			stream1.pathVolume = map[protocol.PathID]float64{1: 0}
+			stream1.onData = func() {}
+			stream1.Flush()
+			stream1.dataForWriting = bytes.Repeat([]byte{'f'}, 1000)
+			mockFcm.EXPECT().SendWindowSize(id1).Return(protocol.MaxByteCount, nil)
+			Expect(framer.PopStreamFramesOfPath(100, pth)).To(BeEmpty())
+		})
+	})
+
+	Context("send rate of streams", func() {
+		var pth *path
+
+		BeforeEach(func() {
+			pth = &path{pathID: 1, sess: &session{config: &Config{PathScheduler: "MultiPath"}}}
+			pth.streamIDs = []protocol.StreamID{id1, id2}
+			stream1.priority = &protocol.Priority{Weight: 16}
+			stream1.pathVolume = map[protocol.PathID]float64{1: 1e9}
+			stream2.priority = &protocol.Priority{Weight: 16}
+			stream2.pathVolume = map[protocol.PathID]float64{1: 1e9}
+			mockFcm.EXPECT().SendWindowSize(gomock.Any()).Return(protocol.MaxByteCount, nil).AnyTimes()
+			mockFcm.EXPECT().AddBytesSent(gomock.Any(), gomock.Any()).AnyTimes()
+			mockFcm.EXPECT().RemainingConnectionWindowSize().Return(protocol.MaxByteCount).AnyTimes()
+		})
+
+		It("leaves the path to an interactive stream if a bulk stream reached its send rate", func() {
+			stream1.SetMaxSendRate(10000) // a burst of one packet
+			stream1.dataForWriting = bytes.Repeat([]byte{'b'}, 100000)
+			stream2.dataForWriting = bytes.Repeat([]byte{'i'}, 3000)
+			sent := make(map[protocol.StreamID]protocol.ByteCount)
+			for i := 0; i < 10; i++ {
+				for _, f := range framer.PopStreamFramesOfPath(1000, pth) {
+					sent[f.StreamID] += f.DataLen()
+				}
+			}
+			Expect(sent[id1]).To(BeNumerically("<", 2000))
+			Expect(sent[id2]).To(Equal(protocol.ByteCount(3000)))
+		})
+
+		It("sends the data of a stream without rate limit", func() {
+			pth.streamIDs = []protocol.StreamID{id1}
+			stream1.SetMaxSendRate(10000)
+			stream1.SetMaxSendRate(0)
+			stream1.dataForWriting = bytes.Repeat([]byte{'b'}, 5000)
+			var sent protocol.ByteCount
+			for i := 0; i < 10; i++ {
+				for _, f := range framer.PopStreamFramesOfPath(1000, pth) {
+					sent += f.DataLen()
+				}
+			}
+			Expect(sent).To(Equal(protocol.ByteCount(5000)))
+			Expect(framer.nextRateLimitedSendTime(time.Now())).To(BeZero())
+		})
+
+		It("sends again once the send rate allows it", func() {
+			pth.streamIDs = []protocol.StreamID{id1}
+			stream1.SetMaxSendRate(10000)
+			stream1.dataForWriting = bytes.Repeat([]byte{'b'}, 100000)
+			Expect(framer.PopStreamFramesOfPath(1000, pth)).ToNot(BeEmpty())
+			Expect(framer.PopStreamFramesOfPath(1000, pth)).ToNot(BeEmpty())
+			Expect(framer.PopStreamFramesOfPath(1000, pth)).To(BeEmpty())
+			sendTime := framer.nextRateLimitedSendTime(time.Now())
+			Expect(sendTime).ToNot(BeZero())
+			Eventually(func() []*wire.StreamFrame { return framer.PopStreamFramesOfPath(1000, pth) }).ShouldNot(BeEmpty())
+			Expect(time.Now()).To(BeTemporally(">=", sendTime))
+		})
+	})
+
+	Context("half-closed streams", func() {
+		It("sends the FIN with the last data of a stream split on two paths", func() {
+			sess := &session{config: &Config{PathScheduler: "MultiPath"}}
+			pthA := &path{pathID: 1, sess: sess}
+			pthA.streamIDs = []protocol.StreamID{id1}
+			pthB := &path{pathID: 3, sess: sess}
+			pthB.streamIDs = []protocol.StreamID{id1}
+			stream1.priority = &protocol.Priority{Weight: 16}
+			stream1.pathVolume = map[protocol.PathID]float64{1: 96, 3: 0}
+			stream1.dataForWriting = bytes.Repeat([]byte{'f'}, 150)
+			stream1.onData = func() {}
+			stream1.ctxCancel = func() {}
+			Expect(stream1.CloseWrite()).To(Succeed())
+			mockFcm.EXPECT().SendWindowSize(id1).Return(protocol.MaxByteCount, nil).Times(2)
+			mockFcm.EXPECT().AddBytesSent(id1, protocol.ByteCount(96))
+			mockFcm.EXPECT().AddBytesSent(id1, protocol.ByteCount(54))
+			mockFcm.EXPECT().RemainingConnectionWindowSize().Return(protocol.MaxByteCount).Times(2)
+			fs := framer.PopStreamFramesOfPath(100, pthA)
+			Expect(fs).To(HaveLen(1))
+			Expect(fs[0].DataLen()).To(Equal(protocol.ByteCount(96)))
+			Expect(fs[0].FinBit).To(BeFalse())
+			fs = framer.PopStreamFramesOfPath(100, pthB)
+			Expect(fs).To(HaveLen(1))
+			Expect(fs[0].Offset).To(Equal(protocol.ByteCount(96)))
+			Expect(fs[0].DataLen()).To(Equal(protocol.ByteCount(54)))
+			Expect(fs[0].FinBit).To(BeTrue())
+			Expect(framer.PopStreamFramesOfPath(100, pthA)).To(BeEmpty())
+		})
+
+		It("sends the FIN on a path without volume left once all data was sent", func() {
+			sess := &session{config: &Config{PathScheduler: "MultiPath"}}
+			pthA := &path{pathID: 1, sess: sess}
+			pthA.streamIDs = []protocol.StreamID{id1}
+			pthB := &path{pathID: 3, sess: sess}
+			pthB.streamIDs = []protocol.StreamID{id1}
+			stream1.priority = &protocol.Priority{Weight: 16}
+			stream1.pathVolume = map[protocol.PathID]float64{1: 0, 3: 0}
+			stream1.writeOffset = 150
+			stream1.onData = func() {}
+			stream1.ctxCancel = func() {}
+			Expect(stream1.CloseWrite()).To(Succeed())
+			mockFcm.EXPECT().AddBytesSent(id1, protocol.ByteCount(0))
+			mockFcm.EXPECT().RemainingConnectionWindowSize().Return(protocol.MaxByteCount)
+			fs := framer.PopStreamFramesOfPath(100, pthB)
+			Expect(fs).To(HaveLen(1))
+			Expect(fs[0].Offset).To(Equal(protocol.ByteCount(150)))
+			Expect(fs[0].FinBit).To(BeTrue())
+			Expect(framer.PopStreamFramesOfPath(100, pthA)).To(BeEmpty())
+		})
+	})
+
+	Context("crypto stream", func() {
+		var cryptoFrame *wire.StreamFrame
+
+		BeforeEach(func() {
+			cryptoFrame = &wire.StreamFrame{StreamID: 1, Offset: 100, Data: []byte("handshake")}
+			framer.AddFrameForRetransmission(retransmittedFrame1)
+			framer.AddFrameForRetransmission(cryptoFrame)
+		})
+
+		It("retransmits crypto frames first on a path that doesn't carry the crypto stream", func() {
+			pth := &path{pathID: 3}
+			pth.streamIDs = []protocol.StreamID{retransmittedFrame1.StreamID}
+			mockFcm.EXPECT().AddBytesRetrans(protocol.StreamID(1), cryptoFrame.DataLen())
+			mockFcm.EXPECT().AddBytesRetrans(retransmittedFrame1.StreamID, retransmittedFrame1.DataLen())
+			fs, _ := framer.maybePopFramesForRetransmissionOfPath(protocol.MaxByteCount, pth)
+			Expect(fs).To(Equal([]*wire.StreamFrame{cryptoFrame, retransmittedFrame1}))
+			Expect(framer.HasFramesForRetransmission()).To(BeFalse())
+		})
+
+		It("retransmits crypto frames on a path without streams", func() {
+			mockFcm.EXPECT().AddBytesRetrans(protocol.StreamID(1), cryptoFrame.DataLen())
+			fs, _ := framer.maybePopFramesForRetransmissionOfPath(protocol.MaxByteCount, &path{pathID: 3})
+			Expect(fs).To(Equal([]*wire.StreamFrame{cryptoFrame}))
+			Expect(framer.retransmissionQueue).To(Equal([]*wire.StreamFrame{retransmittedFrame1}))
+		})
+
+		It("retransmits crypto frames before the frames of a stream", func() {
+			mockFcm.EXPECT().AddBytesRetrans(protocol.StreamID(1), cryptoFrame.DataLen())
+			mockFcm.EXPECT().AddBytesRetrans(retransmittedFrame1.StreamID, retransmittedFrame1.DataLen())
+			fs, _ := framer.maybePopFramesForRetransmissionOfOneStream(protocol.MaxByteCount, retransmittedFrame1.StreamID)
+			Expect(fs).To(Equal([]*wire.StreamFrame{cryptoFrame, retransmittedFrame1}))
+		})
+	})
+
 	Context("BLOCKED frames", func() {
 		It("Pop returns nil if no frame is queued", func() {
 			Expect(framer.PopBlockedFrame()).To(BeNil())
@@ -11,7 +11,9 @@ import (
 
 	"github.com/lucas-clemente/pstream/internal/mocks/mocks_fc"
 	"github.com/lucas-clemente/pstream/internal/protocol"
+	"github.com/lucas-clemente/pstream/internal/utils"
 	"github.com/lucas-clemente/pstream/internal/wire"
+	"github.com/lucas-clemente/pstream/qerr"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -228,6 +230,25 @@ var _ = Describe("Stream", func() {
 			Expect(b).To(Equal([]byte{0xDE, 0xAD, 0xBE, 0xEF}))
 		})
 
+		It("ignores a duplicate StreamFrame received after its data was read", func() {
+			mockFcm.EXPECT().UpdateHighestReceived(streamID, protocol.ByteCount(4)).Times(2)
+			mockFcm.EXPECT().UpdateHighestReceived(streamID, protocol.ByteCount(6))
+			mockFcm.EXPECT().AddBytesRead(streamID, protocol.ByteCount(4))
+			mockFcm.EXPECT().AddBytesRead(streamID, protocol.ByteCount(2))
+			frame := &wire.StreamFrame{Offset: 0, Data: []byte("foob")}
+			Expect(str.AddStreamFrame(frame)).To(Succeed())
+			b := make([]byte, 4)
+			n, err := strWithTimeout.Read(b)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(n).To(Equal(4))
+			// the same frame, e.g. sent redundantly on another path
+			Expect(str.AddStreamFrame(&wire.StreamFrame{Offset: 0, Data: []byte("foob")})).To(Succeed())
+			Expect(str.AddStreamFrame(&wire.StreamFrame{Offset: 4, Data: []byte("ar")})).To(Succeed())
+			n, err = strWithTimeout.Read(b)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(b[:n]).To(Equal([]byte("ar")))
+		})
+
 		It("doesn't rejects a StreamFrames with an overlapping data range", func() {
 			mockFcm.EXPECT().UpdateHighestReceived(streamID, protocol.ByteCount(4))
 			mockFcm.EXPECT().UpdateHighestReceived(streamID, protocol.ByteCount(6))
@@ -342,6 +363,186 @@ var _ = Describe("Stream", func() {
 				Expect(err).To(MatchError(errDeadline))
 				Expect(n).To(BeZero())
 			})
+
+			Context("with frames arriving out of order on different paths", func() {
+				// the frame with the higher offset is received first, e.g. on a path with a lower RTT
+				fastFrame := &wire.StreamFrame{Offset: 4, Data: []byte{0xBE, 0xEF}}
+				slowFrame := &wire.StreamFrame{Offset: 0, Data: []byte{0xDE, 0xAD, 0xCA, 0xFE}}
+
+				BeforeEach(func() {
+					mockFcm.EXPECT().UpdateHighestReceived(streamID, protocol.ByteCount(6)).AnyTimes()
+					mockFcm.EXPECT().UpdateHighestReceived(streamID, protocol.ByteCount(4)).AnyTimes()
+				})
+
+				It("blocks until the in-order data arrives and returns it in order", func() {
+					mockFcm.EXPECT().AddBytesRead(streamID, protocol.ByteCount(4))
+					mockFcm.EXPECT().AddBytesRead(streamID, protocol.ByteCount(2))
+					err := str.AddStreamFrame(fastFrame)
+					Expect(err).ToNot(HaveOccurred())
+					str.SetReadDeadline(time.Now().Add(scaleDuration(200 * time.Millisecond)))
+					go func() {
+						defer GinkgoRecover()
+						time.Sleep(scaleDuration(20 * time.Millisecond))
+						err := str.AddStreamFrame(slowFrame)
+						Expect(err).ToNot(HaveOccurred())
+					}()
+					b := make([]byte, 6)
+					n, err := strWithTimeout.Read(b)
+					Expect(err).ToNot(HaveOccurred())
+					Expect(n).To(Equal(6))
+					Expect(b).To(Equal([]byte{0xDE, 0xAD, 0xCA, 0xFE, 0xBE, 0xEF}))
+				})
+
+				It("times out if the in-order data doesn't arrive before the deadline", func() {
+					err := str.AddStreamFrame(fastFrame)
+					Expect(err).ToNot(HaveOccurred())
+					deadline := time.Now().Add(scaleDuration(50 * time.Millisecond))
+					str.SetReadDeadline(deadline)
+					b := make([]byte, 6)
+					n, err := strWithTimeout.Read(b)
+					Expect(err).To(MatchError(errDeadline))
+					Expect(n).To(BeZero())
+					Expect(time.Now()).To(BeTemporally("~", deadline, scaleDuration(20*time.Millisecond)))
+				})
+
+				It("reports the missing data once the gap persisted for the timeout", func() {
+					err := str.AddStreamFrame(fastFrame)
+					Expect(err).ToNot(HaveOccurred())
+					now := time.Now()
+					_, ok := str.getMissingData(now, time.Second)
+					Expect(ok).To(BeFalse())
+					_, ok = str.getMissingData(now.Add(time.Second/2), time.Second)
+					Expect(ok).To(BeFalse())
+					gap, ok := str.getMissingData(now.Add(time.Second), time.Second)
+					Expect(ok).To(BeTrue())
+					Expect(gap).To(Equal(utils.ByteInterval{Start: 0, End: 4}))
+					// only reported again once the timeout expires another time
+					_, ok = str.getMissingData(now.Add(time.Second+time.Second/2), time.Second)
+					Expect(ok).To(BeFalse())
+					_, ok = str.getMissingData(now.Add(2*time.Second), time.Second)
+					Expect(ok).To(BeTrue())
+				})
+
+				It("doesn't report missing data once the gap is filled", func() {
+					err := str.AddStreamFrame(fastFrame)
+					Expect(err).ToNot(HaveOccurred())
+					now := time.Now()
+					_, ok := str.getMissingData(now, time.Second)
+					Expect(ok).To(BeFalse())
+					err = str.AddStreamFrame(slowFrame)
+					Expect(err).ToNot(HaveOccurred())
+					_, ok = str.getMissingData(now.Add(time.Second), time.Second)
+					Expect(ok).To(BeFalse())
+				})
+			})
+		})
+
+		Context("FIN on split streams", func() {
+			It("returns EOF only after the data sent on a slower path arrived", func() {
+				mockFcm.EXPECT().UpdateHighestReceived(streamID, protocol.ByteCount(6))
+				mockFcm.EXPECT().UpdateHighestReceived(streamID, protocol.ByteCount(3))
+				mockFcm.EXPECT().AddBytesRead(streamID, protocol.ByteCount(3)).Times(2)
+				// the end of the stream arrives first on the fast path
+				err := str.AddStreamFrame(&wire.StreamFrame{Offset: 3, Data: []byte("bar"), FinBit: true})
+				Expect(err).ToNot(HaveOccurred())
+				b := make([]byte, 6)
+				str.SetReadDeadline(time.Now().Add(scaleDuration(20 * time.Millisecond)))
+				n, err := str.Read(b)
+				Expect(err).To(MatchError(errDeadline))
+				Expect(n).To(BeZero())
+				str.SetReadDeadline(time.Time{})
+				err = str.AddStreamFrame(&wire.StreamFrame{Offset: 0, Data: []byte("foo")})
+				Expect(err).ToNot(HaveOccurred())
+				n, err = strWithTimeout.Read(b)
+				Expect(err).To(MatchError(io.EOF))
+				Expect(n).To(Equal(6))
+				Expect(b).To(Equal([]byte("foobar")))
+			})
+
+			It("returns EOF once when the FinBit arrives twice on different paths", func() {
+				mockFcm.EXPECT().UpdateHighestReceived(streamID, protocol.ByteCount(6)).Times(2)
+				mockFcm.EXPECT().AddBytesRead(streamID, protocol.ByteCount(3)).Times(2)
+				// the last bytes are retransmitted on the fast path, and the original frame arrives late
+				err := str.AddStreamFrame(&wire.StreamFrame{Offset: 3, Data: []byte("bar"), FinBit: true})
+				Expect(err).ToNot(HaveOccurred())
+				err = str.AddStreamFrame(&wire.StreamFrame{Offset: 0, Data: []byte("foobar"), FinBit: true})
+				Expect(err).ToNot(HaveOccurred())
+				b := make([]byte, 10)
+				n, err := strWithTimeout.Read(b)
+				Expect(err).To(MatchError(io.EOF))
+				Expect(n).To(Equal(6))
+				Expect(b[:n]).To(Equal([]byte("foobar")))
+				n, err = strWithTimeout.Read(b)
+				Expect(err).To(MatchError(io.EOF))
+				Expect(n).To(BeZero())
+			})
+		})
+
+		Context("skipping expired data", func() {
+			It("skips data that expired at the peer", func() {
+				mockFcm.EXPECT().UpdateHighestReceived(streamID, protocol.ByteCount(4))
+				mockFcm.EXPECT().UpdateHighestReceived(streamID, protocol.ByteCount(6))
+				mockFcm.EXPECT().AddBytesRead(streamID, protocol.ByteCount(4))
+				mockFcm.EXPECT().AddBytesRead(streamID, protocol.ByteCount(2))
+				err := str.AddStreamFrame(&wire.StreamFrame{Offset: 4, Data: []byte{0xBE, 0xEF}})
+				Expect(err).ToNot(HaveOccurred())
+				err = str.SkipStreamData(0, 4)
+				Expect(err).ToNot(HaveOccurred())
+				b := make([]byte, 6)
+				n, err := strWithTimeout.Read(b)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(n).To(Equal(2))
+				Expect(b[:n]).To(Equal([]byte{0xBE, 0xEF}))
+				Expect(str.readOffset).To(Equal(protocol.ByteCount(6)))
+				Expect(str.frameQueue.skipped).To(BeEmpty())
+			})
+
+			It("only skips the data that wasn't received yet", func() {
+				mockFcm.EXPECT().UpdateHighestReceived(streamID, protocol.ByteCount(2))
+				mockFcm.EXPECT().UpdateHighestReceived(streamID, protocol.ByteCount(4))
+				mockFcm.EXPECT().AddBytesRead(streamID, protocol.ByteCount(2)).Times(2)
+				err := str.AddStreamFrame(&wire.StreamFrame{Offset: 0, Data: []byte{0xDE, 0xAD}})
+				Expect(err).ToNot(HaveOccurred())
+				err = str.SkipStreamData(0, 4)
+				Expect(err).ToNot(HaveOccurred())
+				b := make([]byte, 4)
+				n, err := strWithTimeout.Read(b)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(n).To(Equal(2))
+				Expect(b[:n]).To(Equal([]byte{0xDE, 0xAD}))
+				Expect(str.readOffset).To(Equal(protocol.ByteCount(4)))
+			})
+
+			It("ignores ranges that were already received", func() {
+				mockFcm.EXPECT().UpdateHighestReceived(streamID, protocol.ByteCount(4)).Times(2)
+				err := str.AddStreamFrame(&wire.StreamFrame{Offset: 0, Data: []byte{0xDE, 0xAD, 0xBE, 0xEF}})
+				Expect(err).ToNot(HaveOccurred())
+				err = str.SkipStreamData(0, 4)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(str.frameQueue.skipped).To(BeEmpty())
+			})
+
+			It("records a skipped range without buffering its data", func() {
+				mockFcm.EXPECT().UpdateHighestReceived(streamID, protocol.ByteCount(1<<20))
+				err := str.SkipStreamData(0, 1<<20)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(str.frameQueue.queuedFrames).To(BeEmpty())
+				Expect(str.frameQueue.skipped).To(Equal(map[protocol.ByteCount]protocol.ByteCount{0: 1 << 20}))
+			})
+
+			It("rejects a range that overflows the maximum offset", func() {
+				err := str.SkipStreamData(10, protocol.MaxByteCount-5)
+				Expect(err).To(MatchError(qerr.Error(qerr.InvalidStreamData, "skipped data overflows maximum offset")))
+				Expect(str.frameQueue.skipped).To(BeEmpty())
+			})
+
+			It("rejects a range beyond the receive window", func() {
+				testErr := qerr.Error(qerr.FlowControlReceivedTooMuchData, "too much")
+				mockFcm.EXPECT().UpdateHighestReceived(streamID, protocol.ByteCount(1<<40)).Return(testErr)
+				err := str.SkipStreamData(0, 1<<40)
+				Expect(err).To(MatchError(testErr))
+				Expect(str.frameQueue.skipped).To(BeEmpty())
+			})
 		})
 
 		Context("closing", func() {
@@ -736,6 +937,18 @@ var _ = Describe("Stream", func() {
 	})
 
 	Context("writing", func() {
+		It("flushes the data written so far", func() {
+			str.writeOffset = 10
+			str.dataForWriting = []byte("foobar")
+			str.Flush()
+			Expect(onDataCalled).To(BeTrue())
+			Expect(str.flushing()).To(BeTrue())
+			Expect(str.getDataForWriting(3)).To(Equal([]byte("foo")))
+			Expect(str.flushing()).To(BeTrue())
+			Expect(str.getDataForWriting(3)).To(Equal([]byte("bar")))
+			Expect(str.flushing()).To(BeFalse())
+		})
+
 		It("writes and gets all data at once", func() {
 			done := make(chan struct{})
 			go func() {
@@ -760,6 +973,45 @@ var _ = Describe("Stream", func() {
 			Eventually(done).Should(BeClosed())
 		})
 
+		It("buffers the data of the first writes until the scheduler can detect the size of the stream", func() {
+			str.minDetectedSize = 10
+			n, err := strWithTimeout.Write([]byte("foo"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(n).To(Equal(3))
+			n, err = strWithTimeout.Write([]byte("bar"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(n).To(Equal(3))
+			Expect(str.lenOfDataForWriting()).To(Equal(protocol.ByteCount(6)))
+			Expect(str.getSizeDetectionDeadline()).To(BeTemporally("~", time.Now().Add(protocol.MaxStreamSizeDetectionDelay), 20*time.Millisecond))
+			done := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				n, err := strWithTimeout.Write([]byte("foobar"))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(n).To(Equal(6))
+				close(done)
+			}()
+			Eventually(func() protocol.ByteCount { return str.lenOfDataForWriting() }).Should(Equal(protocol.ByteCount(12)))
+			Consistently(done).ShouldNot(BeClosed())
+			Expect(str.getDataForWriting(1000)).To(Equal([]byte("foobarfoobar")))
+			Eventually(done).Should(BeClosed())
+		})
+
+		It("doesn't buffer writes once data was sent", func() {
+			str.minDetectedSize = 10
+			str.writeOffset = 3
+			done := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				_, err := strWithTimeout.Write([]byte("foo"))
+				Expect(err).ToNot(HaveOccurred())
+				close(done)
+			}()
+			Consistently(done).ShouldNot(BeClosed())
+			Expect(str.getDataForWriting(1000)).To(Equal([]byte("foo")))
+			Eventually(done).Should(BeClosed())
+		})
+
 		It("writes and gets data in two turns", func() {
 			done := make(chan struct{})
 			go func() {
@@ -910,6 +1162,27 @@ var _ = Describe("Stream", func() {
 				Expect(str.shouldSendFin()).To(BeFalse())
 			})
 
+			It("keeps reading after the write side was closed", func() {
+				Expect(str.CloseWrite()).To(Succeed())
+				Expect(str.finishedWriting.Get()).To(BeTrue())
+				Expect(str.Context().Done()).To(BeClosed())
+				mockFcm.EXPECT().UpdateHighestReceived(streamID, protocol.ByteCount(4))
+				mockFcm.EXPECT().AddBytesRead(streamID, protocol.ByteCount(4))
+				err := str.AddStreamFrame(&wire.StreamFrame{
+					Data:   []byte{0xDE, 0xAD, 0xBE, 0xEF},
+					FinBit: true,
+				})
+				Expect(err).ToNot(HaveOccurred())
+				b := make([]byte, 4)
+				n, err := strWithTimeout.Read(b)
+				Expect(err).To(MatchError(io.EOF))
+				Expect(n).To(Equal(4))
+				Expect(b).To(Equal([]byte{0xDE, 0xAD, 0xBE, 0xEF}))
+				Expect(str.finished()).To(BeFalse())
+				str.sentFin()
+				Expect(str.finished()).To(BeTrue())
+			})
+
 			It("does not allow FIN twice", func() {
 				str.Close()
 				Expect(str.shouldSendFin()).To(BeTrue())
@@ -1049,4 +1322,34 @@ var _ = Describe("Stream", func() {
 			Expect(bytes).To(Equal(protocol.ByteCount(200)))
 		})
 	})
+
+	Context("tracking acked data", func() {
+		It("returns the whole range if nothing was acked", func() {
+			Expect(str.unackedRanges(10, 20)).To(Equal([]utils.ByteInterval{{Start: 10, End: 30}}))
+		})
+
+		It("merges overlapping and adjacent ranges", func() {
+			str.onDataAcked(20, 10)
+			str.onDataAcked(0, 5)
+			str.onDataAcked(25, 10)
+			str.onDataAcked(5, 5)
+			Expect(str.ackedData).To(Equal([]utils.ByteInterval{{Start: 0, End: 10}, {Start: 20, End: 35}}))
+			str.onDataAcked(8, 14)
+			Expect(str.ackedData).To(Equal([]utils.ByteInterval{{Start: 0, End: 35}}))
+		})
+
+		It("ignores empty ranges", func() {
+			str.onDataAcked(10, 0)
+			Expect(str.ackedData).To(BeEmpty())
+		})
+
+		It("returns the unacked parts of a range", func() {
+			str.onDataAcked(0, 5)
+			str.onDataAcked(10, 5)
+			str.onDataAcked(20, 5)
+			Expect(str.unackedRanges(3, 20)).To(Equal([]utils.ByteInterval{{Start: 5, End: 10}, {Start: 15, End: 20}}))
+			Expect(str.unackedRanges(12, 2)).To(BeEmpty())
+			Expect(str.unackedRanges(22, 10)).To(Equal([]utils.ByteInterval{{Start: 25, End: 32}}))
+		})
+	})
 })
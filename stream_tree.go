@@ -2,6 +2,7 @@ package quic
 
 import (
 	"fmt"
+	"sort"
 	"sync"
 
 	"github.com/lucas-clemente/pstream/internal/protocol"
@@ -291,7 +292,7 @@ func (sch *streamTree) setActive(id protocol.StreamID) error {
 	sch.Lock()
 	defer sch.Unlock()
 
-	if id == 1 /* Crypto stream handled separatly */ {
+	if id == cryptoStreamID /* Crypto stream handled separatly */ {
 		return nil
 	}
 	if id == 3 /* Header stream is always considered active */ {
@@ -408,6 +409,71 @@ func (sch *streamTree) scheduleAll() []*stream {
 	return streams
 }
 
+// weight of a node used for ordering, the stream priority takes precedence over the node weight
+func (n *node) orderWeight() uint8 {
+	if n.stream != nil && n.stream.priority != nil {
+		return n.stream.priority.Weight
+	}
+	return n.weight
+}
+
+// share of the parent resources given by the weights of all nodes on the way to the root (RFC 7540, 5.3.2),
+// and depth of the node in the tree
+func (n *node) dependencyShare() (share float64, depth int) {
+	share = 1
+	for ; n.parent != nil; n = n.parent {
+		weight := float64(n.orderWeight()) + 1
+		siblingsWeight := weight
+		for _, c := range n.parent.children {
+			if c != n {
+				siblingsWeight += float64(c.orderWeight()) + 1
+			}
+		}
+		share *= weight / siblingsWeight
+		depth++
+	}
+	return
+}
+
+// sortByDependency orders the streams so that a stream never comes before one of its ancestors,
+// and weights only count among siblings
+func (sch *streamTree) sortByDependency(streams []*stream) []*stream {
+	sch.Lock()
+	defer sch.Unlock()
+
+	type dependencyOrder struct {
+		stream *stream
+		share  float64
+		depth  int
+	}
+
+	orders := make([]dependencyOrder, 0, len(streams))
+	for _, str := range streams {
+		o := dependencyOrder{stream: str, share: 1}
+		if n, ok := sch.nodeMap[str.streamID]; ok {
+			o.share, o.depth = n.dependencyShare()
+		}
+		orders = append(orders, o)
+	}
+
+	// the share of a child never exceeds the share of its parent, ties are broken by the depth
+	sort.SliceStable(orders, func(i, j int) bool {
+		if orders[i].share != orders[j].share {
+			return orders[i].share > orders[j].share
+		}
+		if orders[i].depth != orders[j].depth {
+			return orders[i].depth < orders[j].depth
+		}
+		return orders[i].stream.streamID < orders[j].stream.streamID
+	})
+
+	sorted := make([]*stream, 0, len(orders))
+	for _, o := range orders {
+		sorted = append(sorted, o.stream)
+	}
+	return sorted
+}
+
 //printTree print all nodes with level order
 func (sch *streamTree) printTree() {
 
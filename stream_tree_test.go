@@ -33,6 +33,9 @@ func (f *mockFlowControlManager) UpdateHighestReceived(streamID protocol.StreamI
 func (f *mockFlowControlManager) AddBytesRead(streamID protocol.StreamID, n protocol.ByteCount) error {
 	panic("not yet implemented")
 }
+func (f *mockFlowControlManager) SetReceiveWindowLimit(streamID protocol.StreamID, limit protocol.ByteCount) error {
+	panic("not yet implemented")
+}
 func (f *mockFlowControlManager) GetWindowUpdates(force bool) (res []flowcontrol.WindowUpdate) {
 	panic("not yet implemented")
 }
@@ -694,4 +697,32 @@ var _ = Describe("Stream Tree", func() {
 
 		})
 	})
+	Context("ordering by dependency", func() {
+		BeforeEach(func() {
+			streamTree.addNode(stream1) //4
+			streamTree.addNode(stream2) //5
+			streamTree.addNode(stream3) //6
+
+			Expect(streamTree.maybeSetWeight(id1, 10)).To(Succeed())
+			Expect(streamTree.maybeSetWeight(id2, 200)).To(Succeed())
+			Expect(streamTree.maybeSetWeight(id3, 255)).To(Succeed())
+			Expect(streamTree.maybeSetParent(id3, id1, false)).To(Succeed())
+		})
+
+		It("never orders a child before its parent", func() {
+			s := streamTree.sortByDependency([]*stream{stream3, stream1})
+			Expect(s).To(Equal([]*stream{stream1, stream3}))
+		})
+
+		It("applies weights among siblings only", func() {
+			s := streamTree.sortByDependency([]*stream{stream3, stream1, stream2})
+			Expect(s).To(Equal([]*stream{stream2, stream1, stream3}))
+		})
+
+		It("keeps streams unknown to the tree", func() {
+			stream4.priority = &protocol.Priority{Weight: 100}
+			s := streamTree.sortByDependency([]*stream{stream4, stream2})
+			Expect(s).To(ConsistOf(stream4, stream2))
+		})
+	})
 })
@@ -1,10 +1,10 @@
 package quic
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"math/rand"
-	"sort"
 	"sync"
 	"time"
 
@@ -14,6 +14,9 @@ import (
 	"github.com/lucas-clemente/pstream/qerr"
 )
 
+// the crypto stream carries the handshake, its frames are packed by the stream framer separately from the other streams
+const cryptoStreamID protocol.StreamID = 1
+
 type streamsMap struct {
 	mutex sync.RWMutex
 
@@ -45,6 +48,9 @@ type streamsMap struct {
 	numIncomingStreams uint32
 
 	streamTree *streamTree
+
+	// streams always scheduled on the lowest-RTT path and sent before all other streams
+	controlStreams []protocol.StreamID
 }
 
 type streamLambda func(*stream) (bool, error)
@@ -66,6 +72,7 @@ func newStreamsMap(newStream newStreamLambda, pers protocol.Perspective, connect
 		newStream:            newStream,
 		connectionParameters: connectionParameters,
 		priorityOrder:        make([]protocol.StreamID, 0),
+		controlStreams:       []protocol.StreamID{1, 3},
 	}
 	sm.nextStreamOrErrCond.L = &sm.mutex
 	sm.openStreamOrErrCond.L = &sm.mutex
@@ -89,6 +96,7 @@ func newStreamsMapPriority(newStreamPriority newStreamLambdaPriority, pers proto
 		newStreamPriority:    newStreamPriority,
 		connectionParameters: connectionParameters,
 		priorityOrder:        make([]protocol.StreamID, 0),
+		controlStreams:       []protocol.StreamID{1, 3},
 	}
 	sm.nextStreamOrErrCond.L = &sm.mutex
 	sm.openStreamOrErrCond.L = &sm.mutex
@@ -112,6 +120,7 @@ func newStreamsMapPrioritySize(newStreamPrioritySize newStreamLambdaPrioritySize
 		newStreamPrioritySize: newStreamPrioritySize,
 		connectionParameters:  connectionParameters,
 		priorityOrder:         make([]protocol.StreamID, 0),
+		controlStreams:        []protocol.StreamID{1, 3},
 	}
 	sm.nextStreamOrErrCond.L = &sm.mutex
 	sm.openStreamOrErrCond.L = &sm.mutex
@@ -138,6 +147,7 @@ func newStreamsMapTree(newStreamPrioritySize newStreamLambdaPrioritySize,
 		newStreamPrioritySize: newStreamPrioritySize,
 		connectionParameters:  connectionParameters,
 		streamTree:            streamTree,
+		controlStreams:        []protocol.StreamID{1, 3},
 	}
 	sm.nextStreamOrErrCond.L = &sm.mutex
 	sm.openStreamOrErrCond.L = &sm.mutex
@@ -153,6 +163,26 @@ func newStreamsMapTree(newStreamPrioritySize newStreamLambdaPrioritySize,
 	return &sm
 }
 
+// addControlStreams adds streams handled like the crypto and header streams
+func (m *streamsMap) addControlStreams(ids []protocol.StreamID) {
+	for _, id := range ids {
+		if id == 0 || m.isControlStream(id) {
+			continue
+		}
+		m.controlStreams = append(m.controlStreams, id)
+	}
+}
+
+// isControlStream checks whether the stream is the crypto stream, the header stream or a configured control stream
+func (m *streamsMap) isControlStream(id protocol.StreamID) bool {
+	for _, sid := range m.controlStreams {
+		if sid == id {
+			return true
+		}
+	}
+	return false
+}
+
 // GetOrOpenStream either returns an existing stream, a newly opened stream, or nil if a stream with the provided ID is already closed.
 // Newly opened streams should only originate from the client. To open a stream from the server, OpenStream should be used.
 func (m *streamsMap) GetOrOpenStream(id protocol.StreamID) (*stream, error) {
@@ -519,6 +549,22 @@ func (m *streamsMap) OpenStream() (*stream, error) {
 	return m.openStreamImpl()
 }
 
+// OpenStreamStriped opens a stream that is split across all paths
+func (m *streamsMap) OpenStreamStriped() (*stream, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.closeErr != nil {
+		return nil, m.closeErr
+	}
+	str, err := m.openStreamImpl()
+	if err != nil {
+		return nil, err
+	}
+	str.striped.Set(true)
+	return str, nil
+}
+
 func (m *streamsMap) OpenStreamSync() (*stream, error) {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
@@ -579,6 +625,27 @@ func (m *streamsMap) OpenStreamPrioritySizeSync(priority *protocol.Priority) (*s
 // AcceptStream returns the next stream opened by the peer
 // it blocks until a new stream is opened
 func (m *streamsMap) AcceptStream() (*stream, error) {
+	return m.AcceptStreamContext(context.Background())
+}
+
+// AcceptStreamContext is like AcceptStream,
+// but it gives up with the context's error once ctx is done
+func (m *streamsMap) AcceptStreamContext(ctx context.Context) (*stream, error) {
+	if ctx.Done() != nil {
+		// wake up the waiting loop below when the context is done
+		returned := make(chan struct{})
+		defer close(returned)
+		go func() {
+			select {
+			case <-ctx.Done():
+				m.mutex.Lock()
+				m.nextStreamOrErrCond.Broadcast()
+				m.mutex.Unlock()
+			case <-returned:
+			}
+		}()
+	}
+
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 	var str *stream
@@ -587,6 +654,9 @@ func (m *streamsMap) AcceptStream() (*stream, error) {
 		if m.closeErr != nil {
 			return nil, m.closeErr
 		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 		str, ok = m.streams[m.nextStreamToAccept]
 		if ok {
 			break
@@ -636,14 +706,14 @@ func (m *streamsMap) RoundRobinIterateSchedule(fn streamLambda) (bool, error) {
 	return true, nil
 }
 
-//  perform sending data of stream 1 and 3, until there is no data or window
+//  perform sending data of stream 1, 3 and the control streams, until there is no data or window
 func (m *streamsMap) RoundRobinSendingPrioritizeStream(fn streamLambdaSend, s *session, sch *scheduler) (bool, bool, bool, error) {
 	for {
 		allStreamNotExisted := true //true if all stream not existed
 		notEmptyPackets := false    //true if exist one sent not empty packet
 		hasWindows := false         //true if exist one has window
 
-		for _, i := range []protocol.StreamID{1, 3} {
+		for _, i := range m.controlStreams {
 			notEmptyPacket, hasWindow, streamNotExist, err := m.iterateFuncPacketSend(i, fn)
 			if err != nil && err != errMapAccess {
 				return false, false, false, err
@@ -668,7 +738,7 @@ func (m *streamsMap) RoundRobinIterate(fn streamLambda) error {
 	numStreams := uint32(len(m.streams))
 	startIndex := m.roundRobinIndex
 
-	for _, i := range []protocol.StreamID{1, 3} {
+	for _, i := range m.controlStreams {
 		cont, err := m.iterateFunc(i, fn)
 		if err != nil && err != errMapAccess {
 			return err
@@ -680,7 +750,7 @@ func (m *streamsMap) RoundRobinIterate(fn streamLambda) error {
 
 	for i := uint32(0); i < numStreams; i++ {
 		streamID := m.openStreams[(i+startIndex)%numStreams]
-		if streamID == 1 || streamID == 3 {
+		if m.isControlStream(streamID) {
 			continue
 		}
 
@@ -707,7 +777,7 @@ func (m *streamsMap) RoundRobinIteratePopOfPath(fn streamLambda, pth *path) erro
 	for i := uint32(0); i < numStreamsOfPath; i++ {
 		sid := pth.streamIDs[(i+startIndex)%numStreamsOfPath]
 
-		if sid == 1 || sid == 3 {
+		if m.isControlStream(sid) {
 			continue
 		}
 
@@ -750,14 +820,16 @@ func (m *streamsMap) PriorityIteratePopOfPath(fn streamLambda, pth *path) error
 	defer m.mutex.Unlock()
 
 	sum := float32(0)
+	now := time.Now()
+	rateLimited := make(map[protocol.StreamID]bool)
 
 	for i := 0; i < len(pth.streamIDs); i++ {
 		sid := pth.streamIDs[i]
 		// if utils.Debug() {
 		// 	utils.Debugf("PriorityIteratePopOfPath: path %d assigned stream %d \n", pth.pathID, sid)
 		// }
-		//   we prioritize stream 3 if either of them in this path, crypto stream (stream 1) is handled separately
-		if sid == 3 {
+		//   we prioritize stream 3 and control streams if any of them in this path, crypto stream (stream 1) is handled separately
+		if sid != 1 && m.isControlStream(sid) {
 			cont, err := m.iterateFunc(sid, fn)
 			// if utils.Debug() {
 			// 	utils.Debugf("PriorityIteratePopOfPath: path %d pop data of stream %d \n", sid)
@@ -770,15 +842,26 @@ func (m *streamsMap) PriorityIteratePopOfPath(fn streamLambda, pth *path) error
 			}
 			continue
 		}
+		//   a stream waiting for its send rate doesn't take the pop of the other streams, fn only records until when it waits
+		if m.streams[sid].sendRateLimited(now) {
+			rateLimited[sid] = true
+			if _, err := m.iterateFunc(sid, fn); err != nil && err != errMapAccess {
+				return err
+			}
+			continue
+		}
 		sum += float32(m.streams[sid].priority.Weight)
 
 	}
+	if sum == 0 && len(rateLimited) > 0 {
+		return nil
+	}
 
 	probability := make(map[protocol.StreamID]float32)
 
 	for i := 0; i < len(pth.streamIDs); i++ {
 		sid := pth.streamIDs[i]
-		if sid == 1 || sid == 3 {
+		if m.isControlStream(sid) || rateLimited[sid] {
 			continue
 		}
 		probability[sid] = float32(m.streams[sid].priority.Weight) / sum
@@ -823,7 +906,7 @@ func (m *streamsMap) RoundRobinIterateSend(fn streamLambdaSend, s *session, sch
 		notEmptyPackets := false
 		hasWindows := false
 
-		for _, i := range []protocol.StreamID{1, 3} {
+		for _, i := range m.controlStreams {
 			notEmptyPacket, hasWindow, streamNotExist, err := m.iterateFuncPacketSend(i, fn)
 			if err != nil && err != errMapAccess {
 				return err
@@ -837,7 +920,7 @@ func (m *streamsMap) RoundRobinIterateSend(fn streamLambdaSend, s *session, sch
 	NormalStreamLoop:
 		for i := uint32(0); i < numStreams; i++ {
 			streamID := m.openStreams[(i+startIndex)%numStreams]
-			if streamID == 1 || streamID == 3 {
+			if m.isControlStream(streamID) {
 				continue NormalStreamLoop
 			}
 
@@ -860,41 +943,20 @@ func (m *streamsMap) RoundRobinIterateSend(fn streamLambdaSend, s *session, sch
 	}
 }
 
-//   implement sort interface to sort priority of streams
-
-type order struct {
-	Key   protocol.StreamID
-	Value uint8
-}
-
-//sort existing stream id with priority descending order
+//sort existing stream id with dependency and priority, parents first and weights among siblings
 func (m *streamsMap) sortStreamPriorityOrder() bool {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
-	var orders []order
 	//get stream list to be scheduled
 	streams := m.streamTree.schedule()
 
 	if len(streams) != 0 {
 		// clean result
 		m.priorityOrder = nil
-		orders = nil
-
-		//every time extract streamID and its priority
-		for _, str := range streams {
-			// if utils.Debug() {
-			// 	utils.Debugf("====== streamMap.streams: stream %d, priority %d, dependency %d, Exclusive %t\n", str.streamID, str.priority.Weight, str.priority.Dependency, str.priority.Exclusive)
-			// }
-			orders = append(orders, order{str.streamID, str.priority.Weight})
-		}
-
-		sort.Slice(orders, func(i, j int) bool {
-			return orders[i].Value > orders[j].Value
-		})
 
-		for _, order := range orders {
-			m.priorityOrder = append(m.priorityOrder, order.Key)
+		for _, str := range m.streamTree.sortByDependency(streams) {
+			m.priorityOrder = append(m.priorityOrder, str.streamID)
 		}
 		return true
 
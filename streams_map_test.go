@@ -175,6 +175,16 @@ var _ = Describe("Streams Map", func() {
 					Expect(err).To(MatchError(testErr))
 				})
 
+				It("opens striped streams", func() {
+					s, err := m.OpenStreamStriped()
+					Expect(err).ToNot(HaveOccurred())
+					Expect(s.StreamID()).To(Equal(protocol.StreamID(2)))
+					Expect(s.striped.Get()).To(BeTrue())
+					s, err = m.OpenStream()
+					Expect(err).ToNot(HaveOccurred())
+					Expect(s.striped.Get()).To(BeFalse())
+				})
+
 				It("doesn't reopen an already closed stream", func() {
 					str, err := m.OpenStream()
 					Expect(err).ToNot(HaveOccurred())